@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"time"
+
+	"learn-go/pkg/trace"
 )
 
 /*
@@ -16,6 +19,7 @@ import (
 3. カスタムヘッダー
 4. タイムアウト
 5. エラーハンドリング
+6. トレースの伝播（http.RoundTripper で traceparent ヘッダーを付ける）
 */
 
 func main() {
@@ -155,6 +159,30 @@ func main() {
 			fmt.Printf("最初の投稿: %s\n", posts2[0].Title)
 		}
 	}
+
+	// ========== トレースの伝播 ==========
+	fmt.Println("\n=== トレースの伝播 ===")
+
+	// 呼び出し側でスパンを始めると、APIClient の呼び出しはその子のスパンになり、
+	// 送信先には同じトレースIDの traceparent ヘッダーが届く
+	traces := trace.NewRingBuffer(10)
+	tracer := trace.NewTracer(traces)
+
+	ctx, span := tracer.Start(context.Background(), "ユーザーと投稿の取得")
+	if _, err := apiClient.GetUserContext(ctx, 1); err != nil {
+		log.Printf("エラー: %v\n", err)
+	}
+	if _, err := apiClient.GetPostsContext(ctx, 1); err != nil {
+		log.Printf("エラー: %v\n", err)
+	}
+	span.End()
+
+	for _, t := range traces.Traces() {
+		fmt.Printf("トレース %s（%.1fms）\n", t.TraceID, t.DurationMS)
+		for _, sp := range t.Spans {
+			fmt.Printf("  %-30s %6.1fms %s\n", sp.Name, sp.DurationMS, sp.Status)
+		}
+	}
 }
 
 // ========== 構造体の定義 ==========
@@ -180,19 +208,28 @@ type APIClient struct {
 	Client  *http.Client
 }
 
+// NewAPIClient はクライアントを作る。
+// Transport を trace.Transport にしておくと、すべてのリクエストに traceparent が付き、
+// 呼び出した側と呼ばれた側のログをトレースIDで突き合わせられる
 func NewAPIClient(baseURL string) *APIClient {
 	return &APIClient{
 		BaseURL: baseURL,
 		Client: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: trace.NewTransport(nil, nil),
 		},
 	}
 }
 
 func (c *APIClient) GetUser(id int) (*User, error) {
+	return c.GetUserContext(context.Background(), id)
+}
+
+// GetUserContext は ctx のトレース（スパン）を引き継いでユーザーを取得する
+func (c *APIClient) GetUserContext(ctx context.Context, id int) (*User, error) {
 	url := fmt.Sprintf("%s/users/%d", c.BaseURL, id)
 
-	resp, err := c.Client.Get(url)
+	resp, err := c.get(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -212,9 +249,14 @@ func (c *APIClient) GetUser(id int) (*User, error) {
 }
 
 func (c *APIClient) GetPosts(userID int) ([]Post, error) {
+	return c.GetPostsContext(context.Background(), userID)
+}
+
+// GetPostsContext は ctx のトレース（スパン）を引き継いで投稿一覧を取得する
+func (c *APIClient) GetPostsContext(ctx context.Context, userID int) ([]Post, error) {
 	url := fmt.Sprintf("%s/posts?userId=%d", c.BaseURL, userID)
 
-	resp, err := c.Client.Get(url)
+	resp, err := c.get(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -233,6 +275,15 @@ func (c *APIClient) GetPosts(userID int) ([]Post, error) {
 	return posts, nil
 }
 
+// get は ctx 付きで GET する（キャンセルとトレースが ctx で伝わる）
+func (c *APIClient) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
 /*
 【実行方法】
 go run 02_http_client.go
@@ -242,6 +293,7 @@ go run 02_http_client.go
 2. http.NewRequest: カスタムリクエスト
 3. http.Client: クライアント設定
 4. defer resp.Body.Close(): リソース解放
+5. http.RoundTripper: 送信するすべてのリクエストに共通の処理（ヘッダー付与・計測）を挟む
 
 【基本的な流れ】
 1. リクエストを作成
@@ -269,6 +321,7 @@ http.StatusInternalServerError // 500
 3. ステータスコードをチェックする
 4. エラーハンドリングを適切に行う
 5. 構造体でクライアントをラップする
+6. context を受け取るメソッドを用意し、キャンセルとトレースを伝える
 
 【次のステップ】
 05_web_basics/ で HTTP サーバーを学びましょう
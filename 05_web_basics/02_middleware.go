@@ -1,11 +1,24 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"learn-go/pkg/ratelimit"
 )
 
 /*
@@ -14,6 +27,8 @@ import (
 2. ミドルウェアの実装
 3. ミドルウェアのチェーン
 4. 実用的なミドルウェア
+5. レスポンスの圧縮（Accept-Encoding の交渉、ResponseWriter のラップ、sync.Pool）
+6. レート制限（トークンバケット / スライディングウィンドウ、キーの選び方、RateLimit ヘッダー）
 */
 
 func main() {
@@ -37,6 +52,7 @@ func main() {
 			loggingMiddleware,
 			corsMiddleware,
 			authMiddleware,
+			rateLimitMiddleware,
 		)(http.HandlerFunc(dataHandler)),
 	)
 
@@ -45,15 +61,34 @@ func main() {
 		chain(
 			loggingMiddleware,
 			corsMiddleware,
+			rateLimitMiddleware,
 		)(http.HandlerFunc(publicHandler)),
 	)
 
+	// ========== 圧縮するエンドポイント ==========
+	http.Handle("/api/posts",
+		chain(
+			loggingMiddleware,
+			corsMiddleware,
+			rateLimitMiddleware,
+			compressMiddleware,
+		)(http.HandlerFunc(postsHandler)),
+	)
+	http.Handle("/api/stream",
+		chain(
+			loggingMiddleware,
+			compressMiddleware,
+		)(http.HandlerFunc(streamHandler)),
+	)
+
 	fmt.Println("サーバー起動: http://localhost:8080")
 	fmt.Println("エンドポイント:")
 	fmt.Println("  GET /without-middleware")
 	fmt.Println("  GET /with-middleware (要認証)")
-	fmt.Println("  GET /api/data (要認証)")
-	fmt.Println("  GET /api/public")
+	fmt.Println("  GET /api/data (要認証、ユーザーごとに 5 回まで続けて・1 秒に 1 回補充)")
+	fmt.Println("  GET /api/public (IP ごとに 1 分 10 回まで)")
+	fmt.Println("  GET /api/posts (Accept-Encoding: gzip / deflate で圧縮、IP ごとに 1 分 10 回まで)")
+	fmt.Println("  GET /api/stream (圧縮しながら少しずつ送る)")
 	fmt.Println("\n認証ヘッダー例:")
 	fmt.Println("  curl -H \"Authorization: Bearer secret-token\" http://localhost:8080/with-middleware")
 
@@ -126,26 +161,53 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// ========== レート制限ミドルウェア（簡易版） ==========
+// ========== レート制限ミドルウェア ==========
 
-var requestCount = make(map[string]int)
-
-func rateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
+// rateLimitMiddleware はパスごとに決めた方法でリクエスト数を制限する。
+// 数え方とキーごとの状態は learn-go/pkg/ratelimit が持つ（複数のゴルーチンから同時に呼ばれても安全で、使われなくなったキーは消える）
+var rateLimitMiddleware = newRateLimitMiddleware()
 
-		// リクエスト数をカウント
-		requestCount[ip]++
+func newRateLimitMiddleware() Middleware {
+	// 手前にローカルのリバースプロキシ（nginx など）を置いたときだけ X-Forwarded-For を信じる。
+	// それ以外から届いた X-Forwarded-For は、クライアントが書いた値かもしれないので無視する
+	proxies, err := ratelimit.ParseTrustedProxies("127.0.0.1", "::1")
+	if err != nil {
+		log.Fatal(err)
+	}
+	byClient := ratelimit.ByIP(proxies)
+
+	// ログインしたユーザーはユーザーID、それ以外は IP をキーにする。
+	// 検証していない X-API-Key などをキーにすると、値を毎回変えるだけで別人として数えられてしまう。
+	// 短い集中は許したいのでトークンバケット（5 回まで続けて、その後は 1 秒に 1 回）
+	perUser := &ratelimit.Policy{
+		Name:    "user",
+		Limiter: ratelimit.NewTokenBucket(1, 5),
+		Key:     ratelimit.First(ratelimit.ByUser(userID), byClient),
+	}
+	// 公開 API は IP ごとにスライディングウィンドウ（1 分に 10 回）
+	perIP := &ratelimit.Policy{
+		Name:    "ip",
+		Limiter: ratelimit.NewSlidingWindow(10, time.Minute),
+		Key:     byClient,
+	}
 
-		// 10リクエストまで許可
-		if requestCount[ip] > 10 {
-			http.Error(w, "レート制限を超えました", http.StatusTooManyRequests)
-			return
-		}
+	// 上から順に見て、最初に当てはまったルールを使う
+	rules := []ratelimit.Rule{
+		{Prefix: "/api/data", Policy: perUser},
+		{Prefix: "/api/", Policy: perIP},
+	}
+	return ratelimit.NewMiddleware(rules, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Retry-After と RateLimit-* ヘッダーはミドルウェアが付けている
+		http.Error(w, "レート制限を超えました", http.StatusTooManyRequests)
+	}))
+}
 
-		// 次のハンドラーを実行
-		next.ServeHTTP(w, r)
-	})
+// userID は認証済みのユーザーID（この例ではトークンが1つだけ。実際には認証ミドルウェアが context に入れる）
+func userID(r *http.Request) string {
+	if r.Header.Get("Authorization") == "Bearer secret-token" {
+		return "123"
+	}
+	return ""
 }
 
 // ========== リカバリーミドルウェア ==========
@@ -164,6 +226,282 @@ func recoveryMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// ========== 圧縮ミドルウェア ==========
+
+// compressMiddleware は既定の設定（1KB 未満は圧縮しない、標準の圧縮レベル）の圧縮ミドルウェア
+var compressMiddleware = newCompressMiddleware(1024, gzip.DefaultCompression)
+
+// newCompressMiddleware は Accept-Encoding に従って gzip か deflate でレスポンスを圧縮するミドルウェアを作る。
+// minSize バイト未満の本文と、画像・動画・zip などすでに圧縮された形式はそのまま返す
+func newCompressMiddleware(minSize, level int) Middleware {
+	// 圧縮器は内部に数百KBのバッファを持つので、リクエストごとに作らず使い回す
+	gzipPool := sync.Pool{New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, level)
+		return w
+	}}
+	// HTTP の "deflate" は生の DEFLATE ではなく zlib 形式（RFC 1950）
+	deflatePool := sync.Pool{New: func() interface{} {
+		w, _ := zlib.NewWriterLevel(io.Discard, level)
+		return w
+	}}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// 圧縮するかどうかに関係なく、Accept-Encoding によって中身が変わりうることをキャッシュに伝える
+			addVary(w.Header(), "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			switch encoding {
+			case "gzip":
+				cw.pool = &gzipPool
+			case "deflate":
+				cw.pool = &deflatePool
+			}
+			defer cw.finish()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding は Accept-Encoding から使う圧縮方式を選ぶ（圧縮しないなら ""）。
+// q 値の大きいものを選び、同じなら gzip を優先する。q=0 は「使わない」。"*" は書かれていない方式すべてを表す
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return ""
+	}
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		value := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || f < 0 || f > 1 {
+				continue // 不正な q 値の要素は無視する
+			}
+			value = f
+		}
+		q[name] = value
+	}
+
+	type candidate struct {
+		name string
+		q    float64
+	}
+	var candidates []candidate
+	for i, name := range []string{"gzip", "deflate"} {
+		v, ok := q[name]
+		if !ok {
+			// x-gzip は gzip の古い別名
+			if name == "gzip" {
+				v, ok = q["x-gzip"]
+			}
+			if !ok {
+				v, ok = q["*"]
+			}
+		}
+		if ok && v > 0 {
+			// 同じ q 値なら並びの先（gzip）を選ぶよう、わずかに差を付ける
+			candidates = append(candidates, candidate{name, v - float64(i)*1e-9})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].name
+}
+
+// addVary は Vary ヘッダーに値を足す（すでにあれば何もしない）
+func addVary(h http.Header, value string) {
+	for _, v := range h.Values("Vary") {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || strings.EqualFold(item, value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
+
+// resettableWriter は *gzip.Writer と *zlib.Writer に共通のメソッド（Reset で書き込み先を替えて使い回す）
+type resettableWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressWriter は本文を minSize バイトまで溜めてから、圧縮するかどうかを決める。
+// 決める前に Flush された場合（ストリーミング）は、その時点で圧縮を始める
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	pool     *sync.Pool
+
+	status     int
+	buf        bytes.Buffer
+	decided    bool
+	compressor resettableWriter
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = code
+	}
+	// 1xx（103 Early Hints など）はそのまま送る
+	if code >= 100 && code < 200 {
+		cw.status = 0
+		cw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		// 小さいと分かっている本文や、圧縮できない本文は溜めずにすぐ決める
+		if !cw.compressible() {
+			cw.decide(false)
+		} else {
+			cw.buf.Write(b)
+			if cw.buf.Len() < cw.minSize {
+				return len(b), nil
+			}
+			cw.decide(true)
+			return len(b), nil
+		}
+	}
+	if cw.compressor != nil {
+		return cw.compressor.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// compressible はヘッダーとステータスから、圧縮してよいレスポンスかを判定する
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	switch {
+	case cw.status < 200 || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified:
+		return false
+	case cw.status == http.StatusPartialContent || h.Get("Content-Range") != "":
+		return false // Range の位置は圧縮前の本文に対するもの
+	case h.Get("Content-Encoding") != "":
+		return false // ハンドラーがすでに符号化している
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < cw.minSize {
+		return false
+	}
+	return !alreadyCompressed(h.Get("Content-Type"))
+}
+
+// alreadyCompressed は圧縮しても小さくならない形式か
+func alreadyCompressed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "image/") && mediaType != "image/svg+xml":
+		return true
+	case strings.HasPrefix(mediaType, "video/"), strings.HasPrefix(mediaType, "audio/"):
+		return true
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/x-7z-compressed", "application/pdf", "font/woff", "font/woff2":
+		return true
+	}
+	return false
+}
+
+// decide はヘッダーを送り、溜めていた本文を書き出す
+func (cw *compressWriter) decide(compress bool) {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if compress {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length") // 圧縮後の長さは分からない
+		// 強い ETag は元の本文に対するものなので、弱い ETag にする
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		if h.Get("Content-Type") == "" {
+			// 圧縮後の本文から推測されないよう、圧縮前の本文で決めておく
+			h.Set("Content-Type", http.DetectContentType(cw.buf.Bytes()))
+		}
+		cw.compressor = cw.pool.Get().(resettableWriter)
+		cw.compressor.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if cw.buf.Len() > 0 {
+		if cw.compressor != nil {
+			cw.compressor.Write(cw.buf.Bytes())
+		} else {
+			cw.ResponseWriter.Write(cw.buf.Bytes())
+		}
+		cw.buf.Reset()
+	}
+}
+
+// Flush は圧縮器に溜まった分も含めてクライアントに送る（SSE などのストリーミングで必要）
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide(cw.compressible())
+	}
+	if cw.compressor != nil {
+		cw.compressor.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Hijack は WebSocket などで接続を取り上げる。以降は圧縮しない
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	cw.decided = true
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// finish はハンドラーが戻った後に呼ぶ。minSize に届かなかった本文は圧縮せずに送る
+func (cw *compressWriter) finish() {
+	if !cw.decided {
+		if cw.status == 0 && cw.buf.Len() == 0 {
+			return // 何も書かれていなければ net/http に任せる（200 で空の本文）
+		}
+		cw.decide(false)
+	}
+	if cw.compressor != nil {
+		cw.compressor.Close()
+		cw.compressor.Reset(io.Discard) // ResponseWriter への参照を残さない
+		cw.pool.Put(cw.compressor)
+		cw.compressor = nil
+	}
+}
+
 // ========== ミドルウェアチェーン ==========
 
 func chain(middlewares ...Middleware) Middleware {
@@ -193,6 +531,42 @@ func dataHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(data)
 }
 
+// postsHandler は圧縮の効果が分かるよう、大きめの JSON を返す
+func postsHandler(w http.ResponseWriter, r *http.Request) {
+	posts := make([]map[string]interface{}, 0, 200)
+	for i := 1; i <= 200; i++ {
+		posts = append(posts, map[string]interface{}{
+			"id":      i,
+			"title":   fmt.Sprintf("投稿 %d", i),
+			"content": "ミドルウェアでレスポンスを圧縮すると、同じ文字列が繰り返される JSON は大きく縮む。",
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(posts)
+}
+
+// streamHandler は Flush で少しずつ送る。圧縮ミドルウェアを通しても届くたびに表示される
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "ストリーミングに対応していません", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(w, "%d: %s\n", i, time.Now().Format(time.RFC3339))
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
 func publicHandler(w http.ResponseWriter, r *http.Request) {
 	data := map[string]interface{}{
 		"message": "公開データ",
@@ -223,6 +597,20 @@ curl -H "Authorization: Bearer secret-token" http://localhost:8080/api/data
 # 公開エンドポイント
 curl http://localhost:8080/api/public
 
+# 圧縮（--compressed は gzip / deflate を受け付けて展開する。-w でサイズを比べる）
+curl -s -o /dev/null -w "%{size_download}\n" http://localhost:8080/api/posts
+curl -s -o /dev/null -w "%{size_download}\n" --compressed http://localhost:8080/api/posts
+curl -i -H "Accept-Encoding: deflate;q=1, gzip;q=0.5" http://localhost:8080/api/posts -o /dev/null -D -
+curl -N --compressed http://localhost:8080/api/stream
+
+# レート制限（11 回目から 429。RateLimit-* と Retry-After ヘッダーを見る）
+for i in $(seq 1 11); do curl -s -o /dev/null -w "%{http_code} " http://localhost:8080/api/public; done; echo
+curl -i http://localhost:8080/api/public
+# ユーザーごと（5 回続けた後は 1 秒に 1 回）
+for i in $(seq 1 7); do curl -s -o /dev/null -w "%{http_code} " -H "Authorization: Bearer secret-token" http://localhost:8080/api/data; done; echo
+# ローカルのプロキシからの X-Forwarded-For は信じる（別のクライアントとして数える）
+curl -i -H "X-Forwarded-For: 203.0.113.7" http://localhost:8080/api/public
+
 【重要な概念】
 1. ミドルウェアは http.Handler を受け取り http.Handler を返す
 2. 複数のミドルウェアをチェーンできる
@@ -246,6 +634,7 @@ func middleware(next http.Handler) http.Handler {
 3. CORS: クロスオリジンリクエストを許可
 4. レート制限: リクエスト数を制限
 5. リカバリー: パニックから回復
+6. 圧縮: Accept-Encoding に合わせて gzip / deflate で送る
 
 【ミドルウェアの順序】
 1. リカバリー（最外層）
@@ -253,7 +642,8 @@ func middleware(next http.Handler) http.Handler {
 3. CORS
 4. 認証
 5. レート制限
-6. ハンドラー（最内層）
+6. 圧縮
+7. ハンドラー（最内層）
 
 【ベストプラクティス】
 1. ミドルウェアは小さく保つ
@@ -261,6 +651,27 @@ func middleware(next http.Handler) http.Handler {
 3. 順序に注意する
 4. エラーハンドリングを適切に行う
 
+【圧縮ミドルウェアのポイント】
+1. Accept-Encoding の q 値で方式を選ぶ（q=0 は拒否、"*" はその他すべて）
+2. 圧縮しないときも Vary: Accept-Encoding を付け、キャッシュが別のクライアントに誤った形式を返さないようにする
+3. 小さい本文は圧縮しても縮まないので、minSize まで溜めてから判断する
+4. 画像・動画・zip や Range のレスポンス、すでに Content-Encoding があるものは圧縮しない
+5. 圧縮すると長さが変わるので Content-Length を消し、強い ETag は弱い ETag にする
+6. Flush では圧縮器の中身も送る。しないと SSE などが届かない
+7. gzip.Writer は大きなバッファを持つので sync.Pool で使い回す
+
+【レート制限のポイント】
+1. 数え方
+   - トークンバケット: 一定の速さで溜まるトークンを使う。バーストを許しつつ平均の速さを抑える
+   - スライディングウィンドウ: 直前の窓の回数を按分して足す。固定窓の境目で2倍通る問題がない
+2. 状態を持つ map は Mutex で守る（ハンドラーは同時に動く）。使われなくなったキーは消してメモリを増やさない
+3. キーはユーザー・IP から選ぶ。IP だけだと同じ NAT の後ろの人がまとめて制限される。
+   API キーなどクライアントが自由に書ける値は、検証してからでないとキーにしない（値を変えれば制限を回避できる）
+4. X-Forwarded-For は偽造できる。信頼するプロキシから届いたときだけ、右から見て最初の信頼しないアドレスを使う
+5. パスごとにルールを分ける（ログインは厳しく、公開 API は緩く、など）
+6. RateLimit-Limit / RateLimit-Remaining / RateLimit-Reset で残りを伝え、429 には Retry-After を付ける
+7. 認証の後に置くと、ユーザーごとに数えられる（認証前に置くと IP でしか数えられない）
+
 【次のステップ】
 07_rest_api/ で REST API を学びましょう
 */
@@ -1,14 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"learn-go/pkg/config"
+	"learn-go/pkg/jsonbody"
+	"learn-go/pkg/jsonrpc"
+	"learn-go/pkg/metrics"
 )
 
 /*
@@ -18,6 +30,10 @@ import (
 3. ルーティング
 4. エラーハンドリング
 5. バリデーション
+6. JSON-RPC 2.0（同じ操作を /rpc からも呼べるようにする）
+7. 設定の読み込み（既定値 → 設定ファイル → 環境変数 → フラグ）とグレースフルシャットダウン
+8. メトリクス（Prometheus 形式の /metrics）
+9. リクエストボディの厳密なデコード（Content-Type・大きさの上限・知らないフィールド）
 */
 
 // ========== データモデル ==========
@@ -41,10 +57,11 @@ type UpdateUserRequest struct {
 }
 
 type Response struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success bool              `json:"success"`
+	Message string            `json:"message,omitempty"`
+	Data    interface{}       `json:"data,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Details map[string]string `json:"details,omitempty"` // どのフィールドが不正か
 }
 
 // ========== インメモリデータストア ==========
@@ -137,13 +154,23 @@ func (s *UserStore) Delete(id int) error {
 // ========== API ハンドラー ==========
 
 type API struct {
-	store *UserStore
+	store   *UserStore
+	rpc     *jsonrpc.Server
+	metrics *metrics.Registry
 }
 
 func NewAPI() *API {
-	return &API{
-		store: NewUserStore(),
+	api := &API{
+		store:   NewUserStore(),
+		metrics: metrics.NewRegistry(),
 	}
+	api.rpc = api.newRPCServer()
+
+	api.metrics.RegisterRuntime()
+	api.metrics.GaugeFunc("api_users", "登録されているユーザー数", func() float64 {
+		return float64(len(api.store.GetAll()))
+	})
+	return api
 }
 
 // ルーター
@@ -152,8 +179,12 @@ func (api *API) Router() http.Handler {
 
 	mux.HandleFunc("/api/users", api.usersHandler)
 	mux.HandleFunc("/api/users/", api.userHandler)
+	mux.Handle("/rpc", api.rpc)
+	mux.Handle("/metrics", api.metrics.Handler())
 
-	return loggingMiddleware(corsMiddleware(mux))
+	// リクエスト数と処理時間を経路（登録パターン）ごとに数える
+	instrument := api.metrics.NewHTTPMetrics().Middleware(metrics.MuxRoute(mux))
+	return instrument(loggingMiddleware(corsMiddleware(mux)))
 }
 
 // GET /api/users - 全ユーザー取得
@@ -204,8 +235,7 @@ func (api *API) getAllUsers(w http.ResponseWriter, r *http.Request) {
 // ユーザー作成
 func (api *API) createUser(w http.ResponseWriter, r *http.Request) {
 	var req CreateUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 	defer r.Body.Close()
@@ -246,8 +276,7 @@ func (api *API) getUser(w http.ResponseWriter, r *http.Request, id int) {
 // ユーザー更新
 func (api *API) updateUser(w http.ResponseWriter, r *http.Request, id int) {
 	var req UpdateUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 	defer r.Body.Close()
@@ -279,6 +308,109 @@ func (api *API) deleteUser(w http.ResponseWriter, r *http.Request, id int) {
 	}, http.StatusOK)
 }
 
+// ========== JSON-RPC ==========
+
+// サーバー定義のエラーコード（-32000〜-32099 は実装側で自由に使える）
+const rpcCodeNotFound = -32004
+
+type rpcUserParams struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// newRPCServer は REST と同じユーザー操作を JSON-RPC のメソッドとして登録する
+func (api *API) newRPCServer() *jsonrpc.Server {
+	s := jsonrpc.NewServer("ユーザーAPI", "1.0.0")
+	idParam := jsonrpc.Param{Name: "id", Type: "integer", Required: true}
+	userResult := jsonrpc.Param{Name: "user", Type: "object"}
+
+	s.Register(jsonrpc.Method{
+		Name:        "users.list",
+		Description: "全ユーザー取得",
+		Result:      jsonrpc.Param{Name: "users", Type: "array"},
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			return api.store.GetAll(), nil
+		},
+	})
+	s.Register(jsonrpc.Method{
+		Name:        "users.get",
+		Description: "ユーザー取得",
+		Params:      []jsonrpc.Param{idParam},
+		Result:      userResult,
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			var p rpcUserParams
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+			user, err := api.store.GetByID(p.ID)
+			if err != nil {
+				return nil, jsonrpc.NewError(rpcCodeNotFound, err.Error(), nil)
+			}
+			return user, nil
+		},
+	})
+	s.Register(jsonrpc.Method{
+		Name:        "users.create",
+		Description: "ユーザー作成",
+		Params: []jsonrpc.Param{
+			{Name: "name", Type: "string", Required: true},
+			{Name: "email", Type: "string", Required: true},
+		},
+		Result: userResult,
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			var p rpcUserParams
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+			// バリデーション（REST と同じ条件）
+			if p.Name == "" || p.Email == "" {
+				return nil, jsonrpc.InvalidParams("名前とメールアドレスは必須です", nil)
+			}
+			return api.store.Create(p.Name, p.Email)
+		},
+	})
+	s.Register(jsonrpc.Method{
+		Name:        "users.update",
+		Description: "ユーザー更新（name / email は省略可）",
+		Params: []jsonrpc.Param{
+			idParam,
+			{Name: "name", Type: "string"},
+			{Name: "email", Type: "string"},
+		},
+		Result: userResult,
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			var p rpcUserParams
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+			user, err := api.store.Update(p.ID, p.Name, p.Email)
+			if err != nil {
+				return nil, jsonrpc.NewError(rpcCodeNotFound, err.Error(), nil)
+			}
+			return user, nil
+		},
+	})
+	s.Register(jsonrpc.Method{
+		Name:        "users.delete",
+		Description: "ユーザー削除",
+		Params:      []jsonrpc.Param{idParam},
+		Result:      jsonrpc.Param{Name: "deleted", Type: "boolean"},
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			var p rpcUserParams
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+			if err := api.store.Delete(p.ID); err != nil {
+				return nil, jsonrpc.NewError(rpcCodeNotFound, err.Error(), nil)
+			}
+			return true, nil
+		},
+	})
+
+	return s
+}
+
 // ========== ヘルパー関数 ==========
 
 func extractID(path string) (int, error) {
@@ -302,6 +434,26 @@ func respondError(w http.ResponseWriter, message string, status int) {
 	}, status)
 }
 
+// decodeJSON はリクエストボディを厳密にデコードする（Content-Type・大きさ・知らないフィールドを確認）。
+// 失敗したらエラーレスポンスを返して false を返す
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	err := jsonbody.Decode(w, r, dst)
+	if err == nil {
+		return true
+	}
+	var bodyErr *jsonbody.Error
+	if !errors.As(err, &bodyErr) {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	respondJSON(w, Response{
+		Success: false,
+		Error:   bodyErr.Message,
+		Details: bodyErr.Details,
+	}, bodyErr.Status)
+	return false
+}
+
 // ========== ミドルウェア ==========
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -328,9 +480,54 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// ========== 設定 ==========
+
+// Config はサーバーの設定（server.addr は 設定ファイル / 環境変数 SERVER_ADDR / フラグ -server-addr で変更できる）
+type Config struct {
+	Server struct {
+		Addr              string        `config:"addr" help:"待ち受けアドレス"`
+		ReadTimeout       time.Duration `config:"read_timeout" help:"リクエスト全体を読む期限"`
+		ReadHeaderTimeout time.Duration `config:"read_header_timeout" help:"リクエストヘッダーを読む期限"`
+		WriteTimeout      time.Duration `config:"write_timeout" help:"レスポンスを書き終える期限"`
+		IdleTimeout       time.Duration `config:"idle_timeout" help:"keep-alive 接続を待つ時間"`
+		ShutdownTimeout   time.Duration `config:"shutdown_timeout" help:"停止時に処理中のリクエストを待つ時間"`
+	} `config:"server"`
+}
+
+func loadConfig() Config {
+	var cfg Config
+	cfg.Server.Addr = ":8080"
+	cfg.Server.ReadTimeout = 10 * time.Second
+	cfg.Server.ReadHeaderTimeout = 5 * time.Second
+	cfg.Server.WriteTimeout = 10 * time.Second
+	cfg.Server.IdleTimeout = 60 * time.Second
+	cfg.Server.ShutdownTimeout = 10 * time.Second
+
+	_, err := config.Load(&cfg, config.Options{Name: "01_rest_api", Args: os.Args[1:], FileEnv: "API_CONFIG"})
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("設定の読み込みに失敗しました: %v", err)
+	}
+
+	// 不正な設定では起動しない
+	if _, _, err := net.SplitHostPort(cfg.Server.Addr); err != nil {
+		log.Fatalf("server.addr は host:port の形で指定してください: %q", cfg.Server.Addr)
+	}
+	if cfg.Server.ReadTimeout < 0 || cfg.Server.WriteTimeout < 0 || cfg.Server.IdleTimeout < 0 {
+		log.Fatal("タイムアウトに負の値は指定できません")
+	}
+	if cfg.Server.ReadHeaderTimeout <= 0 || cfg.Server.ShutdownTimeout <= 0 {
+		log.Fatal("server.read_header_timeout と server.shutdown_timeout は正の値にしてください")
+	}
+	return cfg
+}
+
 // ========== メイン関数 ==========
 
 func main() {
+	cfg := loadConfig()
 	api := NewAPI()
 
 	// サンプルデータを追加
@@ -338,26 +535,68 @@ func main() {
 	api.store.Create("花子", "hanako@example.com")
 	api.store.Create("次郎", "jiro@example.com")
 
-	fmt.Println("REST API サーバー起動: http://localhost:8080")
+	fmt.Println("REST API サーバー起動: " + cfg.Server.Addr)
 	fmt.Println("\nエンドポイント:")
 	fmt.Println("  GET    /api/users      - 全ユーザー取得")
 	fmt.Println("  POST   /api/users      - ユーザー作成")
 	fmt.Println("  GET    /api/users/{id} - ユーザー取得")
 	fmt.Println("  PUT    /api/users/{id} - ユーザー更新")
 	fmt.Println("  DELETE /api/users/{id} - ユーザー削除")
+	fmt.Println("  POST   /rpc            - JSON-RPC 2.0（users.list / users.get / users.create / users.update / users.delete）")
+	fmt.Println("  GET    /metrics        - Prometheus 形式のメトリクス")
 	fmt.Println("\n使用例:")
 	fmt.Println("  curl http://localhost:8080/api/users")
 	fmt.Println("  curl -X POST http://localhost:8080/api/users -d '{\"name\":\"四郎\",\"email\":\"shiro@example.com\"}' -H 'Content-Type: application/json'")
 	fmt.Println("  curl http://localhost:8080/api/users/1")
 	fmt.Println("  curl -X PUT http://localhost:8080/api/users/1 -d '{\"name\":\"太郎2\"}' -H 'Content-Type: application/json'")
 	fmt.Println("  curl -X DELETE http://localhost:8080/api/users/1")
+	fmt.Println("  curl -X POST http://localhost:8080/rpc -d '{\"jsonrpc\":\"2.0\",\"method\":\"users.get\",\"params\":{\"id\":1},\"id\":1}'")
+	fmt.Println("  curl http://localhost:8080/metrics")
+
+	srv := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           api.Router(),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	// Ctrl+C（SIGINT）や SIGTERM を受け取ると ctx が終了する
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
 
-	log.Fatal(http.ListenAndServe(":8080", api.Router()))
+	select {
+	case err := <-serveErr:
+		log.Fatal(err) // ポートが使用中など
+	case <-ctx.Done():
+	}
+
+	// 新しい接続の受け付けを止め、処理中のリクエストが終わるまで待つ
+	log.Println("シャットダウン中...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("時間内に終わらなかったリクエストを打ち切りました: %v", err)
+		srv.Close()
+	}
 }
 
 /*
 【実行方法】
 go run 01_rest_api.go
+go run 01_rest_api.go -server-addr :9090          # フラグで上書き
+SERVER_ADDR=:9090 go run 01_rest_api.go          # 環境変数で上書き
+go run 01_rest_api.go -config api.toml           # 設定ファイル（API_CONFIG でも指定できる）
+go run 01_rest_api.go -h                         # 設定項目の一覧
+
+api.toml の例:
+  [server]
+  addr = ":9090"
+  shutdown_timeout = "30s"
 
 【REST API の原則】
 1. リソース指向（/api/users）
@@ -376,8 +615,37 @@ go run 01_rest_api.go
 - 201 Created: 作成成功
 - 400 Bad Request: 不正なリクエスト
 - 404 Not Found: リソースが見つからない
+- 413 Content Too Large: ボディが大きすぎる
+- 415 Unsupported Media Type: Content-Type が JSON ではない
 - 500 Internal Server Error: サーバーエラー
 
+【JSON-RPC 2.0】
+- POST /rpc に {"jsonrpc":"2.0","method":"users.get","params":{"id":1},"id":1} を送る
+- params は名前付き（{"id":1}）でも位置指定（[1]）でもよい
+- id を省略すると通知になり、レスポンスは返らない
+- 配列で送るとバッチ: [{...,"id":1},{...,"id":2}]
+- エラーはコードで返す（-32601 メソッドなし / -32602 引数エラー / -32004 見つからない）
+- rpc.discover でメソッドの一覧を取得できる
+
+【設定とグレースフルシャットダウン】
+- 設定は 既定値 → 設定ファイル → 環境変数 → フラグ の順に上書きされる（pkg/config）
+- http.Server にタイムアウトを設定する（ReadHeaderTimeout がないと遅いクライアントに接続を占有される）
+- signal.NotifyContext で SIGINT / SIGTERM を待ち、srv.Shutdown で処理中のリクエストを待ってから終了する
+- log.Fatal は defer を実行せずに終了するので、後片付けが必要な処理の後には使わない
+
+【メトリクス】
+- GET /metrics で Prometheus のテキスト形式を返す（pkg/metrics、外部ライブラリなし）
+- http_requests_total{route,method,status} はカウンター、http_request_duration_seconds はヒストグラム
+- route は URL ではなく登録パターン（/api/users/）にする。ID ごとに系列が増えるのを防ぐ
+- go_goroutines や go_memstats_* でランタイムの状態も分かる
+
+【リクエストボディのデコード】
+- decodeJSON（pkg/jsonbody）は Content-Type が application/json でなければ 415 を返す
+- http.MaxBytesReader で 1MB までに制限し、超えたら 413 を返す
+- 知らないフィールド・型の違い・JSON の後ろに続くデータはエラーにし、details にフィールドとバイト位置を入れる
+  curl -X POST http://localhost:8080/api/users -H 'Content-Type: application/json' -d '{"name":"四郎","emial":"x"}'
+  → {"success":false,"error":"Unknown field","details":{"emial":"Unknown field","offset":"29"}}
+
 【ベストプラクティス】
 1. 一貫性のあるURL設計
 2. 適切なHTTPメソッドを使用
@@ -1,13 +1,57 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
+	"html"
+	"io"
 	"log"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"learn-go/pkg/blobstore"
+	"learn-go/pkg/config"
+	"learn-go/pkg/graphql"
+	"learn-go/pkg/health"
+	"learn-go/pkg/imaging"
+	"learn-go/pkg/jsonbody"
+	"learn-go/pkg/jsonrpc"
+	"learn-go/pkg/mail"
+	"learn-go/pkg/markdown"
+	"learn-go/pkg/metrics"
+	"learn-go/pkg/password"
+	"learn-go/pkg/recorder"
+	"learn-go/pkg/signedtoken"
+	"learn-go/pkg/slugify"
+	"learn-go/pkg/trace"
+	"learn-go/pkg/webhook"
+	"learn-go/pkg/websocket"
+	"learn-go/pkg/workerpool"
+	"learn-go/pkg/workspace"
 )
 
 /*
@@ -17,26 +61,136 @@ import (
 3. ページネーション
 4. フィルタリング
 5. エラーレスポンス
+6. 予約投稿（time.Ticker によるバックグラウンド処理）
+7. ワークフロー（ステートマシンとロールによる権限チェック）
+8. メール認証とパスワードリセット（pkg/mail の Mailer インターフェース、pkg/signedtoken の署名付きトークン）
+9. ブルートフォース対策（指数バックオフとアカウントロック）
+10. APIキー（スコープ付き、ハッシュ化して保存）
+11. 監査ログ（追記専用の JSON Lines ファイル）
+12. Webhook（pkg/webhook、ワーカープール、HMAC署名、指数バックオフでの再試行）
+13. Server-Sent Events（リアルタイム配信、Last-Event-ID での再開）
+14. WebSocket（RFC 6455 を net/http で実装、投稿ごとのコメントルーム）
+15. GraphQL（スキーマ、バッチ読み込み、イントロスペクション）
+16. JSON-RPC 2.0（バッチ、通知、メソッドレジストリと rpc.discover）
+17. マルチテナント（pkg/workspace、ワークスペース単位のリポジトリ、ロール、招待、上限）
+18. 添付ファイル（pkg/blobstore、multipart のストリーム処理、MIME 判定、SHA-256 によるコンテンツアドレス保存、Range）
+19. サムネイル（pkg/imaging、ワーカープールでの非同期生成、EXIF の向き補正、派生ファイルのキャッシュ）
+20. Markdown（pkg/markdown、安全な HTML への変換、Accept による返し分け、変換結果のキャッシュ）
+21. スラッグ（pkg/slugify、かなのローマ字化とハッシュ、旧スラッグからの 301 転送）
+22. フィード（encoding/xml で RSS 2.0 / Atom を生成、ETag による条件付き GET）
+23. 設定とグレースフルシャットダウン（pkg/config、http.Server のタイムアウト、SIGTERM での停止）
+24. ヘルスチェック（pkg/health、liveness と readiness、チェックごとの期限とキャッシュ）
+25. メトリクス（pkg/metrics、Prometheus のテキスト形式、カウンター・ゲージ・ヒストグラム）
+26. 構造化ログ（log/slog、リクエストごとのロガー、秘密の値の伏せ字、実行中のレベル変更）
+27. トレース（pkg/trace、W3C Trace Context の受け渡し、プロセス内のスパン、書き出し先の差し替え）
+28. リクエストボディの厳密なデコード（pkg/jsonbody、Content-Type・大きさの上限・知らないフィールド・エラーの位置）
+29. パスワードの保存（pkg/password、ソルト付き PBKDF2-HMAC-SHA256、平文で保存していたデータの移行）
 */
 
 // ========== データモデル ==========
 
 type User struct {
-	ID        int       `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"` // JSONに含めない
-	CreatedAt time.Time `json:"created_at"`
+	ID            int       `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email,omitempty"` // 本人と admin 以外には redactUser で空にして返す
+	PasswordHash  string    `json:"-"`               // pkg/password のハッシュ。JSONに含めない
+	Role          string    `json:"role"`
+	EmailVerified bool      `json:"email_verified"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	// CredentialVersion はパスワードを変更するたびに増やす番号。
+	// トークンに埋め込んでおき、番号が変わったら発行済みのトークンをまとめて無効にする
+	CredentialVersion int `json:"-"`
 }
 
+// ロール
+const (
+	RoleAuthor = "author" // 投稿の作成とレビュー依頼ができる
+	RoleEditor = "editor" // レビュー（承認/差し戻し）と公開ができる
+	RoleAdmin  = "admin"  // すべての操作ができる
+)
+
+// PostStatus は投稿のワークフロー上の状態
+// draft → in_review → approved → published → archived
+type PostStatus string
+
+const (
+	StatusDraft     PostStatus = "draft"
+	StatusInReview  PostStatus = "in_review"
+	StatusApproved  PostStatus = "approved"
+	StatusPublished PostStatus = "published"
+	StatusArchived  PostStatus = "archived"
+)
+
+// postStatuses はすべての状態（ワークフローの順）。検証やメソッドの説明はここから作る
+var postStatuses = []PostStatus{StatusDraft, StatusInReview, StatusApproved, StatusPublished, StatusArchived}
+
 type Post struct {
+	ID          int        `json:"id"`
+	WorkspaceID int        `json:"workspace_id"`
+	UserID      int        `json:"user_id"`
+	Title       string     `json:"title"`
+	Slug        string     `json:"slug"` // URL 用の名前（ワークスペース内で一意、タイトルを変えても変わらない）
+	Content     string     `json:"content"`
+	Status      PostStatus `json:"status"`
+	Published   bool       `json:"published"`            // Status == published（互換性のため残している）
+	PublishAt   *time.Time `json:"publish_at,omitempty"` // 予約公開日時（承認済みの投稿が対象）
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// setStatus は Status と Published を同時に更新する
+func (p *Post) setStatus(status PostStatus) {
+	p.Status = status
+	p.Published = status == StatusPublished
+}
+
+// PostTransition はワークフローの状態遷移の履歴
+type PostTransition struct {
+	PostID    int        `json:"post_id"`
+	From      PostStatus `json:"from"`
+	To        PostStatus `json:"to"`
+	ActorID   int        `json:"actor_id"` // 0 はスケジューラーによる自動公開
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Comment は投稿へのコメント（WebSocket でリアルタイムに配信する）
+type Comment struct {
 	ID        int       `json:"id"`
+	PostID    int       `json:"post_id"`
 	UserID    int       `json:"user_id"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	Published bool      `json:"published"`
+	Username  string    `json:"username"`
+	Body      string    `json:"body"`
 	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Attachment は投稿の添付ファイル。
+// 本体は内容の SHA-256 をファイル名にして保存するため、同じ内容は1つのファイルを共有する
+type Attachment struct {
+	ID          int       `json:"id"`
+	PostID      int       `json:"post_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"` // 中身から判定した MIME タイプ
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	UploadedBy  int       `json:"uploaded_by"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// サムネイル（画像のみ）。生成はワーカーが非同期に行い、状態は pending → ready / failed と変わる
+	ThumbnailStatus string      `json:"thumbnail_status,omitempty"`
+	Thumbnails      []Thumbnail `json:"thumbnails,omitempty"`
+	ThumbnailError  string      `json:"thumbnail_error,omitempty"`
+}
+
+// Thumbnail は縮小した画像（縦横比を保ったまま設定の枠に収める）
+type Thumbnail struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int64  `json:"size"`
+	URL    string `json:"url"`
+	File   string `json:"file"` // キャッシュのファイル名（サイズの設定が変わったかの判定に使う）
 }
 
 // リクエスト/レスポンス型
@@ -46,20 +200,129 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      User      `json:"user"`
+}
+
+type SocketTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
 }
 
 type CreatePostRequest struct {
-	Title     string `json:"title"`
-	Content   string `json:"content"`
-	Published bool   `json:"published"`
+	Title     string     `json:"title"`
+	Slug      string     `json:"slug,omitempty"` // 省略時はタイトルから作る
+	Content   string     `json:"content"`
+	Published bool       `json:"published"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
 }
 
+// 公開状態の変更は POST /api/posts/{id}/transitions で行う
 type UpdatePostRequest struct {
-	Title     *string `json:"title,omitempty"`
-	Content   *string `json:"content,omitempty"`
-	Published *bool   `json:"published,omitempty"`
+	Title     *string    `json:"title,omitempty"`
+	Slug      *string    `json:"slug,omitempty"` // 変更すると古いスラッグは転送用に残る
+	Content   *string    `json:"content,omitempty"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+}
+
+// APIKey はCIやバッチ処理などの機械クライアント向けの認証情報。
+// キー本体は発行時に一度だけ返し、ストアには SHA-256 ハッシュのみ保存する
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // 一覧で見分けるためのキーの先頭部分
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// スコープ
+const (
+	ScopePostsRead  = "posts:read"
+	ScopePostsWrite = "posts:write"
+	ScopeUsersRead  = "users:read"
+)
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key"` // この一度しか表示されない
+	APIKey APIKey `json:"api_key"`
+}
+
+// Webhook は投稿イベントの通知先
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"` // 署名用の秘密鍵（作成時に一度だけ返す）
+	CreatedBy int       `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Webhook のイベント種別
+const (
+	EventPostCreated   = "post.created"
+	EventPostUpdated   = "post.updated"
+	EventPostPublished = "post.published"
+	EventPostDeleted   = "post.deleted"
+)
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"` // 省略時は自動生成
+}
+
+type CreateWebhookResponse struct {
+	Secret  string  `json:"secret"` // この一度しか表示されない
+	Webhook Webhook `json:"webhook"`
+}
+
+type CreateWorkspaceRequest struct {
+	Slug   string `json:"slug"`
+	Name   string `json:"name"`
+	Public bool   `json:"public"`
+}
+
+type CreateInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// WorkspaceResponse はワークスペースと、リクエストしたユーザーから見た情報
+type WorkspaceResponse struct {
+	workspace.Workspace
+	Role    string                 `json:"role,omitempty"` // 自分のロール
+	Usage   *workspace.Usage       `json:"usage,omitempty"`
+	Members []workspace.Membership `json:"members,omitempty"`
+}
+
+type TransitionRequest struct {
+	To     PostStatus `json:"to"`
+	Reason string     `json:"reason"`
 }
 
 type PaginatedResponse struct {
@@ -78,370 +341,6650 @@ type ErrorResponse struct {
 
 // ========== インメモリストア ==========
 
-var (
-	users = []User{
-		{ID: 1, Username: "太郎", Email: "taro@example.com", Password: "password123", CreatedAt: time.Now()},
-		{ID: 2, Username: "花子", Email: "hanako@example.com", Password: "password123", CreatedAt: time.Now()},
-	}
-	posts = []Post{
-		{ID: 1, UserID: 1, Title: "最初の投稿", Content: "これは最初の投稿です", Published: true, CreatedAt: time.Now(), UpdatedAt: time.Now()},
-		{ID: 2, UserID: 1, Title: "2番目の投稿", Content: "これは2番目の投稿です", Published: true, CreatedAt: time.Now(), UpdatedAt: time.Now()},
-		{ID: 3, UserID: 2, Title: "花子の投稿", Content: "花子の投稿内容", Published: false, CreatedAt: time.Now(), UpdatedAt: time.Now()},
-	}
-	nextUserID = 3
-	nextPostID = 4
-)
+// Store は users / posts を保持する。
+// スケジューラーのgoroutineとハンドラーが同時にアクセスするため Mutex で保護する。
+type Store struct {
+	mu               sync.RWMutex
+	users            []User
+	posts            []Post
+	transitions      []PostTransition
+	comments         []Comment
+	nextCommentID    int
+	apiKeys          []APIKey
+	nextKeyID        int
+	webhooks         []Webhook
+	nextHookID       int
+	nextUserID       int
+	nextPostID       int
+	workspaces       *workspace.Directory
+	attachments      []Attachment
+	nextAttachmentID int
+	slugRedirects    []SlugRedirect
+	dataFile         string // 空ならインメモリのみ（再起動で消える）
+	lastSaveErr      error  // 直前の保存の失敗（成功すると nil に戻る。readiness で使う）
+}
 
-func main() {
-	// ========== ルーティング ==========
+// storeSnapshot はファイルに保存する形式
+type storeSnapshot struct {
+	Users            []userRecord     `json:"users"`
+	Posts            []Post           `json:"posts"`
+	Transitions      []PostTransition `json:"transitions"`
+	Comments         []Comment        `json:"comments"`
+	APIKeys          []apiKeyRecord   `json:"api_keys"`
+	Webhooks         []webhookRecord  `json:"webhooks"`
+	NextUserID       int              `json:"next_user_id"`
+	NextPostID       int              `json:"next_post_id"`
+	NextKeyID        int              `json:"next_key_id"`
+	NextHookID       int              `json:"next_hook_id"`
+	NextCommentID    int              `json:"next_comment_id"`
+	Attachments      []Attachment     `json:"attachments"`
+	NextAttachmentID int              `json:"next_attachment_id"`
+	SlugRedirects    []SlugRedirect   `json:"slug_redirects"`
+	// ワークスペース・メンバー・招待（埋め込むと JSON では同じ階層の workspaces などになる）
+	workspace.State
+}
 
-	// 認証
-	http.HandleFunc("/api/auth/login", loginHandler)
-	http.HandleFunc("/api/auth/register", registerHandler)
+// userRecord は User.PasswordHash と CredentialVersion（json:"-"）も保存するための型
+// Password は平文で保存していた頃のデータを読むためだけに残している（Load でハッシュへ移す）
+type userRecord struct {
+	User
+	PasswordHash      string `json:"password_hash"`
+	CredentialVersion int    `json:"credential_version"`
+	Password          string `json:"password,omitempty"`
+}
 
-	// ユーザー
-	http.HandleFunc("/api/users", usersHandler)
-	http.HandleFunc("/api/users/", userHandler)
+// apiKeyRecord は APIKey.Hash（json:"-"）も保存するための型
+type apiKeyRecord struct {
+	APIKey
+	Hash string `json:"hash"`
+}
 
-	// 投稿
-	http.HandleFunc("/api/posts", postsHandler)
-	http.HandleFunc("/api/posts/", postHandler)
+// webhookRecord は Webhook.Secret（json:"-"）も保存するための型
+type webhookRecord struct {
+	Webhook
+	Secret string `json:"secret"`
+}
 
-	// ヘルスチェック
-	http.HandleFunc("/health", healthHandler)
+var store *Store
 
-	fmt.Println("高度なREST APIサーバー起動: http://localhost:8080")
-	fmt.Println("\nエンドポイント:")
-	fmt.Println("  POST   /api/auth/login     - ログイン")
-	fmt.Println("  POST   /api/auth/register  - ユーザー登録")
-	fmt.Println("  GET    /api/users          - ユーザー一覧（ページネーション）")
-	fmt.Println("  GET    /api/users/{id}     - ユーザー詳細")
-	fmt.Println("  GET    /api/posts          - 投稿一覧（フィルタ、ページネーション）")
-	fmt.Println("  POST   /api/posts          - 投稿作成")
-	fmt.Println("  GET    /api/posts/{id}     - 投稿詳細")
-	fmt.Println("  PUT    /api/posts/{id}     - 投稿更新")
-	fmt.Println("  DELETE /api/posts/{id}     - 投稿削除")
-	fmt.Println("  GET    /health             - ヘルスチェック")
+// defaultWorkspaceID は既定のワークスペース。
+// ワークスペースを指定しないリクエストはここに入り、新規ユーザーも自動で参加する
+const defaultWorkspaceID = 1
+
+// defaultWorkspaceQuota は新しく作るワークスペースの上限（管理者が変更できる）
+var defaultWorkspaceQuota = workspace.Quota{MaxPosts: 100, MaxMembers: 10}
 
-	log.Fatal(http.ListenAndServe(":8080", corsMiddleware(http.DefaultServeMux)))
+func NewStore(dataFile string) *Store {
+	return &Store{
+		users: []User{
+			{ID: 1, Username: "太郎", Email: "taro@example.com", PasswordHash: mustHashPassword("password123"), Role: RoleAdmin, EmailVerified: true, CreatedAt: time.Now()},
+			{ID: 2, Username: "花子", Email: "hanako@example.com", PasswordHash: mustHashPassword("password123"), Role: RoleAuthor, EmailVerified: true, CreatedAt: time.Now()},
+		},
+		posts: []Post{
+			{ID: 1, WorkspaceID: 1, UserID: 1, Title: "最初の投稿", Slug: "first-post", Content: "これは最初の投稿です", Status: StatusPublished, Published: true, CreatedAt: time.Now(), UpdatedAt: time.Now()},
+			{ID: 2, WorkspaceID: 1, UserID: 1, Title: "2番目の投稿", Slug: "second-post", Content: "これは2番目の投稿です", Status: StatusPublished, Published: true, CreatedAt: time.Now(), UpdatedAt: time.Now()},
+			{ID: 3, WorkspaceID: 1, UserID: 2, Title: "花子の投稿", Slug: "hanako-post", Content: "花子の投稿内容", Status: StatusDraft, Published: false, CreatedAt: time.Now(), UpdatedAt: time.Now()},
+			{ID: 4, WorkspaceID: 2, UserID: 2, Title: "チームの下書き", Slug: "team-draft", Content: "メンバーだけが読める投稿です", Status: StatusDraft, Published: false, CreatedAt: time.Now(), UpdatedAt: time.Now()},
+		},
+		workspaces: workspace.Restore(workspace.State{
+			Workspaces: []workspace.Workspace{
+				{ID: 1, Slug: "default", Name: "既定のワークスペース", Public: true, CreatedBy: 1, CreatedAt: time.Now()},
+				{ID: 2, Slug: "hanako-team", Name: "花子のチーム", Quota: defaultWorkspaceQuota, CreatedBy: 2, CreatedAt: time.Now()},
+			},
+			Memberships: []workspace.Membership{
+				{WorkspaceID: 1, UserID: 1, Role: RoleAdmin, JoinedAt: time.Now()},
+				{WorkspaceID: 1, UserID: 2, Role: RoleAuthor, JoinedAt: time.Now()},
+				{WorkspaceID: 2, UserID: 2, Role: RoleAdmin, JoinedAt: time.Now()},
+			},
+		}),
+		nextUserID:       3,
+		nextPostID:       5,
+		nextKeyID:        1,
+		nextHookID:       1,
+		nextCommentID:    1,
+		nextAttachmentID: 1,
+		dataFile:         dataFile,
+	}
 }
 
-// ========== 認証ハンドラー ==========
+// Load はデータファイルから状態を復元する（ファイルがなければ初期データを保存する）
+func (s *Store) Load() error {
+	if s.dataFile == "" {
+		return nil
+	}
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
-		return
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.dataFile)
+	if os.IsNotExist(err) {
+		return s.saveLocked()
+	}
+	if err != nil {
+		return fmt.Errorf("データファイルの読み込みに失敗: %w", err)
 	}
 
-	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, "Invalid request body", http.StatusBadRequest, nil)
-		return
+	var snap storeSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("データファイルの解析に失敗: %w", err)
 	}
 
-	// バリデーション
-	if err := validateLoginRequest(req); err != nil {
-		respondError(w, "Validation failed", http.StatusBadRequest, err)
-		return
+	s.users = make([]User, 0, len(snap.Users))
+	migrated := false
+	for _, rec := range snap.Users {
+		u := rec.User
+		u.PasswordHash = rec.PasswordHash
+		u.CredentialVersion = rec.CredentialVersion
+		// 平文で保存されていたパスワードはハッシュにして、あとでファイルから消す
+		if u.PasswordHash == "" && rec.Password != "" {
+			hash, err := password.Hash(rec.Password)
+			if err != nil {
+				return fmt.Errorf("パスワードのハッシュ化に失敗: %w", err)
+			}
+			u.PasswordHash = hash
+			migrated = true
+		}
+		s.users = append(s.users, u)
+	}
+	s.posts = snap.Posts
+	for i := range s.posts {
+		// status 導入前のデータは published から状態を決める
+		if s.posts[i].Status == "" {
+			if s.posts[i].Published {
+				s.posts[i].setStatus(StatusPublished)
+			} else {
+				s.posts[i].setStatus(StatusDraft)
+			}
+		}
+		// ワークスペース導入前の投稿は既定のワークスペースに入れる
+		if s.posts[i].WorkspaceID == 0 {
+			s.posts[i].WorkspaceID = defaultWorkspaceID
+		}
+	}
+	s.slugRedirects = snap.SlugRedirects
+	s.assignMissingSlugsLocked()
+	s.transitions = snap.Transitions
+	s.comments = snap.Comments
+	s.apiKeys = make([]APIKey, 0, len(snap.APIKeys))
+	for _, rec := range snap.APIKeys {
+		k := rec.APIKey
+		k.Hash = rec.Hash
+		s.apiKeys = append(s.apiKeys, k)
+	}
+	s.nextUserID = snap.NextUserID
+	s.nextPostID = snap.NextPostID
+	s.webhooks = make([]Webhook, 0, len(snap.Webhooks))
+	for _, rec := range snap.Webhooks {
+		h := rec.Webhook
+		h.Secret = rec.Secret
+		s.webhooks = append(s.webhooks, h)
+	}
+	s.nextKeyID = snap.NextKeyID
+	if s.nextKeyID == 0 {
+		s.nextKeyID = 1
+	}
+	s.nextHookID = snap.NextHookID
+	if s.nextHookID == 0 {
+		s.nextHookID = 1
+	}
+	s.nextCommentID = snap.NextCommentID
+	if s.nextCommentID == 0 {
+		s.nextCommentID = 1
 	}
 
-	// ユーザー検索
-	var foundUser *User
-	for i := range users {
-		if users[i].Email == req.Email && users[i].Password == req.Password {
-			foundUser = &users[i]
-			break
+	if len(snap.Workspaces) == 0 {
+		// ワークスペース導入前のデータ: 既定のワークスペースを作り、全ユーザーを元のロールで参加させる
+		snap.Workspaces = []workspace.Workspace{{ID: defaultWorkspaceID, Slug: "default", Name: "既定のワークスペース", Public: true, CreatedAt: time.Now()}}
+		snap.Memberships = nil
+		for _, u := range s.users {
+			snap.Memberships = append(snap.Memberships, workspace.Membership{WorkspaceID: defaultWorkspaceID, UserID: u.ID, Role: u.Role, JoinedAt: time.Now()})
 		}
 	}
+	s.workspaces = workspace.Restore(snap.State)
+	s.attachments = snap.Attachments
+	s.nextAttachmentID = snap.NextAttachmentID
+	if s.nextAttachmentID == 0 {
+		s.nextAttachmentID = 1
+	}
+	if migrated {
+		return s.saveLocked()
+	}
+	return nil
+}
 
-	if foundUser == nil {
-		respondError(w, "Invalid credentials", http.StatusUnauthorized, nil)
-		return
+// saveLocked は現在の状態をファイルに書き出す（呼び出し側でロックを取得しておくこと）
+func (s *Store) saveLocked() error {
+	if s.dataFile == "" {
+		return nil
 	}
 
-	// トークン生成（簡易版）
-	token := fmt.Sprintf("token-%d-%d", foundUser.ID, time.Now().Unix())
+	snap := storeSnapshot{
+		Posts:            s.posts,
+		Transitions:      s.transitions,
+		Comments:         s.comments,
+		NextCommentID:    s.nextCommentID,
+		NextUserID:       s.nextUserID,
+		NextPostID:       s.nextPostID,
+		NextKeyID:        s.nextKeyID,
+		NextHookID:       s.nextHookID,
+		State:            s.workspaces.State(),
+		Attachments:      s.attachments,
+		NextAttachmentID: s.nextAttachmentID,
+		SlugRedirects:    s.slugRedirects,
+	}
+	for _, u := range s.users {
+		snap.Users = append(snap.Users, userRecord{User: u, PasswordHash: u.PasswordHash, CredentialVersion: u.CredentialVersion})
+	}
+	for _, k := range s.apiKeys {
+		snap.APIKeys = append(snap.APIKeys, apiKeyRecord{APIKey: k, Hash: k.Hash})
+	}
+	for _, h := range s.webhooks {
+		snap.Webhooks = append(snap.Webhooks, webhookRecord{Webhook: h, Secret: h.Secret})
+	}
 
-	respondJSON(w, LoginResponse{
-		Token: token,
-		User:  *foundUser,
-	}, http.StatusOK)
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// 一時ファイルに書いてからリネームする（書き込み途中で落ちても壊れない）
+	tmp := s.dataFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.dataFile)
 }
 
-func registerHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
-		return
+func (s *Store) persist() {
+	s.lastSaveErr = s.saveLocked()
+	if s.lastSaveErr != nil {
+		slog.Error("データの保存に失敗しました", "file", s.dataFile, "err", s.lastSaveErr)
 	}
+}
 
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		respondError(w, "Invalid request body", http.StatusBadRequest, nil)
-		return
+// Ping はストアが使えるかを確認する（直前の保存が成功しているか、保存先に書き込めるか）
+func (s *Store) Ping(ctx context.Context) error {
+	if s.dataFile == "" {
+		return nil
 	}
 
-	// バリデーション
-	if err := validateUser(user); err != nil {
-		respondError(w, "Validation failed", http.StatusBadRequest, err)
-		return
+	s.mu.RLock()
+	lastErr := s.lastSaveErr
+	s.mu.RUnlock()
+	if lastErr != nil {
+		return fmt.Errorf("直前の保存に失敗しています: %w", lastErr)
 	}
 
-	// 重複チェック
-	for _, u := range users {
-		if u.Email == user.Email {
-			respondError(w, "Email already exists", http.StatusConflict, nil)
-			return
-		}
+	f, err := os.CreateTemp(filepath.Dir(s.dataFile), ".healthcheck-*")
+	if err != nil {
+		return fmt.Errorf("保存先に書き込めません: %w", err)
 	}
+	f.Close()
+	return os.Remove(f.Name())
+}
 
-	// ユーザー作成
-	user.ID = nextUserID
-	nextUserID++
-	user.CreatedAt = time.Now()
-	users = append(users, user)
+func (s *Store) ListUsers() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]User(nil), s.users...)
+}
 
-	respondJSON(w, user, http.StatusCreated)
+func (s *Store) GetUser(id int) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if u.ID == id {
+			return u, true
+		}
+	}
+	return User{}, false
 }
 
-// ========== ユーザーハンドラー ==========
+// GetUsers は複数のユーザーをまとめて取得する（見つからないIDは結果に含まれない）
+func (s *Store) GetUsers(ids []int) map[int]User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-func usersHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
-		return
+	want := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	users := make(map[int]User, len(ids))
+	for _, u := range s.users {
+		if want[u.ID] {
+			users[u.ID] = u
+		}
 	}
+	return users
+}
 
-	// ページネーション
-	page, perPage := getPagination(r)
+// FindUserByCredentials はメールアドレスとパスワードが一致するユーザーを返す
+// ハッシュの計算は重いので、ロックの外で行う
+func (s *Store) FindUserByCredentials(email, plain string) (User, bool) {
+	u, ok := s.FindUserByEmail(email)
+	if !ok {
+		// 存在しないメールアドレスでも同じだけ計算し、応答時間からユーザーの有無を推測させない
+		password.Verify(dummyPasswordHash(), plain)
+		return User{}, false
+	}
+	if !password.Verify(u.PasswordHash, plain) {
+		return User{}, false
+	}
+	return u, true
+}
 
-	start := (page - 1) * perPage
-	end := start + perPage
+// dummyPasswordHash は存在しないユーザーのログイン試行で照合に使うハッシュ（初回だけ計算する）
+var dummyPasswordHash = sync.OnceValue(func() string {
+	return mustHashPassword("dummy-password")
+})
 
-	if start >= len(users) {
-		respondJSON(w, PaginatedResponse{
-			Data:       []User{},
-			Page:       page,
-			PerPage:    perPage,
-			Total:      len(users),
-			TotalPages: (len(users) + perPage - 1) / perPage,
-		}, http.StatusOK)
-		return
+// mustHashPassword は初期データ用。乱数が取れないときは起動を止める
+func mustHashPassword(plain string) string {
+	hash, err := password.Hash(plain)
+	if err != nil {
+		log.Fatalf("パスワードのハッシュ化に失敗: %v", err)
 	}
+	return hash
+}
 
-	if end > len(users) {
-		end = len(users)
+func (s *Store) FindUserByEmail(email string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if u.Email == email {
+			return u, true
+		}
 	}
-
-	respondJSON(w, PaginatedResponse{
-		Data:       users[start:end],
-		Page:       page,
-		PerPage:    perPage,
-		Total:      len(users),
-		TotalPages: (len(users) + perPage - 1) / perPage,
-	}, http.StatusOK)
+	return User{}, false
 }
 
-func userHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := extractID(r.URL.Path, "/api/users/")
-	if err != nil {
-		respondError(w, "Invalid user ID", http.StatusBadRequest, nil)
-		return
-	}
+// UpdateUser はロックを取得した状態で fn を呼び出し、ユーザーを更新する
+func (s *Store) UpdateUser(id int, fn func(u *User)) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if r.Method != http.MethodGet {
-		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
-		return
+	for i := range s.users {
+		if s.users[i].ID == id {
+			fn(&s.users[i])
+			s.persist()
+			return s.users[i], true
+		}
 	}
+	return User{}, false
+}
 
-	for _, user := range users {
-		if user.ID == id {
-			respondJSON(w, user, http.StatusOK)
-			return
+// CreateUser はユーザーを追加する。メールアドレスが重複していれば false を返す
+func (s *Store) CreateUser(user User) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Email == user.Email {
+			return User{}, false
 		}
 	}
 
-	respondError(w, "User not found", http.StatusNotFound, nil)
+	user.ID = s.nextUserID
+	s.nextUserID++
+	user.CreatedAt = time.Now()
+	s.users = append(s.users, user)
+	// 既定のワークスペースには全員が参加する
+	s.workspaces.Join(workspace.Membership{WorkspaceID: defaultWorkspaceID, UserID: user.ID, Role: user.Role, JoinedAt: user.CreatedAt})
+	s.persist()
+	return user, true
 }
 
-// ========== 投稿ハンドラー ==========
+func (s *Store) CreateAPIKey(key APIKey) APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-func postsHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		getPostsHandler(w, r)
-	case http.MethodPost:
-		createPostHandler(w, r)
-	default:
-		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	key.ID = s.nextKeyID
+	s.nextKeyID++
+	s.apiKeys = append(s.apiKeys, key)
+	s.persist()
+	return key
+}
+
+func (s *Store) ListAPIKeys(userID int) []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []APIKey{}
+	for _, k := range s.apiKeys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
 	}
+	return keys
 }
 
-func getPostsHandler(w http.ResponseWriter, r *http.Request) {
-	// フィルタリング
-	userIDStr := r.URL.Query().Get("user_id")
-	publishedStr := r.URL.Query().Get("published")
+// FindAPIKeyByHash は失効していないキーをハッシュから探す
+func (s *Store) FindAPIKeyByHash(hash string) (APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	filtered := posts
+	for _, k := range s.apiKeys {
+		if k.RevokedAt == nil && hmac.Equal([]byte(k.Hash), []byte(hash)) {
+			return k, true
+		}
+	}
+	return APIKey{}, false
+}
 
-	// ユーザーIDでフィルタ
-	if userIDStr != "" {
-		userID, _ := strconv.Atoi(userIDStr)
-		var temp []Post
-		for _, p := range filtered {
-			if p.UserID == userID {
-				temp = append(temp, p)
-			}
+// RevokeAPIKey は本人のキーを失効させ、失効後のキーを返す
+func (s *Store) RevokeAPIKey(userID, id int) (APIKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.apiKeys {
+		k := &s.apiKeys[i]
+		if k.ID == id && k.UserID == userID && k.RevokedAt == nil {
+			now := time.Now()
+			k.RevokedAt = &now
+			s.persist()
+			return *k, true
 		}
-		filtered = temp
 	}
+	return APIKey{}, false
+}
 
-	// 公開状態でフィルタ
-	if publishedStr != "" {
-		published := publishedStr == "true"
-		var temp []Post
-		for _, p := range filtered {
-			if p.Published == published {
-				temp = append(temp, p)
+// TouchAPIKey は最終使用日時を更新する（毎リクエスト保存しないよう1分単位に間引く）
+func (s *Store) TouchAPIKey(id int, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.apiKeys {
+		k := &s.apiKeys[i]
+		if k.ID == id {
+			if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > time.Minute {
+				k.LastUsedAt = &now
+				s.persist()
 			}
+			return
 		}
-		filtered = temp
 	}
+}
 
-	// ページネーション
-	page, perPage := getPagination(r)
+func (s *Store) CreateWebhook(hook Webhook) Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	start := (page - 1) * perPage
-	end := start + perPage
+	hook.ID = s.nextHookID
+	s.nextHookID++
+	s.webhooks = append(s.webhooks, hook)
+	s.persist()
+	return hook
+}
 
-	if start >= len(filtered) {
-		respondJSON(w, PaginatedResponse{
-			Data:       []Post{},
-			Page:       page,
-			PerPage:    perPage,
-			Total:      len(filtered),
-			TotalPages: (len(filtered) + perPage - 1) / perPage,
-		}, http.StatusOK)
-		return
-	}
+func (s *Store) ListWebhooks() []Webhook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Webhook{}, s.webhooks...)
+}
 
-	if end > len(filtered) {
-		end = len(filtered)
+func (s *Store) GetWebhook(id int) (Webhook, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, h := range s.webhooks {
+		if h.ID == id {
+			return h, true
+		}
 	}
-
-	respondJSON(w, PaginatedResponse{
-		Data:       filtered[start:end],
-		Page:       page,
-		PerPage:    perPage,
-		Total:      len(filtered),
-		TotalPages: (len(filtered) + perPage - 1) / perPage,
-	}, http.StatusOK)
+	return Webhook{}, false
 }
 
-func createPostHandler(w http.ResponseWriter, r *http.Request) {
-	var req CreatePostRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, "Invalid request body", http.StatusBadRequest, nil)
+// WebhooksFor はイベントを購読している Webhook を返す
+func (s *Store) WebhooksFor(event string) []Webhook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var hooks []Webhook
+	for _, h := range s.webhooks {
+		for _, e := range h.Events {
+			if e == event {
+				hooks = append(hooks, h)
+				break
+			}
+		}
+	}
+	return hooks
+}
+
+func (s *Store) DeleteWebhook(id int) (Webhook, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, h := range s.webhooks {
+		if h.ID == id {
+			s.webhooks = append(s.webhooks[:i], s.webhooks[i+1:]...)
+			s.persist()
+			return h, true
+		}
+	}
+	return Webhook{}, false
+}
+
+// PublishDue は公開日時を過ぎた承認済みの予約投稿を公開状態にし、公開した投稿を返す
+func (s *Store) PublishDue(now time.Time) []Post {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var published []Post
+	for i := range s.posts {
+		p := &s.posts[i]
+		if p.Status != StatusApproved || p.PublishAt == nil || p.PublishAt.After(now) {
+			continue
+		}
+		s.transitions = append(s.transitions, PostTransition{
+			PostID:    p.ID,
+			From:      p.Status,
+			To:        StatusPublished,
+			CreatedAt: now,
+		})
+		p.setStatus(StatusPublished)
+		p.UpdatedAt = now
+		published = append(published, *p)
+	}
+
+	if len(published) > 0 {
+		s.persist()
+	}
+	return published
+}
+
+// ========== 投稿リポジトリ（ワークスペース単位） ==========
+
+// PostRepository は1つのワークスペースに限定した投稿・コメントの操作。
+// 投稿へのアクセスはすべてこの型を通すため、他のワークスペースの投稿は
+// ID を直接指定しても「見つからない」扱いになる（テナント間のアクセスを型で防ぐ）
+type PostRepository struct {
+	s           *Store
+	workspaceID int
+	ctx         context.Context // トレースのスパンの親（nil なら記録しない）
+}
+
+// Posts はワークスペースの投稿リポジトリを返す
+func (s *Store) Posts(workspaceID int) *PostRepository {
+	return &PostRepository{s: s, workspaceID: workspaceID}
+}
+
+// WithContext は ctx のトレースに操作ごとのスパンを記録するリポジトリを返す
+func (r *PostRepository) WithContext(ctx context.Context) *PostRepository {
+	return &PostRepository{s: r.s, workspaceID: r.workspaceID, ctx: ctx}
+}
+
+// span はリポジトリの操作1回分のスパンを始める（使い方: defer r.span("Get").End()）
+func (r *PostRepository) span(op string) *trace.Span {
+	_, span := trace.Start(r.ctx, "posts."+op)
+	span.SetAttr("workspace_id", r.workspaceID)
+	return span
+}
+
+// indexLocked はワークスペース内の投稿の位置を返す（なければ -1）
+func (r *PostRepository) indexLocked(id int) int {
+	for i, p := range r.s.posts {
+		if p.ID == id && p.WorkspaceID == r.workspaceID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *PostRepository) List() []Post {
+	defer r.span("List").End()
+
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	posts := []Post{}
+	for _, p := range r.s.posts {
+		if p.WorkspaceID == r.workspaceID {
+			posts = append(posts, p)
+		}
+	}
+	return posts
+}
+
+func (r *PostRepository) Get(id int) (Post, bool) {
+	defer r.span("Get").End()
+
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	if i := r.indexLocked(id); i >= 0 {
+		return r.s.posts[i], true
+	}
+	return Post{}, false
+}
+
+// Create は投稿を追加する。ワークスペースの投稿数の上限を超える場合は *workspace.QuotaError を返す
+func (r *PostRepository) Create(post Post) (Post, error) {
+	defer r.span("Create").End()
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	ws, ok := r.s.workspaces.Get(r.workspaceID)
+	if !ok {
+		return Post{}, workspace.ErrNotFound
+	}
+	if ws.Quota.MaxPosts > 0 && r.s.usageLocked(ws.ID).Posts >= ws.Quota.MaxPosts {
+		return Post{}, &workspace.QuotaError{Field: "max_posts", Limit: ws.Quota.MaxPosts}
+	}
+
+	post.ID = r.s.nextPostID
+	post.WorkspaceID = r.workspaceID
+	if post.Slug == "" {
+		post.Slug = r.s.uniqueSlugLocked(r.workspaceID, post.Title, post.ID)
+	} else if r.s.slugTakenLocked(r.workspaceID, post.Slug, post.ID) {
+		return Post{}, ErrSlugTaken
+	}
+	r.s.nextPostID++
+	r.s.posts = append(r.s.posts, post)
+	r.s.persist()
+	return post, nil
+}
+
+// Update はロックを取得した状態で fn を呼び出し、投稿を更新する。
+// fn がエラーを返した場合は変更を破棄する
+func (r *PostRepository) Update(id int, fn func(p *Post) error) (Post, error) {
+	defer r.span("Update").End()
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	i := r.indexLocked(id)
+	if i < 0 {
+		return Post{}, ErrPostNotFound
+	}
+	updated := r.s.posts[i]
+	if err := fn(&updated); err != nil {
+		return Post{}, err
+	}
+	updated.ID = id
+	updated.WorkspaceID = r.workspaceID // fn でワークスペースを移動させない
+	if old := r.s.posts[i].Slug; updated.Slug != old {
+		if err := r.changeSlugLocked(id, old, updated.Slug); err != nil {
+			return Post{}, err
+		}
+	}
+	updated.UpdatedAt = time.Now()
+	r.s.posts[i] = updated
+	r.s.persist()
+	return updated, nil
+}
+
+// Transition はワークフローのルールを確認したうえで投稿の状態を変更し、履歴を記録する。
+// 変更前と変更後の投稿を返す
+func (r *PostRepository) Transition(id int, to PostStatus, actor User, reason string) (Post, Post, error) {
+	defer r.span("Transition").End()
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	i := r.indexLocked(id)
+	if i < 0 {
+		return Post{}, Post{}, ErrPostNotFound
+	}
+	p := &r.s.posts[i]
+	if err := checkTransition(*p, to, actor, reason); err != nil {
+		return Post{}, Post{}, err
+	}
+
+	before := *p
+	r.s.transitions = append(r.s.transitions, PostTransition{
+		PostID:    p.ID,
+		From:      p.Status,
+		To:        to,
+		ActorID:   actor.ID,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+	p.setStatus(to)
+	p.UpdatedAt = time.Now()
+	r.s.persist()
+	return before, *p, nil
+}
+
+// Transitions は投稿の状態遷移の履歴を古い順に返す
+func (r *PostRepository) Transitions(postID int) ([]PostTransition, bool) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	if r.indexLocked(postID) < 0 {
+		return nil, false
+	}
+	history := []PostTransition{}
+	for _, t := range r.s.transitions {
+		if t.PostID == postID {
+			history = append(history, t)
+		}
+	}
+	return history, true
+}
+
+// Delete は投稿を削除し、削除した投稿と添付ファイルを返す（コメントと添付ファイルのレコードも一緒に削除する）。
+// 添付ファイルの本体は他から参照されている可能性があるので、呼び出し側で cleanupAttachments する
+func (r *PostRepository) Delete(id int) (Post, []Attachment, bool) {
+	defer r.span("Delete").End()
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	i := r.indexLocked(id)
+	if i < 0 {
+		return Post{}, nil, false
+	}
+	p := r.s.posts[i]
+	r.s.posts = append(r.s.posts[:i], r.s.posts[i+1:]...)
+
+	comments := r.s.comments[:0]
+	for _, c := range r.s.comments {
+		if c.PostID != id {
+			comments = append(comments, c)
+		}
+	}
+	r.s.comments = comments
+
+	var removed []Attachment
+	attachments := r.s.attachments[:0]
+	for _, a := range r.s.attachments {
+		if a.PostID == id {
+			removed = append(removed, a)
+		} else {
+			attachments = append(attachments, a)
+		}
+	}
+	r.s.attachments = attachments
+
+	// 削除した投稿の以前のスラッグは、他の投稿が使えるようにする
+	redirects := r.s.slugRedirects[:0]
+	for _, redirect := range r.s.slugRedirects {
+		if redirect.PostID != id {
+			redirects = append(redirects, redirect)
+		}
+	}
+	r.s.slugRedirects = redirects
+
+	r.s.persist()
+	return p, removed, true
+}
+
+// Scheduled は指定ユーザーの公開待ちの予約投稿を公開日時順に返す
+func (r *PostRepository) Scheduled(userID int) []Post {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	scheduled := []Post{}
+	for _, p := range r.s.posts {
+		if p.WorkspaceID == r.workspaceID && p.UserID == userID && p.PublishAt != nil && p.Status != StatusPublished && p.Status != StatusArchived {
+			scheduled = append(scheduled, p)
+		}
+	}
+	sort.Slice(scheduled, func(i, j int) bool {
+		return scheduled[i].PublishAt.Before(*scheduled[j].PublishAt)
+	})
+	return scheduled
+}
+
+// CreateComment はコメントを追加する。投稿がワークスペースになければ false を返す
+func (r *PostRepository) CreateComment(comment Comment) (Comment, bool) {
+	defer r.span("CreateComment").End()
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if r.indexLocked(comment.PostID) < 0 {
+		return Comment{}, false
+	}
+
+	comment.ID = r.s.nextCommentID
+	r.s.nextCommentID++
+	comment.CreatedAt = time.Now()
+	r.s.comments = append(r.s.comments, comment)
+	r.s.persist()
+	return comment, true
+}
+
+// CommentsFor は複数の投稿のコメントをまとめて返す（コメントがない投稿は空スライス、
+// ワークスペース外の投稿は結果に含まれない）
+func (r *PostRepository) CommentsFor(postIDs []int) map[int][]Comment {
+	defer r.span("CommentsFor").End()
+
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	comments := make(map[int][]Comment, len(postIDs))
+	for _, id := range postIDs {
+		if r.indexLocked(id) >= 0 {
+			comments[id] = []Comment{}
+		}
+	}
+	for _, c := range r.s.comments {
+		if list, ok := comments[c.PostID]; ok {
+			comments[c.PostID] = append(list, c)
+		}
+	}
+	return comments
+}
+
+// Comments は投稿のコメントを古い順に返す
+func (r *PostRepository) Comments(postID int) []Comment {
+	return r.CommentsFor([]int{postID})[postID]
+}
+
+// AddAttachment は添付ファイルを記録する。投稿がワークスペースになければ false を返す
+func (r *PostRepository) AddAttachment(a Attachment) (Attachment, bool) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if r.indexLocked(a.PostID) < 0 {
+		return Attachment{}, false
+	}
+
+	a.ID = r.s.nextAttachmentID
+	r.s.nextAttachmentID++
+	r.s.attachments = append(r.s.attachments, a)
+	r.s.persist()
+	return a, true
+}
+
+// Attachments は投稿の添付ファイルを古い順に返す
+func (r *PostRepository) Attachments(postID int) ([]Attachment, bool) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	if r.indexLocked(postID) < 0 {
+		return nil, false
+	}
+	list := []Attachment{}
+	for _, a := range r.s.attachments {
+		if a.PostID == postID {
+			list = append(list, a)
+		}
+	}
+	return list, true
+}
+
+func (r *PostRepository) Attachment(postID, id int) (Attachment, bool) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	if r.indexLocked(postID) < 0 {
+		return Attachment{}, false
+	}
+	for _, a := range r.s.attachments {
+		if a.ID == id && a.PostID == postID {
+			return a, true
+		}
+	}
+	return Attachment{}, false
+}
+
+func (r *PostRepository) DeleteAttachment(postID, id int) (Attachment, bool) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if r.indexLocked(postID) < 0 {
+		return Attachment{}, false
+	}
+	for i, a := range r.s.attachments {
+		if a.ID == id && a.PostID == postID {
+			r.s.attachments = append(r.s.attachments[:i], r.s.attachments[i+1:]...)
+			r.s.persist()
+			return a, true
+		}
+	}
+	return Attachment{}, false
+}
+
+// BlobInUse はその内容（SHA-256）を参照している添付ファイルがあるか。
+// 同じ内容は投稿やワークスペースをまたいで1つのファイルを共有するため、全体を調べる
+func (s *Store) BlobInUse(sha string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, a := range s.attachments {
+		if a.SHA256 == sha {
+			return true
+		}
+	}
+	return false
+}
+
+// ========== スラッグ ==========
+
+// ErrSlugTaken はワークスペース内ですでに使われているスラッグ
+var ErrSlugTaken = errors.New("このスラッグは使われています")
+
+// SlugRedirect は投稿の以前のスラッグ。古い URL から現在のスラッグへ 301 で転送するために残す
+type SlugRedirect struct {
+	WorkspaceID int       `json:"workspace_id"`
+	Slug        string    `json:"slug"`
+	PostID      int       `json:"post_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// slugTakenLocked はワークスペース内で、他の投稿がそのスラッグを（現在または以前に）使っているか。
+// 以前のスラッグも使えなくするのは、古いリンクの転送先が別の投稿に変わらないようにするため
+func (s *Store) slugTakenLocked(workspaceID int, slug string, postID int) bool {
+	for _, p := range s.posts {
+		if p.WorkspaceID == workspaceID && p.Slug == slug && p.ID != postID {
+			return true
+		}
+	}
+	for _, r := range s.slugRedirects {
+		if r.WorkspaceID == workspaceID && r.Slug == slug && r.PostID != postID {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueSlugLocked はタイトルからスラッグを作り、使われていれば -2, -3... を付ける
+func (s *Store) uniqueSlugLocked(workspaceID int, title string, postID int) string {
+	base := slugify.Make(title)
+	slug := base
+	for n := 2; s.slugTakenLocked(workspaceID, slug, postID); n++ {
+		suffix := fmt.Sprintf("-%d", n)
+		slug = strings.TrimRight(base[:min(len(base), slugify.MaxLength-len(suffix))], "-") + suffix
+	}
+	return slug
+}
+
+// assignMissingSlugsLocked はスラッグ導入前の投稿にスラッグを付ける
+func (s *Store) assignMissingSlugsLocked() {
+	for i := range s.posts {
+		if s.posts[i].Slug == "" {
+			s.posts[i].Slug = s.uniqueSlugLocked(s.posts[i].WorkspaceID, s.posts[i].Title, s.posts[i].ID)
+		}
+	}
+}
+
+// FindBySlug はスラッグで投稿を探す。
+// 以前のスラッグで見つかった場合は moved が true になる（呼び出し側で現在のスラッグへ転送する）
+func (r *PostRepository) FindBySlug(slug string) (post Post, moved bool, ok bool) {
+	defer r.span("FindBySlug").End()
+
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, p := range r.s.posts {
+		if p.WorkspaceID == r.workspaceID && p.Slug == slug {
+			return p, false, true
+		}
+	}
+	for _, redirect := range r.s.slugRedirects {
+		if redirect.WorkspaceID == r.workspaceID && redirect.Slug == slug {
+			if i := r.indexLocked(redirect.PostID); i >= 0 {
+				return r.s.posts[i], true, true
+			}
+		}
+	}
+	return Post{}, false, false
+}
+
+// SlugHistory は投稿の以前のスラッグを古い順に返す
+func (r *PostRepository) SlugHistory(postID int) []SlugRedirect {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	history := []SlugRedirect{}
+	for _, redirect := range r.s.slugRedirects {
+		if redirect.PostID == postID && redirect.WorkspaceID == r.workspaceID {
+			history = append(history, redirect)
+		}
+	}
+	return history
+}
+
+// changeSlugLocked はスラッグを変更し、古いスラッグを転送用の履歴に残す。
+// 以前使っていたスラッグに戻す場合は、その履歴を消す
+func (r *PostRepository) changeSlugLocked(postID int, from, to string) error {
+	if r.s.slugTakenLocked(r.workspaceID, to, postID) {
+		return ErrSlugTaken
+	}
+	redirects := r.s.slugRedirects[:0]
+	for _, redirect := range r.s.slugRedirects {
+		if !(redirect.PostID == postID && redirect.Slug == to) {
+			redirects = append(redirects, redirect)
+		}
+	}
+	r.s.slugRedirects = append(redirects, SlugRedirect{
+		WorkspaceID: r.workspaceID,
+		Slug:        from,
+		PostID:      postID,
+		CreatedAt:   time.Now(),
+	})
+	return nil
+}
+
+// ========== ワークスペース ==========
+
+// ワークスペース・メンバー・招待は pkg/workspace の Directory が持つ。
+// Directory は自分では排他しないので、投稿数の上限の確認と同じ s.mu の中で使い、変更したら保存する
+
+func (s *Store) usageLocked(workspaceID int) workspace.Usage {
+	usage := workspace.Usage{Members: s.workspaces.MemberCount(workspaceID)}
+	for _, p := range s.posts {
+		if p.WorkspaceID == workspaceID {
+			usage.Posts++
+		}
+	}
+	return usage
+}
+
+func (s *Store) GetWorkspace(id int) (workspace.Workspace, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.workspaces.Get(id)
+}
+
+func (s *Store) FindWorkspaceBySlug(slug string) (workspace.Workspace, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.workspaces.FindBySlug(slug)
+}
+
+// WorkspacesFor はユーザーが所属するワークスペースと、それぞれのロールを返す
+func (s *Store) WorkspacesFor(userID int) []WorkspaceResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []WorkspaceResponse{}
+	for _, m := range s.workspaces.MembershipsOf(userID) {
+		if ws, ok := s.workspaces.Get(m.WorkspaceID); ok {
+			list = append(list, WorkspaceResponse{Workspace: ws, Role: m.Role})
+		}
+	}
+	return list
+}
+
+// CreateWorkspace はワークスペースを作成し、作成者を admin として参加させる
+func (s *Store) CreateWorkspace(ws workspace.Workspace) (workspace.Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ws, err := s.workspaces.Create(ws, RoleAdmin)
+	if err != nil {
+		return workspace.Workspace{}, err
+	}
+	s.persist()
+	return ws, nil
+}
+
+// UpdateWorkspaceQuota は上限を変更し、変更前と変更後を返す
+func (s *Store) UpdateWorkspaceQuota(id int, quota workspace.Quota) (workspace.Workspace, workspace.Workspace, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, after, ok := s.workspaces.UpdateQuota(id, quota)
+	if ok {
+		s.persist()
+	}
+	return before, after, ok
+}
+
+// MemberRole はワークスペース内でのロールを返す（メンバーでなければ false）
+func (s *Store) MemberRole(workspaceID, userID int) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.workspaces.Role(workspaceID, userID)
+}
+
+func (s *Store) Members(workspaceID int) []workspace.Membership {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.workspaces.Members(workspaceID)
+}
+
+func (s *Store) WorkspaceUsage(workspaceID int) workspace.Usage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.usageLocked(workspaceID)
+}
+
+// CreateInvitation は招待を記録する。メンバー数がすでに上限なら *workspace.QuotaError を返す
+func (s *Store) CreateInvitation(inv workspace.Invitation) (workspace.Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inv, err := s.workspaces.Invite(inv)
+	if err != nil {
+		return workspace.Invitation{}, err
+	}
+	s.persist()
+	return inv, nil
+}
+
+// PendingInvitations は未承諾で有効期限内の招待を返す
+func (s *Store) PendingInvitations(workspaceID int, now time.Time) []workspace.Invitation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.workspaces.PendingInvitations(workspaceID, now)
+}
+
+// AcceptInvitation は招待を承諾してメンバーに追加する
+func (s *Store) AcceptInvitation(id int, user User, now time.Time) (workspace.Invitation, workspace.Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inv, m, err := s.workspaces.Accept(id, user.ID, user.Email, now)
+	if err != nil {
+		return workspace.Invitation{}, workspace.Membership{}, err
+	}
+	s.persist()
+	return inv, m, nil
+}
+
+// ========== ワークフロー ==========
+
+var (
+	ErrPostNotFound      = errors.New("投稿が見つかりません")
+	ErrInvalidTransition = errors.New("この状態遷移はできません")
+	ErrForbidden         = errors.New("この操作を行う権限がありません")
+	ErrReasonRequired    = errors.New("差し戻しには理由が必要です")
+	ErrAlreadyPublished  = errors.New("公開済みの投稿は予約できません")
+)
+
+// transitionRule は1つの状態遷移とそれを実行できるロール
+type transitionRule struct {
+	From          PostStatus
+	To            PostStatus
+	Roles         []string
+	AllowOwner    bool // 投稿者本人も実行できる
+	RequireReason bool
+}
+
+var transitionRules = []transitionRule{
+	{From: StatusDraft, To: StatusInReview, Roles: []string{RoleEditor, RoleAdmin}, AllowOwner: true},
+	{From: StatusInReview, To: StatusDraft, Roles: []string{RoleEditor, RoleAdmin}, RequireReason: true}, // 差し戻し
+	{From: StatusInReview, To: StatusApproved, Roles: []string{RoleEditor, RoleAdmin}},
+	{From: StatusApproved, To: StatusPublished, Roles: []string{RoleEditor, RoleAdmin}},
+	{From: StatusPublished, To: StatusArchived, Roles: []string{RoleEditor, RoleAdmin}, AllowOwner: true},
+}
+
+func checkTransition(post Post, to PostStatus, actor User, reason string) error {
+	for _, rule := range transitionRules {
+		if rule.From != post.Status || rule.To != to {
+			continue
+		}
+
+		allowed := rule.AllowOwner && post.UserID == actor.ID
+		for _, role := range rule.Roles {
+			if actor.Role == role {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return ErrForbidden
+		}
+
+		if rule.RequireReason && strings.TrimSpace(reason) == "" {
+			return ErrReasonRequired
+		}
+		return nil
+	}
+	return ErrInvalidTransition
+}
+
+// canPublish は作成時にいきなり公開できるロールかどうか
+func canPublish(user User) bool {
+	return user.Role == RoleEditor || user.Role == RoleAdmin
+}
+
+// canEditPost は投稿を編集・削除できるか（投稿者本人、またはワークスペースの editor / admin）
+func canEditPost(user User, post Post) bool {
+	return post.UserID == user.ID || canPublish(user)
+}
+
+// canViewPost は投稿を読めるか。公開済みは誰でも読めるが、下書き・レビュー中・承認済み・予約・アーカイブは
+// 投稿者本人とワークスペースの editor / admin だけ。投稿を返す経路（REST・SSE・GraphQL・JSON-RPC）はすべてここを通す
+func canViewPost(ws workspace.Workspace, user User, post Post) bool {
+	if post.Status == StatusPublished {
+		return true
+	}
+	if user.ID != 0 && post.UserID == user.ID {
+		return true
+	}
+	member, ok := memberAs(ws, user)
+	return ok && canPublish(member)
+}
+
+// visiblePost はリクエストのユーザーが読める投稿を返す。
+// 読めない投稿は存在を知られないよう、見つからない場合と同じく false を返す
+func visiblePost(r *http.Request, id int) (Post, bool) {
+	post, ok := postsFor(r).Get(id)
+	if !ok {
+		return Post{}, false
+	}
+	user, _ := currentUser(r)
+	return post, canViewPost(currentWorkspace(r), user, post)
+}
+
+// visiblePosts はリクエストのワークスペースの投稿のうち、ユーザーが読めるものを返す
+func visiblePosts(r *http.Request) []Post {
+	ws := currentWorkspace(r)
+	user, _ := currentUser(r)
+	var visible []Post
+	for _, p := range postsFor(r).List() {
+		if canViewPost(ws, user, p) {
+			visible = append(visible, p)
+		}
+	}
+	return visible
+}
+
+// canSeeEmail は viewer に user のメールアドレスを見せてよいか（本人とインスタンスの admin だけ）
+func canSeeEmail(viewer, user User) bool {
+	return viewer.ID != 0 && (viewer.ID == user.ID || viewer.Role == RoleAdmin)
+}
+
+// redactUser は viewer に見せられない項目（メールアドレス）を空にしたユーザーを返す
+func redactUser(viewer, user User) User {
+	if !canSeeEmail(viewer, user) {
+		user.Email = ""
+	}
+	return user
+}
+
+func isValidStatus(status PostStatus) bool {
+	for _, st := range postStatuses {
+		if st == status {
+			return true
+		}
+	}
+	return false
+}
+
+// ========== メール送信 ==========
+
+// newMailer は設定（mail.backend）から Mailer を選ぶ
+//
+//	smtp  mail.smtp_addr, mail.from, mail.username, mail.password
+//	log   mail.log_file（省略時は標準ログ）
+func newMailer(cfg MailConfig) mail.Mailer {
+	if cfg.Backend == "smtp" {
+		return mail.NewSMTPMailer(cfg.SMTPAddr, cfg.From, cfg.Username, cfg.Password)
+	}
+	return mail.NewLogMailer(cfg.LogFile)
+}
+
+// sendMailAsync はレスポンスを遅らせないようにバックグラウンドで送信する
+func sendMailAsync(m mail.Mail) {
+	go func() {
+		if err := mailer.Send(m); err != nil {
+			slog.Error("メール送信に失敗しました", "email", m.To, "subject", m.Subject, "err", err)
+		}
+	}()
+}
+
+// publicLink は server.public_url を基準にした絶対 URL を返す。
+// メールやフィードのリンクを Host ヘッダーから作ると、偽の Host を送るだけで攻撃者のサイトへのリンクにできてしまう
+func publicLink(path string) string {
+	return publicURL + path
+}
+
+// ========== 署名付きトークン ==========
+
+const (
+	tokenPurposeSession = "session"
+	tokenPurposeSocket  = "ws"
+	tokenPurposeVerify  = "verify"
+	tokenPurposeReset   = "reset"
+	tokenPurposeInvite  = "invite"
+)
+
+// newTokenSignerFromSecret は auth.token_secret から署名鍵を作る（未設定なら起動ごとにランダム）
+func newTokenSignerFromSecret(secret string) *signedtoken.Signer {
+	if secret != "" {
+		return signedtoken.NewSigner([]byte(secret))
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatal(err)
+	}
+	slog.Warn("auth.token_secret（TOKEN_SECRET）が未設定のためランダムな鍵を使用します（再起動でトークンは無効になります）")
+	return signedtoken.NewSigner(key)
+}
+
+// credentialVersion はトークンの追加情報に入れる資格情報のバージョン。
+// パスワードそのものやハッシュは入れない（トークンの中身は誰でも base64 を戻せば読める）
+func credentialVersion(user User) string {
+	return strconv.Itoa(user.CredentialVersion)
+}
+
+// ========== ブルートフォース対策 ==========
+
+// AttemptPolicy は失敗回数に応じた待ち時間とロックの設定
+type AttemptPolicy struct {
+	FreeAttempts int           // この回数までは待ち時間なし
+	BaseDelay    time.Duration // 以降は BaseDelay * 2^n 待たせる
+	MaxDelay     time.Duration
+	MaxFailures  int // この回数でロックする（0ならロックしない）
+	LockDuration time.Duration
+	Window       time.Duration // 最後の失敗からこの時間が経てばカウントをリセット
+}
+
+type attemptEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// AttemptTracker はキー（メールアドレスやIP）ごとの失敗回数を記録する。
+// 複数のリクエストから同時に呼ばれるため Mutex で保護する
+type AttemptTracker struct {
+	mu        sync.Mutex
+	policy    AttemptPolicy
+	entries   map[string]*attemptEntry
+	lastPrune time.Time
+}
+
+func NewAttemptTracker(policy AttemptPolicy) *AttemptTracker {
+	return &AttemptTracker{
+		policy:    policy,
+		entries:   make(map[string]*attemptEntry),
+		lastPrune: time.Now(),
+	}
+}
+
+// Begin はログイン試行を開始する。ブロック中なら待ち時間と false を返す。
+// 同時に大量のリクエストが来てもすり抜けられないよう、試行は先に「失敗」として数えておき、
+// 成功したら Succeeded で取り消す
+func (t *AttemptTracker) Begin(key string, now time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pruneLocked(now)
+
+	e, ok := t.entries[key]
+	if !ok {
+		e = &attemptEntry{}
+		t.entries[key] = e
+	}
+
+	if now.Sub(e.lastFailure) > t.policy.Window && now.After(e.lockedUntil) {
+		e.failures = 0
+	}
+
+	if now.Before(e.lockedUntil) {
+		return e.lockedUntil.Sub(now), false
+	}
+	if next := e.lastFailure.Add(t.delayLocked(e.failures)); now.Before(next) {
+		return next.Sub(now), false
+	}
+
+	e.failures++
+	e.lastFailure = now
+	if t.policy.MaxFailures > 0 && e.failures >= t.policy.MaxFailures {
+		e.lockedUntil = now.Add(t.policy.LockDuration)
+		slog.Warn("ログイン試行が多すぎるためロックしました", "key", key, "until", e.lockedUntil)
+	}
+	return 0, true
+}
+
+// Succeeded は Begin で数えた失敗を取り消す
+func (t *AttemptTracker) Succeeded(key string, reset bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok {
+		return
+	}
+	if reset {
+		delete(t.entries, key)
+		return
+	}
+	if e.failures > 0 {
+		e.failures--
+	}
+}
+
+// Unlock はロックと失敗回数をリセットする（管理者用）
+func (t *AttemptTracker) Unlock(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.entries[key]
+	delete(t.entries, key)
+	return ok
+}
+
+func (t *AttemptTracker) delayLocked(failures int) time.Duration {
+	n := failures - t.policy.FreeAttempts
+	if n <= 0 {
+		return 0
+	}
+	delay := t.policy.BaseDelay
+	for i := 1; i < n && delay < t.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.policy.MaxDelay {
+		delay = t.policy.MaxDelay
+	}
+	return delay
+}
+
+// pruneLocked は期限切れのエントリを定期的に削除する（メモリリーク防止）
+func (t *AttemptTracker) pruneLocked(now time.Time) {
+	if now.Sub(t.lastPrune) < t.policy.Window {
+		return
+	}
+	t.lastPrune = now
+	for key, e := range t.entries {
+		if now.Sub(e.lastFailure) > t.policy.Window && now.After(e.lockedUntil) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+var (
+	// アカウント単位: 3回まで即時、以降は待ち時間が倍々に増え、5回でロック
+	accountAttempts = NewAttemptTracker(AttemptPolicy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		MaxFailures:  5,
+		LockDuration: 15 * time.Minute,
+		Window:       15 * time.Minute,
+	})
+
+	// IP単位: 複数アカウントへの総当たり対策。ロックはせずバックオフのみ
+	ipAttempts = NewAttemptTracker(AttemptPolicy{
+		FreeAttempts: 10,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		Window:       15 * time.Minute,
+	})
+)
+
+func accountAttemptKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ========== 認証（トークン / APIキー） ==========
+
+// Principal はリクエストの認証主体
+type Principal struct {
+	User   User
+	APIKey *APIKey // APIキーで認証された場合のみ
+}
+
+// HasScope はスコープを持つか。ログイントークンの場合は制限なし
+func (p Principal) HasScope(scope string) bool {
+	if p.APIKey == nil {
+		return true
+	}
+	for _, s := range p.APIKey.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+var validScopes = map[string]bool{
+	ScopePostsRead:  true,
+	ScopePostsWrite: true,
+	ScopeUsersRead:  true,
+}
+
+// generateAPIKey はランダムなキーを生成し、キー本体・表示用の先頭部分・ハッシュを返す
+func generateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 24)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	key = "sk_" + hex.EncodeToString(b)
+	return key, key[:11], hashAPIKey(key), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyFromRequest は X-API-Key または Authorization: ApiKey ... からキーを取り出す
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey "); ok {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
+
+// bearerToken は Authorization: Bearer ... からトークンを取り出す
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return strings.TrimSpace(token), ok
+}
+
+// issueSessionToken はログイントークンを発行する。
+// 追加情報に資格情報のバージョンを入れておくと、パスワードを変更した時点で古いトークンは使えなくなる
+func issueSessionToken(user User) (string, time.Time) {
+	expiresAt := time.Now().Add(sessionTTL)
+	return tokens.Sign(tokenPurposeSession, user.ID, credentialVersion(user), sessionTTL), expiresAt
+}
+
+// socketTokenTTL は WebSocket 用トークンの有効期間。
+// URL に入るトークンはアクセスログやブラウザの履歴に残るので、接続に使う間だけ有効にする
+const socketTokenTTL = time.Minute
+
+// issueSocketToken は WebSocket の接続（?token=）だけに使える短いトークンを発行する
+func issueSocketToken(user User) (string, time.Time) {
+	expiresAt := time.Now().Add(socketTokenTTL)
+	return tokens.Sign(tokenPurposeSocket, user.ID, credentialVersion(user), socketTokenTTL), expiresAt
+}
+
+// userFromToken は署名・用途・有効期限を確認してユーザーを返す。
+// 署名のない古い形式（token-{ID}-{時刻}）は誰でも作れるので受け付けない
+func userFromToken(token, purpose string) (User, error) {
+	userID, version, err := tokens.Verify(token, purpose)
+	if err != nil {
+		return User{}, err
+	}
+	user, ok := store.GetUser(userID)
+	if !ok || version != credentialVersion(user) {
+		return User{}, signedtoken.ErrInvalid
+	}
+	return user, nil
+}
+
+// requiredScope はAPIキーでアクセスする際に必要なスコープを返す。
+// false の場合はAPIキーではアクセスできないエンドポイント
+func requiredScope(r *http.Request) (string, bool) {
+	path := r.URL.Path
+	readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	switch {
+	case path == "/api/posts" || strings.HasPrefix(path, "/api/posts/"):
+		if readOnly {
+			return ScopePostsRead, true
+		}
+		return ScopePostsWrite, true
+	case path == "/api/users" || strings.HasPrefix(path, "/api/users/"):
+		if readOnly {
+			return ScopeUsersRead, true
+		}
+	}
+	return "", false
+}
+
+// authMiddleware はログイントークンまたはAPIキーで認証し、Principal をコンテキストに入れる。
+// 認証情報がなければそのまま次へ（認証が必要かどうかは各ハンドラーが判断する）
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 認証にかかった時間だけを計るため、次のハンドラーを呼ぶ前に End する（エラーで返るときは defer で）
+		_, span := trace.Start(r.Context(), "auth")
+		defer span.End()
+
+		var principal *Principal
+
+		if key := apiKeyFromRequest(r); key != "" {
+			apiKey, ok := store.FindAPIKeyByHash(hashAPIKey(key))
+			if !ok {
+				respondError(w, "Invalid API key", http.StatusUnauthorized, nil)
+				return
+			}
+			owner, ok := store.GetUser(apiKey.UserID)
+			if !ok {
+				respondError(w, "Invalid API key", http.StatusUnauthorized, nil)
+				return
+			}
+
+			scope, allowed := requiredScope(r)
+			if !allowed {
+				respondError(w, "This endpoint cannot be accessed with an API key", http.StatusForbidden, nil)
+				return
+			}
+			p := Principal{User: owner, APIKey: &apiKey}
+			if !p.HasScope(scope) {
+				respondError(w, "API key lacks required scope", http.StatusForbidden, map[string]string{
+					"scope": scope,
+				})
+				return
+			}
+
+			store.TouchAPIKey(apiKey.ID, time.Now())
+			principal = &p
+		} else if token, ok := bearerToken(r); ok {
+			// 不正・期限切れのトークンは匿名として通さず、付け直しを促す
+			user, err := userFromToken(token, tokenPurposeSession)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				respondError(w, "Invalid or expired token", http.StatusUnauthorized, nil)
+				return
+			}
+			principal = &Principal{User: user}
+		} else if strings.HasPrefix(r.URL.Path, "/ws/") {
+			// ブラウザの WebSocket API はヘッダーを付けられないため、クエリの ?token= も受け付ける。
+			// URL に残っても困らないよう、POST /api/auth/ws-token で発行した短い WebSocket 用トークンだけを使える
+			if user, err := userFromToken(r.URL.Query().Get("token"), tokenPurposeSocket); err == nil {
+				principal = &Principal{User: user}
+			}
+		}
+
+		if principal != nil {
+			annotateLog(r.Context(), "user_id", principal.User.ID)
+			span.SetAttr("user_id", principal.User.ID)
+			if principal.APIKey != nil {
+				annotateLog(r.Context(), "api_key_id", principal.APIKey.ID)
+				span.SetAttr("api_key_id", principal.APIKey.ID)
+			}
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, *principal))
+		}
+		span.End()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ========== ワークスペースの選択 ==========
+
+type workspaceKey struct{}
+
+// workspaceMiddleware は /w/{slug}/... のパス、または X-Workspace ヘッダーからワークスペースを選び、
+// コンテキストに入れる。どちらもなければ既定のワークスペース。
+// パスの接頭辞は取り除いてから次へ渡すので、以降のルーティングや認証は接頭辞を意識しなくてよい
+func workspaceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slug := r.Header.Get("X-Workspace")
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/w/"); ok {
+			var path string
+			slug, path, _ = strings.Cut(rest, "/")
+			r = r.Clone(r.Context())
+			r.URL.Path = "/" + path
+			r.URL.RawPath = ""
+		}
+
+		ws, ok := store.GetWorkspace(defaultWorkspaceID)
+		if slug != "" {
+			ws, ok = store.FindWorkspaceBySlug(slug)
+		}
+		if !ok {
+			respondError(w, "Workspace not found", http.StatusNotFound, nil)
+			return
+		}
+
+		if ws.ID != defaultWorkspaceID {
+			annotateLog(r.Context(), "workspace", ws.Slug)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), workspaceKey{}, ws)))
+	})
+}
+
+// requireWorkspaceAccess は非公開のワークスペースをメンバー以外から隠す（authMiddleware の内側で使う）。
+// 存在を知られないよう、メンバーでない場合も 404 を返す
+func requireWorkspaceAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws := currentWorkspace(r)
+		if !ws.Public {
+			user, _ := currentUser(r)
+			if _, ok := memberAs(ws, user); !ok {
+				respondError(w, "Workspace not found", http.StatusNotFound, nil)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// currentWorkspace はリクエストで選ばれたワークスペースを返す
+func currentWorkspace(r *http.Request) workspace.Workspace {
+	if ws, ok := r.Context().Value(workspaceKey{}).(workspace.Workspace); ok {
+		return ws
+	}
+	ws, _ := store.GetWorkspace(defaultWorkspaceID)
+	return ws
+}
+
+// postsFor はリクエストのワークスペースに限定した投稿リポジトリを返す
+func postsFor(r *http.Request) *PostRepository {
+	return store.Posts(currentWorkspace(r).ID).WithContext(r.Context())
+}
+
+// memberAs はワークスペース内のロールに置き換えたユーザーを返す（メンバーでなければ false）。
+// ワークフローの権限チェックはこのロールで行う。インスタンス全体の admin はどのワークスペースでも admin
+func memberAs(ws workspace.Workspace, user User) (User, bool) {
+	if user.ID == 0 {
+		return User{}, false
+	}
+	if user.Role == RoleAdmin {
+		return user, true
+	}
+	role, ok := store.MemberRole(ws.ID, user.ID)
+	if !ok {
+		return User{}, false
+	}
+	user.Role = role
+	return user, true
+}
+
+// ========== 監査ログ ==========
+
+// FieldChange は1フィールドの変更前後の値
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditEntry は「誰が・いつ・何を・どう変えたか」の記録
+type AuditEntry struct {
+	ID           int                    `json:"id"`
+	Time         time.Time              `json:"time"`
+	ActorID      int                    `json:"actor_id"` // 0 は未認証またはシステム（スケジューラー）
+	APIKeyID     int                    `json:"api_key_id,omitempty"`
+	Action       string                 `json:"action"` // 例: post.create, post.transition
+	ResourceType string                 `json:"resource_type"`
+	ResourceID   int                    `json:"resource_id"`
+	Changes      map[string]FieldChange `json:"changes,omitempty"`
+	RequestID    string                 `json:"request_id,omitempty"`
+	ClientIP     string                 `json:"client_ip,omitempty"`
+}
+
+// AuditFilter は監査ログの検索条件（ゼロ値の項目は条件にしない）
+type AuditFilter struct {
+	ActorID      int
+	Action       string
+	ResourceType string
+	ResourceID   int
+	Since        time.Time
+	Until        time.Time
+}
+
+func (f AuditFilter) Match(e AuditEntry) bool {
+	if f.ActorID != 0 && e.ActorID != f.ActorID {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if f.ResourceType != "" && e.ResourceType != f.ResourceType {
+		return false
+	}
+	if f.ResourceID != 0 && e.ResourceID != f.ResourceID {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// AuditLog は追記専用の監査ログ。
+// JSON Lines 形式（1行1エントリ）でファイルに追記し、起動時に読み込み直す
+type AuditLog struct {
+	mu      sync.RWMutex
+	entries []AuditEntry
+	file    *os.File
+	nextID  int
+}
+
+func OpenAuditLog(path string) (*AuditLog, error) {
+	a := &AuditLog{nextID: 1}
+
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var e AuditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				// 書き込み途中で落ちた行などは読み飛ばす
+				slog.Warn("監査ログの不正な行を読み飛ばしました", "err", err)
+				continue
+			}
+			a.entries = append(a.entries, e)
+			if e.ID >= a.nextID {
+				a.nextID = e.ID + 1
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("監査ログの読み込みに失敗: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a.file = f
+	return a, nil
+}
+
+// Record はエントリを追記する（ファイルへの書き込みが終わってから返る）
+func (a *AuditLog) Record(e AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e.ID = a.nextID
+	a.nextID++
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = a.file.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = a.file.Sync()
+	}
+	if err != nil {
+		slog.Error("監査ログの書き込みに失敗しました", "err", err)
+	}
+
+	a.entries = append(a.entries, e)
+}
+
+// Query は条件に合うエントリを新しい順に返す（ページネーション付き）
+func (a *AuditLog) Query(filter AuditFilter, page, perPage int) ([]AuditEntry, int) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	matched := []AuditEntry{}
+	for i := len(a.entries) - 1; i >= 0; i-- {
+		if filter.Match(a.entries[i]) {
+			matched = append(matched, a.entries[i])
+		}
+	}
+
+	start := (page - 1) * perPage
+	if start >= len(matched) {
+		return []AuditEntry{}, len(matched)
+	}
+	end := start + perPage
+	if end > len(matched) {
+		end = len(matched)
+	}
+	return matched[start:end], len(matched)
+}
+
+func (a *AuditLog) Close() error {
+	return a.file.Close()
+}
+
+// diffFields は2つの値をJSONのフィールド単位で比較し、変わったフィールドだけを返す。
+// before / after のどちらかが nil の場合は作成・削除として扱う
+func diffFields(before, after interface{}) map[string]FieldChange {
+	b := toFieldMap(before)
+	a := toFieldMap(after)
+
+	changes := make(map[string]FieldChange)
+	for k, bv := range b {
+		if av, ok := a[k]; !ok || !reflect.DeepEqual(bv, av) {
+			changes[k] = FieldChange{Before: bv, After: a[k]}
+		}
+	}
+	for k, av := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = FieldChange{Before: nil, After: av}
+		}
+	}
+	return changes
+}
+
+func toFieldMap(v interface{}) map[string]interface{} {
+	m := make(map[string]interface{})
+	if v == nil {
+		return m
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return m
+	}
+	json.Unmarshal(data, &m)
+	return m
+}
+
+// recordAudit はリクエストの情報（認証主体・リクエストID・IP）を付けて監査ログに記録する
+func recordAudit(r *http.Request, action, resourceType string, resourceID int, before, after interface{}) {
+	e := AuditEntry{
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Changes:      diffFields(before, after),
+		RequestID:    requestIDFromContext(r.Context()),
+		ClientIP:     clientIP(r),
+	}
+	if p, ok := currentPrincipal(r); ok {
+		e.ActorID = p.User.ID
+		if p.APIKey != nil {
+			e.APIKeyID = p.APIKey.ID
+		}
+	}
+	auditLog.Record(e)
+}
+
+// ========== リクエストID ==========
+
+type requestIDKey struct{}
+
+// requestIDMiddleware は X-Request-ID を引き継ぐか新しく発行し、レスポンスヘッダーとコンテキストに設定する
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ========== メトリクス ==========
+
+var (
+	metricsRegistry = metrics.NewRegistry()
+	httpMetrics     = metricsRegistry.NewHTTPMetrics()
+
+	postsCreated  = metricsRegistry.Counter("blog_posts_created_total", "作成された投稿の数（REST / GraphQL / JSON-RPC の合計）")
+	loginFailures = metricsRegistry.CounterVec("blog_login_failures_total", "ログインの失敗回数", "reason")
+)
+
+// registerMetrics は他の場所にある値（キューの長さ・接続数）を読むゲージと、ランタイムの統計を登録する
+func registerMetrics() {
+	metricsRegistry.RegisterRuntime()
+	metricsRegistry.GaugeFunc("blog_webhook_queue_length", "配信待ちの Webhook の数", func() float64 {
+		return float64(webhookDispatcher.Pending())
+	})
+	metricsRegistry.GaugeFunc("blog_thumbnail_queue_length", "生成待ちのサムネイルの数", func() float64 {
+		return float64(thumbnailer.pool.Pending())
+	})
+	metricsRegistry.GaugeFunc("blog_sse_subscribers", "SSE で接続中のクライアント数", func() float64 {
+		return float64(postEvents.Subscribers())
+	})
+	metricsRegistry.GaugeFunc("blog_websocket_connections", "WebSocket で接続中のクライアント数", func() float64 {
+		return float64(commentHub.Connections())
+	})
+}
+
+// routeLabel はメトリクスとアクセスログに使う経路を返す。
+// /w/{slug}/ の接頭辞を外してから、DefaultServeMux に登録されたパターンを探す
+func routeLabel(r *http.Request) string {
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/w/"); ok {
+		_, path, _ := strings.Cut(rest, "/")
+		u := *r.URL
+		u.Path, u.RawPath = "/"+path, ""
+		r = &http.Request{Method: r.Method, URL: &u, Host: r.Host}
+	}
+	return metrics.MuxRoute(http.DefaultServeMux)(r)
+}
+
+// ========== ログ（log/slog） ==========
+
+// logLevel は出力するログの最低レベル。/api/admin/log-level で実行中に変更できる
+var logLevel = new(slog.LevelVar)
+
+// sensitiveLogKeys は値を伏せるキー（大文字小文字は区別しない）
+var sensitiveLogKeys = map[string]bool{
+	"password": true, "new_password": true, "token": true, "secret": true,
+	"authorization": true, "api_key": true, "x-api-key": true, "cookie": true, "set-cookie": true,
+}
+
+// newLogger は log.format に従って JSON かテキストのロガーを作る。
+// slog.SetDefault するとこれまでの log.Printf もこのロガーを通って出力される
+func newLogger(cfg LogConfig) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: redactLogAttr}
+	if cfg.Format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// emailLogKeys はメールアドレスが入りうるキー（ログイン試行のキーはメールアドレスかIP）
+var emailLogKeys = map[string]bool{"email": true, "key": true}
+
+// redactLogAttr は秘密の値を伏せ、メールアドレスは先頭1文字とドメインだけを残す
+func redactLogAttr(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	if sensitiveLogKeys[key] {
+		return slog.String(a.Key, "[REDACTED]")
+	}
+	if emailLogKeys[key] && a.Value.Kind() == slog.KindString && strings.Contains(a.Value.String(), "@") {
+		return slog.String(a.Key, maskEmail(a.Value.String()))
+	}
+	// JSON ではナノ秒の整数になってしまうので "1.5s" の形にする
+	if a.Value.Kind() == slog.KindDuration {
+		return slog.String(a.Key, a.Value.Duration().String())
+	}
+	return a
+}
+
+func maskEmail(email string) string {
+	local, domain, _ := strings.Cut(email, "@")
+	if local == "" {
+		return "***@" + domain
+	}
+	return string([]rune(local)[:1]) + "***@" + domain
+}
+
+type requestLogKey struct{}
+
+// requestLog はリクエストごとのロガー。
+// 内側のミドルウェア（認証・ワークスペース）で分かった情報を足し、外側のアクセスログにも載せる
+type requestLog struct {
+	mu     sync.Mutex
+	logger *slog.Logger
+}
+
+// loggerFrom はリクエストのロガー（request_id・user_id などが付いている）を返す
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if rl, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		return rl.logger
+	}
+	return slog.Default()
+}
+
+// annotateLog はリクエストのロガーに属性を足す（以降のログとアクセスログに出る）
+func annotateLog(ctx context.Context, args ...interface{}) {
+	if rl, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		rl.logger = rl.logger.With(args...)
+	}
+}
+
+// accessLogMiddleware はリクエストごとのロガーを context に入れ、終わったらアクセスログを1行出す
+// （requestIDMiddleware の内側で使う）
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rl := &requestLog{logger: slog.Default().With(
+			"request_id", requestIDFromContext(r.Context()),
+			"trace_id", trace.SpanFromContext(r.Context()).TraceID(),
+		)}
+		rec := recorder.New(w)
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, rl)))
+
+		status := rec.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		// クエリには ?token= などが入ることがあるので、パスだけを出す
+		rl.logger.LogAttrs(r.Context(), level, "access",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", routeLabel(r)),
+			slog.Int("status", status),
+			slog.Int64("bytes", rec.Bytes()),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("ip", clientIP(r)),
+		)
+	})
+}
+
+// ========== トレース（W3C Trace Context） ==========
+
+var (
+	// tracer は受け取った traceparent を引き継いでスパンを記録する（trace.exporter=none なら記録しない）
+	tracer = trace.NewTracer(nil)
+	// traceBuffer は trace.exporter=memory のときの書き出し先。/debug/traces で閲覧する
+	traceBuffer *trace.RingBuffer
+)
+
+// setupTracing は trace.exporter に従って書き出し先を決める。戻り値の関数で書き出し先を閉じる
+func setupTracing(cfg TraceConfig) (func(), error) {
+	switch cfg.Exporter {
+	case "memory":
+		traceBuffer = trace.NewRingBuffer(cfg.BufferSize)
+		tracer = trace.NewTracer(traceBuffer)
+	case "file":
+		exp, err := trace.OpenJSONFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		tracer = trace.NewTracer(exp)
+		return func() { exp.Close() }, nil
+	default:
+		tracer = trace.NewTracer(nil)
+	}
+	return func() {}, nil
+}
+
+// GET /debug/traces - 直近のトレース一覧（?trace_id= でスパンまで、?status=error で失敗のみ）
+func debugTracesHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, RoleAdmin); !ok {
+		return
+	}
+	if traceBuffer == nil {
+		respondError(w, "Traces are not kept in memory (trace.exporter is not memory)", http.StatusNotFound, nil)
+		return
+	}
+	traceBuffer.Handler().ServeHTTP(w, r)
+}
+
+// ========== Webhook ==========
+
+// webhookEndpoints は保存済みの Webhook を配信先として pkg/webhook に渡す
+type webhookEndpoints struct{}
+
+func (webhookEndpoints) Subscribers(event string) []webhook.Endpoint {
+	var eps []webhook.Endpoint
+	for _, hook := range store.WebhooksFor(event) {
+		eps = append(eps, webhookEndpoint(hook))
+	}
+	return eps
+}
+
+func (webhookEndpoints) Lookup(id int) (webhook.Endpoint, bool) {
+	hook, ok := store.GetWebhook(id)
+	if !ok {
+		return webhook.Endpoint{}, false
+	}
+	return webhookEndpoint(hook), true
+}
+
+func webhookEndpoint(hook Webhook) webhook.Endpoint {
+	return webhook.Endpoint{ID: hook.ID, URL: hook.URL, Secret: hook.Secret}
+}
+
+var validWebhookEvents = map[string]bool{
+	EventPostCreated:   true,
+	EventPostUpdated:   true,
+	EventPostPublished: true,
+	EventPostDeleted:   true,
+}
+
+// publishPostEvent は投稿の変更を外部（Webhook / SSE）へ通知する
+func publishPostEvent(event string, post Post) {
+	webhookDispatcher.Dispatch(event, post)
+	postEvents.Publish(event, post)
+}
+
+// ========== イベントブローカー（SSE） ==========
+
+// PostEvent は SSE で配信する投稿の変更イベント
+type PostEvent struct {
+	ID   int64
+	Type string
+	Post Post
+}
+
+type eventSubscriber struct {
+	events  chan PostEvent
+	dropped chan struct{} // 切り離されたら close される（受信が追いつかないとき・サーバー停止時）
+}
+
+// EventBroker は投稿イベントを購読者に配る。
+// 直近のイベントをリングバッファに残し、Last-Event-ID からの再開に使う
+type EventBroker struct {
+	mu          sync.Mutex
+	nextID      int64
+	buffer      []PostEvent
+	bufferSize  int
+	subscribers map[*eventSubscriber]struct{}
+	closed      bool
+}
+
+func NewEventBroker(bufferSize int) *EventBroker {
+	return &EventBroker{
+		nextID:      1,
+		bufferSize:  bufferSize,
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+}
+
+// Publish はイベントを全購読者に送る。
+// 購読者のチャネルが一杯なら待たずに切り離す（遅いクライアントで配信側を止めない）
+func (b *EventBroker) Publish(eventType string, post Post) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ev := PostEvent{ID: b.nextID, Type: eventType, Post: post}
+	b.nextID++
+
+	b.buffer = append(b.buffer, ev)
+	if len(b.buffer) > b.bufferSize {
+		b.buffer = b.buffer[len(b.buffer)-b.bufferSize:]
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- ev:
+		default:
+			close(sub.dropped)
+			delete(b.subscribers, sub)
+		}
+	}
+}
+
+// Subscribe は購読を開始し、lastID より後のバッファ済みイベントを返す
+func (b *EventBroker) Subscribe(lastID int64) (*eventSubscriber, []PostEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &eventSubscriber{
+		events:  make(chan PostEvent, 64),
+		dropped: make(chan struct{}),
+	}
+	if b.closed {
+		close(sub.dropped)
+		return sub, nil
+	}
+	b.subscribers[sub] = struct{}{}
+
+	var replay []PostEvent
+	if lastID > 0 {
+		for _, ev := range b.buffer {
+			if ev.ID > lastID {
+				replay = append(replay, ev)
+			}
+		}
+	}
+	return sub, replay
+}
+
+func (b *EventBroker) Unsubscribe(sub *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, sub)
+}
+
+// Subscribers は購読中のクライアント数
+func (b *EventBroker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// Close は全購読者を切り離し、以降の購読もすぐに終わらせる（サーバー停止時）。
+// クライアントは retry の間隔で再接続し、Last-Event-ID で続きから受け取れる
+func (b *EventBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subscribers {
+		close(sub.dropped)
+		delete(b.subscribers, sub)
+	}
+}
+
+// ========== 添付ファイル ==========
+
+// AttachmentConfig は添付ファイルの設定（設定ファイルの [attachments]。保存先は storage.attachment_dir）
+type AttachmentConfig struct {
+	MaxSize int64    `config:"max_size" env:"ATTACHMENT_MAX_SIZE" help:"1ファイルの上限（バイト）"`
+	Types   []string `config:"types" env:"ATTACHMENT_TYPES" help:"添付できる MIME タイプ（カンマ区切り）。HTML や SVG はスクリプトを含められるので既定では許可しない"`
+}
+
+// Allowed は DetectContentType の結果（"text/plain; charset=utf-8" など）が許可リストにあるか
+func (c AttachmentConfig) Allowed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.Types {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupAttachments は削除した添付ファイルの本体のうち、参照されなくなったものを消す
+func cleanupAttachments(removed []Attachment) {
+	if len(removed) == 0 {
+		return
+	}
+	shas := make([]string, len(removed))
+	for i, a := range removed {
+		shas[i] = a.SHA256
+	}
+	blobs.RemoveUnused(shas, store.BlobInUse)
+}
+
+// sanitizeFilename はアップロードされたファイル名からパスや制御文字を取り除く
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
+
+// ========== サムネイル ==========
+
+// サムネイルの生成状況（Attachment.ThumbnailStatus）
+const (
+	ThumbnailPending     = "pending"     // 生成待ち（ワーカーのキューにある）
+	ThumbnailReady       = "ready"       // 生成済み
+	ThumbnailFailed      = "failed"      // 画像が壊れている・大きすぎるなど
+	ThumbnailUnsupported = "unsupported" // 画像だがデコーダーがない（WebP など）
+)
+
+// ThumbnailConfig はサムネイルの設定（設定ファイルの [thumbnails]）
+type ThumbnailConfig struct {
+	Sizes     []string `config:"sizes" env:"THUMBNAIL_SIZES" help:"サムネイルの名前と枠（名前=幅x高さ をカンマ区切り。名前は URL に使うので英小文字・数字・- と _ のみ）"`
+	Workers   int      `config:"workers" env:"THUMBNAIL_WORKERS" help:"同時に生成する数"`
+	MaxPixels int      `config:"max_pixels" help:"これより画素数の多い画像はサムネイルを作らない（解凍爆弾対策）"`
+}
+
+// ParseSizes は sizes（small=160x160 など）を imaging.Size にする。
+// validateConfig が起動時に呼ぶので、不正な値のままサーバーが動くことはない
+func (c ThumbnailConfig) ParseSizes() ([]imaging.Size, error) {
+	sizes := make([]imaging.Size, 0, len(c.Sizes))
+	seen := make(map[string]bool)
+	for _, item := range c.Sizes {
+		var size imaging.Size
+		name, box, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if ok {
+			_, err := fmt.Sscanf(box, "%dx%d", &size.Width, &size.Height)
+			ok = err == nil && size.Width > 0 && size.Height > 0 &&
+				name != "" && strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789-_") == "" // URL に使うので英小文字・数字・- と _ のみ
+		}
+		if !ok {
+			return nil, fmt.Errorf("Invalid size %q (must be name=WIDTHxHEIGHT)", item)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("Duplicate size name %q", name)
+		}
+		seen[name] = true
+		size.Name = name
+		sizes = append(sizes, size)
+	}
+	if len(sizes) == 0 {
+		return nil, errors.New("At least one size is required")
+	}
+	return sizes, nil
+}
+
+// Size は名前からサイズの設定を探す
+func (t *Thumbnailer) Size(name string) (imaging.Size, bool) {
+	for _, s := range t.sizes {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return imaging.Size{}, false
+}
+
+// initialThumbnailStatus はアップロード時の状態。画像以外は空（サムネイルなし）
+func initialThumbnailStatus(contentType string) string {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return ThumbnailPending
+	}
+	if strings.HasPrefix(contentType, "image/") {
+		return ThumbnailUnsupported
+	}
+	return ""
+}
+
+// derivedFile はサムネイルのファイル名。
+// 枠の大きさを含めるので、thumbnails.sizes を変えると古いキャッシュは使われない
+func derivedFile(a Attachment, size imaging.Size) string {
+	ext := ".png" // PNG / GIF は透過を残すため PNG にする
+	if a.ContentType == "image/jpeg" {
+		ext = ".jpg"
+	}
+	return fmt.Sprintf("%s-%dx%d%s", size.Name, size.Width, size.Height, ext)
+}
+
+// Thumbnailer はサムネイルをワーカープールで非同期に生成する。
+// アップロードのレスポンスは待たせず、状態は Attachment.ThumbnailStatus で確認する
+type Thumbnailer struct {
+	pool      *workerpool.Pool
+	blobs     *blobstore.Store
+	sizes     []imaging.Size
+	maxPixels int
+}
+
+// NewThumbnailer は validateConfig で確認済みの設定から作る
+func NewThumbnailer(blobs *blobstore.Store, cfg ThumbnailConfig) (*Thumbnailer, error) {
+	sizes, err := cfg.ParseSizes()
+	if err != nil {
+		return nil, err
+	}
+	return &Thumbnailer{
+		pool:      workerpool.New(cfg.Workers, 100),
+		blobs:     blobs,
+		sizes:     sizes,
+		maxPixels: cfg.MaxPixels,
+	}, nil
+}
+
+// Start はワーカーを起動し、前回の停止時に生成待ちだったもの・サイズの設定が変わったものを登録し直す
+func (t *Thumbnailer) Start() {
+	t.pool.Start()
+	for _, a := range store.AttachmentsNeedingThumbnails(t.outdated) {
+		t.Enqueue(a)
+	}
+}
+
+func (t *Thumbnailer) Stop() {
+	t.pool.Stop()
+}
+
+// Enqueue は生成を登録する。キューが一杯のときは空くまで待つ
+func (t *Thumbnailer) Enqueue(a Attachment) {
+	if err := t.pool.Add(func() { t.generate(a) }); err != nil {
+		slog.Warn("サムネイル生成を登録できません（停止中）", "attachment", a.ID)
+	}
+}
+
+// outdated は生成済みのサムネイルが今の thumbnails.sizes と一致しないか
+func (t *Thumbnailer) outdated(a Attachment) bool {
+	if a.ThumbnailStatus != ThumbnailReady {
+		return false
+	}
+	if len(a.Thumbnails) != len(t.sizes) {
+		return true
+	}
+	for i, size := range t.sizes {
+		if a.Thumbnails[i].Name != size.Name || a.Thumbnails[i].File != derivedFile(a, size) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Thumbnailer) generate(a Attachment) {
+	thumbs, err := t.build(a)
+	if err != nil {
+		slog.Error("サムネイル生成に失敗しました", "attachment", a.ID, "err", err)
+		store.SetThumbnails(a.ID, ThumbnailFailed, nil, err.Error())
+		return
+	}
+	store.SetThumbnails(a.ID, ThumbnailReady, thumbs, "")
+}
+
+// build は設定されたサイズのサムネイルを作る。
+// 同じ内容（SHA-256）・同じサイズのファイルがすでにあれば、デコードせずにそれを使う
+func (t *Thumbnailer) build(a Attachment) ([]Thumbnail, error) {
+	var decoded *imaging.Decoded
+	thumbs := make([]Thumbnail, 0, len(t.sizes))
+
+	for _, size := range t.sizes {
+		thumb := Thumbnail{Name: size.Name, File: derivedFile(a, size)}
+		thumb.URL = fmt.Sprintf("/api/posts/%d/attachments/%d/thumbnails/%s", a.PostID, a.ID, size.Name)
+
+		if cached, ok := t.blobs.DerivedInfo(a.SHA256, thumb.File); ok {
+			thumb.Width, thumb.Height, thumb.Size = cached.Width, cached.Height, cached.Size
+			thumbs = append(thumbs, thumb)
+			continue
+		}
+
+		// 元画像のデコードは最初に必要になったときの1回だけ
+		if decoded == nil {
+			data, err := t.blobs.ReadAll(a.SHA256)
+			if err != nil {
+				return nil, err
+			}
+			if decoded, err = imaging.Decode(data, t.maxPixels); err != nil {
+				return nil, err
+			}
+		}
+
+		result, err := decoded.Thumbnail(size)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.blobs.WriteDerived(a.SHA256, thumb.File, result.Data); err != nil {
+			return nil, err
+		}
+		thumb.Width, thumb.Height, thumb.Size = result.Width, result.Height, int64(len(result.Data))
+		thumbs = append(thumbs, thumb)
+	}
+	return thumbs, nil
+}
+
+// SetThumbnails はサムネイルの生成結果を記録する。
+// ワーカーはワークスペースを知らないので、PublishDue と同じく Store に置く
+func (s *Store) SetThumbnails(id int, status string, thumbs []Thumbnail, errMsg string) (Attachment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.attachments {
+		if s.attachments[i].ID == id {
+			a := &s.attachments[i]
+			a.ThumbnailStatus = status
+			a.Thumbnails = thumbs
+			a.ThumbnailError = errMsg
+			s.persist()
+			return *a, true
+		}
+	}
+	return Attachment{}, false // 生成中に削除された
+}
+
+// AttachmentsNeedingThumbnails は生成待ちのもの、または outdated が true を返すものを返す
+func (s *Store) AttachmentsNeedingThumbnails(outdated func(Attachment) bool) []Attachment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []Attachment
+	for _, a := range s.attachments {
+		if a.ThumbnailStatus == ThumbnailPending || outdated(a) {
+			list = append(list, a)
+		}
+	}
+	return list
+}
+
+// GET /api/posts/{id}/attachments/{aid}/thumbnails/{name} - サムネイル
+func serveThumbnail(w http.ResponseWriter, r *http.Request, a Attachment, name string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	var thumb *Thumbnail
+	for i := range a.Thumbnails {
+		if a.Thumbnails[i].Name == name {
+			thumb = &a.Thumbnails[i]
+		}
+	}
+	if thumb == nil {
+		if a.ThumbnailStatus == ThumbnailPending {
+			// まだ生成中。少し待ってから取り直してもらう
+			w.Header().Set("Retry-After", "1")
+			respondError(w, "Thumbnail is being generated", http.StatusServiceUnavailable, nil)
+			return
+		}
+		respondError(w, "Thumbnail not found", http.StatusNotFound, nil)
+		return
+	}
+
+	f, err := blobs.OpenDerived(a.SHA256, thumb.File)
+	if err != nil {
+		respondError(w, "Thumbnail not found", http.StatusNotFound, nil)
+		return
+	}
+	defer f.Close()
+
+	contentType := "image/png"
+	if strings.HasSuffix(thumb.File, ".jpg") {
+		contentType = "image/jpeg"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+a.SHA256+"-"+thumb.File+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, thumb.File, a.CreatedAt, f)
+}
+
+// ========== Markdown ==========
+
+// RenderCache は Markdown から変換した HTML を投稿ごとに覚えておく。
+// 投稿の更新・削除で Invalidate し、念のため UpdatedAt が違えば作り直す
+type RenderCache struct {
+	mu         sync.RWMutex
+	entries    map[int]renderedPost
+	maxEntries int
+}
+
+type renderedPost struct {
+	updatedAt time.Time
+	html      string
+}
+
+func NewRenderCache(maxEntries int) *RenderCache {
+	return &RenderCache{
+		entries:    make(map[int]renderedPost),
+		maxEntries: maxEntries,
+	}
+}
+
+// HTML は投稿の本文を HTML にして返す（キャッシュがあればそれを使う）
+func (c *RenderCache) HTML(p Post) string {
+	c.mu.RLock()
+	entry, ok := c.entries[p.ID]
+	c.mu.RUnlock()
+	if ok && entry.updatedAt.Equal(p.UpdatedAt) {
+		return entry.html
+	}
+
+	// 変換はロックの外で行う（同時に同じ投稿を変換しても結果は同じ）
+	rendered := markdown.Render(p.Content)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[p.ID]; !exists && len(c.entries) >= c.maxEntries {
+		// 上限に達したら適当な1件を捨てる（map の反復順は不定）
+		for id := range c.entries {
+			delete(c.entries, id)
+			break
+		}
+	}
+	c.entries[p.ID] = renderedPost{updatedAt: p.UpdatedAt, html: rendered}
+	return rendered
+}
+
+func (c *RenderCache) Invalidate(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, id)
+}
+
+// PostDetailResponse は GET /api/posts/{id} のレスポンス
+type PostDetailResponse struct {
+	Post
+	ContentHTML string `json:"content_html"`
+}
+
+// prefersHTML は Accept ヘッダーで JSON より HTML が望まれているか。
+// q 値の大きい方を選び、同じなら先に書かれた方を選ぶ（*/* だけなら JSON）
+func prefersHTML(r *http.Request) bool {
+	htmlQ, jsonQ := -1.0, -1.0
+	htmlPos, jsonPos := 0, 0
+	for i, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch mediaType {
+		case "text/html":
+			if q > htmlQ {
+				htmlQ, htmlPos = q, i
+			}
+		case "application/json":
+			if q > jsonQ {
+				jsonQ, jsonPos = q, i
+			}
+		}
+	}
+	if htmlQ <= 0 {
+		return false
+	}
+	return htmlQ > jsonQ || htmlQ == jsonQ && htmlPos < jsonPos
+}
+
+// respondPostHTML は投稿を1つの HTML ページとして返す。
+// 本文はレンダラーがエスケープ済みだが、CSP でスクリプトの実行も禁止しておく
+func respondPostHTML(w http.ResponseWriter, p Post) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src https: http: data:; style-src 'unsafe-inline'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html lang=\"ja\">\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n<article>\n<h1>%s</h1>\n%s</article>\n</body>\n</html>\n",
+		html.EscapeString(p.Title), html.EscapeString(p.Title), renderCache.HTML(p))
+}
+
+// ========== コメント（WebSocket） ==========
+
+const (
+	commentWriteWait  = 10 * time.Second // 1回の書き込みの期限
+	commentPongWait   = 60 * time.Second // この間に pong がなければ切断する
+	commentPingPeriod = 50 * time.Second // commentPongWait より短くする
+	commentSendBuffer = 32
+)
+
+// CommentMessage は WebSocket でやり取りするメッセージ。
+// クライアントは {"type":"comment","body":"..."} を送り、
+// サーバーは history（接続直後の履歴）/ comment / error を送る
+type CommentMessage struct {
+	Type     string    `json:"type"`
+	Body     string    `json:"body,omitempty"`
+	Comment  *Comment  `json:"comment,omitempty"`
+	Comments []Comment `json:"comments,omitempty"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// commentClient はルームに参加している1本の接続
+type commentClient struct {
+	conn   *websocket.Conn
+	postID int
+	user   User
+	send   chan []byte   // 送信キュー（writePump が書き込む）
+	done   chan struct{} // 切断が決まったら close される
+
+	once        sync.Once
+	closeCode   int
+	closeReason string
+}
+
+// close は切断を要求する（実際のクローズフレーム送信は writePump が行う）
+func (c *commentClient) close(code int, reason string) {
+	c.once.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+		close(c.done)
+	})
+}
+
+// enqueue はメッセージを送信キューに入れる。キューが一杯なら切断する
+func (c *commentClient) enqueue(msg CommentMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+		c.close(websocket.ClosePolicyViolation, "client too slow")
+	}
+}
+
+// writePump は送信キューのメッセージと定期的な ping を書き込む。
+// 書き込みごとに期限を設定し、応答しないクライアントでブロックし続けないようにする
+func (c *commentClient) writePump() {
+	ticker := time.NewTicker(commentPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close(c.closeCode, c.closeReason)
+	}()
+
+	for {
+		select {
+		case data := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(commentWriteWait))
+			if err := c.conn.WriteMessage(websocket.OpText, data); err != nil {
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.OpPing, nil, time.Now().Add(commentWriteWait)); err != nil {
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// CommentHub は投稿IDごとのルームを管理し、コメントを同じルームの全員に配る
+type CommentHub struct {
+	mu    sync.Mutex
+	rooms map[int]map[*commentClient]struct{}
+}
+
+func NewCommentHub() *CommentHub {
+	return &CommentHub{rooms: make(map[int]map[*commentClient]struct{})}
+}
+
+func (h *CommentHub) Join(c *commentClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	room, ok := h.rooms[c.postID]
+	if !ok {
+		room = make(map[*commentClient]struct{})
+		h.rooms[c.postID] = room
+	}
+	room[c] = struct{}{}
+}
+
+func (h *CommentHub) Leave(c *commentClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if room, ok := h.rooms[c.postID]; ok {
+		delete(room, c)
+		if len(room) == 0 {
+			delete(h.rooms, c.postID)
+		}
+	}
+}
+
+// Broadcast はルームの全員の送信キューにメッセージを入れる（書き込み自体は各 writePump が行う）
+func (h *CommentHub) Broadcast(postID int, msg CommentMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.rooms[postID] {
+		c.enqueue(msg)
+	}
+}
+
+// CloseRoom はルームの全接続を切断する（投稿が削除されたとき）
+func (h *CommentHub) CloseRoom(postID int, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.rooms[postID] {
+		c.close(websocket.CloseGoingAway, reason)
+	}
+	delete(h.rooms, postID)
+}
+
+// Connections は全ルームの接続数
+func (h *CommentHub) Connections() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := 0
+	for _, room := range h.rooms {
+		n += len(room)
+	}
+	return n
+}
+
+// CloseAll は全ルームの接続を切断する（サーバー停止時）
+func (h *CommentHub) CloseAll(reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for postID, room := range h.rooms {
+		for c := range room {
+			c.close(websocket.CloseGoingAway, reason)
+		}
+		delete(h.rooms, postID)
+	}
+}
+
+// ========== GraphQL ==========
+
+// graphQLLoaders はリクエストごとのバッチローダー。
+// 投稿一覧の author などを1件ずつ読まず、まとめて1回で取得する（N+1 問題の回避）
+type graphQLLoaders struct {
+	users          *graphql.Loader // ユーザーID → User
+	postsByUser    *graphql.Loader // ユーザーID → []Post
+	commentsByPost *graphql.Loader // 投稿ID → []Comment
+}
+
+type graphQLLoadersKey struct{}
+type graphQLRequestKey struct{}
+
+// newGraphQLLoaders はリクエストごとのローダーを作る。投稿はリクエストのユーザーが読めるものだけを読む
+func newGraphQLLoaders(r *http.Request) *graphQLLoaders {
+	posts := postsFor(r)
+	return &graphQLLoaders{
+		users: graphql.NewLoader(func(keys []interface{}) (map[interface{}]interface{}, error) {
+			ids := make([]int, len(keys))
+			for i, k := range keys {
+				ids[i] = k.(int)
+			}
+			out := make(map[interface{}]interface{})
+			for id, u := range store.GetUsers(ids) {
+				out[id] = u
+			}
+			return out, nil
+		}),
+		postsByUser: graphql.NewLoader(func(keys []interface{}) (map[interface{}]interface{}, error) {
+			out := make(map[interface{}]interface{})
+			for _, k := range keys {
+				out[k] = []Post{}
+			}
+			for _, p := range visiblePosts(r) {
+				if list, ok := out[p.UserID]; ok {
+					out[p.UserID] = append(list.([]Post), p)
+				}
+			}
+			return out, nil
+		}),
+		commentsByPost: graphql.NewLoader(func(keys []interface{}) (map[interface{}]interface{}, error) {
+			ids := make([]int, len(keys))
+			for i, k := range keys {
+				ids[i] = k.(int)
+			}
+			out := make(map[interface{}]interface{})
+			for id, comments := range posts.CommentsFor(ids) {
+				out[id] = comments
+			}
+			return out, nil
+		}),
+	}
+}
+
+func graphQLLoadersFrom(ctx context.Context) *graphQLLoaders {
+	return ctx.Value(graphQLLoadersKey{}).(*graphQLLoaders)
+}
+
+// graphQLHTTPRequest は元の HTTP リクエスト（認証情報や監査ログに使う）
+func graphQLHTTPRequest(ctx context.Context) *http.Request {
+	return ctx.Value(graphQLRequestKey{}).(*http.Request)
+}
+
+// graphQLError は extensions.code を付けたエラー（REST のステータスコードに相当する）
+type graphQLError struct {
+	code    string
+	message string
+	details map[string]string
+}
+
+func (e *graphQLError) Error() string { return e.message }
+
+func (e *graphQLError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.code}
+	if e.details != nil {
+		ext["details"] = e.details
+	}
+	return ext
+}
+
+// toGraphQLError は投稿の操作のエラーを GraphQL のエラーに変換する
+func toGraphQLError(err error) error {
+	var verr *ValidationError
+	var qerr *workspace.QuotaError
+	switch {
+	case errors.As(err, &verr):
+		return &graphQLError{code: "VALIDATION_FAILED", message: verr.Error(), details: verr.Details}
+	case errors.Is(err, ErrPostNotFound):
+		return &graphQLError{code: "NOT_FOUND", message: "Post not found"}
+	case errors.Is(err, ErrForbidden), errors.Is(err, workspace.ErrNotMember):
+		return &graphQLError{code: "FORBIDDEN", message: err.Error()}
+	case errors.As(err, &qerr):
+		return &graphQLError{code: "FORBIDDEN", message: err.Error(), details: qerr.Details()}
+	case errors.Is(err, ErrInvalidTransition), errors.Is(err, ErrSlugTaken):
+		return &graphQLError{code: "CONFLICT", message: err.Error()}
+	}
+	return &graphQLError{code: "INTERNAL", message: err.Error()}
+}
+
+// graphQLUser は mutation を実行するログインユーザーを返す
+func graphQLUser(ctx context.Context) (User, error) {
+	user, ok := currentUser(graphQLHTTPRequest(ctx))
+	if !ok {
+		return User{}, &graphQLError{code: "UNAUTHENTICATED", message: "Authentication required"}
+	}
+	return user, nil
+}
+
+// GraphQL のクエリの大きさの上限。
+// 投稿 → 投稿者 → 投稿 ... と型が循環しているので、上限がないと入れ子を深くするだけで処理量を増やせる。
+// GraphiQL などが送るイントロスペクションのクエリ（ofType を7段ほど入れ子にする）が通る大きさにしている
+const (
+	graphQLMaxDepth      = 15
+	graphQLMaxComplexity = 1000
+)
+
+// graphQLID は ID 型の引数（文字列）を数値にする。
+// 数値でない ID は「見つからない（null）」ではなく入力の誤りとして返す
+func graphQLID(v interface{}, arg string) (int, error) {
+	s, _ := v.(string)
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		return 0, &graphQLError{code: "BAD_USER_INPUT", message: fmt.Sprintf("Invalid ID %q", s), details: map[string]string{arg: "Must be a positive integer"}}
+	}
+	return id, nil
+}
+
+// graphQLTime は RFC 3339 の文字列を時刻にする
+func graphQLTime(v interface{}, field string) (*time.Time, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, &ValidationError{Details: map[string]string{field: "Must be an RFC 3339 time"}}
+	}
+	return &t, nil
+}
+
+// ---------- コネクション（カーソル形式のページネーション） ----------
+
+type graphQLConnection struct {
+	Edges      []graphQLEdge
+	Nodes      []interface{}
+	PageInfo   graphQLPageInfo
+	TotalCount int
+}
+
+type graphQLEdge struct {
+	Cursor string
+	Node   interface{}
+}
+
+type graphQLPageInfo struct {
+	HasNextPage     bool
+	HasPreviousPage bool
+	StartCursor     *string
+	EndCursor       *string
+}
+
+// カーソルは一覧での位置を base64 にしたもの（クライアントは中身を解釈しない）
+func encodeCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte("cursor:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, bool) {
+	data, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(string(data), "cursor:"))
+	return n, err == nil && n >= 0
+}
+
+// newGraphQLConnection は first / after 引数で nodes を切り出す
+func newGraphQLConnection(nodes []interface{}, args map[string]interface{}) (graphQLConnection, error) {
+	first, _ := args["first"].(int)
+	if first <= 0 || first > 100 {
+		return graphQLConnection{}, &ValidationError{Details: map[string]string{"first": "Must be between 1 and 100"}}
+	}
+
+	start := 0
+	if after, ok := args["after"].(string); ok {
+		offset, ok := decodeCursor(after)
+		if !ok {
+			return graphQLConnection{}, &ValidationError{Details: map[string]string{"after": "Invalid cursor"}}
+		}
+		start = offset + 1
+	}
+	if start > len(nodes) {
+		start = len(nodes)
+	}
+	end := start + first
+	if end > len(nodes) {
+		end = len(nodes)
+	}
+
+	conn := graphQLConnection{
+		Edges:      []graphQLEdge{},
+		Nodes:      nodes[start:end],
+		TotalCount: len(nodes),
+		PageInfo: graphQLPageInfo{
+			HasNextPage:     end < len(nodes),
+			HasPreviousPage: start > 0,
+		},
+	}
+	for i, n := range conn.Nodes {
+		conn.Edges = append(conn.Edges, graphQLEdge{Cursor: encodeCursor(start + i), Node: n})
+	}
+	if len(conn.Edges) > 0 {
+		conn.PageInfo.StartCursor = &conn.Edges[0].Cursor
+		conn.PageInfo.EndCursor = &conn.Edges[len(conn.Edges)-1].Cursor
+	}
+	return conn, nil
+}
+
+// filterPostsByStatus は status 引数（[PostStatus!]）で絞り込み、[]interface{} にする
+func filterPostsByStatus(posts []Post, statuses interface{}) []interface{} {
+	var want map[PostStatus]bool
+	if list, ok := statuses.([]interface{}); ok {
+		want = make(map[PostStatus]bool)
+		for _, st := range list {
+			want[st.(PostStatus)] = true
+		}
+	}
+
+	nodes := []interface{}{}
+	for _, p := range posts {
+		if want == nil || want[p.Status] {
+			nodes = append(nodes, p)
+		}
+	}
+	return nodes
+}
+
+// ---------- スキーマ ----------
+
+// newGraphQLSchema は User / Post / Comment とコネクション型のスキーマを組み立てる。
+// mutation は REST と同じ createPost などの関数を呼ぶので、バリデーションや監査ログも共通
+func newGraphQLSchema() (*graphql.Schema, error) {
+	postStatusEnum := &graphql.Enum{
+		TypeName:    "PostStatus",
+		Description: "投稿のワークフロー上の状態",
+		Values: []*graphql.EnumValueDefinition{
+			{Name: "DRAFT", Value: StatusDraft},
+			{Name: "IN_REVIEW", Value: StatusInReview},
+			{Name: "APPROVED", Value: StatusApproved},
+			{Name: "PUBLISHED", Value: StatusPublished},
+			{Name: "ARCHIVED", Value: StatusArchived},
+		},
+	}
+
+	userType := &graphql.Object{TypeName: "User", Description: "ユーザー"}
+	postType := &graphql.Object{TypeName: "Post", Description: "投稿"}
+	commentType := &graphql.Object{TypeName: "Comment", Description: "投稿へのコメント"}
+
+	pageInfoType := &graphql.Object{
+		TypeName: "PageInfo",
+		Fields: []*graphql.Field{
+			{Name: "hasNextPage", Type: graphql.NewNonNull(graphql.Boolean)},
+			{Name: "hasPreviousPage", Type: graphql.NewNonNull(graphql.Boolean)},
+			{Name: "startCursor", Type: graphql.String},
+			{Name: "endCursor", Type: graphql.String},
+		},
+	}
+	connectionType := func(node *graphql.Object) *graphql.Object {
+		edge := &graphql.Object{
+			TypeName: node.TypeName + "Edge",
+			Fields: []*graphql.Field{
+				{Name: "cursor", Type: graphql.NewNonNull(graphql.String)},
+				{Name: "node", Type: graphql.NewNonNull(node)},
+			},
+		}
+		return &graphql.Object{
+			TypeName: node.TypeName + "Connection",
+			Fields: []*graphql.Field{
+				{Name: "edges", Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(edge)))},
+				{Name: "nodes", Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(node)))},
+				{Name: "pageInfo", Type: graphql.NewNonNull(pageInfoType)},
+				{Name: "totalCount", Type: graphql.NewNonNull(graphql.Int)},
+			},
+		}
+	}
+	postConnectionType := connectionType(postType)
+	userConnectionType := connectionType(userType)
+
+	connectionArgs := func(extra ...*graphql.Argument) []*graphql.Argument {
+		return append([]*graphql.Argument{
+			{Name: "first", Type: graphql.Int, DefaultValue: 10, Description: "取得件数（最大100）"},
+			{Name: "after", Type: graphql.String, Description: "このカーソルより後を取得する"},
+		}, extra...)
+	}
+	statusArg := &graphql.Argument{Name: "status", Type: graphql.NewList(graphql.NewNonNull(postStatusEnum))}
+
+	// 投稿者は Loader 経由で読むので、投稿一覧でも問い合わせは1回
+	authorField := func(userID func(source interface{}) int) *graphql.Field {
+		return &graphql.Field{
+			Name: "author",
+			Type: userType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphQLLoadersFrom(p.Context).users.Load(userID(p.Source)), nil
+			},
+		}
+	}
+
+	// ---------- User ----------
+	userType.Fields = []*graphql.Field{
+		{Name: "id", Type: graphql.NewNonNull(graphql.ID)},
+		{Name: "username", Type: graphql.NewNonNull(graphql.String)},
+		{
+			Name:        "email",
+			Description: "本人と管理者にだけ返す（それ以外は null）",
+			Type:        graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				user := p.Source.(User)
+				viewer, _ := currentUser(graphQLHTTPRequest(p.Context))
+				if !canSeeEmail(viewer, user) {
+					return nil, nil
+				}
+				return user.Email, nil
+			},
+		},
+		{Name: "role", Type: graphql.NewNonNull(graphql.String)},
+		{Name: "emailVerified", Type: graphql.NewNonNull(graphql.Boolean)},
+		{Name: "createdAt", Type: graphql.NewNonNull(graphql.String)},
+		{
+			Name: "posts",
+			Type: graphql.NewNonNull(postConnectionType),
+			Args: connectionArgs(statusArg),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				load := graphQLLoadersFrom(p.Context).postsByUser.Load(p.Source.(User).ID)
+				return graphql.Thunk(func() (interface{}, error) {
+					posts, err := load()
+					if err != nil {
+						return nil, err
+					}
+					conn, err := newGraphQLConnection(filterPostsByStatus(posts.([]Post), p.Args["status"]), p.Args)
+					if err != nil {
+						return nil, toGraphQLError(err)
+					}
+					return conn, nil
+				}), nil
+			},
+		},
+	}
+
+	// ---------- Post ----------
+	postType.Fields = []*graphql.Field{
+		{Name: "id", Type: graphql.NewNonNull(graphql.ID)},
+		{Name: "title", Type: graphql.NewNonNull(graphql.String)},
+		{Name: "slug", Type: graphql.NewNonNull(graphql.String)},
+		{Name: "content", Type: graphql.NewNonNull(graphql.String)},
+		{
+			Name:        "contentHtml",
+			Description: "Markdown の本文を変換した HTML（スクリプトなどは除去済み）",
+			Type:        graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return renderCache.HTML(p.Source.(Post)), nil
+			},
+		},
+		{Name: "status", Type: graphql.NewNonNull(postStatusEnum)},
+		{Name: "published", Type: graphql.NewNonNull(graphql.Boolean)},
+		{Name: "publishAt", Type: graphql.String},
+		{Name: "createdAt", Type: graphql.NewNonNull(graphql.String)},
+		{Name: "updatedAt", Type: graphql.NewNonNull(graphql.String)},
+		authorField(func(source interface{}) int { return source.(Post).UserID }),
+		{
+			Name: "comments",
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(commentType))),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphQLLoadersFrom(p.Context).commentsByPost.Load(p.Source.(Post).ID), nil
+			},
+		},
+		{
+			Name:        "related",
+			Description: "同じ投稿者の公開済みの投稿（新しい順）",
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(postType))),
+			Args:        []*graphql.Argument{{Name: "first", Type: graphql.Int, DefaultValue: 3}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				post := p.Source.(Post)
+				first, _ := p.Args["first"].(int)
+				load := graphQLLoadersFrom(p.Context).postsByUser.Load(post.UserID)
+				return graphql.Thunk(func() (interface{}, error) {
+					posts, err := load()
+					if err != nil {
+						return nil, err
+					}
+					related := []Post{}
+					all := posts.([]Post)
+					for i := len(all) - 1; i >= 0 && len(related) < first; i-- {
+						if all[i].ID != post.ID && all[i].Status == StatusPublished {
+							related = append(related, all[i])
+						}
+					}
+					return related, nil
+				}), nil
+			},
+		},
+	}
+
+	// ---------- Comment ----------
+	commentType.Fields = []*graphql.Field{
+		{Name: "id", Type: graphql.NewNonNull(graphql.ID)},
+		{Name: "body", Type: graphql.NewNonNull(graphql.String)},
+		{Name: "createdAt", Type: graphql.NewNonNull(graphql.String)},
+		authorField(func(source interface{}) int { return source.(Comment).UserID }),
+	}
+
+	// ---------- Query ----------
+	queryType := &graphql.Object{
+		TypeName: "Query",
+		Fields: []*graphql.Field{
+			{
+				Name:        "me",
+				Description: "ログインユーザー",
+				Type:        userType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if user, ok := currentUser(graphQLHTTPRequest(p.Context)); ok {
+						return user, nil
+					}
+					return nil, nil
+				},
+			},
+			{
+				Name: "user",
+				Type: userType,
+				Args: []*graphql.Argument{{Name: "id", Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := graphQLID(p.Args["id"], "id")
+					if err != nil {
+						return nil, err
+					}
+					return graphQLLoadersFrom(p.Context).users.Load(id), nil
+				},
+			},
+			{
+				Name: "users",
+				Type: graphql.NewNonNull(userConnectionType),
+				Args: connectionArgs(),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					nodes := []interface{}{}
+					for _, u := range store.ListUsers() {
+						nodes = append(nodes, u)
+					}
+					conn, err := newGraphQLConnection(nodes, p.Args)
+					if err != nil {
+						return nil, toGraphQLError(err)
+					}
+					return conn, nil
+				},
+			},
+			{
+				Name: "post",
+				Type: postType,
+				Args: []*graphql.Argument{{Name: "id", Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := graphQLID(p.Args["id"], "id")
+					if err != nil {
+						return nil, err
+					}
+					if post, ok := visiblePost(graphQLHTTPRequest(p.Context), id); ok {
+						return post, nil
+					}
+					return nil, nil
+				},
+			},
+			{
+				Name: "posts",
+				Type: graphql.NewNonNull(postConnectionType),
+				Args: connectionArgs(statusArg, &graphql.Argument{Name: "userId", Type: graphql.ID}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					posts := visiblePosts(graphQLHTTPRequest(p.Context))
+					if v, ok := p.Args["userId"]; ok && v != nil {
+						userID, err := graphQLID(v, "userId")
+						if err != nil {
+							return nil, err
+						}
+						var mine []Post
+						for _, post := range posts {
+							if post.UserID == userID {
+								mine = append(mine, post)
+							}
+						}
+						posts = mine
+					}
+					conn, err := newGraphQLConnection(filterPostsByStatus(posts, p.Args["status"]), p.Args)
+					if err != nil {
+						return nil, toGraphQLError(err)
+					}
+					return conn, nil
+				},
+			},
+		},
+	}
+
+	// ---------- Mutation ----------
+	createPostInput := &graphql.InputObject{
+		TypeName: "CreatePostInput",
+		Fields: []*graphql.Argument{
+			{Name: "title", Type: graphql.NewNonNull(graphql.String)},
+			{Name: "content", Type: graphql.NewNonNull(graphql.String)},
+			{Name: "published", Type: graphql.Boolean, DefaultValue: false},
+			{Name: "publishAt", Type: graphql.String, Description: "予約投稿の日時（RFC 3339）"},
+		},
+	}
+	updatePostInput := &graphql.InputObject{
+		TypeName: "UpdatePostInput",
+		Fields: []*graphql.Argument{
+			{Name: "title", Type: graphql.String},
+			{Name: "content", Type: graphql.String},
+			{Name: "publishAt", Type: graphql.String, Description: "予約投稿の日時（RFC 3339）"},
+		},
+	}
+	idArg := &graphql.Argument{Name: "id", Type: graphql.NewNonNull(graphql.ID)}
+
+	mutationType := &graphql.Object{
+		TypeName: "Mutation",
+		Fields: []*graphql.Field{
+			{
+				Name: "createPost",
+				Type: graphql.NewNonNull(postType),
+				Args: []*graphql.Argument{{Name: "input", Type: graphql.NewNonNull(createPostInput)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					user, err := graphQLUser(p.Context)
+					if err != nil {
+						return nil, err
+					}
+					input := p.Args["input"].(map[string]interface{})
+					req := CreatePostRequest{}
+					req.Title, _ = input["title"].(string)
+					req.Content, _ = input["content"].(string)
+					req.Published, _ = input["published"].(bool)
+					if req.PublishAt, err = graphQLTime(input["publishAt"], "publish_at"); err != nil {
+						return nil, toGraphQLError(err)
+					}
+
+					post, err := createPost(graphQLHTTPRequest(p.Context), user, req)
+					if err != nil {
+						return nil, toGraphQLError(err)
+					}
+					return post, nil
+				},
+			},
+			{
+				Name: "updatePost",
+				Type: graphql.NewNonNull(postType),
+				Args: []*graphql.Argument{idArg, {Name: "input", Type: graphql.NewNonNull(updatePostInput)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					user, err := graphQLUser(p.Context)
+					if err != nil {
+						return nil, err
+					}
+					id, err := graphQLID(p.Args["id"], "id")
+					if err != nil {
+						return nil, err
+					}
+					input := p.Args["input"].(map[string]interface{})
+					req := UpdatePostRequest{}
+					if v, ok := input["title"].(string); ok {
+						req.Title = &v
+					}
+					if v, ok := input["content"].(string); ok {
+						req.Content = &v
+					}
+					if req.PublishAt, err = graphQLTime(input["publishAt"], "publish_at"); err != nil {
+						return nil, toGraphQLError(err)
+					}
+
+					post, err := updatePost(graphQLHTTPRequest(p.Context), user, id, req)
+					if err != nil {
+						return nil, toGraphQLError(err)
+					}
+					return post, nil
+				},
+			},
+			{
+				Name: "deletePost",
+				Type: graphql.NewNonNull(postType),
+				Args: []*graphql.Argument{idArg},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					user, err := graphQLUser(p.Context)
+					if err != nil {
+						return nil, err
+					}
+					id, err := graphQLID(p.Args["id"], "id")
+					if err != nil {
+						return nil, err
+					}
+					post, err := deletePost(graphQLHTTPRequest(p.Context), user, id)
+					if err != nil {
+						return nil, toGraphQLError(err)
+					}
+					return post, nil
+				},
+			},
+			{
+				Name: "transitionPost",
+				Type: graphql.NewNonNull(postType),
+				Args: []*graphql.Argument{
+					idArg,
+					{Name: "to", Type: graphql.NewNonNull(postStatusEnum)},
+					{Name: "reason", Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					user, err := graphQLUser(p.Context)
+					if err != nil {
+						return nil, err
+					}
+					id, err := graphQLID(p.Args["id"], "id")
+					if err != nil {
+						return nil, err
+					}
+					req := TransitionRequest{To: p.Args["to"].(PostStatus)}
+					req.Reason, _ = p.Args["reason"].(string)
+
+					post, err := transitionPost(graphQLHTTPRequest(p.Context), id, user, req)
+					if err != nil {
+						return nil, toGraphQLError(err)
+					}
+					return post, nil
+				},
+			},
+		},
+	}
+
+	return graphql.NewSchema(queryType, mutationType)
+}
+
+// ========== JSON-RPC ==========
+
+// サーバー定義のエラーコード（-32000〜-32099 は実装側で自由に使える）。
+// REST のステータスコード、GraphQL の extensions.code に対応する
+const (
+	rpcCodeUnauthenticated = -32001 // 401
+	rpcCodeForbidden       = -32003 // 403
+	rpcCodeNotFound        = -32004 // 404
+	rpcCodeConflict        = -32009 // 409
+)
+
+// toRPCError は投稿の操作のエラーを JSON-RPC のエラーに変換する。
+// バリデーションエラーは仕様の Invalid params（-32602）にし、details を data に入れる
+func toRPCError(err error) *jsonrpc.Error {
+	var verr *ValidationError
+	var qerr *workspace.QuotaError
+	switch {
+	case errors.As(err, &verr):
+		return jsonrpc.InvalidParams(verr.Error(), verr.Details)
+	case errors.Is(err, ErrPostNotFound):
+		return jsonrpc.NewError(rpcCodeNotFound, "Post not found", nil)
+	case errors.Is(err, ErrForbidden), errors.Is(err, workspace.ErrNotMember):
+		return jsonrpc.NewError(rpcCodeForbidden, err.Error(), nil)
+	case errors.As(err, &qerr):
+		return jsonrpc.NewError(rpcCodeForbidden, err.Error(), qerr.Details())
+	case errors.Is(err, ErrInvalidTransition), errors.Is(err, ErrSlugTaken):
+		return jsonrpc.NewError(rpcCodeConflict, err.Error(), nil)
+	}
+	return nil // Internal error
+}
+
+// rpcUser は書き込み系のメソッドを呼ぶログインユーザーを返す
+func rpcUser(r *http.Request) (User, error) {
+	user, ok := currentUser(r)
+	if !ok {
+		return User{}, jsonrpc.NewError(rpcCodeUnauthenticated, "Authentication required", nil)
+	}
+	return user, nil
+}
+
+// rpcPageParams は一覧系メソッドのページネーション引数（省略時や範囲外は REST と同じ既定値）
+type rpcPageParams struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+}
+
+// paginate は total 件のうち、そのページに含まれる範囲 [start, end) を返す
+func (p *rpcPageParams) paginate(total int) (start, end int) {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.PerPage < 1 || p.PerPage > 100 {
+		p.PerPage = 10
+	}
+
+	start = (p.Page - 1) * p.PerPage
+	if start > total {
+		start = total
+	}
+	end = start + p.PerPage
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+func (p rpcPageParams) response(data interface{}, total int) PaginatedResponse {
+	return PaginatedResponse{
+		Data:       data,
+		Page:       p.Page,
+		PerPage:    p.PerPage,
+		Total:      total,
+		TotalPages: (total + p.PerPage - 1) / p.PerPage,
+	}
+}
+
+// newRPCServer はユーザーと投稿の操作を JSON-RPC のメソッドとして登録する。
+// 書き込み系は REST / GraphQL と同じ createPost などを呼ぶ
+func newRPCServer() *jsonrpc.Server {
+	s := jsonrpc.NewServer("ブログAPI", "1.0.0")
+	s.MapError = toRPCError
+
+	idParam := jsonrpc.Param{Name: "id", Type: "integer", Required: true}
+	pageParams := []jsonrpc.Param{
+		{Name: "page", Type: "integer", Description: "ページ番号（既定値 1）"},
+		{Name: "per_page", Type: "integer", Description: "1ページの件数（1〜100、既定値 10）"},
+	}
+	userResult := jsonrpc.Param{Name: "user", Type: "object", Description: "email は本人と admin にだけ含める"}
+	postResult := jsonrpc.Param{Name: "post", Type: "object"}
+	statusNames := make([]string, len(postStatuses))
+	for i, st := range postStatuses {
+		statusNames[i] = string(st)
+	}
+	pageResult := jsonrpc.Param{Name: "page", Type: "object", Description: "data / page / per_page / total / total_pages"}
+
+	// ---------- ユーザー ----------
+
+	s.Register(jsonrpc.Method{
+		Name:        "users.list",
+		Description: "ユーザー一覧（ページネーション）",
+		Params:      pageParams,
+		Result:      pageResult,
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			var p rpcPageParams
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+			viewer, _ := currentUser(r)
+			users := store.ListUsers()
+			for i := range users {
+				users[i] = redactUser(viewer, users[i])
+			}
+			start, end := p.paginate(len(users))
+			return p.response(users[start:end], len(users)), nil
+		},
+	})
+	s.Register(jsonrpc.Method{
+		Name:        "users.get",
+		Description: "ユーザー詳細",
+		Params:      []jsonrpc.Param{idParam},
+		Result:      userResult,
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			var p struct {
+				ID int `json:"id"`
+			}
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+			user, ok := store.GetUser(p.ID)
+			if !ok {
+				return nil, jsonrpc.NewError(rpcCodeNotFound, "User not found", nil)
+			}
+			viewer, _ := currentUser(r)
+			return redactUser(viewer, user), nil
+		},
+	})
+	s.Register(jsonrpc.Method{
+		Name:        "users.me",
+		Description: "ログインユーザー（要認証）",
+		Result:      userResult,
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			return rpcUser(r)
+		},
+	})
+
+	// ---------- 投稿 ----------
+
+	s.Register(jsonrpc.Method{
+		Name:        "posts.list",
+		Description: "投稿一覧（user_id / status で絞り込み、ページネーション。公開前の投稿は投稿者と editor / admin だけ）",
+		Params: append([]jsonrpc.Param{
+			{Name: "user_id", Type: "integer"},
+			{Name: "status", Type: "array", Description: strings.Join(statusNames, " / ") + " のいずれか"},
+		}, pageParams...),
+		Result: pageResult,
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			var p struct {
+				UserID *int         `json:"user_id"`
+				Status []PostStatus `json:"status"`
+				rpcPageParams
+			}
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+
+			filter := PostFilter{WorkspaceID: currentWorkspace(r).ID, UserID: p.UserID}
+			if p.Status != nil {
+				filter.Statuses = make(map[PostStatus]bool)
+				for _, status := range p.Status {
+					if !isValidStatus(status) {
+						return nil, &ValidationError{Details: map[string]string{
+							"status": fmt.Sprintf("Unknown status %q", status),
+						}}
+					}
+					filter.Statuses[status] = true
+				}
+			}
+
+			filtered := []Post{}
+			for _, post := range visiblePosts(r) {
+				if filter.Match(post) {
+					filtered = append(filtered, post)
+				}
+			}
+			start, end := p.paginate(len(filtered))
+			return p.response(filtered[start:end], len(filtered)), nil
+		},
+	})
+	s.Register(jsonrpc.Method{
+		Name:        "posts.get",
+		Description: "投稿詳細",
+		Params:      []jsonrpc.Param{idParam},
+		Result:      postResult,
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			var p struct {
+				ID int `json:"id"`
+			}
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+			post, ok := visiblePost(r, p.ID)
+			if !ok {
+				return nil, ErrPostNotFound
+			}
+			return post, nil
+		},
+	})
+	s.Register(jsonrpc.Method{
+		Name:        "posts.create",
+		Description: "投稿作成（要認証、publish_at で予約投稿）",
+		Params: []jsonrpc.Param{
+			{Name: "title", Type: "string", Required: true},
+			{Name: "content", Type: "string", Required: true},
+			{Name: "published", Type: "boolean", Description: "editor / admin のみ true にできる"},
+			{Name: "publish_at", Type: "string", Description: "RFC 3339 形式の公開日時"},
+		},
+		Result: postResult,
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			author, err := rpcUser(r)
+			if err != nil {
+				return nil, err
+			}
+			var req CreatePostRequest
+			if err := params.Bind(&req); err != nil {
+				return nil, err
+			}
+			return createPost(r, author, req)
+		},
+	})
+	s.Register(jsonrpc.Method{
+		Name:        "posts.update",
+		Description: "投稿更新（要認証、指定したフィールドだけ更新）",
+		Params: []jsonrpc.Param{
+			idParam,
+			{Name: "title", Type: "string"},
+			{Name: "content", Type: "string"},
+			{Name: "publish_at", Type: "string", Description: "RFC 3339 形式の公開日時"},
+		},
+		Result: postResult,
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			user, err := rpcUser(r)
+			if err != nil {
+				return nil, err
+			}
+			var p struct {
+				ID int `json:"id"`
+				UpdatePostRequest
+			}
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+			return updatePost(r, user, p.ID, p.UpdatePostRequest)
+		},
+	})
+	s.Register(jsonrpc.Method{
+		Name:        "posts.delete",
+		Description: "投稿削除（要認証）",
+		Params:      []jsonrpc.Param{idParam},
+		Result:      postResult,
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			user, err := rpcUser(r)
+			if err != nil {
+				return nil, err
+			}
+			var p struct {
+				ID int `json:"id"`
+			}
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+			return deletePost(r, user, p.ID)
+		},
+	})
+	s.Register(jsonrpc.Method{
+		Name:        "posts.transition",
+		Description: "状態遷移（要認証、差し戻しには reason が必要）",
+		Params: []jsonrpc.Param{
+			idParam,
+			{Name: "to", Type: "string", Required: true, Description: "遷移先の状態"},
+			{Name: "reason", Type: "string"},
+		},
+		Result: postResult,
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			actor, err := rpcUser(r)
+			if err != nil {
+				return nil, err
+			}
+			var p struct {
+				ID int `json:"id"`
+				TransitionRequest
+			}
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+			return transitionPost(r, p.ID, actor, p.TransitionRequest)
+		},
+	})
+	s.Register(jsonrpc.Method{
+		Name:        "posts.transitions",
+		Description: "状態遷移の履歴",
+		Params:      []jsonrpc.Param{idParam},
+		Result:      jsonrpc.Param{Name: "transitions", Type: "array"},
+		Handler: func(r *http.Request, params jsonrpc.Params) (interface{}, error) {
+			var p struct {
+				ID int `json:"id"`
+			}
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+			if _, ok := visiblePost(r, p.ID); !ok {
+				return nil, ErrPostNotFound
+			}
+			history, ok := postsFor(r).Transitions(p.ID)
+			if !ok {
+				return nil, ErrPostNotFound
+			}
+			return history, nil
+		},
+	})
+
+	return s
+}
+
+// ========== 予約投稿スケジューラー ==========
+
+// PublishScheduler は time.Ticker で定期的に予約投稿をチェックし、
+// publish_at を過ぎた投稿を公開する
+type PublishScheduler struct {
+	store    *Store
+	interval time.Duration
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewPublishScheduler(store *Store, interval time.Duration) *PublishScheduler {
+	return &PublishScheduler{
+		store:    store,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *PublishScheduler) Start() {
+	// 起動直後にも一度実行する（停止中に公開日時を過ぎた投稿を拾うため）
+	s.publishDue()
+
+	ticker := time.NewTicker(s.interval)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.publishDue()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+func (s *PublishScheduler) Stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+func (s *PublishScheduler) publishDue() {
+	for _, p := range s.store.PublishDue(time.Now()) {
+		slog.Info("予約投稿を公開しました", "post_id", p.ID, "title", p.Title)
+		auditLog.Record(AuditEntry{
+			Action:       "post.publish",
+			ResourceType: "post",
+			ResourceID:   p.ID,
+			Changes: map[string]FieldChange{
+				"status":    {Before: StatusApproved, After: StatusPublished},
+				"published": {Before: false, After: true},
+			},
+		})
+		publishPostEvent(EventPostPublished, p)
+	}
+}
+
+// ========== 設定 ==========
+
+// Config はサーバー全体の設定。
+// 既定値 → 設定ファイル（-config または BLOG_CONFIG）→ 環境変数 → フラグ の順に上書きされる。
+// env タグのない項目の環境変数名はキーから作られる（server.addr → SERVER_ADDR、フラグは -server-addr）
+type Config struct {
+	Server      ServerConfig     `config:"server"`
+	Auth        AuthConfig       `config:"auth"`
+	Storage     StorageConfig    `config:"storage"`
+	Attachments AttachmentConfig `config:"attachments"`
+	Thumbnails  ThumbnailConfig  `config:"thumbnails"`
+	Mail        MailConfig       `config:"mail"`
+	Health      HealthConfig     `config:"health"`
+	Log         LogConfig        `config:"log"`
+	Trace       TraceConfig      `config:"trace"`
+}
+
+type ServerConfig struct {
+	Addr              string        `config:"addr" help:"待ち受けアドレス"`
+	ReadTimeout       time.Duration `config:"read_timeout" help:"リクエスト全体（本文を含む）を読む期限"`
+	ReadHeaderTimeout time.Duration `config:"read_header_timeout" help:"リクエストヘッダーを読む期限（Slowloris 対策）"`
+	WriteTimeout      time.Duration `config:"write_timeout" help:"レスポンスを書き終える期限（SSE・WebSocket は個別に延長する）"`
+	IdleTimeout       time.Duration `config:"idle_timeout" help:"keep-alive 接続を待つ時間"`
+	ShutdownTimeout   time.Duration `config:"shutdown_timeout" help:"停止時に処理中のリクエストを待つ時間"`
+	ShutdownDelay     time.Duration `config:"shutdown_delay" help:"停止時に readiness を落としてから受け付けを止めるまでの時間"`
+	PublicURL         string        `config:"public_url" env:"PUBLIC_URL" help:"メール・フィードのリンクに使う公開 URL（https://blog.example.com）。省略時は http://localhost:{addr のポート}"`
+	AllowedOrigins    []string      `config:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" help:"CORS と WebSocket で許可するオリジン（https://app.example.com、カンマ区切り）。空なら CORS は *、WebSocket は同じオリジンのみ"`
+}
+
+type AuthConfig struct {
+	TokenSecret              string        `config:"token_secret" env:"TOKEN_SECRET" secret:"true" help:"ログイン・確認メール・招待などのトークンの署名鍵（16バイト以上）"`
+	SessionTTL               time.Duration `config:"session_ttl" help:"ログイントークンの有効期間"`
+	RequireEmailVerification bool          `config:"require_email_verification" env:"REQUIRE_EMAIL_VERIFICATION" help:"メール認証が済むまでログインさせない"`
+}
+
+type StorageConfig struct {
+	Backend       string `config:"backend" help:"memory または file（省略時は data_file の有無で決まる）"`
+	DataFile      string `config:"data_file" env:"BLOG_DATA_FILE" help:"backend=file のときの保存先"`
+	AuditLog      string `config:"audit_log" env:"AUDIT_LOG_FILE" help:"監査ログのファイル"`
+	AttachmentDir string `config:"attachment_dir" env:"ATTACHMENT_DIR" help:"添付ファイルの保存先ディレクトリ"`
+}
+
+type MailConfig struct {
+	Backend  string `config:"backend" env:"MAILER" help:"log または smtp"`
+	From     string `config:"from" env:"SMTP_FROM" help:"送信元アドレス"`
+	SMTPAddr string `config:"smtp_addr" env:"SMTP_ADDR" help:"SMTP サーバー（host:port）"`
+	Username string `config:"username" env:"SMTP_USERNAME" help:"SMTP 認証のユーザー名"`
+	Password string `config:"password" env:"SMTP_PASSWORD" secret:"true" help:"SMTP 認証のパスワード"`
+	LogFile  string `config:"log_file" env:"MAIL_LOG_FILE" help:"backend=log のときの出力先（省略時は標準ログ）"`
+}
+
+type HealthConfig struct {
+	CheckTimeout time.Duration `config:"check_timeout" help:"ヘルスチェック1つの期限"`
+	CacheTTL     time.Duration `config:"cache_ttl" help:"readiness のチェック結果を使い回す時間"`
+	MinFreeDisk  int64         `config:"min_free_disk" help:"保存先に必要な空き容量（バイト）"`
+}
+
+type LogConfig struct {
+	Format string `config:"format" env:"LOG_FORMAT" help:"ログの形式（text または json）"`
+	Level  string `config:"level" env:"LOG_LEVEL" help:"出力する最低レベル（debug / info / warn / error）。実行中は /api/admin/log-level で変更できる"`
+}
+
+type TraceConfig struct {
+	Exporter   string `config:"exporter" env:"TRACE_EXPORTER" help:"トレースの書き出し先（memory / file / none）"`
+	File       string `config:"file" env:"TRACE_FILE" help:"exporter=file のときの書き出し先（JSON Lines）"`
+	BufferSize int    `config:"buffer_size" help:"exporter=memory のときに保持するトレースの数"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:              ":8080",
+			ReadTimeout:       30 * time.Second, // 添付ファイルのアップロードに間に合う長さ
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownTimeout:   15 * time.Second,
+		},
+		Auth: AuthConfig{
+			SessionTTL: 24 * time.Hour,
+		},
+		Storage: StorageConfig{
+			AuditLog:      "audit.jsonl",
+			AttachmentDir: "attachments",
+		},
+		Attachments: AttachmentConfig{
+			MaxSize: 10 << 20,
+			Types:   []string{"image/jpeg", "image/png", "image/gif", "image/webp", "application/pdf", "text/plain"},
+		},
+		Thumbnails: ThumbnailConfig{
+			Sizes:     []string{"small=160x160", "medium=480x480", "large=1280x1280"},
+			Workers:   2,
+			MaxPixels: imaging.DefaultMaxPixels,
+		},
+		Mail: MailConfig{
+			Backend: "log",
+			From:    "noreply@example.com",
+		},
+		Health: HealthConfig{
+			CheckTimeout: 2 * time.Second,
+			CacheTTL:     5 * time.Second,
+			MinFreeDisk:  100 << 20,
+		},
+		Log: LogConfig{
+			Format: "text",
+			Level:  "info",
+		},
+		Trace: TraceConfig{
+			Exporter:   "memory",
+			File:       "traces.jsonl",
+			BufferSize: 200,
+		},
+	}
+}
+
+// loadConfig は設定を読み込んで検証する。不正な設定ではサーバーを起動しない
+func loadConfig(args []string) (Config, config.Origin) {
+	cfg := defaultConfig()
+	origin, err := config.Load(&cfg, config.Options{Name: "02_advanced_api", Args: args, FileEnv: "BLOG_CONFIG"})
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("設定の読み込みに失敗しました: %v", err)
+	}
+
+	if cfg.Server.PublicURL == "" {
+		if _, port, err := net.SplitHostPort(cfg.Server.Addr); err == nil {
+			cfg.Server.PublicURL = "http://localhost:" + port
+		}
+	}
+	cfg.Server.PublicURL = strings.TrimSuffix(cfg.Server.PublicURL, "/")
+
+	if cfg.Storage.Backend == "" {
+		cfg.Storage.Backend = "memory"
+		if cfg.Storage.DataFile != "" {
+			cfg.Storage.Backend = "file"
+		}
+	}
+
+	if errs := validateConfig(cfg); errs != nil {
+		keys := make([]string, 0, len(errs))
+		for k := range errs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			log.Printf("設定エラー: %s: %s", k, errs[k])
+		}
+		log.Fatal("設定が不正なため起動を中止します")
+	}
+	return cfg, origin
+}
+
+func validateConfig(cfg Config) map[string]string {
+	errors := make(map[string]string)
+
+	if _, _, err := net.SplitHostPort(cfg.Server.Addr); err != nil {
+		errors["server.addr"] = "Must be host:port (e.g. :8080)"
+	}
+	timeouts := map[string]time.Duration{
+		"server.read_timeout":  cfg.Server.ReadTimeout,
+		"server.write_timeout": cfg.Server.WriteTimeout,
+		"server.idle_timeout":  cfg.Server.IdleTimeout,
+	}
+	for key, d := range timeouts {
+		if d < 0 {
+			errors[key] = "Must not be negative (0 means no timeout)"
+		}
+	}
+	// ヘッダーの読み込みに期限がないと、少しずつ送ってくる接続で枯渇させられる
+	if cfg.Server.ReadHeaderTimeout <= 0 {
+		errors["server.read_header_timeout"] = "Must be positive"
+	}
+	if cfg.Server.ShutdownTimeout <= 0 {
+		errors["server.shutdown_timeout"] = "Must be positive"
+	}
+	if cfg.Server.ShutdownDelay < 0 {
+		errors["server.shutdown_delay"] = "Must not be negative"
+	}
+	if u, err := url.Parse(cfg.Server.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		errors["server.public_url"] = "Must be an absolute http(s) URL (e.g. https://blog.example.com)"
+	}
+	for _, o := range cfg.Server.AllowedOrigins {
+		if _, ok := normalizeOrigin(o); !ok {
+			errors["server.allowed_origins"] = fmt.Sprintf("Invalid origin %q (must be scheme://host[:port])", o)
+		}
+	}
+
+	if cfg.Auth.TokenSecret != "" && len(cfg.Auth.TokenSecret) < 16 {
+		errors["auth.token_secret"] = "Must be at least 16 bytes"
+	}
+	if cfg.Auth.SessionTTL <= 0 {
+		errors["auth.session_ttl"] = "Must be positive"
+	}
+
+	switch cfg.Storage.Backend {
+	case "memory":
+		if cfg.Storage.DataFile != "" {
+			errors["storage.data_file"] = "Cannot be used with backend=memory"
+		}
+	case "file":
+		if cfg.Storage.DataFile == "" {
+			errors["storage.data_file"] = "Required when backend=file"
+		}
+	default:
+		errors["storage.backend"] = "Must be memory or file"
+	}
+	if cfg.Storage.AuditLog == "" {
+		errors["storage.audit_log"] = "Audit log file is required"
+	}
+	if cfg.Storage.AttachmentDir == "" {
+		errors["storage.attachment_dir"] = "Attachment directory is required"
+	}
+
+	if cfg.Attachments.MaxSize <= 0 {
+		errors["attachments.max_size"] = "Must be positive"
+	}
+	if len(cfg.Attachments.Types) == 0 {
+		errors["attachments.types"] = "At least one type is required"
+	}
+	for _, t := range cfg.Attachments.Types {
+		// Allowed は引数なしの "type/subtype" と比べるので、パラメータやワイルドカードは一致しない
+		if mediaType, params, err := mime.ParseMediaType(t); err != nil || mediaType != t || len(params) > 0 || strings.Contains(t, "*") || !strings.Contains(t, "/") {
+			errors["attachments.types"] = fmt.Sprintf("Invalid MIME type %q (must be type/subtype, e.g. image/png)", t)
+		}
+	}
+	if _, err := cfg.Thumbnails.ParseSizes(); err != nil {
+		errors["thumbnails.sizes"] = err.Error()
+	}
+	if cfg.Thumbnails.Workers <= 0 {
+		errors["thumbnails.workers"] = "Must be positive"
+	}
+	if cfg.Thumbnails.MaxPixels <= 0 {
+		errors["thumbnails.max_pixels"] = "Must be positive"
+	}
+
+	switch cfg.Mail.Backend {
+	case "log":
+	case "smtp":
+		if _, _, err := net.SplitHostPort(cfg.Mail.SMTPAddr); err != nil {
+			errors["mail.smtp_addr"] = "Must be host:port when backend=smtp"
+		}
+		if cfg.Mail.From == "" {
+			errors["mail.from"] = "Sender address is required"
+		}
+	default:
+		errors["mail.backend"] = "Must be log or smtp"
+	}
+
+	if cfg.Health.CheckTimeout <= 0 {
+		errors["health.check_timeout"] = "Must be positive"
+	}
+	if cfg.Health.CacheTTL < 0 {
+		errors["health.cache_ttl"] = "Must not be negative"
+	}
+	if cfg.Health.MinFreeDisk < 0 {
+		errors["health.min_free_disk"] = "Must not be negative"
+	}
+
+	if cfg.Log.Format != "text" && cfg.Log.Format != "json" {
+		errors["log.format"] = "Must be text or json"
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
+		errors["log.level"] = "Must be debug, info, warn or error"
+	}
+
+	switch cfg.Trace.Exporter {
+	case "memory":
+		if cfg.Trace.BufferSize <= 0 {
+			errors["trace.buffer_size"] = "Must be positive"
+		}
+	case "file":
+		if cfg.Trace.File == "" {
+			errors["trace.file"] = "Required when exporter=file"
+		}
+	case "none":
+	default:
+		errors["trace.exporter"] = "Must be memory, file or none"
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+// ========== グレースフルシャットダウン ==========
+
+// serve は SIGINT / SIGTERM を受け取るまでリクエストを処理し、受け取ったら
+// readiness を落として shutdown_delay だけ待ち（ロードバランサーが振り分け先から外すまで）、
+// 新しい接続の受け付けを止めて、処理中のリクエストが終わるのを shutdown_timeout まで待つ。
+// 期限を過ぎたら残りの接続を強制的に閉じる
+func serve(srv *http.Server, ln net.Listener, cfg ServerConfig) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	// 以降のシグナルは既定の動作に戻す（もう一度 Ctrl+C を押せばすぐに終了できる）
+	stop()
+	healthChecks.SetShuttingDown()
+	if cfg.ShutdownDelay > 0 {
+		slog.Info("readiness を失敗にしました。待機してから受け付けを止めます", "delay", cfg.ShutdownDelay)
+		time.Sleep(cfg.ShutdownDelay)
+	}
+	slog.Info("シャットダウンを開始します", "timeout", cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("時間内に終わらなかったリクエストを打ち切りました: %w", err)
+	}
+	slog.Info("処理中のリクエストはすべて完了しました")
+	return nil
+}
+
+var (
+	mailer            mail.Mailer
+	tokens            *signedtoken.Signer
+	auditLog          *AuditLog
+	webhookDispatcher *webhook.Dispatcher
+	postEvents        = NewEventBroker(1000)
+	commentHub        = NewCommentHub()
+	renderCache       = NewRenderCache(1000)
+	graphQLSchema     *graphql.Schema
+	rpcServer         *jsonrpc.Server
+	blobs             *blobstore.Store
+	thumbnailer       *Thumbnailer
+	attachmentConfig  AttachmentConfig
+	healthChecks      = health.NewRegistry()
+
+	// ブラウザは別のサイトからの WebSocket 接続にも Origin を付けるだけで止めないので、サーバー側で確かめる
+	commentUpgrader = &websocket.Upgrader{MaxMessageSize: 16 << 10, CheckOrigin: allowedWebSocketOrigin}
+
+	// server.allowed_origins（正規化した scheme://host）。空なら CORS は *、WebSocket は同じオリジンのみ
+	allowedOrigins map[string]bool
+
+	// server.public_url（末尾の / なし）。メール・フィードなど外へ出すリンクの基準
+	publicURL string
+
+	// auth.require_email_verification が true のとき、メール認証が済むまでログインできない
+	requireEmailVerification bool
+
+	// ログイントークンの有効期間（auth.session_ttl）
+	sessionTTL time.Duration
+)
+
+func main() {
+	// ========== 設定 ==========
+
+	cfg, origin := loadConfig(os.Args[1:])
+
+	// 以降のログ（log.Printf を含む）は設定した形式の構造化ログになる
+	logLevel.UnmarshalText([]byte(cfg.Log.Level))
+	slog.SetDefault(newLogger(cfg.Log))
+
+	// ポートが使えないときは、ワーカーを起動する前に失敗させる
+	ln, err := net.Listen("tcp", cfg.Server.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// ========== ストア / スケジューラー ==========
+
+	// backend=file（data_file を指定）ならファイルに永続化され、再起動後も予約投稿が残る
+	dataFile := ""
+	if cfg.Storage.Backend == "file" {
+		dataFile = cfg.Storage.DataFile
+	}
+	store = NewStore(dataFile)
+	if err := store.Load(); err != nil {
+		log.Fatal(err)
+	}
+
+	// 監査ログ
+	auditLog, err = OpenAuditLog(cfg.Storage.AuditLog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	// トレース（Webhook の配信もスパンを記録するので、ワーカーより先に用意して後に閉じる）
+	closeTracing, err := setupTracing(cfg.Trace)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeTracing()
+
+	webhookDispatcher = webhook.New(webhookEndpoints{}, webhook.Options{Workers: 4, Tracer: tracer})
+	webhookDispatcher.Start()
+	defer webhookDispatcher.Stop()
+
+	scheduler := NewPublishScheduler(store, time.Second)
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	// 添付ファイル（storage.attachment_dir と [attachments]）
+	attachmentConfig = cfg.Attachments
+	blobs, err = blobstore.New(cfg.Storage.AttachmentDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// サムネイル（[thumbnails]）
+	thumbnailer, err = NewThumbnailer(blobs, cfg.Thumbnails)
+	if err != nil {
+		log.Fatal(err)
+	}
+	thumbnailer.Start()
+	defer thumbnailer.Stop()
+
+	// ========== メール / トークン ==========
+
+	mailer = newMailer(cfg.Mail)
+	tokens = newTokenSignerFromSecret(cfg.Auth.TokenSecret)
+	requireEmailVerification = cfg.Auth.RequireEmailVerification
+	sessionTTL = cfg.Auth.SessionTTL
+	publicURL = cfg.Server.PublicURL
+	allowedOrigins = make(map[string]bool)
+	for _, o := range cfg.Server.AllowedOrigins {
+		origin, _ := normalizeOrigin(o)
+		allowedOrigins[origin] = true
+	}
+
+	graphQLSchema, err = newGraphQLSchema()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rpcServer = newRPCServer()
+
+	registerHealthChecks(cfg)
+	registerMetrics()
+
+	// ========== ルーティング ==========
+
+	// 認証
+	http.HandleFunc("/api/auth/login", loginHandler)
+	http.HandleFunc("/api/auth/ws-token", socketTokenHandler)
+	http.HandleFunc("/api/auth/register", registerHandler)
+	http.HandleFunc("/api/auth/verify", verifyEmailHandler)
+	http.HandleFunc("/api/auth/forgot", forgotPasswordHandler)
+	http.HandleFunc("/api/auth/reset", resetPasswordHandler)
+
+	// ユーザー
+	http.HandleFunc("/api/users", usersHandler)
+	http.HandleFunc("/api/users/", userHandler)
+
+	// ワークスペース
+	http.HandleFunc("/api/workspaces", workspacesHandler)
+	http.HandleFunc("/api/workspaces/", workspaceHandler)
+	http.HandleFunc("/api/invitations/accept", acceptInvitationHandler)
+
+	// APIキー
+	http.HandleFunc("/api/keys", apiKeysHandler)
+	http.HandleFunc("/api/keys/", apiKeyHandler)
+
+	// Webhook
+	http.HandleFunc("/api/webhooks", webhooksHandler)
+	http.HandleFunc("/api/webhooks/", webhookHandler)
+
+	// 管理者
+	http.HandleFunc("/api/admin/users/", adminUserHandler)
+	http.HandleFunc("/api/admin/audit", adminAuditHandler)
+	http.HandleFunc("/api/admin/log-level", adminLogLevelHandler)
+	http.HandleFunc("/debug/traces", debugTracesHandler)
+
+	// 投稿
+	http.HandleFunc("/api/posts", postsHandler)
+	http.HandleFunc("/api/posts/scheduled", scheduledPostsHandler)
+	http.HandleFunc("/api/posts/stream", postStreamHandler)
+	http.HandleFunc("/api/posts/by-slug/", postBySlugHandler)
+
+	// フィード
+	http.HandleFunc("/feeds/posts.rss", postsFeedHandler)
+	http.HandleFunc("/feeds/posts.atom", postsFeedHandler)
+	http.HandleFunc("/feeds/users/", userFeedHandler)
+	http.HandleFunc("/api/posts/", postHandler)
+
+	// コメント（WebSocket）
+	http.HandleFunc("/ws/posts/", commentSocketHandler)
+
+	// GraphQL
+	http.HandleFunc("/graphql", graphQLHandler)
+
+	// JSON-RPC
+	http.Handle("/rpc", rpcServer)
+
+	// ヘルスチェック
+	http.HandleFunc("/health", healthHandler)
+	http.HandleFunc("/livez", healthChecks.LivezHandler())
+	http.HandleFunc("/readyz", healthChecks.ReadyzHandler())
+
+	// メトリクス（Prometheus）
+	http.Handle("/metrics", metricsRegistry.Handler())
+
+	fmt.Println("高度なREST APIサーバー起動: " + cfg.Server.Addr)
+	fmt.Println("\n設定:")
+	for _, line := range config.Dump(&cfg, origin) {
+		fmt.Println("  " + line)
+	}
+	fmt.Println("\nエンドポイント:")
+	fmt.Println("  POST   /api/auth/login     - ログイン")
+	fmt.Println("  POST   /api/auth/ws-token  - WebSocket 接続用トークンの発行（要認証、1分間有効）")
+	fmt.Println("  POST   /api/auth/register  - ユーザー登録（確認メール送信）")
+	fmt.Println("  GET    /api/auth/verify    - メールアドレス確認（?token=）")
+	fmt.Println("  POST   /api/auth/forgot    - パスワードリセットメール送信")
+	fmt.Println("  POST   /api/auth/reset     - パスワード再設定")
+	fmt.Println("  GET    /api/users          - ユーザー一覧（ページネーション）")
+	fmt.Println("  GET    /api/users/{id}     - ユーザー詳細")
+	fmt.Println("  GET    /api/workspaces     - 所属するワークスペース一覧（要ログイン）")
+	fmt.Println("  POST   /api/workspaces     - ワークスペース作成（作成者が admin）")
+	fmt.Println("  GET    /api/workspaces/{slug} - ワークスペース詳細（メンバーと使用量）")
+	fmt.Println("  PUT    /api/workspaces/{slug}/quota - 上限の変更（admin）")
+	fmt.Println("  GET    /api/workspaces/{slug}/invitations - 未承諾の招待一覧（ワークスペースの admin）")
+	fmt.Println("  POST   /api/workspaces/{slug}/invitations - 招待メール送信（ワークスペースの admin）")
+	fmt.Println("  POST   /api/invitations/accept - 招待の承諾（要ログイン）")
+	fmt.Println("  GET    /api/keys           - 自分のAPIキー一覧（要ログイン）")
+	fmt.Println("  POST   /api/keys           - APIキー発行（キーは一度だけ表示）")
+	fmt.Println("  DELETE /api/keys/{id}      - APIキー失効")
+	fmt.Println("  GET    /api/webhooks       - Webhook一覧（admin）")
+	fmt.Println("  POST   /api/webhooks       - Webhook登録（admin）")
+	fmt.Println("  DELETE /api/webhooks/{id}  - Webhook削除（admin）")
+	fmt.Println("  GET    /api/webhooks/{id}/deliveries - 配信履歴（admin）")
+	fmt.Println("  POST   /api/webhooks/{id}/deliveries/{delivery_id}/redeliver - 再配信（admin）")
+	fmt.Println("  POST   /api/admin/users/{id}/unlock - ログインロック解除（admin）")
+	fmt.Println("  GET    /api/admin/audit    - 監査ログ検索（admin）")
+	fmt.Println("  GET    /api/admin/log-level - 現在のログレベル（admin）")
+	fmt.Println("  PUT    /api/admin/log-level - ログレベルの変更（admin、再起動で設定値に戻る）")
+	fmt.Println("  GET    /api/posts          - 投稿一覧（status フィルタ、ページネーション。公開前の投稿は投稿者と editor / admin だけ）")
+	fmt.Println("  POST   /api/posts          - 投稿作成（publish_at で予約投稿）")
+	fmt.Println("  GET    /api/posts/scheduled - 自分の予約投稿一覧（要認証）")
+	fmt.Println("  GET    /api/posts/stream   - 投稿の変更をリアルタイム配信（SSE）")
+	fmt.Println("  GET    /api/posts/by-slug/{slug} - スラッグで投稿を取得（旧スラッグは 301）")
+	fmt.Println("  GET    /api/posts/{id}     - 投稿詳細（content_html 付き。Accept: text/html なら HTML）")
+	fmt.Println("  PUT    /api/posts/{id}     - 投稿更新（投稿者・editor・admin）")
+	fmt.Println("  DELETE /api/posts/{id}     - 投稿削除（投稿者・editor・admin）")
+	fmt.Println("  GET    /api/posts/{id}/transitions - 状態遷移の履歴")
+	fmt.Println("  POST   /api/posts/{id}/transitions - 状態遷移（要認証）")
+	fmt.Println("  GET    /api/posts/{id}/comments - コメント一覧")
+	fmt.Println("  GET    /api/posts/{id}/attachments - 添付ファイル一覧")
+	fmt.Println("  POST   /api/posts/{id}/attachments - 添付ファイルのアップロード（multipart/form-data、投稿者本人または editor / admin）")
+	fmt.Println("  GET    /api/posts/{id}/attachments/{aid} - 添付ファイルのダウンロード（Range 対応）")
+	fmt.Println("  DELETE /api/posts/{id}/attachments/{aid} - 添付ファイルの削除（要認証）")
+	fmt.Println("  GET    /api/posts/{id}/attachments/{aid}/thumbnails/{name} - サムネイル（生成中は 503）")
+	fmt.Println("  GET    /feeds/posts.rss    - 公開済みの投稿の RSS 2.0 フィード")
+	fmt.Println("  GET    /feeds/posts.atom   - 公開済みの投稿の Atom フィード")
+	fmt.Println("  GET    /feeds/users/{id}.atom - 投稿者別の Atom フィード")
+	fmt.Println("  GET    /ws/posts/{id}      - コメントのリアルタイム送受信（WebSocket、要認証）")
+	fmt.Println("  POST   /graphql            - GraphQL（query / mutation、イントロスペクション対応）")
+	fmt.Println("  POST   /rpc                - JSON-RPC 2.0（バッチ・通知対応、rpc.discover でメソッド一覧）")
+	fmt.Println("  GET    /health             - ヘルスチェック（readyz と同じ判定）")
+	fmt.Println("  GET    /livez              - liveness（?verbose でチェックごとの結果）")
+	fmt.Println("  GET    /readyz             - readiness（保存先・空き容量・キュー。停止中は 503）")
+	fmt.Println("  GET    /metrics            - Prometheus 形式のメトリクス（リクエスト数・処理時間・ランタイム）")
+	fmt.Println("  GET    /debug/traces       - 直近のトレース（admin、?trace_id= でスパンの一覧）")
+
+	fmt.Println("\n投稿・コメント・GraphQL・JSON-RPC は X-Workspace ヘッダーまたは /w/{slug}/ の接頭辞でワークスペースを選ぶ")
+	fmt.Println("  例: curl http://localhost:8080/w/hanako-team/api/posts -H \"Authorization: Bearer $HANAKO_TOKEN\"")
+
+	handler := workspaceMiddleware(authMiddleware(requireWorkspaceAccess(http.DefaultServeMux)))
+	srv := &http.Server{
+		Handler:           httpMetrics.Middleware(routeLabel)(tracer.Middleware(routeLabel)(corsMiddleware(requestIDMiddleware(accessLogMiddleware(handler))))),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	// Shutdown は SSE のような終わらないリクエストや、乗っ取られた（Hijack）WebSocket 接続を待てないので、
+	// 停止が始まったらこちらから閉じる
+	srv.RegisterOnShutdown(func() {
+		postEvents.Close()
+		commentHub.CloseAll("server shutting down")
+	})
+
+	// serve から戻ったら、defer でワーカー（サムネイル・予約投稿・Webhook）と監査ログを順に止める
+	if err := serve(srv, ln, cfg.Server); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("サーバーの停止でエラーが発生しました", "err", err)
+	}
+}
+
+// ========== 認証ハンドラー ==========
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	var req LoginRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	// バリデーション
+	if err := validateLoginRequest(req); err != nil {
+		respondError(w, "Validation failed", http.StatusBadRequest, err)
+		return
+	}
+
+	// 試行回数の制限（存在しないメールアドレスでも同じように数え、存在有無を悟らせない）
+	accountKey := accountAttemptKey(req.Email)
+	ipKey := "ip:" + clientIP(r)
+	now := time.Now()
+
+	if wait, ok := ipAttempts.Begin(ipKey, now); !ok {
+		loginFailures.With("too_many_attempts").Inc()
+		respondTooManyAttempts(w, wait)
+		return
+	}
+	if wait, ok := accountAttempts.Begin(accountKey, now); !ok {
+		ipAttempts.Succeeded(ipKey, false)
+		loginFailures.With("too_many_attempts").Inc()
+		respondTooManyAttempts(w, wait)
+		return
+	}
+
+	// ユーザー検索
+	foundUser, ok := store.FindUserByCredentials(req.Email, req.Password)
+	if !ok {
+		loggerFrom(r.Context()).Warn("ログインに失敗しました", "email", req.Email, "ip", clientIP(r))
+		loginFailures.With("invalid_credentials").Inc()
+		respondError(w, "Invalid credentials", http.StatusUnauthorized, nil)
+		return
+	}
+
+	accountAttempts.Succeeded(accountKey, true)
+	ipAttempts.Succeeded(ipKey, false)
+
+	if requireEmailVerification && !foundUser.EmailVerified {
+		loginFailures.With("email_not_verified").Inc()
+		respondError(w, "Email not verified", http.StatusForbidden, nil)
+		return
+	}
+
+	token, expiresAt := issueSessionToken(foundUser)
+
+	respondJSON(w, LoginResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+		User:      foundUser,
+	}, http.StatusOK)
+}
+
+// POST /api/auth/ws-token - WebSocket の接続に使うトークンを発行する（ログイントークンが必要）
+func socketTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	user, ok := requireLoginToken(w, r)
+	if !ok {
+		return
+	}
+
+	token, expiresAt := issueSocketToken(user)
+	respondJSON(w, SocketTokenResponse{Token: token, ExpiresAt: expiresAt}, http.StatusOK)
+}
+
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	// User にはパスワードのハッシュしか持たせないので、専用のリクエスト型で受け取る
+	var req RegisterRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	// バリデーション（長さはハッシュ化する前の平文で調べる）
+	if err := validateRegister(req); err != nil {
+		respondError(w, "Validation failed", http.StatusBadRequest, err)
+		return
+	}
+
+	hash, err := password.Hash(req.Password)
+	if err != nil {
+		respondError(w, "Internal server error", http.StatusInternalServerError, nil)
+		return
+	}
+	user := User{
+		Username:     req.Username,
+		Email:        req.Email,
+		PasswordHash: hash,
+		Role:         RoleAuthor, // ロールは自己申告させない
+	}
+
+	// ユーザー作成（重複チェック込み）
+	created, ok := store.CreateUser(user)
+	if !ok {
+		respondError(w, "Email already exists", http.StatusConflict, nil)
+		return
+	}
+	recordAudit(r, "user.register", "user", created.ID, nil, created)
+
+	// 確認メールを送信
+	token := tokens.Sign(tokenPurposeVerify, created.ID, created.Email, 24*time.Hour)
+	sendMailAsync(mail.Mail{
+		To:      created.Email,
+		Subject: "メールアドレスの確認",
+		Body: fmt.Sprintf("%sさん、ご登録ありがとうございます。\n以下のURLを開いてメールアドレスを確認してください（24時間有効）:\n\n%s?token=%s\n",
+			created.Username, publicLink("/api/auth/verify"), token),
+	})
+
+	respondJSON(w, created, http.StatusCreated)
+}
+
+// GET  /api/auth/verify?token=...
+// POST /api/auth/verify {"token":"..."}
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var token string
+	switch r.Method {
+	case http.MethodGet:
+		token = r.URL.Query().Get("token")
+	case http.MethodPost:
+		var req struct {
+			Token string `json:"token"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		token = req.Token
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	userID, email, err := tokens.Verify(token, tokenPurposeVerify)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	// 発行後にメールアドレスが変わっていたら無効
+	var before User
+	user, ok := store.UpdateUser(userID, func(u *User) {
+		before = *u
+		if u.Email == email {
+			u.EmailVerified = true
+		}
+	})
+	if !ok || !user.EmailVerified {
+		respondError(w, signedtoken.ErrInvalid.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	recordAudit(r, "user.verify_email", "user", user.ID, before, user)
+
+	respondJSON(w, user, http.StatusOK)
+}
+
+// POST /api/auth/forgot - メールアドレスの存在有無に関わらず同じレスポンスを返す
+func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	var req ForgotPasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.Email == "" {
+		respondError(w, "Validation failed", http.StatusBadRequest, map[string]string{
+			"email": "Email is required",
+		})
+		return
+	}
+
+	if user, ok := store.FindUserByEmail(req.Email); ok {
+		token := tokens.Sign(tokenPurposeReset, user.ID, credentialVersion(user), time.Hour)
+		sendMailAsync(mail.Mail{
+			To:      user.Email,
+			Subject: "パスワードの再設定",
+			Body: fmt.Sprintf("%sさん\n以下のトークンを POST /api/auth/reset に送信してパスワードを再設定してください（1時間有効）:\n\n%s\n\n心当たりがない場合はこのメールを無視してください。\n",
+				user.Username, token),
+		})
+	}
+
+	respondJSON(w, map[string]string{
+		"message": "登録済みのメールアドレスであれば、再設定用のメールを送信しました",
+	}, http.StatusAccepted)
+}
+
+// POST /api/auth/reset
+func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	var req ResetPasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := validateResetPassword(req); err != nil {
+		respondError(w, "Validation failed", http.StatusBadRequest, err)
+		return
+	}
+
+	userID, version, err := tokens.Verify(req.Token, tokenPurposeReset)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	// ハッシュの計算は重いので、ストアのロックを取る前に済ませる
+	hash, err := password.Hash(req.NewPassword)
+	if err != nil {
+		respondError(w, "Internal server error", http.StatusInternalServerError, nil)
+		return
+	}
+
+	// バージョンが一致しなければ使用済み（パスワード変更済み）のトークン
+	reset := false
+	var before User
+	after, ok := store.UpdateUser(userID, func(u *User) {
+		before = *u
+		if credentialVersion(*u) == version {
+			u.PasswordHash = hash
+			u.CredentialVersion++
+			// リセットメールを受け取れた = メールアドレスの所有が確認できた
+			u.EmailVerified = true
+			reset = true
+		}
+	})
+	if !ok || !reset {
+		respondError(w, signedtoken.ErrInvalid.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	// パスワード自体は記録しない
+	recordAudit(r, "user.reset_password", "user", userID, before, after)
+
+	respondJSON(w, map[string]string{"message": "パスワードを再設定しました"}, http.StatusOK)
+}
+
+func respondTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(wait.Seconds() + 0.999)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondError(w, "Too many login attempts. Please try again later.", http.StatusTooManyRequests, nil)
+}
+
+// ========== APIキーハンドラー ==========
+
+// GET  /api/keys - 自分のAPIキー一覧
+// POST /api/keys - APIキー発行
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireLoginToken(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, store.ListAPIKeys(user.ID), http.StatusOK)
+	case http.MethodPost:
+		createAPIKeyHandler(w, r, user)
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func createAPIKeyHandler(w http.ResponseWriter, r *http.Request, user User) {
+	var req CreateAPIKeyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := validateCreateAPIKey(req); err != nil {
+		respondError(w, "Validation failed", http.StatusBadRequest, err)
+		return
+	}
+
+	key, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		respondError(w, "Failed to generate API key", http.StatusInternalServerError, nil)
+		return
+	}
+
+	apiKey := store.CreateAPIKey(APIKey{
+		UserID:    user.ID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now(),
+	})
+	recordAudit(r, "api_key.create", "api_key", apiKey.ID, nil, apiKey)
+
+	respondJSON(w, CreateAPIKeyResponse{Key: key, APIKey: apiKey}, http.StatusCreated)
+}
+
+// DELETE /api/keys/{id} - APIキー失効
+func apiKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := extractID(r.URL.Path, "/api/keys/")
+	if err != nil {
+		respondError(w, "Invalid API key ID", http.StatusBadRequest, nil)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, ok := requireLoginToken(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, ok := store.RevokeAPIKey(user.ID, id)
+	if !ok {
+		respondError(w, "API key not found", http.StatusNotFound, nil)
+		return
+	}
+	before := revoked
+	before.RevokedAt = nil
+	recordAudit(r, "api_key.revoke", "api_key", revoked.ID, before, revoked)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ========== Webhookハンドラー ==========
+
+// GET  /api/webhooks - Webhook一覧
+// POST /api/webhooks - Webhook登録
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, RoleAdmin)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, store.ListWebhooks(), http.StatusOK)
+	case http.MethodPost:
+		createWebhookHandler(w, r, user)
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func createWebhookHandler(w http.ResponseWriter, r *http.Request, user User) {
+	var req CreateWebhookRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := validateCreateWebhook(req); err != nil {
+		respondError(w, "Validation failed", http.StatusBadRequest, err)
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			respondError(w, "Failed to generate secret", http.StatusInternalServerError, nil)
+			return
+		}
+		secret = "whsec_" + hex.EncodeToString(b)
+	}
+
+	hook := store.CreateWebhook(Webhook{
+		URL:       req.URL,
+		Events:    req.Events,
+		Secret:    secret,
+		CreatedBy: user.ID,
+		CreatedAt: time.Now(),
+	})
+	recordAudit(r, "webhook.create", "webhook", hook.ID, nil, hook)
+
+	respondJSON(w, CreateWebhookResponse{Secret: secret, Webhook: hook}, http.StatusCreated)
+}
+
+// DELETE /api/webhooks/{id}
+// GET    /api/webhooks/{id}/deliveries
+// POST   /api/webhooks/{id}/deliveries/{delivery_id}/redeliver
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, sub, err := extractSubresource(r.URL.Path, "/api/webhooks/")
+	if err != nil {
+		respondError(w, "Invalid webhook ID", http.StatusBadRequest, nil)
+		return
+	}
+
+	if _, ok := requireRole(w, r, RoleAdmin); !ok {
+		return
+	}
+
+	parts := strings.Split(sub, "/")
+	switch {
+	case sub == "" && r.Method == http.MethodDelete:
+		hook, ok := store.DeleteWebhook(id)
+		if !ok {
+			respondError(w, "Webhook not found", http.StatusNotFound, nil)
+			return
+		}
+		recordAudit(r, "webhook.delete", "webhook", hook.ID, hook, nil)
+		w.WriteHeader(http.StatusNoContent)
+
+	case sub == "deliveries" && r.Method == http.MethodGet:
+		if _, ok := store.GetWebhook(id); !ok {
+			respondError(w, "Webhook not found", http.StatusNotFound, nil)
+			return
+		}
+		respondJSON(w, webhookDispatcher.Deliveries(id), http.StatusOK)
+
+	case len(parts) == 3 && parts[0] == "deliveries" && parts[2] == "redeliver" && r.Method == http.MethodPost:
+		if _, ok := store.GetWebhook(id); !ok {
+			respondError(w, "Webhook not found", http.StatusNotFound, nil)
+			return
+		}
+		del, err := webhookDispatcher.Redeliver(id, parts[1])
+		if err != nil {
+			respondError(w, err.Error(), http.StatusNotFound, nil)
+			return
+		}
+		recordAudit(r, "webhook.redeliver", "webhook", id, nil, map[string]string{
+			"delivery_id":          del.ID,
+			"original_delivery_id": parts[1],
+		})
+		respondJSON(w, del, http.StatusAccepted)
+
+	case sub == "" || sub == "deliveries" || len(parts) == 3:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	default:
+		respondError(w, "Not found", http.StatusNotFound, nil)
+	}
+}
+
+// ========== 管理者ハンドラー ==========
+
+// POST /api/admin/users/{id}/unlock - ログインロックを解除する
+func adminUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, sub, err := extractSubresource(r.URL.Path, "/api/admin/users/")
+	if err != nil {
+		respondError(w, "Invalid user ID", http.StatusBadRequest, nil)
+		return
+	}
+	if sub != "unlock" {
+		respondError(w, "Not found", http.StatusNotFound, nil)
+		return
+	}
+	if r.Method != http.MethodPost {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	if _, ok := requireRole(w, r, RoleAdmin); !ok {
+		return
+	}
+
+	user, ok := store.GetUser(id)
+	if !ok {
+		respondError(w, "User not found", http.StatusNotFound, nil)
+		return
+	}
+
+	unlocked := accountAttempts.Unlock(accountAttemptKey(user.Email))
+	recordAudit(r, "user.unlock", "user", user.ID, nil, nil)
+	respondJSON(w, map[string]interface{}{
+		"user_id":  user.ID,
+		"unlocked": unlocked,
+	}, http.StatusOK)
+}
+
+// GET /api/admin/audit - 監査ログの検索
+// フィルタ: actor_id, action, resource_type, resource_id, since, until（RFC3339）
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	if _, ok := requireRole(w, r, RoleAdmin); !ok {
+		return
+	}
+
+	filter, errs := parseAuditFilter(r)
+	if errs != nil {
+		respondError(w, "Invalid filter", http.StatusBadRequest, errs)
+		return
+	}
+
+	page, perPage := getPagination(r)
+	entries, total := auditLog.Query(filter, page, perPage)
+
+	respondJSON(w, PaginatedResponse{
+		Data:       entries,
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: (total + perPage - 1) / perPage,
+	}, http.StatusOK)
+}
+
+// GET /api/admin/log-level - 現在のログレベル
+// PUT /api/admin/log-level - ログレベルの変更（{"level": "debug"}）。再起動すると log.level の値に戻る
+func adminLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	if _, ok := requireRole(w, r, RoleAdmin); !ok {
+		return
+	}
+
+	type logLevelBody struct {
+		Level string `json:"level"`
+	}
+	current := logLevelBody{Level: strings.ToLower(logLevel.Level().String())}
+	if r.Method == http.MethodGet {
+		respondJSON(w, current, http.StatusOK)
+		return
+	}
+
+	var req logLevelBody
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+		respondError(w, "Validation failed", http.StatusBadRequest, map[string]string{
+			"level": "Must be debug, info, warn or error",
+		})
+		return
+	}
+
+	logLevel.Set(level)
+	updated := logLevelBody{Level: strings.ToLower(level.String())}
+	loggerFrom(r.Context()).Info("ログレベルを変更しました", "from", current.Level, "to", updated.Level)
+	recordAudit(r, "log.level", "log", 0, current, updated)
+	respondJSON(w, updated, http.StatusOK)
+}
+
+func parseAuditFilter(r *http.Request) (AuditFilter, map[string]string) {
+	q := r.URL.Query()
+	errors := make(map[string]string)
+	filter := AuditFilter{
+		Action:       q.Get("action"),
+		ResourceType: q.Get("resource_type"),
+	}
+
+	if v := q.Get("actor_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			errors["actor_id"] = "actor_id must be an integer"
+		}
+		filter.ActorID = id
+	}
+	if v := q.Get("resource_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			errors["resource_id"] = "resource_id must be an integer"
+		}
+		filter.ResourceID = id
+	}
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			errors["since"] = "since must be RFC3339"
+		}
+		filter.Since = t
+	}
+	if v := q.Get("until"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			errors["until"] = "until must be RFC3339"
+		}
+		filter.Until = t
+	}
+
+	if len(errors) > 0 {
+		return filter, errors
+	}
+	return filter, nil
+}
+
+// ========== ワークスペースハンドラー ==========
+
+// invitationTTL は招待の有効期限
+const invitationTTL = 7 * 24 * time.Hour
+
+// GET  /api/workspaces - 自分が所属するワークスペース一覧
+// POST /api/workspaces - ワークスペース作成（作成者が admin になる）
+func workspacesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, store.WorkspacesFor(user.ID), http.StatusOK)
+	case http.MethodPost:
+		createWorkspaceHandler(w, r, user)
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func createWorkspaceHandler(w http.ResponseWriter, r *http.Request, user User) {
+	var req CreateWorkspaceRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := validateCreateWorkspace(req); err != nil {
+		respondError(w, "Validation failed", http.StatusBadRequest, err)
+		return
+	}
+
+	ws, err := store.CreateWorkspace(workspace.Workspace{
+		Slug:      req.Slug,
+		Name:      req.Name,
+		Public:    req.Public,
+		Quota:     defaultWorkspaceQuota,
+		CreatedBy: user.ID,
+	})
+	if err != nil {
+		respondError(w, err.Error(), http.StatusConflict, nil)
+		return
+	}
+	recordAudit(r, "workspace.create", "workspace", ws.ID, nil, ws)
+
+	respondJSON(w, WorkspaceResponse{Workspace: ws, Role: RoleAdmin}, http.StatusCreated)
+}
+
+// GET  /api/workspaces/{slug}             - 詳細（メンバーと使用量、メンバーのみ）
+// PUT  /api/workspaces/{slug}/quota       - 上限の変更（インスタンスの admin）
+// GET  /api/workspaces/{slug}/invitations - 未承諾の招待一覧（ワークスペースの admin）
+// POST /api/workspaces/{slug}/invitations - 招待（トークンをメールで送る）
+func workspaceHandler(w http.ResponseWriter, r *http.Request) {
+	slug, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/workspaces/"), "/")
+
+	user, ok := currentUser(r)
+	if !ok {
+		respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+		return
+	}
+
+	// メンバー以外には存在しないワークスペースと同じ 404 を返す
+	ws, found := store.FindWorkspaceBySlug(slug)
+	member, isMember := memberAs(ws, user)
+	if !found || !isMember {
+		respondError(w, "Workspace not found", http.StatusNotFound, nil)
+		return
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		usage := store.WorkspaceUsage(ws.ID)
+		respondJSON(w, WorkspaceResponse{
+			Workspace: ws,
+			Role:      member.Role,
+			Usage:     &usage,
+			Members:   store.Members(ws.ID),
+		}, http.StatusOK)
+
+	case sub == "quota" && r.Method == http.MethodPut:
+		// 上限はインスタンスの管理者だけが変更できる（ワークスペースの admin では不可）
+		if user.Role != RoleAdmin {
+			respondError(w, ErrForbidden.Error(), http.StatusForbidden, nil)
+			return
+		}
+		var quota workspace.Quota
+		if !decodeJSON(w, r, &quota) {
+			return
+		}
+		if err := validateWorkspaceQuota(quota); err != nil {
+			respondError(w, "Validation failed", http.StatusBadRequest, err)
+			return
+		}
+		before, after, _ := store.UpdateWorkspaceQuota(ws.ID, quota)
+		recordAudit(r, "workspace.quota", "workspace", ws.ID, before, after)
+		respondJSON(w, after, http.StatusOK)
+
+	case sub == "invitations" && (r.Method == http.MethodGet || r.Method == http.MethodPost):
+		if member.Role != RoleAdmin {
+			respondError(w, ErrForbidden.Error(), http.StatusForbidden, nil)
+			return
+		}
+		if r.Method == http.MethodGet {
+			respondJSON(w, store.PendingInvitations(ws.ID, time.Now()), http.StatusOK)
+			return
+		}
+		createInvitationHandler(w, r, ws, member)
+
+	case sub == "" || sub == "quota" || sub == "invitations":
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	default:
+		respondError(w, "Not found", http.StatusNotFound, nil)
+	}
+}
+
+func createInvitationHandler(w http.ResponseWriter, r *http.Request, ws workspace.Workspace, inviter User) {
+	var req CreateInvitationRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := validateCreateInvitation(req); err != nil {
+		respondError(w, "Validation failed", http.StatusBadRequest, err)
+		return
+	}
+
+	if existing, ok := store.FindUserByEmail(req.Email); ok {
+		if _, member := store.MemberRole(ws.ID, existing.ID); member {
+			respondError(w, workspace.ErrAlreadyMember.Error(), http.StatusConflict, nil)
+			return
+		}
+	}
+
+	now := time.Now()
+	inv, err := store.CreateInvitation(workspace.Invitation{
+		WorkspaceID: ws.ID,
+		Email:       req.Email,
+		Role:        req.Role,
+		InvitedBy:   inviter.ID,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(invitationTTL),
+	})
+	var qerr *workspace.QuotaError
+	if errors.As(err, &qerr) {
+		respondError(w, err.Error(), http.StatusForbidden, qerr.Details())
+		return
+	}
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError, nil)
+		return
+	}
+	recordAudit(r, "invitation.create", "workspace", ws.ID, nil, inv)
+
+	// トークンには招待IDだけを入れる（ロールやメールアドレスはストアの招待を正とする）
+	token := tokens.Sign(tokenPurposeInvite, inv.ID, "", invitationTTL)
+	sendMailAsync(mail.Mail{
+		To:      inv.Email,
+		Subject: fmt.Sprintf("「%s」への招待", ws.Name),
+		Body: fmt.Sprintf("%sさんから「%s」に招待されました（ロール: %s、7日間有効）。\nこのメールアドレスのアカウントでログインし、以下のトークンを %s に POST してください:\n\n%s\n",
+			inviter.Username, ws.Name, inv.Role, publicLink("/api/invitations/accept"), token),
+	})
+
+	respondJSON(w, inv, http.StatusCreated)
+}
+
+// POST /api/invitations/accept {"token":"..."} - 招待を承諾してメンバーになる（要ログイン）
+func acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, ok := currentUser(r)
+	if !ok {
+		respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	invitationID, _, err := tokens.Verify(req.Token, tokenPurposeInvite)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	inv, membership, err := store.AcceptInvitation(invitationID, user, time.Now())
+	var qerr *workspace.QuotaError
+	switch {
+	case err == nil:
+	case errors.As(err, &qerr):
+		respondError(w, err.Error(), http.StatusForbidden, qerr.Details())
+		return
+	case errors.Is(err, workspace.ErrInvitationNotFound):
+		respondError(w, err.Error(), http.StatusNotFound, nil)
+		return
+	case errors.Is(err, workspace.ErrInvitationEmail):
+		respondError(w, err.Error(), http.StatusForbidden, nil)
+		return
+	case errors.Is(err, workspace.ErrInvitationUsed), errors.Is(err, workspace.ErrAlreadyMember):
+		respondError(w, err.Error(), http.StatusConflict, nil)
+		return
+	default:
+		respondError(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	recordAudit(r, "invitation.accept", "workspace", inv.WorkspaceID, nil, membership)
+
+	respondJSON(w, membership, http.StatusOK)
+}
+
+// ========== ユーザーハンドラー ==========
+
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	users := store.ListUsers()
+
+	// ページネーション
+	page, perPage := getPagination(r)
+
+	start := (page - 1) * perPage
+	end := start + perPage
+
+	if start >= len(users) {
+		respondJSON(w, PaginatedResponse{
+			Data:       []User{},
+			Page:       page,
+			PerPage:    perPage,
+			Total:      len(users),
+			TotalPages: (len(users) + perPage - 1) / perPage,
+		}, http.StatusOK)
+		return
+	}
+
+	if end > len(users) {
+		end = len(users)
+	}
+
+	respondJSON(w, PaginatedResponse{
+		Data:       users[start:end],
+		Page:       page,
+		PerPage:    perPage,
+		Total:      len(users),
+		TotalPages: (len(users) + perPage - 1) / perPage,
+	}, http.StatusOK)
+}
+
+func userHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := extractID(r.URL.Path, "/api/users/")
+	if err != nil {
+		respondError(w, "Invalid user ID", http.StatusBadRequest, nil)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, ok := store.GetUser(id)
+	if !ok {
+		respondError(w, "User not found", http.StatusNotFound, nil)
+		return
+	}
+
+	respondJSON(w, user, http.StatusOK)
+}
+
+// ========== 投稿の操作（REST / GraphQL 共通） ==========
+
+// ValidationError はフィールドごとの入力エラー
+type ValidationError struct {
+	Details map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	return "Validation failed"
+}
+
+// createPost はバリデーションと権限チェックをして投稿を作成し、監査ログとイベントを記録する
+func createPost(r *http.Request, author User, req CreatePostRequest) (Post, error) {
+	if errs := validateCreatePost(req); errs != nil {
+		return Post{}, &ValidationError{Details: errs}
+	}
+
+	// 権限はワークスペース内のロールで判定する
+	author, ok := memberAs(currentWorkspace(r), author)
+	if !ok {
+		return Post{}, workspace.ErrNotMember
+	}
+
+	// 作成時に公開できるのは editor / admin のみ（author はワークフローを通す）
+	status := StatusDraft
+	if req.Published {
+		if !canPublish(author) {
+			return Post{}, ErrForbidden
+		}
+		status = StatusPublished
+	}
+
+	post := Post{
+		UserID:    author.ID,
+		Title:     req.Title,
+		Slug:      req.Slug,
+		Content:   req.Content,
+		PublishAt: req.PublishAt,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	post.setStatus(status)
+	post, err := postsFor(r).Create(post)
+	if err != nil {
+		return Post{}, err
+	}
+	recordAudit(r, "post.create", "post", post.ID, nil, post)
+	postsCreated.Inc()
+	publishPostEvent(EventPostCreated, post)
+	if post.Status == StatusPublished {
+		publishPostEvent(EventPostPublished, post)
+	}
+	return post, nil
+}
+
+// updatePost は投稿者本人か editor / admin だけが更新できる
+func updatePost(r *http.Request, actor User, id int, req UpdatePostRequest) (Post, error) {
+	if errs := validateUpdatePost(req); errs != nil {
+		return Post{}, &ValidationError{Details: errs}
+	}
+
+	actor, ok := memberAs(currentWorkspace(r), actor)
+	if !ok {
+		return Post{}, workspace.ErrNotMember
+	}
+
+	var before Post
+	post, err := postsFor(r).Update(id, func(p *Post) error {
+		// 権限の確認は更新と同じロックの中で行う
+		if !canEditPost(actor, *p) {
+			return ErrForbidden
+		}
+		before = *p
+		if req.PublishAt != nil {
+			if p.Status == StatusPublished || p.Status == StatusArchived {
+				return ErrAlreadyPublished
+			}
+			p.PublishAt = req.PublishAt
+		}
+		if req.Title != nil {
+			p.Title = *req.Title
+		}
+		if req.Content != nil {
+			p.Content = *req.Content
+		}
+		// タイトルを変えてもスラッグは変えない（指定されたときだけ変える）
+		if req.Slug != nil {
+			p.Slug = *req.Slug
+		}
+		return nil
+	})
+	if errors.Is(err, ErrAlreadyPublished) {
+		return Post{}, &ValidationError{Details: map[string]string{"publish_at": err.Error()}}
+	}
+	if err != nil {
+		return Post{}, err
+	}
+	renderCache.Invalidate(post.ID)
+	recordAudit(r, "post.update", "post", post.ID, before, post)
+	publishPostEvent(EventPostUpdated, post)
+	return post, nil
+}
+
+// deletePost は投稿者本人か editor / admin だけが削除できる
+func deletePost(r *http.Request, actor User, id int) (Post, error) {
+	actor, ok := memberAs(currentWorkspace(r), actor)
+	if !ok {
+		return Post{}, workspace.ErrNotMember
+	}
+
+	// 投稿者は変わらないので、先に読んで確認してから削除してよい
+	posts := postsFor(r)
+	post, ok := posts.Get(id)
+	if !ok {
+		return Post{}, ErrPostNotFound
+	}
+	if !canEditPost(actor, post) {
+		return Post{}, ErrForbidden
+	}
+
+	deleted, attachments, ok := posts.Delete(id)
+	if !ok {
+		return Post{}, ErrPostNotFound
+	}
+	cleanupAttachments(attachments)
+	renderCache.Invalidate(deleted.ID)
+	recordAudit(r, "post.delete", "post", deleted.ID, deleted, nil)
+	publishPostEvent(EventPostDeleted, deleted)
+	commentHub.CloseRoom(deleted.ID, "post deleted")
+	return deleted, nil
+}
+
+func transitionPost(r *http.Request, id int, actor User, req TransitionRequest) (Post, error) {
+	if !isValidStatus(req.To) {
+		return Post{}, &ValidationError{Details: map[string]string{"to": "Unknown status"}}
+	}
+
+	actor, ok := memberAs(currentWorkspace(r), actor)
+	if !ok {
+		return Post{}, workspace.ErrNotMember
+	}
+
+	before, post, err := postsFor(r).Transition(id, req.To, actor, req.Reason)
+	if errors.Is(err, ErrReasonRequired) {
+		return Post{}, &ValidationError{Details: map[string]string{"reason": err.Error()}}
+	}
+	if err != nil {
+		return Post{}, err
+	}
+	recordAudit(r, "post.transition", "post", post.ID, before, post)
+	publishPostEvent(EventPostUpdated, post)
+	if post.Status == StatusPublished {
+		publishPostEvent(EventPostPublished, post)
+	}
+	return post, nil
+}
+
+// respondPostError は投稿の操作のエラーを HTTP ステータスに変換する
+func respondPostError(w http.ResponseWriter, err error) {
+	var verr *ValidationError
+	var qerr *workspace.QuotaError
+	switch {
+	case errors.As(err, &verr):
+		respondError(w, "Validation failed", http.StatusBadRequest, verr.Details)
+	case errors.Is(err, ErrPostNotFound):
+		respondError(w, "Post not found", http.StatusNotFound, nil)
+	case errors.Is(err, ErrForbidden), errors.Is(err, workspace.ErrNotMember):
+		respondError(w, err.Error(), http.StatusForbidden, nil)
+	case errors.As(err, &qerr):
+		respondError(w, err.Error(), http.StatusForbidden, qerr.Details())
+	case errors.Is(err, ErrInvalidTransition):
+		respondError(w, err.Error(), http.StatusConflict, nil)
+	case errors.Is(err, ErrSlugTaken):
+		respondError(w, err.Error(), http.StatusConflict, map[string]string{"slug": err.Error()})
+	default:
+		respondError(w, err.Error(), http.StatusInternalServerError, nil)
+	}
+}
+
+// ========== 投稿ハンドラー ==========
+
+func postsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getPostsHandler(w, r)
+	case http.MethodPost:
+		createPostHandler(w, r)
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func getPostsHandler(w http.ResponseWriter, r *http.Request) {
+	// フィルタリング
+	filter, errs := parsePostFilter(r)
+	if errs != nil {
+		respondError(w, "Invalid status", http.StatusBadRequest, errs)
+		return
+	}
+
+	var filtered []Post
+	for _, p := range visiblePosts(r) {
+		if filter.Match(p) {
+			filtered = append(filtered, p)
+		}
+	}
+
+	// ページネーション
+	page, perPage := getPagination(r)
+
+	start := (page - 1) * perPage
+	end := start + perPage
+
+	if start >= len(filtered) {
+		respondJSON(w, PaginatedResponse{
+			Data:       []Post{},
+			Page:       page,
+			PerPage:    perPage,
+			Total:      len(filtered),
+			TotalPages: (len(filtered) + perPage - 1) / perPage,
+		}, http.StatusOK)
+		return
+	}
+
+	if end > len(filtered) {
+		end = len(filtered)
+	}
+
+	respondJSON(w, PaginatedResponse{
+		Data:       filtered[start:end],
+		Page:       page,
+		PerPage:    perPage,
+		Total:      len(filtered),
+		TotalPages: (len(filtered) + perPage - 1) / perPage,
+	}, http.StatusOK)
+}
+
+func createPostHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreatePostRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	author, ok := currentUser(r)
+	if !ok {
+		respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+		return
+	}
+
+	post, err := createPost(r, author, req)
+	if err != nil {
+		respondPostError(w, err)
+		return
+	}
+
+	respondJSON(w, post, http.StatusCreated)
+}
+
+func postHandler(w http.ResponseWriter, r *http.Request) {
+	id, sub, err := extractSubresource(r.URL.Path, "/api/posts/")
+	if err != nil {
+		respondError(w, "Invalid post ID", http.StatusBadRequest, nil)
+		return
+	}
+
+	// サブリソース（/api/posts/{id}/transitions）
+	switch sub {
+	case "":
+	case "transitions":
+		transitionsHandler(w, r, id)
+		return
+	case "comments":
+		commentsHandler(w, r, id)
+		return
+	case "attachments":
+		attachmentsHandler(w, r, id, "")
+		return
+	default:
+		if aid, ok := strings.CutPrefix(sub, "attachments/"); ok {
+			attachmentsHandler(w, r, id, aid)
+			return
+		}
+		respondError(w, "Not found", http.StatusNotFound, nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		getPostHandler(w, r, id)
+	case http.MethodPut:
+		updatePostHandler(w, r, id)
+	case http.MethodDelete:
+		deletePostHandler(w, r, id)
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// GET /api/posts/by-slug/{slug} - スラッグで投稿を取得
+// 以前のスラッグなら現在のスラッグへ 301 で転送する
+func postBySlugHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	slug := strings.TrimPrefix(r.URL.Path, "/api/posts/by-slug/")
+	if !slugify.Valid(slug) {
+		respondError(w, "Post not found", http.StatusNotFound, nil)
+		return
+	}
+
+	post, moved, ok := postsFor(r).FindBySlug(slug)
+	user, _ := currentUser(r)
+	if !ok || !canViewPost(currentWorkspace(r), user, post) {
+		respondError(w, "Post not found", http.StatusNotFound, nil)
+		return
+	}
+	if moved {
+		// 相対 URL にすると、/w/{slug}/ の接頭辞付きで来たリクエストもそのまま転送できる。
+		// http.Redirect は（接頭辞を取り除いた）r.URL.Path を基準に絶対パスへ直してしまうので使わない
+		location := post.Slug
+		if r.URL.RawQuery != "" {
+			location += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+	getPostHandler(w, r, post.ID)
+}
+
+func getPostHandler(w http.ResponseWriter, r *http.Request, id int) {
+	post, ok := visiblePost(r, id)
+	if !ok {
+		respondError(w, "Post not found", http.StatusNotFound, nil)
+		return
+	}
+
+	// 同じ URL で HTML と JSON を返し分けるので、キャッシュには Accept の違いを伝える
+	w.Header().Add("Vary", "Accept")
+	if prefersHTML(r) {
+		respondPostHTML(w, post)
+		return
+	}
+	respondJSON(w, PostDetailResponse{Post: post, ContentHTML: renderCache.HTML(post)}, http.StatusOK)
+}
+
+func updatePostHandler(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := currentUser(r)
+	if !ok {
+		respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req UpdatePostRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	post, err := updatePost(r, user, id, req)
+	if err != nil {
+		respondPostError(w, err)
+		return
+	}
+
+	respondJSON(w, post, http.StatusOK)
+}
+
+func deletePostHandler(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := currentUser(r)
+	if !ok {
+		respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if _, err := deletePost(r, user, id); err != nil {
+		respondPostError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET  /api/posts/{id}/transitions - 状態遷移の履歴
+// POST /api/posts/{id}/transitions - 状態遷移
+func transitionsHandler(w http.ResponseWriter, r *http.Request, id int) {
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := visiblePost(r, id); !ok {
+			respondError(w, "Post not found", http.StatusNotFound, nil)
+			return
+		}
+		history, ok := postsFor(r).Transitions(id)
+		if !ok {
+			respondError(w, "Post not found", http.StatusNotFound, nil)
+			return
+		}
+		respondJSON(w, history, http.StatusOK)
+	case http.MethodPost:
+		createTransitionHandler(w, r, id)
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func createTransitionHandler(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := currentUser(r)
+	if !ok {
+		respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req TransitionRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	post, err := transitionPost(r, id, user, req)
+	if err != nil {
+		respondPostError(w, err)
+		return
+	}
+	respondJSON(w, post, http.StatusOK)
+}
+
+// PostFilter は投稿一覧と SSE ストリームで共通の絞り込み条件
+type PostFilter struct {
+	WorkspaceID int
+	UserID      *int
+	Statuses    map[PostStatus]bool
+	Published   *bool // 互換性のため残している
+}
+
+// parsePostFilter はクエリ（user_id, status, published）からフィルタを作る
+func parsePostFilter(r *http.Request) (PostFilter, map[string]string) {
+	q := r.URL.Query()
+	filter := PostFilter{WorkspaceID: currentWorkspace(r).ID}
+
+	// ユーザーIDでフィルタ
+	if v := q.Get("user_id"); v != "" {
+		userID, _ := strconv.Atoi(v)
+		filter.UserID = &userID
+	}
+
+	// 状態でフィルタ（カンマ区切りで複数指定可: status=draft,in_review）
+	if v := q.Get("status"); v != "" {
+		filter.Statuses = make(map[PostStatus]bool)
+		for _, st := range strings.Split(v, ",") {
+			status := PostStatus(strings.TrimSpace(st))
+			if !isValidStatus(status) {
+				return filter, map[string]string{
+					"status": fmt.Sprintf("Unknown status %q", status),
+				}
+			}
+			filter.Statuses[status] = true
+		}
+	}
+
+	// 公開状態でフィルタ
+	if v := q.Get("published"); v != "" {
+		published := v == "true"
+		filter.Published = &published
+	}
+
+	return filter, nil
+}
+
+func (f PostFilter) Match(p Post) bool {
+	// 他のワークスペースのイベントは配信しない
+	if p.WorkspaceID != f.WorkspaceID {
+		return false
+	}
+	if f.UserID != nil && p.UserID != *f.UserID {
+		return false
+	}
+	if f.Statuses != nil && !f.Statuses[p.Status] {
+		return false
+	}
+	if f.Published != nil && p.Published != *f.Published {
+		return false
+	}
+	return true
+}
+
+// GET /api/posts/stream - 投稿の変更を Server-Sent Events で配信する
+// フィルタは一覧と同じ（user_id, status, published）
+func postStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
 		return
 	}
 
-	// バリデーション
-	if err := validateCreatePost(req); err != nil {
-		respondError(w, "Validation failed", http.StatusBadRequest, err)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, "Streaming not supported", http.StatusInternalServerError, nil)
 		return
 	}
 
-	post := Post{
-		ID:        nextPostID,
-		UserID:    1, // 実際は認証から取得
-		Title:     req.Title,
-		Content:   req.Content,
-		Published: req.Published,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	filter, errs := parsePostFilter(r)
+	if errs != nil {
+		respondError(w, "Invalid status", http.StatusBadRequest, errs)
+		return
+	}
+	// 公開前の投稿のイベントは、一覧と同じく読める購読者にだけ送る
+	ws := currentWorkspace(r)
+	user, _ := currentUser(r)
+	deliver := func(p Post) bool {
+		return filter.Match(p) && canViewPost(ws, user, p)
+	}
+
+	// 再接続時はブラウザが Last-Event-ID ヘッダーを付けてくる
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var lastEventID int64
+	if lastID != "" {
+		lastEventID, _ = strconv.ParseInt(lastID, 10, 64)
+	}
+
+	sub, replay := postEvents.Subscribe(lastEventID)
+	defer postEvents.Unsubscribe(sub)
+	logger := loggerFrom(r.Context())
+	logger.Debug("SSE の購読を開始しました", "last_event_id", lastEventID, "replay", len(replay))
+	defer logger.Debug("SSE の購読を終了しました")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // リバースプロキシのバッファリングを無効化
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	write := func(format string, args ...interface{}) bool {
+		// 書き込みが詰まったクライアントでハンドラーが止まり続けないよう期限を付ける
+		rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	if !write("retry: 3000\n\n") {
+		return
+	}
+	for _, ev := range replay {
+		if deliver(ev.Post) && !writeSSEEvent(write, ev) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case ev := <-sub.events:
+			if deliver(ev.Post) && !writeSSEEvent(write, ev) {
+				return
+			}
+		case <-heartbeat.C:
+			// コメント行はクライアントに無視されるが、接続を維持できる
+			if !write(": heartbeat\n\n") {
+				return
+			}
+		case <-sub.dropped:
+			// 受信が追いつかないクライアントやサーバー停止時は切断する（再接続時に Last-Event-ID で再開できる）
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeSSEEvent(write func(string, ...interface{}) bool, ev PostEvent) bool {
+	data, err := json.Marshal(ev.Post)
+	if err != nil {
+		return true
+	}
+	return write("id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+}
+
+// GET /api/posts/{id}/comments - コメント一覧
+func commentsHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	if _, ok := visiblePost(r, id); !ok {
+		respondError(w, "Post not found", http.StatusNotFound, nil)
+		return
+	}
+	respondJSON(w, postsFor(r).Comments(id), http.StatusOK)
+}
+
+// GET  /api/posts/{id}/attachments        - 添付ファイル一覧
+// POST /api/posts/{id}/attachments        - アップロード（multipart/form-data の file フィールド、要認証）
+// GET  /api/posts/{id}/attachments/{aid}  - ダウンロード（Range リクエスト対応）
+// DELETE /api/posts/{id}/attachments/{aid} - 削除（アップロードした本人・投稿者・editor / admin）
+func attachmentsHandler(w http.ResponseWriter, r *http.Request, postID int, sub string) {
+	// 読めない投稿の添付ファイル（一覧・ダウンロード・サムネイル）は、投稿と同じく存在しないものとして扱う
+	if _, ok := visiblePost(r, postID); !ok {
+		respondError(w, "Post not found", http.StatusNotFound, nil)
+		return
+	}
+	posts := postsFor(r)
+
+	if sub == "" {
+		switch r.Method {
+		case http.MethodGet:
+			list, ok := posts.Attachments(postID)
+			if !ok {
+				respondError(w, "Post not found", http.StatusNotFound, nil)
+				return
+			}
+			respondJSON(w, list, http.StatusOK)
+		case http.MethodPost:
+			uploadAttachmentHandler(w, r, postID)
+		default:
+			respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		}
+		return
+	}
+
+	idStr, rest, _ := strings.Cut(sub, "/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondError(w, "Invalid attachment ID", http.StatusBadRequest, nil)
+		return
+	}
+	attachment, ok := posts.Attachment(postID, id)
+	if !ok {
+		respondError(w, "Attachment not found", http.StatusNotFound, nil)
+		return
+	}
+	if rest != "" {
+		name, ok := strings.CutPrefix(rest, "thumbnails/")
+		if !ok {
+			respondError(w, "Not found", http.StatusNotFound, nil)
+			return
+		}
+		serveThumbnail(w, r, attachment, name)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		serveAttachment(w, r, attachment)
+	case http.MethodDelete:
+		user, ok := currentUser(r)
+		if !ok {
+			respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+			return
+		}
+		post, _ := posts.Get(postID)
+		member, _ := memberAs(currentWorkspace(r), user)
+		if user.ID != attachment.UploadedBy && user.ID != post.UserID && !canPublish(member) {
+			respondError(w, ErrForbidden.Error(), http.StatusForbidden, nil)
+			return
+		}
+
+		deleted, ok := posts.DeleteAttachment(postID, id)
+		if !ok {
+			respondError(w, "Attachment not found", http.StatusNotFound, nil)
+			return
+		}
+		cleanupAttachments([]Attachment{deleted})
+		recordAudit(r, "attachment.delete", "attachment", deleted.ID, deleted, nil)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func uploadAttachmentHandler(w http.ResponseWriter, r *http.Request, postID int) {
+	user, ok := currentUser(r)
+	if !ok {
+		respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+		return
+	}
+	member, ok := memberAs(currentWorkspace(r), user)
+	if !ok {
+		respondError(w, workspace.ErrNotMember.Error(), http.StatusForbidden, nil)
+		return
+	}
+	posts := postsFor(r)
+	post, ok := posts.Get(postID)
+	if !ok {
+		respondError(w, "Post not found", http.StatusNotFound, nil)
+		return
+	}
+	// 添付は投稿の編集なので、updatePost と同じく投稿者本人か editor / admin に限る。
+	// ボディを読む前に確かめ、権限のないアップロードを一時ファイルに書かせない
+	if !canEditPost(member, post) {
+		respondError(w, ErrForbidden.Error(), http.StatusForbidden, nil)
+		return
+	}
+
+	// ボディ全体にも上限をかける（multipart の境界やヘッダーの分を少し足す）
+	r.Body = http.MaxBytesReader(w, r.Body, attachmentConfig.MaxSize+64<<10)
+
+	// ParseMultipartForm はファイルを丸ごとメモリか一時ファイルに置くので、
+	// MultipartReader でパートを順に読み、file フィールドをそのまま保存先へ流す
+	mr, err := r.MultipartReader()
+	if err != nil {
+		respondError(w, "Expected multipart/form-data", http.StatusBadRequest, nil)
+		return
+	}
+
+	var staged *blobstore.Staged
+	var filename string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondUploadError(w, err)
+			return
+		}
+		if part.FormName() != "file" || staged != nil {
+			part.Close()
+			continue
+		}
+
+		filename = sanitizeFilename(part.FileName())
+		staged, err = blobs.Stage(part, attachmentConfig.MaxSize, attachmentConfig.Allowed)
+		part.Close()
+		if err != nil {
+			respondUploadError(w, err)
+			return
+		}
+	}
+	if staged == nil {
+		respondError(w, "Validation failed", http.StatusBadRequest, map[string]string{"file": "File is required"})
+		return
+	}
+
+	var attachment Attachment
+	err = blobs.Commit(staged, func() error {
+		var ok bool
+		attachment, ok = posts.AddAttachment(Attachment{
+			PostID:      postID,
+			Filename:    filename,
+			ContentType: staged.ContentType,
+			Size:        staged.Size,
+			SHA256:      staged.SHA256,
+			UploadedBy:  user.ID,
+			CreatedAt:   time.Now(),
+
+			ThumbnailStatus: initialThumbnailStatus(staged.ContentType),
+		})
+		if !ok {
+			return ErrPostNotFound // アップロード中に投稿が削除された
+		}
+		return nil
+	})
+	if errors.Is(err, ErrPostNotFound) {
+		respondError(w, "Post not found", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		respondError(w, "Failed to store file", http.StatusInternalServerError, nil)
+		return
+	}
+	recordAudit(r, "attachment.create", "attachment", attachment.ID, nil, attachment)
+	if attachment.ThumbnailStatus == ThumbnailPending {
+		thumbnailer.Enqueue(attachment)
+	}
+
+	respondJSON(w, attachment, http.StatusCreated)
+}
+
+// respondUploadError はアップロード中のエラーをステータスコードに変換する
+func respondUploadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, blobstore.ErrTooLarge), errors.As(err, &tooLarge):
+		respondError(w, blobstore.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge, map[string]string{
+			"file": fmt.Sprintf("Maximum size is %d bytes", attachmentConfig.MaxSize),
+		})
+	case errors.Is(err, blobstore.ErrUnsupportedType):
+		respondError(w, err.Error(), http.StatusUnsupportedMediaType, nil)
+	default:
+		respondError(w, "Invalid multipart body", http.StatusBadRequest, nil)
+	}
+}
+
+// serveAttachment は http.ServeContent で返す。
+// Range / If-Range / If-None-Match（ETag は SHA-256）/ HEAD の処理は ServeContent に任せる
+func serveAttachment(w http.ResponseWriter, r *http.Request, a Attachment) {
+	f, err := blobs.Open(a.SHA256)
+	if err != nil {
+		respondError(w, "Attachment data not found", http.StatusNotFound, nil)
+		return
+	}
+	defer f.Close()
+
+	// 画像はブラウザでそのまま表示し、それ以外はダウンロードさせる
+	disposition := "attachment"
+	if strings.HasPrefix(a.ContentType, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+a.SHA256+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+
+	http.ServeContent(w, r, a.Filename, a.CreatedAt, f)
+}
+
+// GET /ws/posts/{id} - 投稿のコメントルームに WebSocket で参加する。
+// ブラウザの WebSocket API はヘッダーを付けられないので ?token=（POST /api/auth/ws-token で発行）でも認証できる
+func commentSocketHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := extractID(r.URL.Path, "/ws/posts/")
+	if err != nil {
+		respondError(w, "Invalid post ID", http.StatusBadRequest, nil)
+		return
+	}
+
+	user, ok := currentUser(r)
+	if !ok {
+		respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+		return
+	}
+
+	// 読めない投稿のコメントルームには入れない
+	if _, ok := visiblePost(r, id); !ok {
+		respondError(w, "Post not found", http.StatusNotFound, nil)
+		return
+	}
+	posts := postsFor(r)
+
+	conn, err := commentUpgrader.Upgrade(w, r)
+	if err != nil {
+		return // エラーレスポンスは Upgrade が返している
+	}
+
+	client := &commentClient{
+		conn:   conn,
+		postID: id,
+		user:   user,
+		send:   make(chan []byte, commentSendBuffer),
+		done:   make(chan struct{}),
+	}
+	commentHub.Join(client)
+	defer commentHub.Leave(client)
+	logger := loggerFrom(r.Context())
+	logger.Debug("WebSocket 接続を開始しました", "post_id", id)
+	defer logger.Debug("WebSocket 接続を終了しました", "post_id", id)
+
+	go client.writePump()
+	client.enqueue(CommentMessage{Type: "history", Comments: posts.Comments(id)})
+
+	// 読み込みはこの goroutine で行う。pong が届くたびに読み込み期限を延ばす
+	conn.SetReadDeadline(time.Now().Add(commentPongWait))
+	conn.SetPongHandler(func([]byte) {
+		conn.SetReadDeadline(time.Now().Add(commentPongWait))
+	})
+
+	for {
+		opcode, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				client.close(closeErr.Code, "")
+			} else {
+				client.close(websocket.CloseGoingAway, "")
+			}
+			return
+		}
+
+		var msg CommentMessage
+		if opcode != websocket.OpText || json.Unmarshal(data, &msg) != nil || msg.Type != "comment" {
+			client.enqueue(CommentMessage{Type: "error", Message: `Expected {"type":"comment","body":"..."}`})
+			continue
+		}
+		if errs := validateComment(msg.Body); errs != nil {
+			client.enqueue(CommentMessage{Type: "error", Message: errs["body"]})
+			continue
+		}
+
+		comment, ok := posts.CreateComment(Comment{
+			PostID:   id,
+			UserID:   user.ID,
+			Username: user.Username,
+			Body:     msg.Body,
+		})
+		if !ok {
+			client.close(websocket.CloseGoingAway, "post deleted")
+			return
+		}
+		recordAudit(r, "comment.create", "comment", comment.ID, nil, comment)
+		commentHub.Broadcast(id, CommentMessage{Type: "comment", Comment: &comment})
+	}
+}
+
+// graphQLRequest は GraphQL over HTTP のリクエストボディ
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// POST /graphql - {"query": "...", "variables": {...}}
+// GET  /graphql?query=... - query のみ（mutation は POST で送る）
+func graphQLHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphQLRequest
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				respondJSON(w, graphql.Result{Errors: []*graphql.Error{{Message: "Invalid variables"}}}, http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		// GraphQL over HTTP ではクライアントが extensions などを付けてくるので、知らないフィールドは無視する
+		if err := jsonbody.DecodeWith(w, r, &req, jsonbody.Options{AllowUnknownFields: true}); err != nil {
+			gqlErr := &graphql.Error{Message: "Invalid request body"}
+			status := http.StatusBadRequest
+			var bodyErr *jsonbody.Error
+			if errors.As(err, &bodyErr) {
+				gqlErr.Message = bodyErr.Message
+				gqlErr.Extensions = map[string]interface{}{"details": bodyErr.Details}
+				status = bodyErr.Status
+			}
+			respondJSON(w, graphql.Result{Errors: []*graphql.Error{gqlErr}}, status)
+			return
+		}
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	if req.Query == "" {
+		respondJSON(w, graphql.Result{Errors: []*graphql.Error{{Message: "Must provide query string"}}}, http.StatusBadRequest)
+		return
+	}
+
+	// ローダーはリクエストごとに作る（キャッシュが他のリクエストに漏れないように）
+	ctx := context.WithValue(r.Context(), graphQLLoadersKey{}, newGraphQLLoaders(r))
+	ctx = context.WithValue(ctx, graphQLRequestKey{}, r)
+
+	result := graphql.Do(graphql.Params{
+		Schema:        graphQLSchema,
+		Query:         req.Query,
+		OperationName: req.OperationName,
+		Variables:     req.Variables,
+		Context:       ctx,
+		AllowMutation: r.Method == http.MethodPost,
+		MaxDepth:      graphQLMaxDepth,
+		MaxComplexity: graphQLMaxComplexity,
+	})
+
+	// 構文エラーなどで実行できなかった場合は 400、実行できた場合は部分的なエラーがあっても 200
+	status := http.StatusOK
+	if result.Data == nil {
+		status = http.StatusBadRequest
+	}
+	respondJSON(w, result, status)
+}
+
+// GET /api/posts/scheduled - ログインユーザーの予約投稿一覧
+func scheduledPostsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, ok := currentUser(r)
+	if !ok {
+		respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+		return
+	}
+
+	respondJSON(w, postsFor(r).Scheduled(user.ID), http.StatusOK)
+}
+
+// ========== フィード（RSS / Atom） ==========
+
+// feedSize はフィードに載せる投稿の数（新しい順）
+const feedSize = 20
+
+// RSS 2.0（https://www.rssboard.org/rss-specification）
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	SelfLink      atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"` // HTML（XML としてエスケープされる）
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// Atom（RFC 4287）
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  *atomPerson `xml:"author,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Links     []atomLink  `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    atomPerson  `xml:"author"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// feedSource はフィードの元になる投稿と、リンクの組み立てに必要な情報
+type feedSource struct {
+	title   string
+	posts   []Post // 公開済み・新しい順・feedSize 件まで
+	base    string // server.public_url + /w/{slug}（既定のワークスペースなら接頭辞なし）
+	self    string // フィード自身の URL
+	author  *User  // 投稿者別のフィードのとき
+	updated time.Time
+}
+
+// newFeedSource は現在のワークスペースの公開済みの投稿を集める（userID が 0 なら全員分）
+func newFeedSource(r *http.Request, title string, userID int) feedSource {
+	ws := currentWorkspace(r)
+	src := feedSource{title: title, base: publicURL}
+	if ws.ID != defaultWorkspaceID {
+		src.base += "/w/" + ws.Slug
+		src.title += " - " + ws.Name
+	}
+	src.self = src.base + r.URL.Path
+
+	for _, p := range postsFor(r).List() {
+		if p.Status == StatusPublished && (userID == 0 || p.UserID == userID) {
+			src.posts = append(src.posts, p)
+		}
+	}
+	sort.Slice(src.posts, func(i, j int) bool {
+		a, b := src.posts[i], src.posts[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+	if len(src.posts) > feedSize {
+		src.posts = src.posts[:feedSize]
+	}
+
+	// フィードの更新日時は、載せている投稿の最新の更新日時
+	for _, p := range src.posts {
+		if p.UpdatedAt.After(src.updated) {
+			src.updated = p.UpdatedAt
+		}
+	}
+	return src
+}
+
+// postID は投稿ごとに変わらない URL（スラッグは変わることがあるので ID を使う）
+func (f feedSource) postID(p Post) string {
+	return fmt.Sprintf("%s/api/posts/%d", f.base, p.ID)
+}
+
+func (f feedSource) postLink(p Post) string {
+	return f.base + "/api/posts/by-slug/" + p.Slug
+}
+
+func (f feedSource) rss() rssFeed {
+	channel := rssChannel{
+		Title:       f.title,
+		Link:        f.base + "/api/posts",
+		Description: f.title + "の公開済みの投稿",
+		Language:    "ja",
+		SelfLink:    atomLink{Href: f.self, Rel: "self", Type: "application/rss+xml"},
+		Items:       []rssItem{},
+	}
+	if !f.updated.IsZero() {
+		// RSS の日付は RFC 822 形式（年は4桁にした RFC 1123）
+		channel.LastBuildDate = f.updated.Format(time.RFC1123Z)
 	}
-	nextPostID++
+	for _, p := range f.posts {
+		channel.Items = append(channel.Items, rssItem{
+			Title:       p.Title,
+			Link:        f.postLink(p),
+			Description: renderCache.HTML(p),
+			GUID:        rssGUID{IsPermaLink: true, Value: f.postID(p)},
+			PubDate:     p.CreatedAt.Format(time.RFC1123Z),
+		})
+	}
+	return rssFeed{Version: "2.0", AtomNS: "http://www.w3.org/2005/Atom", Channel: channel}
+}
 
-	posts = append(posts, post)
+func (f feedSource) atom() atomFeed {
+	feed := atomFeed{
+		Title: f.title,
+		ID:    f.self,
+		Links: []atomLink{
+			{Href: f.self, Rel: "self", Type: "application/atom+xml"},
+			{Href: f.base + "/api/posts", Rel: "alternate", Type: "application/json"},
+		},
+		Entries: []atomEntry{},
+	}
+	// Atom の日付は RFC 3339。updated は必須なので、投稿がなければ固定の日時にする
+	feed.Updated = f.updated.UTC().Format(time.RFC3339)
+	if f.updated.IsZero() {
+		feed.Updated = time.Unix(0, 0).UTC().Format(time.RFC3339)
+	}
+	if f.author != nil {
+		feed.Author = &atomPerson{Name: f.author.Username}
+	}
 
-	respondJSON(w, post, http.StatusCreated)
+	names := make(map[int]string)
+	for _, p := range f.posts {
+		if _, ok := names[p.UserID]; !ok {
+			if u, ok := store.GetUser(p.UserID); ok {
+				names[p.UserID] = u.Username
+			}
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     p.Title,
+			ID:        f.postID(p),
+			Links:     []atomLink{{Href: f.postLink(p), Rel: "alternate", Type: "application/json"}},
+			Published: p.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   p.UpdatedAt.UTC().Format(time.RFC3339),
+			Author:    atomPerson{Name: names[p.UserID]},
+			Content:   atomContent{Type: "html", Value: renderCache.HTML(p)},
+		})
+	}
+	return feed
 }
 
-func postHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := extractID(r.URL.Path, "/api/posts/")
+// respondFeed は XML にして返す。
+// ETag（本文のハッシュ）を付け、If-None-Match の判定と HEAD は http.ServeContent に任せる。
+// Last-Modified は付けない。載せている投稿の最新の更新日時は、投稿の削除や非公開で内容が変わっても
+// 進まないので、If-Modified-Since で古いフィードに 304 を返してしまう
+func respondFeed(w http.ResponseWriter, r *http.Request, contentType string, feed interface{}) {
+	body, err := xml.MarshalIndent(feed, "", "  ")
 	if err != nil {
-		respondError(w, "Invalid post ID", http.StatusBadRequest, nil)
+		respondError(w, "Failed to build feed", http.StatusInternalServerError, nil)
 		return
 	}
+	body = append([]byte(xml.Header), body...)
 
-	switch r.Method {
-	case http.MethodGet:
-		getPostHandler(w, r, id)
-	case http.MethodPut:
-		updatePostHandler(w, r, id)
-	case http.MethodDelete:
-		deletePostHandler(w, r, id)
-	default:
-		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	sum := sha256.Sum256(body)
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	if currentWorkspace(r).Public {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	} else {
+		// 非公開のワークスペースはメンバーにしか見せないので、共有キャッシュ（CDN・プロキシ）に保存させない
+		w.Header().Set("Cache-Control", "private, max-age=300")
 	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
 }
 
-func getPostHandler(w http.ResponseWriter, r *http.Request, id int) {
-	for _, post := range posts {
-		if post.ID == id {
-			respondJSON(w, post, http.StatusOK)
-			return
-		}
+// GET /feeds/posts.rss / /feeds/posts.atom - 公開済みの投稿のフィード
+func postsFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	src := newFeedSource(r, "ブログ", 0)
+	if strings.HasSuffix(r.URL.Path, ".rss") {
+		respondFeed(w, r, "application/rss+xml", src.rss())
+	} else {
+		respondFeed(w, r, "application/atom+xml", src.atom())
 	}
-	respondError(w, "Post not found", http.StatusNotFound, nil)
 }
 
-func updatePostHandler(w http.ResponseWriter, r *http.Request, id int) {
-	var req UpdatePostRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, "Invalid request body", http.StatusBadRequest, nil)
+// GET /feeds/users/{id}.atom（.rss も可） - 投稿者別のフィード
+func userFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
 		return
 	}
 
-	for i := range posts {
-		if posts[i].ID == id {
-			if req.Title != nil {
-				posts[i].Title = *req.Title
-			}
-			if req.Content != nil {
-				posts[i].Content = *req.Content
-			}
-			if req.Published != nil {
-				posts[i].Published = *req.Published
-			}
-			posts[i].UpdatedAt = time.Now()
-
-			respondJSON(w, posts[i], http.StatusOK)
-			return
-		}
+	name := strings.TrimPrefix(r.URL.Path, "/feeds/users/")
+	idStr, format, _ := strings.Cut(name, ".")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || (format != "atom" && format != "rss") {
+		respondError(w, "Not found", http.StatusNotFound, nil)
+		return
+	}
+	user, ok := store.GetUser(id)
+	if !ok {
+		respondError(w, "User not found", http.StatusNotFound, nil)
+		return
 	}
 
-	respondError(w, "Post not found", http.StatusNotFound, nil)
-}
-
-func deletePostHandler(w http.ResponseWriter, r *http.Request, id int) {
-	for i, post := range posts {
-		if post.ID == id {
-			posts = append(posts[:i], posts[i+1:]...)
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
+	src := newFeedSource(r, user.Username+"の投稿", user.ID)
+	src.author = &user
+	if format == "rss" {
+		respondFeed(w, r, "application/rss+xml", src.rss())
+	} else {
+		respondFeed(w, r, "application/atom+xml", src.atom())
 	}
-	respondError(w, "Post not found", http.StatusNotFound, nil)
 }
 
 // ========== ヘルスチェック ==========
 
+// registerHealthChecks は /livez と /readyz で実行するチェックを登録する。
+//
+//	livez:  store_lock（ストアのロックが取れるか。デッドロックなら再起動で直る）
+//	readyz: storage / disk / webhook_queue / thumbnail_queue
+func registerHealthChecks(cfg Config) {
+	timeout, ttl := cfg.Health.CheckTimeout, cfg.Health.CacheTTL
+
+	healthChecks.AddLiveness(health.Check{
+		Name:    "store_lock",
+		Timeout: timeout,
+		Func: func(ctx context.Context) error {
+			store.mu.Lock()
+			store.mu.Unlock()
+			return nil
+		},
+	})
+
+	healthChecks.AddReadiness(health.Check{Name: "storage", Timeout: timeout, CacheTTL: ttl, Func: store.Ping})
+
+	// 保存先（データファイル・添付ファイル）の空き容量
+	dirs := []string{cfg.Storage.AttachmentDir}
+	if cfg.Storage.Backend == "file" {
+		dirs = append(dirs, filepath.Dir(cfg.Storage.DataFile))
+	}
+	minFree := uint64(cfg.Health.MinFreeDisk)
+	healthChecks.AddReadiness(health.Check{
+		Name:     "disk",
+		Timeout:  timeout,
+		CacheTTL: ttl,
+		Func: func(ctx context.Context) error {
+			for _, dir := range dirs {
+				if err := health.DiskSpace(dir, minFree)(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+
+	// ワーカープールのキューが9割を超えたら、受け付けても処理が追いつかない
+	healthChecks.AddReadiness(health.Check{
+		Name:    "webhook_queue",
+		Timeout: timeout,
+		Func:    health.QueueBacklog(webhookDispatcher.Pending, webhookDispatcher.Capacity, 0.9),
+	})
+	healthChecks.AddReadiness(health.Check{
+		Name:    "thumbnail_queue",
+		Timeout: timeout,
+		Func:    health.QueueBacklog(thumbnailer.pool.Pending, thumbnailer.pool.Capacity, 0.9),
+	})
+}
+
+// GET /health - 以前からのヘルスチェック（readiness と同じチェックを行う）
 func healthHandler(w http.ResponseWriter, r *http.Request) {
+	report := healthChecks.Readiness(r.Context())
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+	}
 	respondJSON(w, map[string]interface{}{
-		"status": "ok",
+		"status": report.Status,
 		"time":   time.Now(),
-	}, http.StatusOK)
+	}, status)
 }
 
 // ========== バリデーション ==========
@@ -462,18 +7005,18 @@ func validateLoginRequest(req LoginRequest) map[string]string {
 	return nil
 }
 
-func validateUser(user User) map[string]string {
+func validateRegister(req RegisterRequest) map[string]string {
 	errors := make(map[string]string)
 
-	if user.Username == "" {
+	if req.Username == "" {
 		errors["username"] = "Username is required"
 	}
-	if user.Email == "" {
+	if req.Email == "" {
 		errors["email"] = "Email is required"
 	}
-	if user.Password == "" {
+	if req.Password == "" {
 		errors["password"] = "Password is required"
-	} else if len(user.Password) < 6 {
+	} else if len(req.Password) < 6 {
 		errors["password"] = "Password must be at least 6 characters"
 	}
 
@@ -483,6 +7026,81 @@ func validateUser(user User) map[string]string {
 	return nil
 }
 
+func validateResetPassword(req ResetPasswordRequest) map[string]string {
+	errors := make(map[string]string)
+
+	if req.Token == "" {
+		errors["token"] = "Token is required"
+	}
+	if req.NewPassword == "" {
+		errors["new_password"] = "Password is required"
+	} else if len(req.NewPassword) < 6 {
+		errors["new_password"] = "Password must be at least 6 characters"
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+func validateCreateAPIKey(req CreateAPIKeyRequest) map[string]string {
+	errors := make(map[string]string)
+
+	if req.Name == "" {
+		errors["name"] = "Name is required"
+	} else if len(req.Name) > 100 {
+		errors["name"] = "Name must be less than 100 characters"
+	}
+
+	if len(req.Scopes) == 0 {
+		errors["scopes"] = "At least one scope is required"
+	}
+	for _, scope := range req.Scopes {
+		if !validScopes[scope] {
+			errors["scopes"] = fmt.Sprintf("Unknown scope %q", scope)
+			break
+		}
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+func validateCreateWebhook(req CreateWebhookRequest) map[string]string {
+	errors := make(map[string]string)
+
+	if req.URL == "" {
+		errors["url"] = "URL is required"
+	} else if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errors["url"] = "URL must be an absolute http(s) URL"
+	}
+
+	if len(req.Events) == 0 {
+		errors["events"] = "At least one event is required"
+	}
+	for _, event := range req.Events {
+		if !validWebhookEvents[event] {
+			errors["events"] = fmt.Sprintf("Unknown event %q", event)
+			break
+		}
+	}
+
+	if req.Secret != "" && len(req.Secret) < 16 {
+		errors["secret"] = "Secret must be at least 16 characters"
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+// maxContentLength は本文の上限。表示のたびに Markdown を HTML に変換するので、ボディの上限（1MB）より小さくする
+const maxContentLength = 100 << 10
+
 func validateCreatePost(req CreatePostRequest) map[string]string {
 	errors := make(map[string]string)
 
@@ -494,6 +7112,117 @@ func validateCreatePost(req CreatePostRequest) map[string]string {
 
 	if req.Content == "" {
 		errors["content"] = "Content is required"
+	} else if len(req.Content) > maxContentLength {
+		errors["content"] = fmt.Sprintf("Content must be at most %d bytes", maxContentLength)
+	}
+
+	if req.Slug != "" && !slugify.Valid(req.Slug) {
+		errors["slug"] = "Slug may contain only lowercase letters, digits and inner hyphens (max 60)"
+	}
+
+	if req.PublishAt != nil {
+		if req.Published {
+			errors["publish_at"] = "Cannot schedule a post that is already published"
+		} else if !req.PublishAt.After(time.Now()) {
+			errors["publish_at"] = "Publish time must be in the future"
+		}
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+func validateUpdatePost(req UpdatePostRequest) map[string]string {
+	errors := make(map[string]string)
+
+	if req.Title != nil {
+		if *req.Title == "" {
+			errors["title"] = "Title is required"
+		} else if len(*req.Title) > 200 {
+			errors["title"] = "Title must be less than 200 characters"
+		}
+	}
+
+	if req.Content != nil {
+		if *req.Content == "" {
+			errors["content"] = "Content is required"
+		} else if len(*req.Content) > maxContentLength {
+			errors["content"] = fmt.Sprintf("Content must be at most %d bytes", maxContentLength)
+		}
+	}
+
+	if req.Slug != nil && !slugify.Valid(*req.Slug) {
+		errors["slug"] = "Slug may contain only lowercase letters, digits and inner hyphens (max 60)"
+	}
+
+	if req.PublishAt != nil && !req.PublishAt.After(time.Now()) {
+		errors["publish_at"] = "Publish time must be in the future"
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+func validateCreateWorkspace(req CreateWorkspaceRequest) map[string]string {
+	errors := make(map[string]string)
+
+	// スラッグは URL（/w/{slug}/...）に使うため、英小文字・数字・ハイフンのみ
+	if req.Slug == "" {
+		errors["slug"] = "Slug is required"
+	} else if len(req.Slug) < 3 || len(req.Slug) > 32 {
+		errors["slug"] = "Slug must be between 3 and 32 characters"
+	} else if strings.Trim(req.Slug, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" ||
+		strings.HasPrefix(req.Slug, "-") || strings.HasSuffix(req.Slug, "-") {
+		errors["slug"] = "Slug may contain only lowercase letters, digits and inner hyphens"
+	}
+
+	if req.Name == "" {
+		errors["name"] = "Name is required"
+	} else if len(req.Name) > 100 {
+		errors["name"] = "Name must be less than 100 characters"
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+func validateWorkspaceQuota(quota workspace.Quota) map[string]string {
+	errors := make(map[string]string)
+
+	if quota.MaxPosts < 0 {
+		errors["max_posts"] = "Must be 0 (unlimited) or greater"
+	}
+	if quota.MaxMembers < 0 {
+		errors["max_members"] = "Must be 0 (unlimited) or greater"
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+func validateCreateInvitation(req CreateInvitationRequest) map[string]string {
+	errors := make(map[string]string)
+
+	if req.Email == "" {
+		errors["email"] = "Email is required"
+	} else if !strings.Contains(req.Email, "@") {
+		errors["email"] = "Invalid email address"
+	}
+
+	switch req.Role {
+	case RoleAuthor, RoleEditor, RoleAdmin:
+	case "":
+		errors["role"] = "Role is required"
+	default:
+		errors["role"] = fmt.Sprintf("Unknown role %q", req.Role)
 	}
 
 	if len(errors) > 0 {
@@ -502,6 +7231,17 @@ func validateCreatePost(req CreatePostRequest) map[string]string {
 	return nil
 }
 
+func validateComment(body string) map[string]string {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return map[string]string{"body": "Comment is required"}
+	}
+	if len([]rune(body)) > 1000 {
+		return map[string]string{"body": "Comment must be less than 1000 characters"}
+	}
+	return nil
+}
+
 // ========== ヘルパー関数 ==========
 
 func extractID(path, prefix string) (int, error) {
@@ -509,6 +7249,65 @@ func extractID(path, prefix string) (int, error) {
 	return strconv.Atoi(idStr)
 }
 
+// extractSubresource は "/api/posts/1/transitions" を (1, "transitions") に分解する
+func extractSubresource(path, prefix string) (int, string, error) {
+	idStr, sub, _ := strings.Cut(strings.TrimPrefix(path, prefix), "/")
+	id, err := strconv.Atoi(idStr)
+	return id, sub, err
+}
+
+// currentPrincipal は authMiddleware がコンテキストに入れた認証主体を取り出す
+func currentPrincipal(r *http.Request) (Principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// currentUser はログインユーザー（APIキーの場合はキーの所有者）を返す
+func currentUser(r *http.Request) (User, bool) {
+	p, ok := currentPrincipal(r)
+	return p.User, ok
+}
+
+// requireLoginToken はログイントークンでの認証を要求する（APIキーでは不可）
+func requireLoginToken(w http.ResponseWriter, r *http.Request) (User, bool) {
+	p, ok := currentPrincipal(r)
+	if !ok {
+		respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+		return User{}, false
+	}
+	if p.APIKey != nil {
+		respondError(w, "This endpoint cannot be accessed with an API key", http.StatusForbidden, nil)
+		return User{}, false
+	}
+	return p.User, true
+}
+
+// requireRole はログインユーザーが指定ロールのいずれかを持つか確認する。
+// 持っていなければエラーレスポンスを書き込んで false を返す
+func requireRole(w http.ResponseWriter, r *http.Request, roles ...string) (User, bool) {
+	user, ok := currentUser(r)
+	if !ok {
+		respondError(w, "Authentication required", http.StatusUnauthorized, nil)
+		return User{}, false
+	}
+	for _, role := range roles {
+		if user.Role == role {
+			return user, true
+		}
+	}
+	respondError(w, ErrForbidden.Error(), http.StatusForbidden, nil)
+	return User{}, false
+}
+
+// clientIP は接続元のIPアドレス（ポート番号を除く）
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func getPagination(r *http.Request) (page, perPage int) {
 	page = 1
 	perPage = 10
@@ -542,11 +7341,69 @@ func respondError(w http.ResponseWriter, message string, status int, details map
 	}, status)
 }
 
+// decodeJSON はリクエストボディの JSON を dst に厳密にデコードする（pkg/jsonbody）。
+// 失敗したらフィールド・型・バイト位置を details に入れたエラーレスポンスを返し、false を返す
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	err := jsonbody.Decode(w, r, dst)
+	if err == nil {
+		return true
+	}
+	var bodyErr *jsonbody.Error
+	if !errors.As(err, &bodyErr) {
+		bodyErr = &jsonbody.Error{Status: http.StatusBadRequest, Message: "Invalid request body"}
+	}
+	respondError(w, bodyErr.Message, bodyErr.Status, bodyErr.Details)
+	return false
+}
+
+// normalizeOrigin は Origin を比較できる形（小文字の scheme://host[:port]）にする
+func normalizeOrigin(origin string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(origin))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// allowedWebSocketOrigin は WebSocket のハンドシェイクを受け付けるか。
+// Origin を付けないのはブラウザ以外のクライアントなので通す（トークンで認証する）。
+// ブラウザからは、同じオリジンか server.allowed_origins に含まれるオリジンだけを受け付ける
+func allowedWebSocketOrigin(r *http.Request) bool {
+	header := r.Header.Get("Origin")
+	if header == "" {
+		return true
+	}
+	origin, ok := normalizeOrigin(header)
+	if !ok {
+		return false
+	}
+	if allowedOrigins[origin] {
+		return true
+	}
+	// 公開 URL のオリジンも同じオリジンとみなす（リバースプロキシの後ろでは Host と一致しないことがある）
+	if pub, err := url.Parse(publicURL); err == nil && strings.EqualFold(pub.Scheme+"://"+pub.Host, origin) {
+		return true
+	}
+	u, _ := url.Parse(origin)
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// corsMiddleware は server.allowed_origins が空なら * を、そうでなければ許可したオリジンだけを返す
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if len(allowedOrigins) == 0 {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Add("Vary", "Origin")
+			if origin, ok := normalizeOrigin(r.Header.Get("Origin")); ok && allowedOrigins[origin] {
+				w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+			}
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Workspace, traceparent, tracestate")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -560,25 +7417,206 @@ func corsMiddleware(next http.Handler) http.Handler {
 /*
 【実行方法】
 go run 02_advanced_api.go
+go run 02_advanced_api.go -h                                  # 設定項目・環境変数・既定値の一覧
+go run 02_advanced_api.go -config blog.toml -server-addr :9090  # 設定ファイル（BLOG_CONFIG でも可）をフラグで上書き
+
+blog.toml の例（キーは起動時に「設定:」として出どころと一緒に表示される）:
+  [server]
+  addr = ":8080"
+  public_url = "https://blog.example.com"  # メール・フィードのリンクに使う
+  write_timeout = "60s"
+  shutdown_timeout = "15s"
+  allowed_origins = ["https://app.example.com"]
+
+  [auth]
+  token_secret = "change-me-to-a-long-secret"
+
+  [storage]
+  backend = "file"
+  data_file = "blog.json"
+
+  [attachments]
+  max_size = 5242880
+  types = ["image/jpeg", "image/png", "application/pdf"]
+
+  [thumbnails]
+  sizes = ["small=160x160", "medium=480x480"]
+  workers = 4
+
+停止は Ctrl+C または kill（SIGTERM）。処理中のリクエストを server.shutdown_timeout まで待ってから終了する
 
 【テスト用コマンド】
-# ログイン
+# ログイン（返ってきた token を Authorization: Bearer に付ける。auth.session_ttl を過ぎると 401）
 curl -X POST http://localhost:8080/api/auth/login -d '{"email":"taro@example.com","password":"password123"}' -H "Content-Type: application/json"
+TOKEN=$(curl -s -X POST http://localhost:8080/api/auth/login -d '{"email":"taro@example.com","password":"password123"}' -H "Content-Type: application/json" | jq -r .token)
+HANAKO_TOKEN=$(curl -s -X POST http://localhost:8080/api/auth/login -d '{"email":"hanako@example.com","password":"password123"}' -H "Content-Type: application/json" | jq -r .token)
+
+# ユーザー登録（確認メールはログ、または MAIL_LOG_FILE に出力される）
+curl -X POST http://localhost:8080/api/auth/register -d '{"username":"次郎","email":"jiro@example.com","password":"password123"}' -H "Content-Type: application/json"
+
+# メールアドレス確認（メールに記載されたURL）
+curl "http://localhost:8080/api/auth/verify?token=..."
+
+# パスワードリセット
+curl -X POST http://localhost:8080/api/auth/forgot -d '{"email":"taro@example.com"}' -H "Content-Type: application/json"
+curl -X POST http://localhost:8080/api/auth/reset -d '{"token":"...","new_password":"newpass123"}' -H "Content-Type: application/json"
+
+# メール認証を必須にし、SMTPで送信する（ローカルのテスト用SMTPサーバー向け）
+REQUIRE_EMAIL_VERIFICATION=true MAILER=smtp SMTP_ADDR=localhost:1025 TOKEN_SECRET=change-me-to-a-long-secret go run 02_advanced_api.go
+
+# APIキー発行（レスポンスの key は一度しか表示されない）
+curl -X POST http://localhost:8080/api/keys -d '{"name":"ci-bot","scopes":["posts:read","posts:write"]}' -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json"
+
+# APIキーでアクセス（X-API-Key または Authorization: ApiKey ...）
+curl http://localhost:8080/api/posts -H "X-API-Key: sk_..."
+curl -X POST http://localhost:8080/api/posts -d '{"title":"CIから","content":"内容"}' -H "Authorization: ApiKey sk_..." -H "Content-Type: application/json"
+
+# APIキー一覧 / 失効
+curl http://localhost:8080/api/keys -H "Authorization: Bearer $TOKEN"
+curl -X DELETE http://localhost:8080/api/keys/1 -H "Authorization: Bearer $TOKEN"
+
+# 監査ログ検索（AUDIT_LOG_FILE に JSON Lines で追記され、再起動後も残る）
+curl "http://localhost:8080/api/admin/audit?resource_type=post&action=post.update&page=1" -H "Authorization: Bearer $TOKEN"
+
+# Webhook 登録（レスポンスの secret で X-Webhook-Signature を検証する）
+curl -X POST http://localhost:8080/api/webhooks -d '{"url":"http://localhost:9000/hook","events":["post.created","post.published"]}' -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json"
+
+# 配信履歴 / 再配信
+curl http://localhost:8080/api/webhooks/1/deliveries -H "Authorization: Bearer $TOKEN"
+curl -X POST http://localhost:8080/api/webhooks/1/deliveries/{delivery_id}/redeliver -H "Authorization: Bearer $TOKEN"
+
+# ログインロック解除（5回失敗するとアカウントが15分ロックされる）
+curl -X POST http://localhost:8080/api/admin/users/2/unlock -H "Authorization: Bearer $TOKEN"
 
 # ユーザー一覧（ページネーション）
 curl "http://localhost:8080/api/users?page=1&per_page=10"
 
 # 投稿一覧（フィルタ）
-curl "http://localhost:8080/api/posts?user_id=1&published=true&page=1"
+curl "http://localhost:8080/api/posts?user_id=1&status=published&page=1"
+curl "http://localhost:8080/api/posts?status=draft,in_review"
 
 # 投稿作成
-curl -X POST http://localhost:8080/api/posts -d '{"title":"新しい投稿","content":"内容","published":true}' -H "Content-Type: application/json"
+curl -X POST http://localhost:8080/api/posts -d '{"title":"新しい投稿","content":"内容","published":true}' -H "Content-Type: application/json" -H "Authorization: Bearer $TOKEN"
+
+# 投稿の変更をリアルタイムに受信（SSE、フィルタは一覧と同じ）
+curl -N "http://localhost:8080/api/posts/stream?status=published"
+
+# 切断後に続きから再開（受け取った最後のイベントIDを指定）
+curl -N http://localhost:8080/api/posts/stream -H "Last-Event-ID: 42"
+
+# 予約投稿（承認済みになった後、publish_at を過ぎるとスケジューラーが自動で公開する）
+curl -X POST http://localhost:8080/api/posts -d '{"title":"予約投稿","content":"内容","publish_at":"2030-01-01T09:00:00+09:00"}' -H "Content-Type: application/json" -H "Authorization: Bearer $TOKEN"
 
-# 投稿更新
-curl -X PUT http://localhost:8080/api/posts/1 -d '{"title":"更新されたタイトル"}' -H "Content-Type: application/json"
+# 自分の予約投稿一覧
+curl http://localhost:8080/api/posts/scheduled -H "Authorization: Bearer $TOKEN"
+
+# ファイルに永続化して起動（再起動後も予約投稿が残る）
+BLOG_DATA_FILE=blog.json go run 02_advanced_api.go
+
+# 投稿更新（投稿者本人か editor / admin。他人の投稿なら 403）
+curl -X PUT http://localhost:8080/api/posts/1 -d '{"title":"更新されたタイトル"}' -H "Content-Type: application/json" -H "Authorization: Bearer $TOKEN"
 
 # 投稿削除
-curl -X DELETE http://localhost:8080/api/posts/1
+curl -X DELETE http://localhost:8080/api/posts/1 -H "Authorization: Bearer $TOKEN"
+
+# ワークフロー（花子=author がレビュー依頼 → 太郎=admin が承認 → 公開）
+curl -X POST http://localhost:8080/api/posts/3/transitions -d '{"to":"in_review"}' -H "Authorization: Bearer $HANAKO_TOKEN" -H "Content-Type: application/json"
+curl -X POST http://localhost:8080/api/posts/3/transitions -d '{"to":"approved"}' -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json"
+curl -X POST http://localhost:8080/api/posts/3/transitions -d '{"to":"published"}' -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json"
+
+# 差し戻し（理由が必要）
+curl -X POST http://localhost:8080/api/posts/3/transitions -d '{"to":"draft","reason":"誤字があります"}' -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json"
+
+# 状態遷移の履歴
+curl http://localhost:8080/api/posts/3/transitions
+
+# コメントルームに参加（websocat などの WebSocket クライアントで接続し、JSON を送る）
+WS_TOKEN=$(curl -s -X POST http://localhost:8080/api/auth/ws-token -H "Authorization: Bearer $TOKEN" | jq -r .token)
+websocat "ws://localhost:8080/ws/posts/1?token=$WS_TOKEN"
+{"type":"comment","body":"いい記事ですね"}
+
+# コメント一覧
+curl http://localhost:8080/api/posts/1/comments
+
+# GraphQL: 投稿と投稿者、同じ投稿者の関連記事を1回で取得
+curl -X POST http://localhost:8080/graphql -H "Content-Type: application/json" -d '{"query":"{ post(id: 1) { title author { username } related { id title } } }"}'
+
+# GraphQL: カーソル形式のページネーション（endCursor を after に渡す）
+curl -X POST http://localhost:8080/graphql -d '{"query":"{ posts(first: 2, status: [PUBLISHED]) { totalCount pageInfo { hasNextPage endCursor } nodes { id title author { username } } } }"}' -H "Content-Type: application/json"
+
+# GraphQL: mutation（REST と同じバリデーション、エラーは extensions.code で判別）
+curl -X POST http://localhost:8080/graphql -H "Authorization: Bearer $TOKEN" -d '{"query":"mutation($input: CreatePostInput!) { createPost(input: $input) { id status } }","variables":{"input":{"title":"GraphQLから","content":"内容です"}}}' -H "Content-Type: application/json"
+
+# GraphQL: イントロスペクション
+curl -X POST http://localhost:8080/graphql -d '{"query":"{ __schema { types { name kind } } }"}' -H "Content-Type: application/json"
+
+# JSON-RPC: 1件の呼び出し（params は名前付きでも位置指定 [1] でもよい）
+curl -X POST http://localhost:8080/rpc -d '{"jsonrpc":"2.0","method":"posts.get","params":{"id":1},"id":1}'
+
+# JSON-RPC: バッチ（id のない2件目は通知なのでレスポンスに含まれない）
+curl -X POST http://localhost:8080/rpc -H "Authorization: Bearer $TOKEN" -d '[{"jsonrpc":"2.0","method":"posts.create","params":{"title":"RPCから","content":"内容です"},"id":1},{"jsonrpc":"2.0","method":"posts.transition","params":{"id":3,"to":"in_review"}},{"jsonrpc":"2.0","method":"users.me","id":2}]'
+
+# JSON-RPC: メソッド一覧
+curl -X POST http://localhost:8080/rpc -d '{"jsonrpc":"2.0","method":"rpc.discover","id":1}'
+
+# ワークスペース: 非公開の hanako-team はメンバー（花子）だけが見える。他のワークスペースの投稿IDは 404
+curl http://localhost:8080/w/hanako-team/api/posts -H "Authorization: Bearer $HANAKO_TOKEN"
+curl http://localhost:8080/api/posts/1 -H "X-Workspace: hanako-team" -H "Authorization: Bearer $HANAKO_TOKEN"
+
+# ワークスペースの作成と招待（招待トークンはメールで届く）
+curl -X POST http://localhost:8080/api/workspaces -d '{"slug":"team-b","name":"チームB"}' -H "Authorization: Bearer $HANAKO_TOKEN" -H "Content-Type: application/json"
+curl -X POST http://localhost:8080/api/workspaces/team-b/invitations -d '{"email":"taro@example.com","role":"editor"}' -H "Authorization: Bearer $HANAKO_TOKEN" -H "Content-Type: application/json"
+curl -X POST http://localhost:8080/api/invitations/accept -d '{"token":"..."}' -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json"
+
+# 上限の変更（インスタンスの admin のみ、0 は無制限）
+curl -X PUT http://localhost:8080/api/workspaces/team-b/quota -d '{"max_posts":50,"max_members":5}' -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json"
+
+# 添付ファイル（storage.attachment_dir と [attachments] の max_size / types で設定。環境変数なら ATTACHMENT_MAX_SIZE など）
+curl -F file=@photo.png http://localhost:8080/api/posts/1/attachments -H "Authorization: Bearer $TOKEN"
+curl http://localhost:8080/api/posts/1/attachments/1 -H "Range: bytes=0-99" -o part.bin
+
+# サムネイル（[thumbnails] の sizes、または THUMBNAIL_SIZES=small=160x160,medium=480x480。thumbnail_status が ready になったら取得できる）
+curl http://localhost:8080/api/posts/1/attachments
+curl http://localhost:8080/api/posts/1/attachments/1/thumbnails/small -o small.jpg
+
+# スラッグ（省略時はタイトルから作る。変更すると旧スラッグは 301 で転送される）
+curl -X PUT http://localhost:8080/api/posts/1 -d '{"slug":"hello-go"}' -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json"
+curl -L http://localhost:8080/api/posts/by-slug/first-post
+
+# フィード（2回目以降は If-None-Match で 304 が返る）
+curl -i http://localhost:8080/feeds/posts.rss
+curl -i http://localhost:8080/feeds/users/1.atom -H 'If-None-Match: "..."'
+
+# Markdown の本文を HTML で取得（JSON の content_html、または Accept: text/html）
+curl http://localhost:8080/api/posts/1
+curl http://localhost:8080/api/posts/1 -H "Accept: text/html"
+
+# ヘルスチェック（失敗すると 503。?verbose でチェックごとの結果と所要時間）
+curl -i http://localhost:8080/livez
+curl "http://localhost:8080/readyz?verbose"
+
+# 停止時に readiness を先に落とす（この間 /readyz は 503、処理中のリクエストは続く）
+go run 02_advanced_api.go -server-shutdown-delay 5s
+
+# メトリクス（Prometheus 形式。scrape_configs の targets に localhost:8080 を書けば収集される）
+curl http://localhost:8080/metrics
+curl -s http://localhost:8080/metrics | grep -E '^(http_requests_total|blog_)'
+
+# 構造化ログ（JSON で出力し、実行中にレベルを debug に上げる。再起動で log.level に戻る）
+go run 02_advanced_api.go -log-format json -log-level info
+curl http://localhost:8080/api/admin/log-level -H "Authorization: Bearer $TOKEN"
+curl -X PUT http://localhost:8080/api/admin/log-level -H "Authorization: Bearer $TOKEN" -d '{"level":"debug"}' -H "Content-Type: application/json"
+
+# トレース（traceparent を送ると同じトレースIDで続きを記録する。レスポンスの traceresponse で確認できる）
+curl -i http://localhost:8080/api/posts/1 -H "traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+curl http://localhost:8080/debug/traces -H "Authorization: Bearer $TOKEN"
+curl "http://localhost:8080/debug/traces?trace_id=4bf92f3577b34da6a3ce929d0e0e4736" -H "Authorization: Bearer $TOKEN"
+go run 02_advanced_api.go -trace-exporter file -trace-file traces.jsonl   # ファイルに1行1トレースで書き出す
+
+# 厳密なデコード（JSON のボディには Content-Type: application/json が必要。間違いは details にフィールドと位置が入る）
+curl -X POST http://localhost:8080/api/posts -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json" -d '{"title":"タイトル","contnet":"内容"}'
+curl -X POST http://localhost:8080/api/posts -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json" -d '{"title":123}'
+curl -X POST http://localhost:8080/api/posts -H "Authorization: Bearer $TOKEN" -d '{"title":"x"}'   # 415
 
 【学習ポイント】
 1. バリデーション - 入力チェック
@@ -586,6 +7624,136 @@ curl -X DELETE http://localhost:8080/api/posts/1
 3. フィルタリング - 条件による絞り込み
 4. エラーレスポンス - 統一されたエラー形式
 5. CORS対応 - クロスオリジンリクエスト
+6. 予約投稿 - time.Ticker で定期的に公開日時をチェック
+   - Store を Mutex で保護（スケジューラーとハンドラーが並行アクセスする）
+   - 起動時にも一度チェックし、停止中に期限を迎えた投稿を公開する
+   - 公開前の投稿は canViewPost で投稿者本人と editor / admin にだけ見せる（読めない投稿は 404）
+7. ワークフロー - draft → in_review → approved → published → archived
+   - 遷移ルールをテーブル（transitionRules）で定義し、ロールで権限を判定
+   - 状態遷移の履歴を記録する
+8. メール認証 / パスワードリセット
+   - Mailer インターフェース（pkg/mail）で送信方法を差し替える（LogMailer / SMTPMailer）
+   - メール内のリンクは server.public_url から作る（Host ヘッダーは偽造できるので使わない）
+   - HMAC-SHA256 で署名した有効期限付きトークン（pkg/signedtoken）
+   - トークンには資格情報のバージョンを含め、パスワード変更で使い回しを防ぐ
+   - ログイントークンも同じ署名付きトークンで署名する（用途 session・有効期限付き。パスワードを変えると無効になる）
+   - パスワードを忘れた場合のレスポンスはメールアドレスの有無で変えない
+9. ブルートフォース対策
+   - アカウント単位とIP単位で失敗回数を記録し、待ち時間を指数的に伸ばす
+   - 試行を先に数えておくことで、同時リクエストでもすり抜けられない
+   - 存在しないメールアドレスでも同じレスポンスを返す
+10. APIキー
+   - キー本体は発行時に一度だけ返し、SHA-256 ハッシュのみ保存する
+   - authMiddleware でトークン / APIキーを判定し、context に認証主体を入れる
+   - スコープ（posts:read / posts:write / users:read）でアクセスできる範囲を制限する
+11. 監査ログ
+   - 変更系の操作ごとに、実行者・操作・対象・変更差分・リクエストID・IPを記録
+   - JSON Lines（1行1件）で追記し、起動時に読み込み直す
+   - 変更差分は JSON のフィールド単位で比較（json:"-" のパスワードは記録されない）
+12. Webhook（pkg/webhook）
+   - ワーカープール（pkg/workerpool、03_concurrency/05_patterns.go と同じ形）で配信を並列に処理
+   - キューへの登録は TryAdd（select の default）で待たない。一杯なら試行として記録し、バックオフ後に登録し直す
+     （受信側が遅くても、投稿の作成・更新・削除のリクエストは止まらない）
+   - X-Webhook-Signature: sha256=HMAC(secret, "タイムスタンプ.ボディ")
+   - 失敗したら 1s, 2s, 4s... と待ち時間を倍にして再試行し、履歴を残す
+13. Server-Sent Events
+   - text/event-stream で id / event / data を送り、Flush する
+   - 直近のイベントをバッファに残し、Last-Event-ID から再開できる
+   - 定期的にコメント行（: heartbeat）を送って接続を維持する
+   - チャネルが一杯の遅いクライアントは切断し、配信側をブロックしない
+14. WebSocket（pkg/websocket）
+   - Sec-WebSocket-Key + 固定GUID の SHA-1 を返してハンドシェイクし、http.Hijacker で接続を取り出す
+   - フレームの組み立て、クライアントからのマスク解除、ping/pong、クローズコード
+   - ハブが投稿ごとのルームを管理し、各接続の writePump が期限付きで書き込む
+   - ブラウザは他のサイトからの接続も止めないので、Origin を同じオリジンか server.allowed_origins と照合する
+   - ?token= には POST /api/auth/ws-token で発行した用途 ws・1分間有効のトークンだけを使える
+     （URL はログや履歴に残るため、ログイントークンは受け付けない）
+15. GraphQL（pkg/graphql）
+   - クエリを構文解析し、選択されたフィールドの Resolve だけを呼ぶ
+   - mutation は REST と同じ createPost / updatePost などを呼ぶ（バリデーションや監査ログを共通化）
+   - Loader がリクエスト中のキーを溜めてまとめて取得し、N+1 問題を防ぐ
+   - __schema / __type でスキーマを公開し、GraphiQL などのツールが補完に使える
+   - 実行前にフラグメントの循環・入れ子の深さ（15）・フィールド数（1000）を調べ、超えたら何も実行せず 400
+   - 数値でない ID（post(id: "abc")）は null ではなく BAD_USER_INPUT のエラーにする
+16. JSON-RPC 2.0（pkg/jsonrpc）
+   - メソッドを Register で登録表に載せ、引数の説明から rpc.discover の一覧と必須チェックを作る
+   - 配列で送るとバッチ、id のないリクエストは通知（レスポンスなし）
+   - ValidationError は -32602（Invalid params）に、見つからない・権限なしなどは -32000 番台に変換する
+17. マルチテナント（pkg/workspace）
+   - X-Workspace ヘッダーまたは /w/{slug}/ の接頭辞でワークスペースを選び、context に入れる
+   - 投稿は store.Posts(workspaceID) が返す PostRepository からしか読み書きできない
+     （他のワークスペースの投稿は ID を指定しても「見つからない」）
+   - ワークフローの権限はユーザー全体のロールではなく、ワークスペース内のロールで判定する
+   - 更新・削除は REST / GraphQL / JSON-RPC 共通の updatePost / deletePost で、投稿者本人か editor / admin かを確認する
+   - 招待トークンには招待IDだけを署名して入れ、承諾時にメールアドレスと上限を1回のロックの中で確認する
+   - workspace.Directory は自分では排他せず Store の Mutex の中で使う（投稿数の上限の確認と投稿の追加を1回のロックにまとめる）
+18. 添付ファイル（pkg/blobstore）
+   - MultipartReader でパートを順に読み、一時ファイルへ書きながら SHA-256 を計算する（全体をメモリに載せない）
+   - 種類はクライアントの申告ではなく、先頭 512 バイトを http.DetectContentType で判定して許可リストと照合する
+   - 内容のハッシュをファイル名にするので、同じファイルは1つだけ保存される
+   - 削除時は参照が残っていないファイルだけ消す（確定と削除は Mutex で排他）
+   - http.ServeContent が Range / If-None-Match / HEAD を処理する
+19. サムネイル（pkg/imaging）
+   - image.DecodeConfig で先に大きさを確認してから image.Decode する（巨大な画像でメモリを使い切らない）
+   - 面積平均で縦横比を保って縮小し、EXIF の Orientation に従って回転する
+   - 生成は pkg/workerpool（同時実行数を制限）で非同期に行い、状態を Attachment に記録する
+   - 派生ファイルは「元の SHA-256 + サイズ」で保存し、同じ内容なら作り直さない
+20. Markdown（pkg/markdown）
+   - 入力の HTML はエスケープし、レンダラーが生成するタグと属性だけを出力する（後から除去するより安全）
+   - リンクや画像の URL はスキームを許可リストで確認する（javascript: などを拒否）
+   - Accept ヘッダーの q 値で JSON と HTML を返し分け、Vary: Accept を付ける
+   - 変換結果は投稿ごとにキャッシュし、更新・削除で破棄する
+   - 強調とリンクは区切り文字スタックで1回の走査で組み立てる（閉じられない * が並んでも文字数に比例した時間）。本文の長さにも上限を設ける
+21. スラッグ（pkg/slugify）
+   - タイトルから英小文字・数字・ハイフンだけの文字列を作る（かなはローマ字、漢字はハッシュで区別）
+   - 重複したら -2, -3... を付ける。一意性の確認と登録は同じロックの中で行う
+   - タイトルを変えてもスラッグは変えない。明示的に変えたときは旧スラッグを残し、301 で転送する
+   - Location を相対 URL にして、/w/{slug}/ の接頭辞付きのリクエストにも対応する
+22. フィード
+   - 構造体のタグ（xml:"..."、,attr、,chardata）で RSS / Atom の要素を表し、encoding/xml で出力する
+   - 日付は RSS が RFC 1123（time.RFC1123Z）、Atom が RFC 3339
+   - エントリの ID は変わらない URL（/api/posts/{id}）にし、リンクはスラッグの URL にする
+   - リンクの基準は server.public_url（Host ヘッダーから作ると、キャッシュされたフィードに偽のリンクが混ざる）
+   - ETag を付け、http.ServeContent が条件付き GET（If-None-Match）に 304 を返す
+     （Last-Modified は投稿の削除・非公開で進まないので付けない）
+   - 非公開のワークスペースのフィードは Cache-Control: private にし、共有キャッシュに残さない
+23. 設定とグレースフルシャットダウン（pkg/config）
+   - 既定値 → 設定ファイル（JSON / TOML 風）→ 環境変数 → フラグ の順に上書きし、値の出どころを表示する
+   - 知らないキー・型の合わない値・矛盾した組み合わせ（backend=file なのに data_file がない等）は起動前にエラーにする
+   - 待ち受けはワーカーの起動前に行い、ポートが使えなければすぐに失敗させる
+   - Shutdown は新しい接続を止めて処理中のリクエストを待つが、SSE と WebSocket は待てないので RegisterOnShutdown で閉じる
+   - log.Fatal は defer を飛ばすので、serve から戻ってから defer でワーカーと監査ログを止める
+24. ヘルスチェック（pkg/health）
+   - /livez はプロセス自体の異常（ストアのロックが取れない）だけを見る。依存先の障害で再起動させない
+   - /readyz は保存先への書き込み・空き容量・ワーカープールのキューを見て、受け付けられるかを返す
+   - チェックは並行に実行し、それぞれに期限を付ける。readiness の結果は cache_ttl の間使い回す
+   - 空き容量は syscall.Statfs で調べる。OS ごとの違いはビルドタグ（//go:build）でファイルを分ける
+   - SIGTERM を受けたら readiness を失敗にし、shutdown_delay の後に受け付けを止める
+25. メトリクス（pkg/metrics）
+   - カウンター（http_requests_total、blog_posts_created_total）、ゲージ（処理中の数、キューの長さ）、ヒストグラム（処理時間）
+   - route ラベルは URL ではなく ServeMux の登録パターン（/api/posts/）にし、系列が増え続けないようにする
+   - 値は sync/atomic で更新する（float64 は CAS で足す）。出力時だけロックを取って一覧を作る
+   - ステータスを記録する ResponseWriter のラッパーは Flush / Hijack / Unwrap を持ち、SSE と WebSocket を壊さない
+   - runtime.ReadMemStats は全体を一瞬止めるので、スクレイプ1回につき1回だけ呼ぶ
+26. 構造化ログ（log/slog）
+   - アクセスログは1リクエスト1行で、request_id・user_id・route・status・bytes・duration_ms を属性として出す
+   - ロガーを context に入れ、認証・ワークスペースのミドルウェアで分かった属性を後から足す（ハンドラーは loggerFrom で取り出す）
+   - ReplaceAttr でパスワードやトークンを伏せ、メールアドレスは先頭1文字とドメインだけにする。クエリ（?token=）は出さない
+   - slog.LevelVar を使うと、ハンドラーを作り直さずにレベルを変えられる
+   - slog.SetDefault すると標準の log パッケージの出力も同じ形式になる
+27. トレース（pkg/trace）
+   - traceparent の形式が不正なら捨てて新しいトレースを始める（tracestate は traceparent が正しいときだけ引き継ぐ）
+   - スパンは context で受け渡す。PostRepository は WithContext で受け取った context のスパンの子を作る
+   - 認証のように次のハンドラーを呼ぶミドルウェアでは、呼ぶ前に End して自分の処理時間だけを記録する
+   - Webhook の配信はリクエストの後に行うので別のトレースにし、trace.Transport で送信先に traceparent を付ける
+   - 書き出し先は Exporter インターフェースで差し替える（メモリのリングバッファ、JSON Lines のファイル）
+   - アクセスログにも trace_id を出し、ログとトレースを突き合わせられるようにする
+28. リクエストボディの厳密なデコード（pkg/jsonbody）
+   - すべてのハンドラーが decodeJSON を通すので、チェックとエラーの形が1か所にまとまる
+   - Content-Type が JSON でなければ 415、http.MaxBytesReader の上限（1MB）を超えたら 413
+   - DisallowUnknownFields で知らないフィールドを拒否し、{"a":1}{"b":2} のような後続のデータも拒否する
+   - json.SyntaxError / json.UnmarshalTypeError の Offset と Field を details に入れ、どこが違うかを返す
+   - GraphQL はクライアントが extensions などを付けるので、知らないフィールドだけは許す（Options で切り替え）
 
 【次のステップ】
 実際のプロジェクトでこれらの技術を組み合わせましょう!
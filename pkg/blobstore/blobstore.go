@@ -0,0 +1,229 @@
+// Package blobstore は内容の SHA-256 をファイル名にしてファイルを保存する（コンテンツアドレス方式）
+//
+// 【学習ポイント】
+//  1. 同じ内容を何度アップロードしても保存されるのは1つだけ（名前が内容から決まるので重複しない）
+//  2. 一時ファイルに書きながら SHA-256 を計算し、書き終えてからリネームで確定する（書きかけのファイルを配信しない）
+//  3. 中身の種類はクライアントの Content-Type ではなく、先頭 512 バイトから http.DetectContentType で判定する
+//  4. 上限 + 1 バイトまで読み、読めてしまったら上限超過とする（io.LimitReader だけでは超過に気づけない）
+//  5. 「確定と参照の登録」と「参照されていないファイルの削除」を Mutex で排他にし、登録前のファイルを消さない
+package blobstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// エラーの文言はそのままクライアントへ返すことがある
+var (
+	ErrTooLarge        = errors.New("ファイルが大きすぎます")
+	ErrUnsupportedType = errors.New("この種類のファイルは添付できません")
+)
+
+// Store はファイルの保存先。
+// 保存先: {dir}/ab/cdef...（先頭2文字でディレクトリを分け、1ディレクトリのファイル数を抑える）
+type Store struct {
+	dir string
+	// mu は「ファイルの確定と参照の登録」と「参照されていないファイルの削除」を排他にする。
+	// 登録前のファイルを削除処理が消してしまうのを防ぐ
+	mu sync.Mutex
+}
+
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0755); err != nil {
+		return nil, fmt.Errorf("添付ファイルのディレクトリを作成できません: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (b *Store) path(sha string) string {
+	return filepath.Join(b.dir, sha[:2], sha[2:])
+}
+
+// Open は保存済みのファイルを開く
+func (b *Store) Open(sha string) (*os.File, error) {
+	return os.Open(b.path(sha))
+}
+
+// Staged は一時ファイルに書き終えた、まだ確定していないファイル
+type Staged struct {
+	tmpPath     string
+	SHA256      string
+	Size        int64
+	ContentType string
+}
+
+// Stage は r を一時ファイルに書き込みながら SHA-256 を計算する。
+// 先頭 512 バイトで MIME タイプを判定し（http.DetectContentType）、allowed が false を返したら書き込む前に中断する。
+// maxSize を超えたら ErrTooLarge を返す
+func (b *Store) Stage(r io.Reader, maxSize int64, allowed func(contentType string) bool) (*Staged, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	// クライアントが送る Content-Type は信用せず、中身から判定する
+	contentType := http.DetectContentType(head)
+	if !allowed(contentType) {
+		return nil, ErrUnsupportedType
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(b.dir, "tmp"), "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+
+	// 上限 + 1 バイトまで読み、読めてしまったら上限超過
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(io.MultiReader(bytes.NewReader(head), r), maxSize+1))
+	if err == nil && size > maxSize {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return &Staged{
+		tmpPath:     tmp.Name(),
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		Size:        size,
+		ContentType: contentType,
+	}, nil
+}
+
+// Commit は一時ファイルを確定し、register で参照（添付ファイルのレコード）を登録する。
+// 同じ内容がすでにあれば一時ファイルは捨てる。register が失敗したら、新しく置いたファイルを消す
+func (b *Store) Commit(staged *Staged, register func() error) error {
+	defer os.Remove(staged.tmpPath) // リネーム済みなら何もしない
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	dest := b.path(staged.SHA256)
+	created := false
+	if _, err := os.Stat(dest); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(staged.tmpPath, dest); err != nil {
+			return err
+		}
+		created = true
+	}
+
+	if err := register(); err != nil {
+		if created {
+			os.Remove(dest)
+		}
+		return err
+	}
+	return nil
+}
+
+// Discard は確定しなかった一時ファイルを消す
+func (b *Store) Discard(staged *Staged) {
+	os.Remove(staged.tmpPath)
+}
+
+// ReadAll は保存済みのファイルを読み込む（サムネイル生成用。大きさは Stage の maxSize で制限済み）
+func (b *Store) ReadAll(sha string) ([]byte, error) {
+	return os.ReadFile(b.path(sha))
+}
+
+// 派生ファイル（サムネイル）は元の内容ごとに {dir}/derived/ab/abcdef.../{file} に置く。
+// 同じ内容のファイルは派生ファイルも共有する
+func (b *Store) derivedPath(sha, file string) string {
+	return filepath.Join(b.dir, "derived", sha[:2], sha, file)
+}
+
+func (b *Store) OpenDerived(sha, file string) (*os.File, error) {
+	return os.Open(b.derivedPath(sha, file))
+}
+
+// DerivedImage は生成済みの派生画像の大きさ
+type DerivedImage struct {
+	Width  int
+	Height int
+	Size   int64
+}
+
+// DerivedInfo はキャッシュ済みの派生画像があれば、その大きさを返す（ピクセルは展開しない）
+func (b *Store) DerivedInfo(sha, file string) (DerivedImage, bool) {
+	f, err := b.OpenDerived(sha, file)
+	if err != nil {
+		return DerivedImage{}, false
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return DerivedImage{}, false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return DerivedImage{}, false
+	}
+	return DerivedImage{Width: cfg.Width, Height: cfg.Height, Size: info.Size()}, true
+}
+
+// WriteDerived は派生ファイルを保存する。
+// 元のファイルが（RemoveUnused で）消えていれば保存しない。確認と書き込みを Mutex の中で行うので、
+// 削除と入れ違いになって派生ファイルだけが残ることはない
+func (b *Store) WriteDerived(sha, file string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := os.Stat(b.path(sha)); err != nil {
+		return err
+	}
+	dest := b.derivedPath(sha, file)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	// 一時ファイルに書いてからリネームし、書きかけのファイルを配信しない
+	tmp, err := os.CreateTemp(filepath.Join(b.dir, "tmp"), "derived-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// RemoveUnused は inUse が false を返すファイル（とその派生ファイル）を消す
+func (b *Store) RemoveUnused(shas []string, inUse func(sha string) bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, sha := range shas {
+		if inUse(sha) {
+			continue
+		}
+		if err := os.Remove(b.path(sha)); err != nil && !os.IsNotExist(err) {
+			slog.Error("添付ファイルの削除に失敗しました", "sha256", sha, "err", err)
+		}
+		if err := os.RemoveAll(b.derivedPath(sha, "")); err != nil {
+			slog.Error("サムネイルの削除に失敗しました", "sha256", sha, "err", err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package blobstore
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func textOnly(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/plain")
+}
+
+func TestStage(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		maxSize  int64
+		wantErr  error
+		wantType string
+	}{
+		{"text", "hello", 10, nil, "text/plain; charset=utf-8"},
+		{"exactly max size", "0123456789", 10, nil, "text/plain; charset=utf-8"},
+		{"too large", "0123456789a", 10, ErrTooLarge, ""},
+		{"html is rejected", "<html><body>x</body></html>", 100, ErrUnsupportedType, ""},
+		{"png is rejected", "\x89PNG\r\n\x1a\n", 100, ErrUnsupportedType, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := New(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			staged, err := b.Stage(strings.NewReader(tt.body), tt.maxSize, textOnly)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				defer b.Discard(staged)
+				if staged.ContentType != tt.wantType || staged.Size != int64(len(tt.body)) {
+					t.Errorf("got %q (%d bytes), want %q (%d bytes)", staged.ContentType, staged.Size, tt.wantType, len(tt.body))
+				}
+			}
+			// 失敗しても成功しても、確定前のファイルは tmp 以外に残らない
+			if entries, _ := os.ReadDir(b.dir); len(entries) != 1 {
+				t.Errorf("unexpected entries in store: %v", entries)
+			}
+		})
+	}
+}
+
+func TestCommitDeduplicatesAndRollsBack(t *testing.T) {
+	b, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage := func() *Staged {
+		staged, err := b.Stage(strings.NewReader("same content"), 100, textOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return staged
+	}
+
+	// register が失敗したら、新しく置いたファイルは消える
+	first := stage()
+	if err := b.Commit(first, func() error { return errors.New("register failed") }); err == nil {
+		t.Fatal("Commit should return the register error")
+	}
+	if _, err := b.Open(first.SHA256); !os.IsNotExist(err) {
+		t.Fatalf("blob should be removed after a failed register, err = %v", err)
+	}
+
+	// 同じ内容を2回確定しても、ファイルは1つで内容も同じ
+	for i := 0; i < 2; i++ {
+		if err := b.Commit(stage(), func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := b.Open(first.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "same content" {
+		t.Errorf("content = %q", data)
+	}
+	if entries, _ := os.ReadDir(b.dir + "/tmp"); len(entries) != 0 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+
+	// 2回目の確定で登録が失敗しても、すでにあったファイルは消さない
+	if err := b.Commit(stage(), func() error { return errors.New("register failed") }); err == nil {
+		t.Fatal("Commit should return the register error")
+	}
+	if _, err := b.ReadAll(first.SHA256); err != nil {
+		t.Errorf("existing blob was removed: %v", err)
+	}
+}
+
+func TestRemoveUnused(t *testing.T) {
+	b, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shas []string
+	for _, body := range []string{"keep me", "remove me"} {
+		staged, err := b.Stage(strings.NewReader(body), 100, textOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Commit(staged, func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.WriteDerived(staged.SHA256, "thumb.txt", []byte("derived")); err != nil {
+			t.Fatal(err)
+		}
+		shas = append(shas, staged.SHA256)
+	}
+
+	b.RemoveUnused(shas, func(sha string) bool { return sha == shas[0] })
+
+	tests := []struct {
+		sha      string
+		wantKept bool
+	}{
+		{shas[0], true},
+		{shas[1], false},
+	}
+	for _, tt := range tests {
+		_, blobErr := b.ReadAll(tt.sha)
+		_, derivedErr := b.OpenDerived(tt.sha, "thumb.txt")
+		if (blobErr == nil) != tt.wantKept || (derivedErr == nil) != tt.wantKept {
+			t.Errorf("%s: blob err = %v, derived err = %v, want kept = %v", tt.sha[:8], blobErr, derivedErr, tt.wantKept)
+		}
+	}
+
+	// 元のファイルが消えたあとは派生ファイルを書けない
+	if err := b.WriteDerived(shas[1], "thumb.txt", []byte("late")); err == nil {
+		t.Error("WriteDerived should fail after the blob was removed")
+	}
+}
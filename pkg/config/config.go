@@ -0,0 +1,509 @@
+// Package config は設定を「既定値 → 設定ファイル → 環境変数 → コマンドラインフラグ」の順に重ねて読み込む
+//
+// 【学習ポイント】
+// 1. 既定値は構造体の初期値として書き、後の層ほど優先して上書きする（12-Factor App の考え方）
+// 2. 構造体タグ（config / env / secret / help）とリフレクションで、項目ごとのコードを書かずに済ませる
+// 3. 設定ファイルは拡張子で JSON か TOML 風（[section] と key = value）を選ぶ
+// 4. 知らないキーや型の合わない値は起動時にエラーにする（打ち間違いに気づかないまま動かさない）
+// 5. 秘密の値（secret:"true"）は表示するときに伏せる
+//
+// 例:
+//
+//	type Config struct {
+//		Server struct {
+//			Addr    string        `config:"addr" help:"待ち受けアドレス"`
+//			Timeout time.Duration `config:"timeout"`
+//		} `config:"server"`
+//		Secret string `config:"secret" env:"APP_SECRET" secret:"true"`
+//	}
+//
+// server.addr は 設定ファイルの [server] addr、環境変数 SERVER_ADDR、フラグ -server-addr で指定できる
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 値の出どころ（Origin に入る）
+const (
+	FromDefault = "default"
+	FromFile    = "file"
+	FromEnv     = "env"
+	FromFlag    = "flag"
+)
+
+// Options は Load の設定
+type Options struct {
+	Name    string                          // フラグの使い方に表示するプログラム名
+	Args    []string                        // コマンドライン引数（通常は os.Args[1:]）
+	FileEnv string                          // 設定ファイルのパスを指定する環境変数（-config フラグが優先）
+	Lookup  func(key string) (string, bool) // 環境変数の取得（省略時は os.LookupEnv）
+	Output  io.Writer                       // -h で表示する使い方の出力先（省略時は標準エラー）
+}
+
+// Origin は設定キーごとに、値がどの層から来たかを表す
+type Origin map[string]string
+
+// field は設定できる1項目
+type field struct {
+	key    string // server.addr
+	env    string // SERVER_ADDR
+	flag   string // server-addr
+	help   string
+	secret bool
+	value  reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load は dst（構造体へのポインタ）に設定を読み込む。
+// 呼び出す前に dst に入っている値が既定値になる。
+// -h / -help が指定されたときは使い方を表示して flag.ErrHelp を返す
+func Load(dst interface{}, opts Options) (Origin, error) {
+	fields, err := collect(dst)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Lookup == nil {
+		opts.Lookup = os.LookupEnv
+	}
+
+	origin := make(Origin)
+	byKey := make(map[string]*field)
+	for _, f := range fields {
+		origin[f.key] = FromDefault
+		byKey[f.key] = f
+	}
+
+	// フラグは最後に適用するが、設定ファイルのパス（-config）を知るために最初に解析しておく
+	fs := flag.NewFlagSet(opts.Name, flag.ContinueOnError)
+	if opts.Output != nil {
+		fs.SetOutput(opts.Output)
+	}
+	configFile := fs.String("config", "", "設定ファイル（.json または TOML 風の .toml / .conf）")
+	flagValues := make(map[string]*rawFlag)
+	for _, f := range fields {
+		v := &rawFlag{isBool: f.value.Kind() == reflect.Bool}
+		flagValues[f.key] = v
+		usage := f.help
+		if usage == "" {
+			usage = f.key
+		}
+		fs.Var(v, f.flag, fmt.Sprintf("%s（環境変数 %s、既定値 %s）", usage, f.env, format(f)))
+	}
+	if err := fs.Parse(opts.Args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("余分な引数があります: %s", strings.Join(fs.Args(), " "))
+	}
+
+	// 1. 設定ファイル
+	path := *configFile
+	if path == "" && opts.FileEnv != "" {
+		path, _ = opts.Lookup(opts.FileEnv)
+	}
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range values {
+			where := path
+			if kv.line > 0 {
+				where = fmt.Sprintf("%s:%d", path, kv.line)
+			}
+			f, ok := byKey[kv.key]
+			if !ok {
+				return nil, fmt.Errorf("%s: 不明な設定キー %q", where, kv.key)
+			}
+			if err := set(f, kv.value); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", where, kv.key, err)
+			}
+			origin[f.key] = FromFile
+		}
+	}
+
+	// 2. 環境変数
+	for _, f := range fields {
+		if raw, ok := opts.Lookup(f.env); ok {
+			if err := set(f, raw); err != nil {
+				return nil, fmt.Errorf("環境変数 %s: %w", f.env, err)
+			}
+			origin[f.key] = FromEnv
+		}
+	}
+
+	// 3. フラグ
+	for _, f := range fields {
+		if v := flagValues[f.key]; v.set {
+			if err := set(f, v.raw); err != nil {
+				return nil, fmt.Errorf("フラグ -%s: %w", f.flag, err)
+			}
+			origin[f.key] = FromFlag
+		}
+	}
+	return origin, nil
+}
+
+// Dump は「キー = 値（出どころ）」の一覧を返す。secret の値は伏せる
+func Dump(src interface{}, origin Origin) []string {
+	fields, err := collect(src)
+	if err != nil {
+		return nil
+	}
+	lines := make([]string, 0, len(fields))
+	for _, f := range fields {
+		value := format(f)
+		if f.secret {
+			value = "(未設定)"
+			if !f.value.IsZero() {
+				value = "********"
+			}
+		}
+		line := f.key + " = " + value
+		if o := origin[f.key]; o != "" {
+			line += " (" + o + ")"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// ========== 構造体の走査 ==========
+
+// collect は構造体のフィールドをたどり、設定できる項目の一覧を作る。
+// 入れ子の構造体は「親のキー.子のキー」になる
+func collect(dst interface{}) ([]*field, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, errors.New("config: 構造体（へのポインタ）を渡してください")
+	}
+	var fields []*field
+	if err := walk(v, "", &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func walk(v reflect.Value, prefix string, fields *[]*field) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := sf.Tag.Get("config")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		key := prefix + name
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+			if err := walk(fv, key+".", fields); err != nil {
+				return err
+			}
+			continue
+		}
+		if !supported(fv) {
+			return fmt.Errorf("config: %s の型 %s には対応していません", key, fv.Type())
+		}
+
+		env := sf.Tag.Get("env")
+		if env == "" {
+			env = strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+		}
+		*fields = append(*fields, &field{
+			key:    key,
+			env:    env,
+			flag:   strings.NewReplacer(".", "-", "_", "-").Replace(key),
+			help:   sf.Tag.Get("help"),
+			secret: sf.Tag.Get("secret") == "true",
+			value:  fv,
+		})
+	}
+	return nil
+}
+
+func supported(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return v.Type().Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// set は文字列を項目の型に変換して代入する
+func set(f *field, raw string) error {
+	v := f.value
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("時間の形式が不正です（例: 500ms, 10s, 1m）: %q", raw)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("true か false を指定してください: %q", raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("整数を指定してください: %q", raw)
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("数値を指定してください: %q", raw)
+		}
+		v.SetFloat(n)
+	case v.Kind() == reflect.Slice:
+		// カンマ区切り（空文字列なら空のスライス）
+		var items []string
+		for _, s := range strings.Split(raw, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				items = append(items, s)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
+
+// format は項目の現在の値を文字列にする
+func format(f *field) string {
+	v := f.value
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		return strings.Join(v.Interface().([]string), ",")
+	case v.Kind() == reflect.String:
+		return strconv.Quote(v.String())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// rawFlag はフラグの値を文字列のまま覚えておく flag.Value。
+// 型の変換は設定ファイル・環境変数を適用した後で set が行う
+type rawFlag struct {
+	raw    string
+	set    bool
+	isBool bool
+}
+
+func (f *rawFlag) String() string { return f.raw }
+
+func (f *rawFlag) Set(s string) error {
+	f.raw, f.set = s, true
+	return nil
+}
+
+// IsBoolFlag が true だと「-debug」のように値なしで指定できる
+func (f *rawFlag) IsBoolFlag() bool { return f.isBool }
+
+// ========== 設定ファイル ==========
+
+// keyValue は設定ファイルの1項目
+type keyValue struct {
+	key   string
+	value string
+	line  int // 行番号（JSON では 0）
+}
+
+func readFile(path string) ([]keyValue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return parseJSON(path, data)
+	}
+	return parseTOML(path, data)
+}
+
+// parseJSON は入れ子のオブジェクトを「親.子」のキーに平らにする
+func parseJSON(path string, data []byte) ([]keyValue, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // 大きな整数が float64 で丸められないようにする
+	var root map[string]interface{}
+	if err := dec.Decode(&root); err != nil {
+		return nil, fmt.Errorf("%s: JSON の形式が不正です: %w", path, err)
+	}
+
+	var values []keyValue
+	var flatten func(prefix string, m map[string]interface{}) error
+	flatten = func(prefix string, m map[string]interface{}) error {
+		for k, v := range m {
+			key := prefix + k
+			switch v := v.(type) {
+			case map[string]interface{}:
+				if err := flatten(key+".", v); err != nil {
+					return err
+				}
+			case string:
+				values = append(values, keyValue{key: key, value: v})
+			case json.Number:
+				values = append(values, keyValue{key: key, value: v.String()})
+			case bool:
+				values = append(values, keyValue{key: key, value: strconv.FormatBool(v)})
+			case []interface{}:
+				items := make([]string, len(v))
+				for i, item := range v {
+					s, ok := item.(string)
+					if !ok {
+						return fmt.Errorf("%s: %s: 配列には文字列だけを書けます", path, key)
+					}
+					items[i] = s
+				}
+				values = append(values, keyValue{key: key, value: strings.Join(items, ",")})
+			case nil:
+				// null は「指定なし」として既定値のままにする
+			}
+		}
+		return nil
+	}
+	if err := flatten("", root); err != nil {
+		return nil, err
+	}
+	// map の順序は不定なので、エラーの出方が毎回変わらないようキーで並べる
+	sort.Slice(values, func(i, j int) bool { return values[i].key < values[j].key })
+	return values, nil
+}
+
+// parseTOML は TOML のよく使う部分だけを読む。
+//
+//	# コメント
+//	[server]
+//	addr = ":8080"
+//	read_timeout = "10s"
+//	debug = true
+//
+// 値は "..."（エスケープあり）、'...'（そのまま）、または数値・true/false などの裸の値
+func parseTOML(path string, data []byte) ([]keyValue, error) {
+	var values []keyValue
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%s:%d: セクションの ] がありません", path, n)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" {
+				return nil, fmt.Errorf("%s:%d: セクション名が空です", path, n)
+			}
+			section += "."
+			continue
+		}
+
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: key = value の形で書いてください", path, n)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if k == "" {
+			return nil, fmt.Errorf("%s:%d: キーが空です", path, n)
+		}
+
+		value, err := tomlValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		values = append(values, keyValue{key: section + k, value: value, line: n})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func tomlValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		s, err := strconv.Unquote(v)
+		if err != nil {
+			return "", fmt.Errorf("文字列の形式が不正です: %s", v)
+		}
+		return s, nil
+	case strings.HasPrefix(v, "'"):
+		if len(v) < 2 || !strings.HasSuffix(v, "'") {
+			return "", fmt.Errorf("文字列の ' が閉じていません: %s", v)
+		}
+		return v[1 : len(v)-1], nil
+	case strings.HasPrefix(v, "["):
+		// 文字列の配列 ["a", "b"] はカンマ区切りにする
+		if !strings.HasSuffix(v, "]") {
+			return "", fmt.Errorf("配列の ] がありません: %s", v)
+		}
+		var items []string
+		for _, item := range strings.Split(v[1:len(v)-1], ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			s, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case v == "":
+		return "", errors.New("値がありません")
+	}
+	return v, nil
+}
+
+// stripComment は引用符の外にある # 以降を取り除く
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
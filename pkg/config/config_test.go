@@ -0,0 +1,270 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Server struct {
+		Addr    string        `config:"addr" help:"待ち受けアドレス"`
+		Timeout time.Duration `config:"timeout"`
+		Debug   bool          `config:"debug"`
+	} `config:"server"`
+	Workers int      `config:"workers" env:"APP_WORKERS"`
+	Ratio   float64  `config:"ratio"`
+	Tags    []string `config:"tags"`
+	Secret  string   `config:"secret" env:"APP_SECRET" secret:"true"`
+}
+
+func defaultTestConfig() testConfig {
+	var c testConfig
+	c.Server.Addr = ":8080"
+	c.Server.Timeout = 5 * time.Second
+	c.Workers = 2
+	return c
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// lookupFrom は環境変数の代わりに map を引く
+func lookupFrom(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "app.toml", "workers = 4\n[server]\naddr = \":9000\"\n")
+	other := writeFile(t, "other.toml", "[server]\naddr = \":9900\"\n")
+
+	tests := []struct {
+		name        string
+		env         map[string]string
+		args        []string
+		wantAddr    string
+		wantWorkers int
+		wantOrigin  map[string]string
+	}{
+		{
+			name:        "defaults only",
+			wantAddr:    ":8080",
+			wantWorkers: 2,
+			wantOrigin:  map[string]string{"server.addr": FromDefault, "workers": FromDefault},
+		},
+		{
+			name:        "file overrides defaults",
+			args:        []string{"-config", file},
+			wantAddr:    ":9000",
+			wantWorkers: 4,
+			wantOrigin:  map[string]string{"server.addr": FromFile, "workers": FromFile},
+		},
+		{
+			name:        "file path from FileEnv",
+			env:         map[string]string{"APP_CONFIG": file},
+			wantAddr:    ":9000",
+			wantWorkers: 4,
+			wantOrigin:  map[string]string{"server.addr": FromFile},
+		},
+		{
+			name:        "-config flag beats FileEnv",
+			env:         map[string]string{"APP_CONFIG": file},
+			args:        []string{"-config", other},
+			wantAddr:    ":9900",
+			wantWorkers: 2,
+			wantOrigin:  map[string]string{"server.addr": FromFile, "workers": FromDefault},
+		},
+		{
+			name:        "env overrides file",
+			env:         map[string]string{"SERVER_ADDR": ":9100", "APP_WORKERS": "8"},
+			args:        []string{"-config", file},
+			wantAddr:    ":9100",
+			wantWorkers: 8,
+			wantOrigin:  map[string]string{"server.addr": FromEnv, "workers": FromEnv},
+		},
+		{
+			name:        "flag overrides env",
+			env:         map[string]string{"SERVER_ADDR": ":9100", "APP_WORKERS": "8"},
+			args:        []string{"-config", file, "-server-addr", ":9200"},
+			wantAddr:    ":9200",
+			wantWorkers: 8,
+			wantOrigin:  map[string]string{"server.addr": FromFlag, "workers": FromEnv},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultTestConfig()
+			origin, err := Load(&cfg, Options{Args: tt.args, FileEnv: "APP_CONFIG", Lookup: lookupFrom(tt.env)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Addr != tt.wantAddr || cfg.Workers != tt.wantWorkers {
+				t.Errorf("addr, workers = %q, %d, want %q, %d", cfg.Server.Addr, cfg.Workers, tt.wantAddr, tt.wantWorkers)
+			}
+			for key, want := range tt.wantOrigin {
+				if origin[key] != want {
+					t.Errorf("origin[%q] = %q, want %q", key, origin[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadTypes(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		body string
+		args []string
+	}{
+		{
+			name: "toml",
+			file: "app.toml",
+			body: `# コメント
+workers = 3
+ratio = 0.5
+tags = ["a", 'b # c']   # 配列
+secret = "p#ss"
+
+[server]
+timeout = "1m30s"
+debug = true
+`,
+		},
+		{
+			name: "json",
+			file: "app.json",
+			body: `{"workers": 3, "ratio": 0.5, "tags": ["a", "b # c"], "secret": "p#ss", "server": {"timeout": "1m30s", "debug": true, "addr": null}}`,
+		},
+		{
+			name: "flags",
+			args: []string{"-workers", "3", "-ratio", "0.5", "-tags", "a, b # c,", "-secret", "p#ss", "-server-timeout", "1m30s", "-server-debug"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = []string{"-config", writeFile(t, tt.file, tt.body)}
+			}
+			cfg := defaultTestConfig()
+			if _, err := Load(&cfg, Options{Args: args, Lookup: lookupFrom(nil)}); err != nil {
+				t.Fatal(err)
+			}
+
+			want := defaultTestConfig()
+			want.Workers = 3
+			want.Ratio = 0.5
+			want.Tags = []string{"a", "b # c"}
+			want.Secret = "p#ss"
+			want.Server.Timeout = 90 * time.Second
+			want.Server.Debug = true
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("config = %+v, want %+v", cfg, want)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		body    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{name: "unknown toml key", file: "app.toml", body: "[server]\nport = 80\n", wantErr: `app.toml:2: 不明な設定キー "server.port"`},
+		{name: "unknown json key", file: "app.json", body: `{"server": {"port": 80}}`, wantErr: `不明な設定キー "server.port"`},
+		{name: "int in file", file: "app.toml", body: "workers = many\n", wantErr: `app.toml:1: workers: 整数を指定してください: "many"`},
+		{name: "duration in env", env: map[string]string{"SERVER_TIMEOUT": "5"}, wantErr: "環境変数 SERVER_TIMEOUT: 時間の形式が不正です"},
+		{name: "bool flag", args: []string{"-server-debug=maybe"}, wantErr: `フラグ -server-debug: true か false を指定してください: "maybe"`},
+		{name: "float in env", env: map[string]string{"RATIO": "half"}, wantErr: "環境変数 RATIO: 数値を指定してください"},
+		{name: "unknown flag", args: []string{"-server-port", "80"}, wantErr: "flag provided but not defined"},
+		{name: "extra argument", args: []string{"serve"}, wantErr: "余分な引数があります: serve"},
+		{name: "unclosed section", file: "app.toml", body: "[server\n", wantErr: "app.toml:1: セクションの ] がありません"},
+		{name: "missing value", file: "app.toml", body: "workers =\n", wantErr: "app.toml:1: 値がありません"},
+		{name: "not key value", file: "app.toml", body: "workers\n", wantErr: "app.toml:1: key = value の形で書いてください"},
+		{name: "json array of numbers", file: "app.json", body: `{"tags": [1, 2]}`, wantErr: "tags: 配列には文字列だけを書けます"},
+		{name: "broken json", file: "app.json", body: `{"workers": `, wantErr: "JSON の形式が不正です"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file, tt.body)}, args...)
+			}
+			cfg := defaultTestConfig()
+			_, err := Load(&cfg, Options{Args: args, Lookup: lookupFrom(tt.env), Output: io.Discard})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	var out strings.Builder
+	cfg := defaultTestConfig()
+	_, err := Load(&cfg, Options{Name: "app", Args: []string{"-h"}, Lookup: lookupFrom(nil), Output: &out})
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("err = %v, want flag.ErrHelp", err)
+	}
+	for _, want := range []string{"-server-addr", "待ち受けアドレス（環境変数 SERVER_ADDR、既定値 \":8080\"）", "環境変数 APP_WORKERS"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("usage does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestLoadUnsupportedType(t *testing.T) {
+	var cfg struct {
+		Limits map[string]int `config:"limits"`
+	}
+	if _, err := Load(&cfg, Options{Lookup: lookupFrom(nil)}); err == nil || !strings.Contains(err.Error(), "limits の型") {
+		t.Errorf("err = %v, want an unsupported type error", err)
+	}
+	if _, err := Load(cfg, Options{Lookup: lookupFrom(nil)}); err == nil {
+		t.Error("want an error for a non-pointer struct with an unsupported field")
+	}
+	if _, err := Load(new(int), Options{Lookup: lookupFrom(nil)}); err == nil {
+		t.Error("want an error for a non-struct")
+	}
+}
+
+func TestDump(t *testing.T) {
+	cfg := defaultTestConfig()
+	origin, err := Load(&cfg, Options{Args: []string{"-secret", "hunter2"}, Lookup: lookupFrom(map[string]string{"APP_WORKERS": "4"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Dump(&cfg, origin)
+	want := []string{
+		`server.addr = ":8080" (default)`,
+		`server.timeout = 5s (default)`,
+		`server.debug = false (default)`,
+		`workers = 4 (env)`,
+		`ratio = 0 (default)`,
+		`tags =  (default)`,
+		`secret = ******** (flag)`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Dump =\n  %s\nwant\n  %s", strings.Join(got, "\n  "), strings.Join(want, "\n  "))
+	}
+}
@@ -0,0 +1,755 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ========== エラー / 結果 ==========
+
+// Error は GraphQL のレスポンスに含めるエラー
+type Error struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// ExtendedError を実装したエラーをリゾルバーが返すと、extensions に追加情報が入る
+type ExtendedError interface {
+	error
+	Extensions() map[string]interface{}
+}
+
+type Result struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Params は1回のリクエスト
+type Params struct {
+	Schema        *Schema
+	Query         string
+	OperationName string
+	Variables     map[string]interface{}
+	Context       context.Context
+	// AllowMutation が false なら mutation を拒否する（GET リクエストなど）
+	AllowMutation bool
+	// MaxDepth はフィールドの入れ子の深さの上限（0 なら制限しない）。
+	// 投稿 → 投稿者 → 投稿 → ... と循環する型は、深くするだけで処理量を増やせてしまう
+	MaxDepth int
+	// MaxComplexity はフラグメントを展開したときのフィールド数の上限（0 なら制限しない）
+	MaxComplexity int
+}
+
+// ========== 実行 ==========
+
+// Do はクエリを解析して実行する。
+// 構文エラーや変数のエラーなど実行前に失敗した場合、Data は nil になる
+func Do(p Params) *Result {
+	if p.Context == nil {
+		p.Context = context.Background()
+	}
+
+	doc, err := Parse(p.Query)
+	if err != nil {
+		return &Result{Errors: []*Error{toError(err)}}
+	}
+
+	op, err := selectOperation(doc, p.OperationName)
+	if err != nil {
+		return &Result{Errors: []*Error{toError(err)}}
+	}
+
+	var root *Object
+	switch op.Type {
+	case "query":
+		root = p.Schema.Query
+	case "mutation":
+		if p.Schema.Mutation == nil {
+			return &Result{Errors: []*Error{{Message: "Schema is not configured for mutations", Locations: []Location{op.Loc}}}}
+		}
+		if !p.AllowMutation {
+			return &Result{Errors: []*Error{{Message: "Mutations are not allowed in this request", Locations: []Location{op.Loc}}}}
+		}
+		root = p.Schema.Mutation
+	default:
+		return &Result{Errors: []*Error{{Message: fmt.Sprintf("%s operations are not supported", op.Type), Locations: []Location{op.Loc}}}}
+	}
+
+	if errs := validate(doc, op, p.MaxDepth, p.MaxComplexity); len(errs) > 0 {
+		return &Result{Errors: errs}
+	}
+
+	e := &executor{schema: p.Schema, ctx: p.Context, fragments: doc.Fragments}
+	if e.variables, err = e.coerceVariables(op.Variables, p.Variables); err != nil {
+		return &Result{Errors: []*Error{toError(err)}}
+	}
+
+	data := e.executeRoot(root, op)
+	return &Result{Data: data, Errors: e.errors}
+}
+
+func selectOperation(doc *Document, name string) (*Operation, error) {
+	if name == "" {
+		if len(doc.Operations) > 1 {
+			return nil, &Error{Message: "Must provide operation name if query contains multiple operations"}
+		}
+		return doc.Operations[0], nil
+	}
+	for _, op := range doc.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("Unknown operation named %q", name)}
+}
+
+func toError(err error) *Error {
+	if gqlErr, ok := err.(*Error); ok {
+		return gqlErr
+	}
+	return &Error{Message: err.Error()}
+}
+
+// executor は1回の実行の状態を持つ（単一の goroutine で動く）
+type executor struct {
+	schema    *Schema
+	ctx       context.Context
+	fragments map[string]*Fragment
+	variables map[string]interface{}
+	errors    []*Error
+
+	// pending は Thunk の評価待ちの処理。
+	// 同じ深さの Thunk を全部溜めてから評価するので、Loader がまとめて取得できる
+	pending []func()
+}
+
+// executeRoot は mutation のルートフィールドを順番に、query は一度に実行する
+func (e *executor) executeRoot(root *Object, op *Operation) *orderedMap {
+	keys, groups := e.collectFields(root, op.SelectionSet)
+	out := newOrderedMap()
+
+	for _, key := range keys {
+		e.executeField(root, nil, groups[key], []interface{}{key}, out)
+		if op.Type == "mutation" {
+			e.drain()
+		}
+	}
+	e.drain()
+	return out
+}
+
+// drain は評価待ちの Thunk がなくなるまで評価を繰り返す
+func (e *executor) drain() {
+	for len(e.pending) > 0 {
+		batch := e.pending
+		e.pending = nil
+		for _, fn := range batch {
+			fn()
+		}
+	}
+}
+
+func (e *executor) executeFields(objType *Object, source interface{}, selections []Selection, path []interface{}) *orderedMap {
+	keys, groups := e.collectFields(objType, selections)
+	out := newOrderedMap()
+	for _, key := range keys {
+		e.executeField(objType, source, groups[key], appendPath(path, key), out)
+	}
+	return out
+}
+
+func (e *executor) executeField(objType *Object, source interface{}, fields []*FieldNode, path []interface{}, out *orderedMap) {
+	field := fields[0]
+	key := field.ResponseKey()
+
+	if field.Name == "__typename" {
+		out.Set(key, objType.TypeName)
+		return
+	}
+
+	def := objType.Field(field.Name)
+	if def == nil {
+		e.addError(fmt.Errorf("Cannot query field %q on type %q", field.Name, objType.TypeName), field, path)
+		return
+	}
+
+	args, err := e.coerceArguments(def.Args, field.Arguments)
+	if err != nil {
+		e.addError(err, field, path)
+		out.Set(key, nil)
+		return
+	}
+
+	out.Set(key, nil)
+	set := func(v interface{}) { out.Set(key, v) }
+
+	resolve := def.Resolve
+	if resolve == nil {
+		resolve = defaultResolve
+	}
+	value, err := e.callResolver(resolve, ResolveParams{
+		Context: e.ctx,
+		Source:  source,
+		Args:    args,
+		Info:    ResolveInfo{FieldName: field.Name, ParentType: objType, Path: path},
+	})
+	if err != nil {
+		e.addError(err, field, path)
+		return
+	}
+	e.completeValue(def.Type, fields, value, path, set)
+}
+
+// callResolver はリゾルバーの panic をエラーに変換する
+func (e *executor) callResolver(fn ResolveFunc, p ResolveParams) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("internal error: %v", r)
+		}
+	}()
+	return fn(p)
+}
+
+// completeValue はリゾルバーの戻り値をフィールドの型に合わせてレスポンスの値にする
+func (e *executor) completeValue(t Type, fields []*FieldNode, value interface{}, path []interface{}, set func(interface{})) {
+	if thunk, ok := value.(Thunk); ok {
+		e.pending = append(e.pending, func() {
+			v, err := thunk()
+			if err != nil {
+				e.addError(err, fields[0], path)
+				set(nil)
+				return
+			}
+			e.completeValue(t, fields, v, path, set)
+		})
+		return
+	}
+
+	if nonNull, ok := t.(*NonNull); ok {
+		if isNil(value) {
+			e.addError(fmt.Errorf("Cannot return null for non-nullable field"), fields[0], path)
+			set(nil)
+			return
+		}
+		e.completeValue(nonNull.OfType, fields, value, path, set)
+		return
+	}
+
+	if isNil(value) {
+		set(nil)
+		return
+	}
+
+	// *string や *time.Time などのポインタはスカラーとして値を取り出す
+	if _, ok := namedType(t).(*Object); !ok {
+		if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr {
+			value = rv.Elem().Interface()
+		}
+	}
+
+	switch t := t.(type) {
+	case *List:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.addError(fmt.Errorf("Expected a list, got %T", value), fields[0], path)
+			set(nil)
+			return
+		}
+		items := make([]interface{}, rv.Len())
+		set(items)
+		for i := range items {
+			i := i
+			e.completeValue(t.OfType, fields, rv.Index(i).Interface(), appendPath(path, i), func(v interface{}) {
+				items[i] = v
+			})
+		}
+	case *Scalar:
+		v, err := t.Serialize(value)
+		if err != nil {
+			e.addError(err, fields[0], path)
+			set(nil)
+			return
+		}
+		set(v)
+	case *Enum:
+		v, err := t.serialize(value)
+		if err != nil {
+			e.addError(err, fields[0], path)
+			set(nil)
+			return
+		}
+		set(v)
+	case *Object:
+		var selections []Selection
+		for _, f := range fields {
+			selections = append(selections, f.SelectionSet...)
+		}
+		if len(selections) == 0 {
+			e.addError(fmt.Errorf("Field %q of type %q must have a selection of subfields", fields[0].Name, t.TypeName), fields[0], path)
+			set(nil)
+			return
+		}
+		set(e.executeFields(t, value, selections, path))
+	default:
+		e.addError(fmt.Errorf("Unsupported output type %s", t), fields[0], path)
+		set(nil)
+	}
+}
+
+// collectFields はフラグメントを展開し、レスポンスキーごとにフィールドをまとめる
+func (e *executor) collectFields(objType *Object, selections []Selection) ([]string, map[string][]*FieldNode) {
+	var keys []string
+	groups := make(map[string][]*FieldNode)
+	visited := make(map[string]bool)
+
+	var collect func([]Selection)
+	collect = func(set []Selection) {
+		for _, sel := range set {
+			switch sel := sel.(type) {
+			case *FieldNode:
+				if !e.shouldInclude(sel.Directives) {
+					continue
+				}
+				key := sel.ResponseKey()
+				if _, ok := groups[key]; !ok {
+					keys = append(keys, key)
+				}
+				groups[key] = append(groups[key], sel)
+			case *FragmentSpread:
+				if !e.shouldInclude(sel.Directives) || visited[sel.Name] {
+					continue
+				}
+				visited[sel.Name] = true
+				frag, ok := e.fragments[sel.Name]
+				if !ok {
+					e.errors = append(e.errors, &Error{Message: fmt.Sprintf("Unknown fragment %q", sel.Name)})
+					continue
+				}
+				if frag.TypeCondition == objType.TypeName {
+					collect(frag.SelectionSet)
+				}
+			case *InlineFragment:
+				if !e.shouldInclude(sel.Directives) {
+					continue
+				}
+				if sel.TypeCondition == "" || sel.TypeCondition == objType.TypeName {
+					collect(sel.SelectionSet)
+				}
+			}
+		}
+	}
+	collect(selections)
+	return keys, groups
+}
+
+// shouldInclude は @skip(if:) / @include(if:) を評価する
+func (e *executor) shouldInclude(directives []*Directive) bool {
+	for _, d := range directives {
+		if d.Name != "skip" && d.Name != "include" {
+			continue
+		}
+		for _, a := range d.Arguments {
+			if a.Name != "if" {
+				continue
+			}
+			v, err := e.coerceLiteral(NewNonNull(Boolean), a.Value)
+			if err != nil {
+				continue
+			}
+			if b, _ := v.(bool); b == (d.Name == "skip") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (e *executor) addError(err error, field *FieldNode, path []interface{}) {
+	gqlErr := &Error{
+		Message:   err.Error(),
+		Locations: []Location{field.Loc},
+		Path:      append([]interface{}(nil), path...),
+	}
+	if ext, ok := err.(ExtendedError); ok {
+		gqlErr.Extensions = ext.Extensions()
+	}
+	e.errors = append(e.errors, gqlErr)
+}
+
+func appendPath(path []interface{}, elem interface{}) []interface{} {
+	next := make([]interface{}, len(path), len(path)+1)
+	copy(next, path)
+	return append(next, elem)
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// defaultResolve は Source（map または構造体）から同名のフィールドを取り出す。
+// 構造体は json タグ（createdAt なら created_at も探す）またはフィールド名で探す
+func defaultResolve(p ResolveParams) (interface{}, error) {
+	if m, ok := p.Source.(map[string]interface{}); ok {
+		return m[p.Info.FieldName], nil
+	}
+
+	rv := reflect.ValueOf(p.Source)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, nil
+	}
+
+	name := p.Info.FieldName
+	snake := toSnakeCase(name)
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if tag == name || tag == snake || (tag == "" && strings.EqualFold(sf.Name, name)) {
+			return rv.Field(i).Interface(), nil
+		}
+	}
+	return nil, nil
+}
+
+func toSnakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ========== 入力値の変換 ==========
+
+// coerceVariables はリクエストの variables（JSON）を宣言された型に変換する
+func (e *executor) coerceVariables(defs []*VariableDefinition, input map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, def := range defs {
+		t, err := e.resolveTypeRef(def.Type)
+		if err != nil {
+			return nil, err
+		}
+		if !isInputType(t) {
+			return nil, &Error{Message: fmt.Sprintf("Variable \"$%s\" cannot be non-input type %q", def.Name, def.Type)}
+		}
+
+		raw, provided := input[def.Name]
+		if !provided {
+			if def.Default != nil {
+				v, err := e.coerceLiteral(t, def.Default)
+				if err != nil {
+					return nil, &Error{Message: fmt.Sprintf("Variable \"$%s\": %v", def.Name, err)}
+				}
+				values[def.Name] = v
+				continue
+			}
+			if _, ok := t.(*NonNull); ok {
+				return nil, &Error{Message: fmt.Sprintf("Variable \"$%s\" of required type %q was not provided", def.Name, def.Type)}
+			}
+			continue
+		}
+
+		v, err := coerceInput(t, raw)
+		if err != nil {
+			return nil, &Error{Message: fmt.Sprintf("Variable \"$%s\" got invalid value: %v", def.Name, err)}
+		}
+		values[def.Name] = v
+	}
+	return values, nil
+}
+
+func (e *executor) resolveTypeRef(ref *TypeRef) (Type, error) {
+	var t Type
+	if ref.Elem != nil {
+		elem, err := e.resolveTypeRef(ref.Elem)
+		if err != nil {
+			return nil, err
+		}
+		t = NewList(elem)
+	} else {
+		named, ok := e.schema.types[ref.Name]
+		if !ok {
+			return nil, &Error{Message: fmt.Sprintf("Unknown type %q", ref.Name)}
+		}
+		t = named
+	}
+	if ref.NonNull {
+		t = NewNonNull(t)
+	}
+	return t, nil
+}
+
+func isInputType(t Type) bool {
+	switch namedType(t).(type) {
+	case *Scalar, *Enum, *InputObject:
+		return true
+	}
+	return false
+}
+
+// coerceArguments はフィールドの引数を型変換し、デフォルト値を補う
+func (e *executor) coerceArguments(defs []*Argument, values []*ArgumentValue) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	given := make(map[string]*Value)
+	for _, a := range values {
+		given[a.Name] = a.Value
+	}
+
+	for _, def := range defs {
+		v, ok := given[def.Name]
+		delete(given, def.Name)
+
+		// 渡されていない変数は「引数なし」と同じ扱い
+		if ok && v.Kind == VariableValue {
+			if _, provided := e.variables[v.Raw]; !provided {
+				ok = false
+			}
+		}
+		if !ok {
+			if def.DefaultValue != nil {
+				args[def.Name] = def.DefaultValue
+			} else if _, required := def.Type.(*NonNull); required {
+				return nil, fmt.Errorf("Argument %q of required type %q was not provided", def.Name, def.Type)
+			}
+			continue
+		}
+
+		coerced, err := e.coerceLiteral(def.Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("Argument %q has invalid value: %v", def.Name, err)
+		}
+		args[def.Name] = coerced
+	}
+
+	for name := range given {
+		return nil, fmt.Errorf("Unknown argument %q", name)
+	}
+	return args, nil
+}
+
+// coerceLiteral はクエリ中のリテラルを型に合わせて Go の値にする
+func (e *executor) coerceLiteral(t Type, v *Value) (interface{}, error) {
+	if v.Kind == VariableValue {
+		val, ok := e.variables[v.Raw]
+		if !ok || val == nil {
+			if _, nonNull := t.(*NonNull); nonNull {
+				return nil, fmt.Errorf("Expected non-null value, variable \"$%s\" is null", v.Raw)
+			}
+			return nil, nil
+		}
+		return val, nil
+	}
+
+	if nonNull, ok := t.(*NonNull); ok {
+		if v.Kind == NullValue {
+			return nil, fmt.Errorf("Expected value of type %q, found null", t)
+		}
+		return e.coerceLiteral(nonNull.OfType, v)
+	}
+	if v.Kind == NullValue {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		// リストでない値は要素1つのリストとして扱う
+		items := v.List
+		if v.Kind != ListValue {
+			items = []*Value{v}
+		}
+		out := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			c, err := e.coerceLiteral(t.OfType, item)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, c)
+		}
+		return out, nil
+	case *Scalar:
+		return t.ParseLiteral(v)
+	case *Enum:
+		if v.Kind != EnumValue {
+			return nil, fmt.Errorf("Enum %q cannot represent non-enum value: %s", t.TypeName, v.Raw)
+		}
+		return t.parse(v.Raw)
+	case *InputObject:
+		if v.Kind != ObjectValue {
+			return nil, fmt.Errorf("Expected type %q to be an object", t.TypeName)
+		}
+		given := make(map[string]*Value)
+		for _, f := range v.Fields {
+			given[f.Name] = f.Value
+		}
+		out := make(map[string]interface{})
+		for _, f := range t.Fields {
+			fv, ok := given[f.Name]
+			delete(given, f.Name)
+			if !ok {
+				if f.DefaultValue != nil {
+					out[f.Name] = f.DefaultValue
+				} else if _, required := f.Type.(*NonNull); required {
+					return nil, fmt.Errorf("Field \"%s.%s\" of required type %q was not provided", t.TypeName, f.Name, f.Type)
+				}
+				continue
+			}
+			c, err := e.coerceLiteral(f.Type, fv)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", t.TypeName, f.Name, err)
+			}
+			out[f.Name] = c
+		}
+		for name := range given {
+			return nil, fmt.Errorf("Field %q is not defined by type %q", name, t.TypeName)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("Unsupported input type %s", t)
+}
+
+// coerceInput は variables（JSON をデコードした値）を型に合わせて Go の値にする
+func coerceInput(t Type, v interface{}) (interface{}, error) {
+	if nonNull, ok := t.(*NonNull); ok {
+		if v == nil {
+			return nil, fmt.Errorf("Expected non-nullable type %q not to be null", t)
+		}
+		return coerceInput(nonNull.OfType, v)
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		items, ok := v.([]interface{})
+		if !ok {
+			items = []interface{}{v}
+		}
+		out := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			c, err := coerceInput(t.OfType, item)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, c)
+		}
+		return out, nil
+	case *Scalar:
+		return t.ParseValue(v)
+	case *Enum:
+		name, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("Enum %q cannot represent non-string value: %v", t.TypeName, v)
+		}
+		return t.parse(name)
+	case *InputObject:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Expected type %q to be an object", t.TypeName)
+		}
+		out := make(map[string]interface{})
+		for _, f := range t.Fields {
+			fv, ok := m[f.Name]
+			if !ok {
+				if f.DefaultValue != nil {
+					out[f.Name] = f.DefaultValue
+				} else if _, required := f.Type.(*NonNull); required {
+					return nil, fmt.Errorf("Field \"%s.%s\" of required type %q was not provided", t.TypeName, f.Name, f.Type)
+				}
+				continue
+			}
+			c, err := coerceInput(f.Type, fv)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", t.TypeName, f.Name, err)
+			}
+			out[f.Name] = c
+		}
+		for name := range m {
+			if fieldByName(t.Fields, name) == nil {
+				return nil, fmt.Errorf("Field %q is not defined by type %q", name, t.TypeName)
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("Unsupported input type %s", t)
+}
+
+func fieldByName(fields []*Argument, name string) *Argument {
+	for _, f := range fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// ========== 結果のマップ ==========
+
+// orderedMap はクエリで選択した順にキーを出力する（GraphQL のレスポンスは順序を保つ）
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{values: make(map[string]interface{})}
+}
+
+func (m *orderedMap) Set(key string, v interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = v
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(strconv.Quote(k))
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
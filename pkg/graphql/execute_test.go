@@ -0,0 +1,249 @@
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+type testPost struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	Status   string `json:"status"`
+	AuthorID int    `json:"author_id"`
+}
+
+// blogSchema は投稿と投稿者だけの小さなスキーマ。author は Loader でまとめて読む
+func blogSchema(t *testing.T, authors *Loader) *Schema {
+	t.Helper()
+	posts := []testPost{
+		{ID: 1, Title: "Go入門", Status: "published", AuthorID: 10},
+		{ID: 2, Title: "下書き", Status: "draft", AuthorID: 20},
+		{ID: 3, Title: "並行処理", Status: "published", AuthorID: 10},
+	}
+
+	userType := &Object{TypeName: "User", Fields: []*Field{
+		{Name: "name", Type: NewNonNull(String)},
+	}}
+	statusEnum := &Enum{TypeName: "Status", Values: []*EnumValueDefinition{
+		{Name: "PUBLISHED", Value: "published"},
+		{Name: "DRAFT", Value: "draft"},
+	}}
+	postType := &Object{TypeName: "Post", Fields: []*Field{
+		{Name: "id", Type: NewNonNull(ID)},
+		{Name: "title", Type: NewNonNull(String)},
+		{Name: "status", Type: statusEnum},
+		{Name: "authorId", Type: Int},
+		{Name: "author", Type: userType, Resolve: func(p ResolveParams) (interface{}, error) {
+			return authors.Load(p.Source.(testPost).AuthorID), nil
+		}},
+		{Name: "broken", Type: NewNonNull(String), Resolve: func(p ResolveParams) (interface{}, error) {
+			return nil, errors.New("読み込みに失敗しました")
+		}},
+	}}
+
+	query := &Object{TypeName: "Query", Fields: []*Field{
+		{
+			Name: "posts",
+			Type: NewList(postType),
+			Args: []*Argument{
+				{Name: "status", Type: statusEnum},
+				{Name: "limit", Type: Int, DefaultValue: 10},
+			},
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				var out []testPost
+				for _, post := range posts {
+					if s, ok := p.Args["status"]; ok && s != nil && s != post.Status {
+						continue
+					}
+					if len(out) == p.Args["limit"].(int) {
+						break
+					}
+					out = append(out, post)
+				}
+				return out, nil
+			},
+		},
+		{
+			Name: "post",
+			Type: postType,
+			Args: []*Argument{{Name: "id", Type: NewNonNull(ID)}},
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				for _, post := range posts {
+					if strconv.Itoa(post.ID) == p.Args["id"] {
+						return post, nil
+					}
+				}
+				return nil, nil
+			},
+		},
+	}}
+	mutation := &Object{TypeName: "Mutation", Fields: []*Field{{
+		Name: "echo",
+		Type: String,
+		Args: []*Argument{{Name: "text", Type: NewNonNull(String)}},
+		Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Args["text"], nil
+		},
+	}}}
+
+	schema, err := NewSchema(query, mutation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func newAuthorLoader() *Loader {
+	names := map[int]string{10: "太郎", 20: "花子"}
+	return NewLoader(func(keys []interface{}) (map[interface{}]interface{}, error) {
+		out := make(map[interface{}]interface{})
+		for _, k := range keys {
+			if name, ok := names[k.(int)]; ok {
+				out[k] = map[string]interface{}{"name": name}
+			}
+		}
+		return out, nil
+	})
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		variables     map[string]interface{}
+		operationName string
+		allowMutation bool
+		want          string // Result を JSON にしたもの（json.Marshal は < > を \u003c \u003e にする）
+	}{
+		{
+			name:  "selected fields only, in query order",
+			query: `{ posts(limit: 2) { title id } }`,
+			want:  `{"data":{"posts":[{"title":"Go入門","id":"1"},{"title":"下書き","id":"2"}]}}`,
+		},
+		{
+			name:  "alias and default resolver with snake_case tag",
+			query: `{ first: post(id: 1) { authorId } }`,
+			want:  `{"data":{"first":{"authorId":10}}}`,
+		},
+		{
+			name:  "enum argument and serialization",
+			query: `{ posts(status: DRAFT) { id status } }`,
+			want:  `{"data":{"posts":[{"id":"2","status":"DRAFT"}]}}`,
+		},
+		{
+			name:      "variables",
+			query:     `query Q($s: Status, $n: Int) { posts(status: $s, limit: $n) { id } }`,
+			variables: map[string]interface{}{"s": "PUBLISHED", "n": 1},
+			want:      `{"data":{"posts":[{"id":"1"}]}}`,
+		},
+		{
+			name:      "skip and include directives",
+			query:     `query Q($yes: Boolean!) { post(id: 1) { id @skip(if: $yes) title @include(if: $yes) } }`,
+			variables: map[string]interface{}{"yes": true},
+			want:      `{"data":{"post":{"title":"Go入門"}}}`,
+		},
+		{
+			name:  "fragments",
+			query: `{ post(id: 3) { ...P } } fragment P on Post { id title }`,
+			want:  `{"data":{"post":{"id":"3","title":"並行処理"}}}`,
+		},
+		{
+			name:  "missing object is null",
+			query: `{ post(id: 99) { id } }`,
+			want:  `{"data":{"post":null}}`,
+		},
+		{
+			name:  "resolver error is reported with path",
+			query: `{ post(id: 1) { id broken } }`,
+			want:  `{"data":{"post":{"id":"1","broken":null}},"errors":[{"message":"読み込みに失敗しました","locations":[{"line":1,"column":20}],"path":["post","broken"]}]}`,
+		},
+		{
+			name:  "syntax error has no data",
+			query: `{ posts { id }`,
+			want:  `{"errors":[{"message":"Syntax Error: unexpected \"\u003cEOF\u003e\"","locations":[{"line":1,"column":15}]}]}`,
+		},
+		{
+			name:          "mutation",
+			query:         `mutation { echo(text: "hi") }`,
+			allowMutation: true,
+			want:          `{"data":{"echo":"hi"}}`,
+		},
+		{
+			name:  "mutation not allowed",
+			query: `mutation { echo(text: "hi") }`,
+			want:  `{"errors":[{"message":"Mutations are not allowed in this request","locations":[{"line":1,"column":1}]}]}`,
+		},
+		{
+			name:  "multiple operations need a name",
+			query: `query A { post(id: 1) { id } } query B { post(id: 2) { id } }`,
+			want:  `{"errors":[{"message":"Must provide operation name if query contains multiple operations"}]}`,
+		},
+		{
+			name:          "operation name selects one",
+			query:         `query A { post(id: 1) { id } } query B { post(id: 2) { id } }`,
+			operationName: "B",
+			want:          `{"data":{"post":{"id":"2"}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := blogSchema(t, newAuthorLoader())
+			result := Do(Params{
+				Schema:        schema,
+				Query:         tt.query,
+				Variables:     tt.variables,
+				OperationName: tt.operationName,
+				AllowMutation: tt.allowMutation,
+			})
+			got, err := json.Marshal(result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("result =\n  %s\nwant\n  %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoVariableErrors(t *testing.T) {
+	schema := blogSchema(t, newAuthorLoader())
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+	}{
+		{"missing non-null variable", `query Q($id: ID!) { post(id: $id) { id } }`, nil},
+		{"wrong type", `query Q($n: Int) { posts(limit: $n) { id } }`, map[string]interface{}{"n": "ten"}},
+		{"unknown enum value", `query Q($s: Status) { posts(status: $s) { id } }`, map[string]interface{}{"s": "ARCHIVED"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Do(Params{Schema: schema, Query: tt.query, Variables: tt.variables})
+			if result.Data != nil {
+				t.Errorf("Data = %v, want nil", result.Data)
+			}
+			if len(result.Errors) == 0 {
+				t.Fatal("want an error")
+			}
+		})
+	}
+}
+
+// TestLoaderBatches は投稿ごとの author がまとめて1回で読まれることを確かめる（N+1 の回避）
+func TestLoaderBatches(t *testing.T) {
+	authors := newAuthorLoader()
+	schema := blogSchema(t, authors)
+
+	result := Do(Params{Schema: schema, Query: `{ posts { id author { name } } }`})
+	got, _ := json.Marshal(result)
+	want := `{"data":{"posts":[{"id":"1","author":{"name":"太郎"}},{"id":"2","author":{"name":"花子"}},{"id":"3","author":{"name":"太郎"}}]}}`
+	if string(got) != want {
+		t.Errorf("result =\n  %s\nwant\n  %s", got, want)
+	}
+	if n := authors.Batches(); n != 1 {
+		t.Errorf("Batches() = %d, want 1", n)
+	}
+}
@@ -0,0 +1,291 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ========== イントロスペクション ==========
+//
+// __schema / __type クエリで返す型も、普通の Object として定義する。
+// Source にはスキーマの Go の値（*Schema / Type / *Field など）がそのまま入る
+
+var (
+	schemaType            = &Object{TypeName: "__Schema", Description: "サーバーが提供する型とディレクティブ"}
+	typeType              = &Object{TypeName: "__Type", Description: "スキーマ上の型"}
+	fieldType             = &Object{TypeName: "__Field", Description: "Object のフィールド"}
+	inputValueType        = &Object{TypeName: "__InputValue", Description: "引数または InputObject のフィールド"}
+	enumValueType         = &Object{TypeName: "__EnumValue", Description: "列挙値"}
+	directiveType         = &Object{TypeName: "__Directive", Description: "ディレクティブ"}
+	typeKindEnum          = &Enum{TypeName: "__TypeKind", Description: "型の種類"}
+	directiveLocationEnum = &Enum{TypeName: "__DirectiveLocation", Description: "ディレクティブを書ける場所"}
+)
+
+// inputValue は __InputValue の Source
+type inputValue struct {
+	*Argument
+}
+
+func init() {
+	for _, k := range []string{"SCALAR", "OBJECT", "INTERFACE", "UNION", "ENUM", "INPUT_OBJECT", "LIST", "NON_NULL"} {
+		typeKindEnum.Values = append(typeKindEnum.Values, &EnumValueDefinition{Name: k})
+	}
+	for _, l := range []string{
+		"QUERY", "MUTATION", "SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION", "FRAGMENT_SPREAD", "INLINE_FRAGMENT", "VARIABLE_DEFINITION",
+		"SCHEMA", "SCALAR", "OBJECT", "FIELD_DEFINITION", "ARGUMENT_DEFINITION", "INTERFACE", "UNION", "ENUM", "ENUM_VALUE", "INPUT_OBJECT", "INPUT_FIELD_DEFINITION",
+	} {
+		directiveLocationEnum.Values = append(directiveLocationEnum.Values, &EnumValueDefinition{Name: l})
+	}
+
+	includeDeprecated := []*Argument{{Name: "includeDeprecated", Type: Boolean, DefaultValue: false}}
+	typeList := NewList(NewNonNull(typeType))
+
+	schemaType.Fields = []*Field{
+		{Name: "description", Type: String, Resolve: constResolve(nil)},
+		{Name: "types", Type: NewNonNull(typeList), Resolve: func(p ResolveParams) (interface{}, error) {
+			s := p.Source.(*Schema)
+			types := make([]Type, 0, len(s.typeNames))
+			for _, name := range s.typeNames {
+				types = append(types, s.types[name])
+			}
+			return types, nil
+		}},
+		{Name: "queryType", Type: NewNonNull(typeType), Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*Schema).Query, nil
+		}},
+		{Name: "mutationType", Type: typeType, Resolve: func(p ResolveParams) (interface{}, error) {
+			if m := p.Source.(*Schema).Mutation; m != nil {
+				return m, nil
+			}
+			return nil, nil
+		}},
+		{Name: "subscriptionType", Type: typeType, Resolve: constResolve(nil)},
+		{Name: "directives", Type: NewNonNull(NewList(NewNonNull(directiveType))), Resolve: constResolve(specifiedDirectives)},
+	}
+
+	typeType.Fields = []*Field{
+		{Name: "kind", Type: NewNonNull(typeKindEnum), Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(Type).Kind(), nil
+		}},
+		{Name: "name", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			if name := p.Source.(Type).Name(); name != "" {
+				return name, nil
+			}
+			return nil, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			return optionalString(typeDescription(p.Source.(Type))), nil
+		}},
+		{Name: "specifiedByURL", Type: String, Resolve: constResolve(nil)},
+		{Name: "fields", Type: NewList(NewNonNull(fieldType)), Args: includeDeprecated, Resolve: func(p ResolveParams) (interface{}, error) {
+			obj, ok := p.Source.(*Object)
+			if !ok {
+				return nil, nil
+			}
+			var fields []*Field
+			for _, f := range obj.Fields {
+				if f.DeprecationReason == "" || p.Args["includeDeprecated"] == true {
+					fields = append(fields, f)
+				}
+			}
+			return fields, nil
+		}},
+		{Name: "interfaces", Type: typeList, Resolve: func(p ResolveParams) (interface{}, error) {
+			if _, ok := p.Source.(*Object); ok {
+				return []Type{}, nil
+			}
+			return nil, nil
+		}},
+		{Name: "possibleTypes", Type: typeList, Resolve: constResolve(nil)},
+		{Name: "enumValues", Type: NewList(NewNonNull(enumValueType)), Args: includeDeprecated, Resolve: func(p ResolveParams) (interface{}, error) {
+			enum, ok := p.Source.(*Enum)
+			if !ok {
+				return nil, nil
+			}
+			var values []*EnumValueDefinition
+			for _, v := range enum.Values {
+				if v.DeprecationReason == "" || p.Args["includeDeprecated"] == true {
+					values = append(values, v)
+				}
+			}
+			return values, nil
+		}},
+		{Name: "inputFields", Type: NewList(NewNonNull(inputValueType)), Args: includeDeprecated, Resolve: func(p ResolveParams) (interface{}, error) {
+			input, ok := p.Source.(*InputObject)
+			if !ok {
+				return nil, nil
+			}
+			return inputValues(input.Fields), nil
+		}},
+		{Name: "ofType", Type: typeType, Resolve: func(p ResolveParams) (interface{}, error) {
+			switch t := p.Source.(type) {
+			case *List:
+				return t.OfType, nil
+			case *NonNull:
+				return t.OfType, nil
+			}
+			return nil, nil
+		}},
+		{Name: "isOneOf", Type: Boolean, Resolve: func(p ResolveParams) (interface{}, error) {
+			if _, ok := p.Source.(*InputObject); ok {
+				return false, nil
+			}
+			return nil, nil
+		}},
+	}
+
+	fieldType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String)},
+		{Name: "description", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			return optionalString(p.Source.(*Field).Description), nil
+		}},
+		{Name: "args", Type: NewNonNull(NewList(NewNonNull(inputValueType))), Args: includeDeprecated, Resolve: func(p ResolveParams) (interface{}, error) {
+			return inputValues(p.Source.(*Field).Args), nil
+		}},
+		{Name: "type", Type: NewNonNull(typeType), Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*Field).Type, nil
+		}},
+		{Name: "isDeprecated", Type: NewNonNull(Boolean), Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*Field).DeprecationReason != "", nil
+		}},
+		{Name: "deprecationReason", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			return optionalString(p.Source.(*Field).DeprecationReason), nil
+		}},
+	}
+
+	inputValueType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String), Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(inputValue).Name, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			return optionalString(p.Source.(inputValue).Description), nil
+		}},
+		{Name: "type", Type: NewNonNull(typeType), Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(inputValue).Type, nil
+		}},
+		{Name: "defaultValue", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			arg := p.Source.(inputValue)
+			if arg.DefaultValue == nil {
+				return nil, nil
+			}
+			return printValue(arg.Type, arg.DefaultValue), nil
+		}},
+		{Name: "isDeprecated", Type: NewNonNull(Boolean), Resolve: constResolve(false)},
+		{Name: "deprecationReason", Type: String, Resolve: constResolve(nil)},
+	}
+
+	enumValueType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String)},
+		{Name: "description", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			return optionalString(p.Source.(*EnumValueDefinition).Description), nil
+		}},
+		{Name: "isDeprecated", Type: NewNonNull(Boolean), Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*EnumValueDefinition).DeprecationReason != "", nil
+		}},
+		{Name: "deprecationReason", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			return optionalString(p.Source.(*EnumValueDefinition).DeprecationReason), nil
+		}},
+	}
+
+	directiveType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String)},
+		{Name: "description", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			return optionalString(p.Source.(*DirectiveDefinition).Description), nil
+		}},
+		{Name: "locations", Type: NewNonNull(NewList(NewNonNull(directiveLocationEnum)))},
+		{Name: "args", Type: NewNonNull(NewList(NewNonNull(inputValueType))), Args: includeDeprecated, Resolve: func(p ResolveParams) (interface{}, error) {
+			return inputValues(p.Source.(*DirectiveDefinition).Args), nil
+		}},
+		{Name: "isRepeatable", Type: NewNonNull(Boolean), Resolve: constResolve(false)},
+	}
+}
+
+func introspectionTypes() []Type {
+	return []Type{schemaType, typeType, fieldType, inputValueType, enumValueType, directiveType, typeKindEnum, directiveLocationEnum}
+}
+
+func constResolve(v interface{}) ResolveFunc {
+	return func(ResolveParams) (interface{}, error) { return v, nil }
+}
+
+func optionalString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func inputValues(args []*Argument) []inputValue {
+	values := make([]inputValue, 0, len(args))
+	for _, a := range args {
+		values = append(values, inputValue{a})
+	}
+	return values
+}
+
+func typeDescription(t Type) string {
+	switch t := t.(type) {
+	case *Scalar:
+		return t.Description
+	case *Object:
+		return t.Description
+	case *Enum:
+		return t.Description
+	case *InputObject:
+		return t.Description
+	}
+	return ""
+}
+
+// printValue はデフォルト値を GraphQL のリテラルとして書き出す（例: "abc", 10, [DRAFT]）
+func printValue(t Type, v interface{}) string {
+	if nonNull, ok := t.(*NonNull); ok {
+		t = nonNull.OfType
+	}
+	if v == nil {
+		return "null"
+	}
+
+	switch t := t.(type) {
+	case *List:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice {
+			return printValue(t.OfType, v)
+		}
+		items := make([]string, rv.Len())
+		for i := range items {
+			items[i] = printValue(t.OfType, rv.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *Enum:
+		if name, err := t.serialize(v); err == nil {
+			return name.(string)
+		}
+	case *InputObject:
+		if m, ok := v.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			parts := make([]string, 0, len(keys))
+			for _, k := range keys {
+				ft := Type(String)
+				if f := fieldByName(t.Fields, k); f != nil {
+					ft = f.Type
+				}
+				parts = append(parts, k+": "+printValue(ft, m[k]))
+			}
+			return "{" + strings.Join(parts, ", ") + "}"
+		}
+	}
+
+	// スカラーは JSON と同じ表記
+	if data, err := json.Marshal(v); err == nil {
+		return string(data)
+	}
+	return fmt.Sprint(v)
+}
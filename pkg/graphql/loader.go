@@ -0,0 +1,81 @@
+package graphql
+
+import "sync"
+
+// ========== バッチ読み込み ==========
+
+// BatchFunc は複数のキーをまとめて取得する。見つからないキーは結果に含めなくてよい
+type BatchFunc func(keys []interface{}) (map[interface{}]interface{}, error)
+
+// Loader はリクエスト中に要求されたキーを溜めておき、まとめて1回で取得する。
+//
+// 例: 投稿一覧の各投稿の author を1件ずつ読むと投稿数だけ問い合わせが発生する（N+1 問題）。
+// Load は Thunk を返すだけで、実際の取得は実行エンジンが Thunk を評価するときに
+// それまでに溜まったキーをまとめて1回で行う。結果はリクエストの間キャッシュする
+type Loader struct {
+	mu      sync.Mutex
+	batch   BatchFunc
+	queue   []interface{}
+	queued  map[interface{}]bool
+	cache   map[interface{}]interface{}
+	errs    map[interface{}]error
+	batches int
+}
+
+// NewLoader はリクエストごとに作る（キャッシュがリクエストをまたがないように）
+func NewLoader(batch BatchFunc) *Loader {
+	return &Loader{
+		batch:  batch,
+		queued: make(map[interface{}]bool),
+		cache:  make(map[interface{}]interface{}),
+		errs:   make(map[interface{}]error),
+	}
+}
+
+// Load はキーを予約し、値を返す Thunk を返す
+func (l *Loader) Load(key interface{}) Thunk {
+	l.mu.Lock()
+	_, cached := l.cache[key]
+	if !cached && l.errs[key] == nil && !l.queued[key] {
+		l.queued[key] = true
+		l.queue = append(l.queue, key)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.queued[key] {
+			l.dispatchLocked()
+		}
+		if err := l.errs[key]; err != nil {
+			return nil, err
+		}
+		return l.cache[key], nil
+	}
+}
+
+// Batches はこれまでに BatchFunc を呼んだ回数（動作確認用）
+func (l *Loader) Batches() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.batches
+}
+
+func (l *Loader) dispatchLocked() {
+	keys := l.queue
+	l.queue = nil
+	l.queued = make(map[interface{}]bool)
+	l.batches++
+
+	values, err := l.batch(keys)
+	for _, k := range keys {
+		if err != nil {
+			l.errs[k] = err
+			continue
+		}
+		// 見つからなかったキーも nil としてキャッシュし、再取得しない
+		l.cache[k] = values[k]
+	}
+}
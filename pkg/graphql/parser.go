@@ -0,0 +1,754 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ========== 構文木 ==========
+
+// Document はクエリ文字列を解析した結果
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation は query / mutation 1つ分
+type Operation struct {
+	Type         string // "query" / "mutation" / "subscription"
+	Name         string
+	Variables    []*VariableDefinition
+	SelectionSet []Selection
+	Loc          Location
+}
+
+type VariableDefinition struct {
+	Name    string
+	Type    *TypeRef
+	Default *Value
+}
+
+// TypeRef は変数定義の型（例: [ID!]!）
+type TypeRef struct {
+	Name    string   // 名前付きの型（Elem が nil のとき）
+	Elem    *TypeRef // リスト型の要素
+	NonNull bool
+}
+
+func (t *TypeRef) String() string {
+	s := t.Name
+	if t.Elem != nil {
+		s = "[" + t.Elem.String() + "]"
+	}
+	if t.NonNull {
+		s += "!"
+	}
+	return s
+}
+
+// Selection は *FieldNode / *FragmentSpread / *InlineFragment のいずれか
+type Selection interface{}
+
+type FieldNode struct {
+	Alias        string
+	Name         string
+	Arguments    []*ArgumentValue
+	Directives   []*Directive
+	SelectionSet []Selection
+	Loc          Location
+}
+
+// ResponseKey はレスポンスのキー（別名があれば別名）
+func (f *FieldNode) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+type ArgumentValue struct {
+	Name  string
+	Value *Value
+}
+
+type Directive struct {
+	Name      string
+	Arguments []*ArgumentValue
+}
+
+type FragmentSpread struct {
+	Name       string
+	Directives []*Directive
+}
+
+type InlineFragment struct {
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+}
+
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	SelectionSet  []Selection
+}
+
+// ValueKind はリテラルの種類
+type ValueKind int
+
+const (
+	VariableValue ValueKind = iota
+	IntValue
+	FloatValue
+	StringValue
+	BooleanValue
+	NullValue
+	EnumValue
+	ListValue
+	ObjectValue
+)
+
+// Value はクエリ中のリテラル（または $変数）
+type Value struct {
+	Kind   ValueKind
+	Raw    string // 変数名・数値・文字列・列挙値の名前
+	List   []*Value
+	Fields []*ObjectField
+}
+
+type ObjectField struct {
+	Name  string
+	Value *Value
+}
+
+// Location はエラー表示用の行と列（1始まり）
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// ========== 字句解析 ==========
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$&():=@[]{|}", c) >= 0:
+		l.pos++
+		return token{kind: tokPunct, value: string(c), pos: start}, nil
+	case c == '.':
+		if strings.HasPrefix(l.src[l.pos:], "...") {
+			l.pos += 3
+			return token{kind: tokPunct, value: "...", pos: start}, nil
+		}
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokName, value: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '"':
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			return l.blockString()
+		}
+		return l.string()
+	}
+	return token{}, &Error{Message: fmt.Sprintf("Syntax Error: unexpected character %q", c), Locations: []Location{locate(l.src, start)}}
+}
+
+// skipIgnored は空白・カンマ・コメントを読み飛ばす（GraphQL ではカンマも空白扱い）
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "\uFEFF"):
+			l.pos += len("\uFEFF")
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	l.digits()
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokFloat
+		l.pos++
+		l.digits()
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		l.digits()
+	}
+	raw := l.src[start:l.pos]
+	if _, err := strconv.ParseFloat(raw, 64); err != nil {
+		return token{}, &Error{Message: fmt.Sprintf("Syntax Error: invalid number %q", raw), Locations: []Location{locate(l.src, start)}}
+	}
+	return token{kind: kind, value: raw, pos: start}, nil
+}
+
+func (l *lexer) digits() {
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+}
+
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	l.pos++ // 開始の "
+
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: tokString, value: b.String(), pos: start}, nil
+		case c == '\n' || c == '\r':
+			return token{}, &Error{Message: "Syntax Error: unterminated string", Locations: []Location{locate(l.src, start)}}
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, &Error{Message: "Syntax Error: unterminated string", Locations: []Location{locate(l.src, start)}}
+			}
+			esc := l.src[l.pos+1]
+			l.pos += 2
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					return token{}, &Error{Message: "Syntax Error: invalid unicode escape", Locations: []Location{locate(l.src, l.pos)}}
+				}
+				n, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return token{}, &Error{Message: "Syntax Error: invalid unicode escape", Locations: []Location{locate(l.src, l.pos)}}
+				}
+				b.WriteRune(rune(n))
+				l.pos += 4
+			default:
+				return token{}, &Error{Message: fmt.Sprintf("Syntax Error: invalid escape \\%c", esc), Locations: []Location{locate(l.src, l.pos-2)}}
+			}
+		default:
+			_, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			b.WriteString(l.src[l.pos : l.pos+size])
+			l.pos += size
+		}
+	}
+	return token{}, &Error{Message: "Syntax Error: unterminated string", Locations: []Location{locate(l.src, start)}}
+}
+
+// blockString は """ で囲まれた複数行の文字列（主に説明文で使われる）
+func (l *lexer) blockString() (token, error) {
+	start := l.pos
+	l.pos += 3
+
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		switch {
+		case strings.HasPrefix(l.src[l.pos:], `\"""`):
+			b.WriteString(`"""`)
+			l.pos += 4
+		case strings.HasPrefix(l.src[l.pos:], `"""`):
+			l.pos += 3
+			return token{kind: tokString, value: strings.TrimSpace(b.String()), pos: start}, nil
+		default:
+			b.WriteByte(l.src[l.pos])
+			l.pos++
+		}
+	}
+	return token{}, &Error{Message: "Syntax Error: unterminated block string", Locations: []Location{locate(l.src, start)}}
+}
+
+func isLetter(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+// locate はバイト位置を行と列に変換する
+func locate(src string, pos int) Location {
+	if pos > len(src) {
+		pos = len(src)
+	}
+	line := strings.Count(src[:pos], "\n") + 1
+	col := pos - strings.LastIndex(src[:pos], "\n")
+	return Location{Line: line, Column: col}
+}
+
+// ========== 構文解析 ==========
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+// Parse はクエリ文字列を Document に変換する
+func Parse(src string) (*Document, error) {
+	p := &parser{lex: &lexer{src: src}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &Document{Fragments: make(map[string]*Fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek(tokPunct, "{"):
+			op := &Operation{Type: "query", Loc: p.loc()}
+			set, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			op.SelectionSet = set
+			doc.Operations = append(doc.Operations, op)
+		case p.peek(tokName, "query"), p.peek(tokName, "mutation"), p.peek(tokName, "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		case p.peek(tokName, "fragment"):
+			frag, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := doc.Fragments[frag.Name]; dup {
+				return nil, &Error{Message: fmt.Sprintf("There can be only one fragment named %q", frag.Name)}
+			}
+			doc.Fragments[frag.Name] = frag
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, &Error{Message: "Document does not contain any operations"}
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+func (p *parser) loc() Location {
+	return locate(p.lex.src, p.tok.pos)
+}
+
+func (p *parser) unexpected() error {
+	desc := p.tok.value
+	if p.tok.kind == tokEOF {
+		desc = "<EOF>"
+	}
+	return &Error{Message: fmt.Sprintf("Syntax Error: unexpected %q", desc), Locations: []Location{p.loc()}}
+}
+
+// expect は指定の記号を読み飛ばす
+func (p *parser) expect(punct string) error {
+	if !p.peek(tokPunct, punct) {
+		return &Error{Message: fmt.Sprintf("Syntax Error: expected %q, found %q", punct, p.tok.value), Locations: []Location{p.loc()}}
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", &Error{Message: fmt.Sprintf("Syntax Error: expected name, found %q", p.tok.value), Locations: []Location{p.loc()}}
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) operation() (*Operation, error) {
+	op := &Operation{Type: p.tok.value, Loc: p.loc()}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokName {
+		op.Name = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek(tokPunct, "(") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		for !p.peek(tokPunct, ")") {
+			def, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.Variables = append(op.Variables, def)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 操作に付くディレクティブは読み飛ばす
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+
+	set, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.SelectionSet = set
+	return op, nil
+}
+
+func (p *parser) variableDefinition() (*VariableDefinition, error) {
+	if err := p.expect("$"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	typ, err := p.typeRef()
+	if err != nil {
+		return nil, err
+	}
+
+	def := &VariableDefinition{Name: name, Type: typ}
+	if p.peek(tokPunct, "=") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if def.Default, err = p.value(true); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+func (p *parser) typeRef() (*TypeRef, error) {
+	var t *TypeRef
+	if p.peek(tokPunct, "[") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		elem, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		t = &TypeRef{Elem: elem}
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		t = &TypeRef{Name: name}
+	}
+
+	if p.peek(tokPunct, "!") {
+		t.NonNull = true
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (p *parser) fragment() (*Fragment, error) {
+	if err := p.advance(); err != nil { // "fragment"
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, p.unexpected()
+	}
+	if !p.peek(tokName, "on") {
+		return nil, &Error{Message: `Syntax Error: expected "on"`, Locations: []Location{p.loc()}}
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	cond, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	set, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &Fragment{Name: name, TypeCondition: cond, SelectionSet: set}, nil
+}
+
+func (p *parser) selectionSet() ([]Selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var set []Selection
+	for !p.peek(tokPunct, "}") {
+		if p.tok.kind == tokEOF {
+			return nil, p.unexpected()
+		}
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, sel)
+	}
+	if len(set) == 0 {
+		return nil, &Error{Message: "Syntax Error: empty selection set", Locations: []Location{p.loc()}}
+	}
+	return set, p.advance()
+}
+
+func (p *parser) selection() (Selection, error) {
+	if p.peek(tokPunct, "...") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		// ... on Type { } / ... @dir { } はインラインフラグメント、... Name はフラグメントの展開
+		if p.tok.kind == tokName && p.tok.value != "on" {
+			name := p.tok.value
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			dirs, err := p.directives()
+			if err != nil {
+				return nil, err
+			}
+			return &FragmentSpread{Name: name, Directives: dirs}, nil
+		}
+
+		frag := &InlineFragment{}
+		if p.peek(tokName, "on") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			cond, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			frag.TypeCondition = cond
+		}
+		var err error
+		if frag.Directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		if frag.SelectionSet, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+		return frag, nil
+	}
+
+	field := &FieldNode{Loc: p.loc()}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek(tokPunct, ":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		field.Alias = name
+		if name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	field.Name = name
+
+	if field.Arguments, err = p.arguments(); err != nil {
+		return nil, err
+	}
+	if field.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokPunct, "{") {
+		if field.SelectionSet, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+func (p *parser) arguments() ([]*ArgumentValue, error) {
+	if !p.peek(tokPunct, "(") {
+		return nil, nil
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var args []*ArgumentValue
+	for !p.peek(tokPunct, ")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		val, err := p.value(false)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, &ArgumentValue{Name: name, Value: val})
+	}
+	return args, p.advance()
+}
+
+func (p *parser) directives() ([]*Directive, error) {
+	var dirs []*Directive
+	for p.peek(tokPunct, "@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, &Directive{Name: name, Arguments: args})
+	}
+	return dirs, nil
+}
+
+// value はリテラルを読む。constOnly のとき（変数のデフォルト値）は $変数を使えない
+func (p *parser) value(constOnly bool) (*Value, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokPunct && tok.value == "$" && !constOnly:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Kind: VariableValue, Raw: name}, nil
+	case tok.kind == tokInt:
+		return &Value{Kind: IntValue, Raw: tok.value}, p.advance()
+	case tok.kind == tokFloat:
+		return &Value{Kind: FloatValue, Raw: tok.value}, p.advance()
+	case tok.kind == tokString:
+		return &Value{Kind: StringValue, Raw: tok.value}, p.advance()
+	case tok.kind == tokName:
+		v := &Value{Kind: EnumValue, Raw: tok.value}
+		switch tok.value {
+		case "true", "false":
+			v.Kind = BooleanValue
+		case "null":
+			v.Kind = NullValue
+		}
+		return v, p.advance()
+	case tok.kind == tokPunct && tok.value == "[":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		v := &Value{Kind: ListValue}
+		for !p.peek(tokPunct, "]") {
+			item, err := p.value(constOnly)
+			if err != nil {
+				return nil, err
+			}
+			v.List = append(v.List, item)
+		}
+		return v, p.advance()
+	case tok.kind == tokPunct && tok.value == "{":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		v := &Value{Kind: ObjectValue}
+		for !p.peek(tokPunct, "}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			fv, err := p.value(constOnly)
+			if err != nil {
+				return nil, err
+			}
+			v.Fields = append(v.Fields, &ObjectField{Name: name, Value: fv})
+		}
+		return v, p.advance()
+	}
+	return nil, p.unexpected()
+}
@@ -0,0 +1,486 @@
+// Package graphql は外部ライブラリを使わない小さな GraphQL 実行エンジン
+//
+// 【学習ポイント】
+// 1. クエリ文字列を字句解析・構文解析して構文木（Document）にする
+// 2. スキーマ（Object / Scalar / Enum / InputObject / List / NonNull）を Go の値で組み立てる
+// 3. 各フィールドの Resolve 関数を呼び、選択されたフィールドだけを結果に詰める
+// 4. Thunk と Loader で取得を後回しにし、まとめて1回で読む（N+1 問題の回避）
+// 5. __schema / __type によるイントロスペクションで、ツールがスキーマを調べられる
+// 6. 実行前にフラグメントの循環・深さ・フィールド数を調べ、大きすぎるクエリは何も実行せずに拒否する
+//
+// 簡略化している点: インターフェース・ユニオン・サブスクリプションは未対応。
+// non-null フィールドが null になった場合も、エラーを記録してそのフィールドを null にする（親へは伝播しない）
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// ========== 型 ==========
+
+// Type はスキーマ上の型
+type Type interface {
+	Name() string // List / NonNull は空文字
+	Kind() string // SCALAR / OBJECT / ENUM / INPUT_OBJECT / LIST / NON_NULL
+	String() string
+}
+
+// Scalar は Int / String などの値そのものを表す型
+type Scalar struct {
+	TypeName    string
+	Description string
+	// Serialize は Go の値をレスポンスの値に変換する
+	Serialize func(value interface{}) (interface{}, error)
+	// ParseValue は変数（JSON）の値を Go の値に変換する
+	ParseValue func(value interface{}) (interface{}, error)
+	// ParseLiteral はクエリ中のリテラルを Go の値に変換する
+	ParseLiteral func(value *Value) (interface{}, error)
+}
+
+func (s *Scalar) Name() string   { return s.TypeName }
+func (s *Scalar) Kind() string   { return "SCALAR" }
+func (s *Scalar) String() string { return s.TypeName }
+
+// Object はフィールドを持つ出力用の型
+type Object struct {
+	TypeName    string
+	Description string
+	Fields      []*Field
+
+	hidden map[string]*Field // __schema などイントロスペクションに出さないフィールド
+}
+
+func (o *Object) Name() string   { return o.TypeName }
+func (o *Object) Kind() string   { return "OBJECT" }
+func (o *Object) String() string { return o.TypeName }
+
+// AddField はフィールドを追加する（型同士が循環参照する場合は後から追加する）
+func (o *Object) AddField(f *Field) {
+	o.Fields = append(o.Fields, f)
+}
+
+// Field は名前でフィールドを探す
+func (o *Object) Field(name string) *Field {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return o.hidden[name]
+}
+
+// ResolveFunc はフィールドの値を返す。Thunk を返すと取得を後回しにできる
+type ResolveFunc func(p ResolveParams) (interface{}, error)
+
+// Thunk は後で評価される値（Loader.Load が返す）
+type Thunk func() (interface{}, error)
+
+type ResolveParams struct {
+	Context context.Context
+	Source  interface{}            // 親オブジェクトの値
+	Args    map[string]interface{} // 型変換済みの引数
+	Info    ResolveInfo
+}
+
+type ResolveInfo struct {
+	FieldName  string
+	ParentType *Object
+	Path       []interface{}
+}
+
+type Field struct {
+	Name              string
+	Description       string
+	Type              Type
+	Args              []*Argument
+	Resolve           ResolveFunc // nil なら Source の同名フィールドを返す
+	DeprecationReason string
+}
+
+// Argument はフィールドの引数、または InputObject のフィールド
+type Argument struct {
+	Name         string
+	Description  string
+	Type         Type
+	DefaultValue interface{}
+}
+
+// Enum は決まった値だけを取る型
+type Enum struct {
+	TypeName    string
+	Description string
+	Values      []*EnumValueDefinition
+}
+
+type EnumValueDefinition struct {
+	Name              string
+	Description       string
+	Value             interface{} // Go 側の値（nil なら Name）
+	DeprecationReason string
+}
+
+func (e *Enum) Name() string   { return e.TypeName }
+func (e *Enum) Kind() string   { return "ENUM" }
+func (e *Enum) String() string { return e.TypeName }
+
+func (e *Enum) goValue(v *EnumValueDefinition) interface{} {
+	if v.Value == nil {
+		return v.Name
+	}
+	return v.Value
+}
+
+// serialize は Go の値に対応する列挙値の名前を返す
+func (e *Enum) serialize(value interface{}) (interface{}, error) {
+	for _, v := range e.Values {
+		if reflect.DeepEqual(e.goValue(v), value) || fmt.Sprint(e.goValue(v)) == fmt.Sprint(value) {
+			return v.Name, nil
+		}
+	}
+	return nil, fmt.Errorf("Enum %q cannot represent value: %v", e.TypeName, value)
+}
+
+func (e *Enum) parse(name string) (interface{}, error) {
+	for _, v := range e.Values {
+		if v.Name == name {
+			return e.goValue(v), nil
+		}
+	}
+	return nil, fmt.Errorf("Value %q does not exist in %q enum", name, e.TypeName)
+}
+
+// InputObject は引数に渡すオブジェクトの型。値は map[string]interface{} になる
+type InputObject struct {
+	TypeName    string
+	Description string
+	Fields      []*Argument
+}
+
+func (i *InputObject) Name() string   { return i.TypeName }
+func (i *InputObject) Kind() string   { return "INPUT_OBJECT" }
+func (i *InputObject) String() string { return i.TypeName }
+
+type List struct {
+	OfType Type
+}
+
+func NewList(t Type) *List { return &List{OfType: t} }
+
+func (l *List) Name() string   { return "" }
+func (l *List) Kind() string   { return "LIST" }
+func (l *List) String() string { return "[" + l.OfType.String() + "]" }
+
+type NonNull struct {
+	OfType Type
+}
+
+func NewNonNull(t Type) *NonNull { return &NonNull{OfType: t} }
+
+func (n *NonNull) Name() string   { return "" }
+func (n *NonNull) Kind() string   { return "NON_NULL" }
+func (n *NonNull) String() string { return n.OfType.String() + "!" }
+
+// namedType は List / NonNull を外した型を返す
+func namedType(t Type) Type {
+	for {
+		switch w := t.(type) {
+		case *List:
+			t = w.OfType
+		case *NonNull:
+			t = w.OfType
+		default:
+			return t
+		}
+	}
+}
+
+// ========== 組み込みスカラー ==========
+
+var (
+	Int = &Scalar{
+		TypeName:    "Int",
+		Description: "32ビット符号付き整数",
+		Serialize: func(v interface{}) (interface{}, error) {
+			n, ok := toInt(v)
+			if !ok {
+				return nil, fmt.Errorf("Int cannot represent value: %v", v)
+			}
+			return n, nil
+		},
+		ParseValue: func(v interface{}) (interface{}, error) {
+			n, ok := toInt(v)
+			if !ok {
+				return nil, fmt.Errorf("Int cannot represent value: %v", v)
+			}
+			return n, nil
+		},
+		ParseLiteral: func(v *Value) (interface{}, error) {
+			if v.Kind != IntValue {
+				return nil, fmt.Errorf("Int cannot represent non-integer value: %s", v.Raw)
+			}
+			n, err := strconv.ParseInt(v.Raw, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Int cannot represent value: %s", v.Raw)
+			}
+			return int(n), nil
+		},
+	}
+
+	Float = &Scalar{
+		TypeName:    "Float",
+		Description: "倍精度浮動小数点数",
+		Serialize:   floatValue,
+		ParseValue:  floatValue,
+		ParseLiteral: func(v *Value) (interface{}, error) {
+			if v.Kind != IntValue && v.Kind != FloatValue {
+				return nil, fmt.Errorf("Float cannot represent non-numeric value: %s", v.Raw)
+			}
+			return strconv.ParseFloat(v.Raw, 64)
+		},
+	}
+
+	String = &Scalar{
+		TypeName:    "String",
+		Description: "UTF-8 文字列",
+		Serialize: func(v interface{}) (interface{}, error) {
+			switch s := v.(type) {
+			case string:
+				return s, nil
+			case time.Time:
+				return s.Format(time.RFC3339), nil
+			case fmt.Stringer:
+				return s.String(), nil
+			}
+			// type PostStatus string のような独自の文字列型
+			if rv := reflect.ValueOf(v); rv.Kind() == reflect.String {
+				return rv.String(), nil
+			}
+			return nil, fmt.Errorf("String cannot represent value: %v", v)
+		},
+		ParseValue: func(v interface{}) (interface{}, error) {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("String cannot represent a non string value: %v", v)
+			}
+			return s, nil
+		},
+		ParseLiteral: func(v *Value) (interface{}, error) {
+			if v.Kind != StringValue {
+				return nil, fmt.Errorf("String cannot represent a non string value: %s", v.Raw)
+			}
+			return v.Raw, nil
+		},
+	}
+
+	Boolean = &Scalar{
+		TypeName:    "Boolean",
+		Description: "true または false",
+		Serialize: func(v interface{}) (interface{}, error) {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("Boolean cannot represent value: %v", v)
+			}
+			return b, nil
+		},
+		ParseValue: func(v interface{}) (interface{}, error) {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("Boolean cannot represent a non boolean value: %v", v)
+			}
+			return b, nil
+		},
+		ParseLiteral: func(v *Value) (interface{}, error) {
+			if v.Kind != BooleanValue {
+				return nil, fmt.Errorf("Boolean cannot represent a non boolean value: %s", v.Raw)
+			}
+			return v.Raw == "true", nil
+		},
+	}
+
+	// ID はレスポンスでは常に文字列。入力は文字列と整数のどちらも受け付ける
+	ID = &Scalar{
+		TypeName:    "ID",
+		Description: "一意な識別子（文字列としてシリアライズされる）",
+		Serialize: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, ok := toInt(v); ok {
+				return strconv.Itoa(n), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent value: %v", v)
+		},
+		ParseValue: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, ok := toInt(v); ok {
+				return strconv.Itoa(n), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent value: %v", v)
+		},
+		ParseLiteral: func(v *Value) (interface{}, error) {
+			if v.Kind != StringValue && v.Kind != IntValue {
+				return nil, fmt.Errorf("ID cannot represent value: %s", v.Raw)
+			}
+			return v.Raw, nil
+		},
+	}
+)
+
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, n >= math.MinInt32 && n <= math.MaxInt32
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), n >= math.MinInt32 && n <= math.MaxInt32
+	case float64:
+		// JSON の数値は float64 になる
+		if n != math.Trunc(n) || n < math.MinInt32 || n > math.MaxInt32 {
+			return 0, false
+		}
+		return int(n), true
+	}
+	return 0, false
+}
+
+func floatValue(v interface{}) (interface{}, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	}
+	return nil, fmt.Errorf("Float cannot represent value: %v", v)
+}
+
+// ========== ディレクティブ ==========
+
+// DirectiveDefinition はイントロスペクションで公開するディレクティブ
+type DirectiveDefinition struct {
+	Name        string
+	Description string
+	Locations   []string
+	Args        []*Argument
+}
+
+var specifiedDirectives = []*DirectiveDefinition{
+	{
+		Name:        "include",
+		Description: "if が true のときだけフィールドを含める",
+		Locations:   []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		Args:        []*Argument{{Name: "if", Type: NewNonNull(Boolean)}},
+	},
+	{
+		Name:        "skip",
+		Description: "if が true のときフィールドを除外する",
+		Locations:   []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		Args:        []*Argument{{Name: "if", Type: NewNonNull(Boolean)}},
+	},
+	{
+		Name:        "deprecated",
+		Description: "非推奨のフィールドや列挙値を示す",
+		Locations:   []string{"FIELD_DEFINITION", "ARGUMENT_DEFINITION", "INPUT_FIELD_DEFINITION", "ENUM_VALUE"},
+		Args:        []*Argument{{Name: "reason", Type: String, DefaultValue: "No longer supported"}},
+	},
+}
+
+// ========== スキーマ ==========
+
+type Schema struct {
+	Query    *Object
+	Mutation *Object
+
+	types     map[string]Type
+	typeNames []string // 登録順
+}
+
+// NewSchema はルート型から辿れる型をすべて登録する。
+// 同じ名前の別の型があるとエラーになる
+func NewSchema(query, mutation *Object) (*Schema, error) {
+	s := &Schema{Query: query, Mutation: mutation, types: make(map[string]Type)}
+
+	roots := []Type{query, String, Boolean, Int, Float, ID}
+	if mutation != nil {
+		roots = append(roots, mutation)
+	}
+	roots = append(roots, introspectionTypes()...)
+	for _, t := range roots {
+		if err := s.register(t); err != nil {
+			return nil, err
+		}
+	}
+
+	// __schema / __type はクエリのルートから使えるが、フィールド一覧には出さない
+	query.hidden = map[string]*Field{
+		"__schema": {
+			Name: "__schema",
+			Type: NewNonNull(schemaType),
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				return s, nil
+			},
+		},
+		"__type": {
+			Name: "__type",
+			Type: typeType,
+			Args: []*Argument{{Name: "name", Type: NewNonNull(String)}},
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				if t, ok := s.types[p.Args["name"].(string)]; ok {
+					return t, nil
+				}
+				return nil, nil
+			},
+		},
+	}
+	return s, nil
+}
+
+func (s *Schema) register(t Type) error {
+	t = namedType(t)
+	if existing, ok := s.types[t.Name()]; ok {
+		if existing != t {
+			return fmt.Errorf("graphql: 型 %q が重複しています", t.Name())
+		}
+		return nil
+	}
+	s.types[t.Name()] = t
+	s.typeNames = append(s.typeNames, t.Name())
+
+	switch t := t.(type) {
+	case *Object:
+		for _, f := range t.Fields {
+			if err := s.register(f.Type); err != nil {
+				return err
+			}
+			for _, a := range f.Args {
+				if err := s.register(a.Type); err != nil {
+					return err
+				}
+			}
+		}
+	case *InputObject:
+		for _, f := range t.Fields {
+			if err := s.register(f.Type); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Type は名前で型を探す
+func (s *Schema) Type(name string) (Type, bool) {
+	t, ok := s.types[name]
+	return t, ok
+}
@@ -0,0 +1,176 @@
+package graphql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ========== 実行前の検証 ==========
+
+// maxCount は数え上げの上限（フラグメントを何重にも展開すると桁あふれするため、ここで止める）
+const maxCount = 1 << 40
+
+// validate はクエリを実行する前に、フラグメントの循環と大きさの上限を調べる。
+// どれかに引っかかれば何も実行しない（Data は nil）
+func validate(doc *Document, op *Operation, maxDepth, maxComplexity int) []*Error {
+	if errs := fragmentCycles(doc); len(errs) > 0 {
+		return errs
+	}
+
+	m := &measurer{fragments: doc.Fragments, memo: make(map[string]size)}
+	s := m.selections(op.SelectionSet)
+
+	var errs []*Error
+	if maxDepth > 0 && s.depth > maxDepth {
+		errs = append(errs, &Error{
+			Message:    fmt.Sprintf("Query depth %d exceeds the maximum of %d", s.depth, maxDepth),
+			Locations:  []Location{op.Loc},
+			Extensions: map[string]interface{}{"code": "QUERY_TOO_DEEP"},
+		})
+	}
+	if maxComplexity > 0 && s.complexity > maxComplexity {
+		errs = append(errs, &Error{
+			Message:    fmt.Sprintf("Query complexity %d exceeds the maximum of %d", s.complexity, maxComplexity),
+			Locations:  []Location{op.Loc},
+			Extensions: map[string]interface{}{"code": "QUERY_TOO_COMPLEX"},
+		})
+	}
+	return errs
+}
+
+// fragmentCycles はフラグメントが（他のフラグメントを経由して）自分自身を展開していないか調べる。
+// 循環があると展開が終わらないので、仕様でも検証エラーとされている
+func fragmentCycles(doc *Document) []*Error {
+	names := make([]string, 0, len(doc.Fragments))
+	for name := range doc.Fragments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var errs []*Error
+	var stack []string
+
+	var visit func(name string)
+	visit = func(name string) {
+		frag, ok := doc.Fragments[name]
+		if !ok || state[name] == done {
+			return // 未定義のフラグメントは実行時にエラーにする
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, spread := range fragmentSpreads(frag.SelectionSet) {
+			switch state[spread] {
+			case visiting:
+				// stack の中で spread から先が循環している
+				start := 0
+				for i, n := range stack {
+					if n == spread {
+						start = i
+					}
+				}
+				msg := fmt.Sprintf("Cannot spread fragment %q within itself", spread)
+				if via := stack[start+1:]; len(via) > 0 {
+					msg += " via " + quoteAll(via)
+				}
+				errs = append(errs, &Error{Message: msg})
+			case unvisited:
+				visit(spread)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return errs
+}
+
+// fragmentSpreads は選択の中（入れ子のフィールド・インラインフラグメントを含む）で展開しているフラグメント名
+func fragmentSpreads(selections []Selection) []string {
+	var names []string
+	for _, sel := range selections {
+		switch sel := sel.(type) {
+		case *FieldNode:
+			names = append(names, fragmentSpreads(sel.SelectionSet)...)
+		case *FragmentSpread:
+			names = append(names, sel.Name)
+		case *InlineFragment:
+			names = append(names, fragmentSpreads(sel.SelectionSet)...)
+		}
+	}
+	return names
+}
+
+func quoteAll(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = fmt.Sprintf("%q", n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// size はクエリの大きさ。
+// depth はフィールドの入れ子の深さ、complexity はフラグメントを展開したときのフィールドの数
+// （@skip / @include は考えず、書かれたものはすべて数える）
+type size struct {
+	depth      int
+	complexity int
+}
+
+// measurer はフラグメントごとの大きさを覚えておき、同じフラグメントを何度展開しても1回だけ数える
+type measurer struct {
+	fragments map[string]*Fragment
+	memo      map[string]size
+}
+
+func (m *measurer) selections(selections []Selection) size {
+	var total size
+	for _, sel := range selections {
+		var s size
+		switch sel := sel.(type) {
+		case *FieldNode:
+			s = m.selections(sel.SelectionSet)
+			s.depth++
+			s.complexity = addCount(s.complexity, 1)
+		case *FragmentSpread:
+			s = m.fragment(sel.Name)
+		case *InlineFragment:
+			s = m.selections(sel.SelectionSet)
+		}
+		if s.depth > total.depth {
+			total.depth = s.depth
+		}
+		total.complexity = addCount(total.complexity, s.complexity)
+	}
+	return total
+}
+
+// fragment はフラグメントの大きさ（循環がないことを確認してから呼ぶ）
+func (m *measurer) fragment(name string) size {
+	if s, ok := m.memo[name]; ok {
+		return s
+	}
+	frag, ok := m.fragments[name]
+	if !ok {
+		return size{}
+	}
+	s := m.selections(frag.SelectionSet)
+	m.memo[name] = s
+	return s
+}
+
+// addCount は上限で頭打ちにする足し算
+func addCount(a, b int) int {
+	if a+b > maxCount {
+		return maxCount
+	}
+	return a + b
+}
@@ -0,0 +1,97 @@
+package graphql
+
+import (
+	"strings"
+	"testing"
+)
+
+// node は自分自身を子に持つ型（投稿 → 関連記事 → ... のように循環する）
+type node struct {
+	ID   string
+	Name string
+}
+
+func cyclicSchema(t *testing.T) *Schema {
+	t.Helper()
+	nodeType := &Object{TypeName: "Node", Fields: []*Field{
+		{Name: "id", Type: NewNonNull(ID)},
+		{Name: "name", Type: String},
+	}}
+	nodeType.AddField(&Field{
+		Name:    "child",
+		Type:    nodeType,
+		Resolve: func(p ResolveParams) (interface{}, error) { return node{ID: "2", Name: "child"}, nil },
+	})
+	query := &Object{TypeName: "Query", Fields: []*Field{{
+		Name:    "node",
+		Type:    nodeType,
+		Resolve: func(p ResolveParams) (interface{}, error) { return node{ID: "1", Name: "root"}, nil },
+	}}}
+	schema, err := NewSchema(query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestValidate(t *testing.T) {
+	schema := cyclicSchema(t)
+	tests := []struct {
+		name      string
+		query     string
+		wantError string // 空なら成功（Data が返る）
+	}{
+		{
+			name:  "within limits",
+			query: `{ node { id child { name } } }`,
+		},
+		{
+			name:  "fragments without cycle",
+			query: `{ node { ...A } } fragment A on Node { id child { ...B } } fragment B on Node { name }`,
+		},
+		{
+			name:      "fragment spreads itself",
+			query:     `{ node { ...A } } fragment A on Node { id ...A }`,
+			wantError: `Cannot spread fragment "A" within itself`,
+		},
+		{
+			name:      "cycle through another fragment",
+			query:     `{ node { ...A } } fragment A on Node { child { ...B } } fragment B on Node { ...A }`,
+			wantError: `Cannot spread fragment "A" within itself via "B"`,
+		},
+		{
+			name:      "too deep",
+			query:     `{ node { child { child { child { child { id } } } } } }`,
+			wantError: "Query depth 6 exceeds the maximum of 4",
+		},
+		{
+			name:  "depth at the limit",
+			query: `{ node { child { child { id } } } }`,
+		},
+		{
+			// フラグメントは展開した回数だけ数える（書いた量は小さくても、展開すると大きくなる）
+			name: "fragments multiply the complexity",
+			query: `{ node { ...F2 } }
+				fragment F2 on Node { a: child { ...F1 } b: child { ...F1 } }
+				fragment F1 on Node { f0: id f1: id f2: id f3: id f4: id f5: id f6: id f7: id f8: id f9: id f10: id f11: id f12: id f13: id f14: id f15: id f16: id f17: id f18: id f19: id f20: id f21: id f22: id f23: id f24: id f25: id f26: id f27: id f28: id f29: id }`,
+			wantError: "Query complexity 63 exceeds the maximum of 50",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Do(Params{Schema: schema, Query: tt.query, MaxDepth: 4, MaxComplexity: 50})
+			if tt.wantError == "" {
+				if len(result.Errors) > 0 || result.Data == nil {
+					t.Fatalf("unexpected errors: %v", result.Errors[0].Message)
+				}
+				return
+			}
+			if result.Data != nil {
+				t.Errorf("Data = %v, want nil (nothing should run)", result.Data)
+			}
+			if len(result.Errors) == 0 || !strings.Contains(result.Errors[0].Message, tt.wantError) {
+				t.Fatalf("errors = %+v, want %q", result.Errors, tt.wantError)
+			}
+		})
+	}
+}
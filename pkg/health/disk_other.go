@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package health
+
+// freeBytes は Statfs のない OS では確認しない
+func freeBytes(path string) (uint64, error) {
+	return 0, ErrNotSupported
+}
@@ -0,0 +1,287 @@
+// Package health は名前付きのヘルスチェックを登録し、/livez と /readyz として公開する
+//
+// 【学習ポイント】
+// 1. liveness（生きているか）と readiness（リクエストを受けられるか）を分ける。liveness が失敗すると再起動されるので、外部の依存先の障害では失敗させない
+// 2. チェックごとに期限を付け、応答しない依存先でプローブ自体が止まらないようにする
+// 3. 結果を短時間キャッシュし、頻繁なプローブで依存先に負荷をかけない
+// 4. 停止中は readiness を失敗させ、ロードバランサーに新しいリクエストを送らせない
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout は Check.Timeout を省略したときの期限
+const DefaultTimeout = 2 * time.Second
+
+// 結果の状態
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// ErrNotSupported はこの環境では確認できないことを表す（失敗として扱わない）
+var ErrNotSupported = errors.New("health: この環境では確認できません")
+
+// Check は1つのヘルスチェック
+type Check struct {
+	Name     string
+	Func     func(ctx context.Context) error
+	Timeout  time.Duration // 1回の実行の期限（0 なら DefaultTimeout）
+	CacheTTL time.Duration // 結果を使い回す時間（0 なら毎回実行する）
+}
+
+// Result はチェック1つの結果
+type Result struct {
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS float64   `json:"duration_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+	Cached     bool      `json:"cached"`
+}
+
+// Report はチェック全体の結果
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks,omitempty"`
+}
+
+// OK はすべてのチェックが成功したか
+func (r Report) OK() bool { return r.Status == StatusOK }
+
+type entry struct {
+	check Check
+
+	mu   sync.Mutex // 同じチェックを同時に何本も走らせない
+	last Result
+}
+
+// Registry はチェックの登録表
+type Registry struct {
+	mu           sync.RWMutex
+	liveness     []*entry
+	readiness    []*entry
+	shuttingDown atomic.Bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// AddLiveness は /livez で実行するチェックを登録する。
+// プロセス自体の異常（デッドロックなど）だけを確認し、外部の依存先は含めない
+func (r *Registry) AddLiveness(c Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.liveness = append(r.liveness, &entry{check: c})
+}
+
+// AddReadiness は /readyz で実行するチェックを登録する
+func (r *Registry) AddReadiness(c Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readiness = append(r.readiness, &entry{check: c})
+}
+
+// SetShuttingDown は停止が始まったことを記録する。以降の readiness は失敗になる
+func (r *Registry) SetShuttingDown() {
+	r.shuttingDown.Store(true)
+}
+
+// Liveness は liveness のチェックをすべて実行する
+func (r *Registry) Liveness(ctx context.Context) Report {
+	r.mu.RLock()
+	entries := r.liveness
+	r.mu.RUnlock()
+	return runAll(ctx, entries)
+}
+
+// Readiness は readiness のチェックをすべて実行する。停止中はチェックせずに失敗を返す
+func (r *Registry) Readiness(ctx context.Context) Report {
+	if r.shuttingDown.Load() {
+		return Report{Status: StatusFail, Checks: []Result{{
+			Name:      "shutdown",
+			Status:    StatusFail,
+			Error:     "シャットダウン中",
+			CheckedAt: time.Now(),
+		}}}
+	}
+	r.mu.RLock()
+	entries := r.readiness
+	r.mu.RUnlock()
+	return runAll(ctx, entries)
+}
+
+// runAll はチェックを並行に実行する（全体の時間は一番遅いチェックで決まる）
+func runAll(ctx context.Context, entries []*entry) Report {
+	results := make([]Result, len(entries))
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func(i int, e *entry) {
+			defer wg.Done()
+			results[i] = e.run(ctx)
+		}(i, e)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: results}
+	for _, res := range results {
+		if res.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	sort.Slice(report.Checks, func(i, j int) bool { return report.Checks[i].Name < report.Checks[j].Name })
+	return report
+}
+
+func (e *entry) run(ctx context.Context) Result {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if ttl := e.check.CacheTTL; ttl > 0 && !e.last.CheckedAt.IsZero() && time.Since(e.last.CheckedAt) < ttl {
+		res := e.last
+		res.Cached = true
+		return res
+	}
+
+	timeout := e.check.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// チェックが ctx を見ずに止まっても待ち続けないよう、別のゴルーチンで実行する
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				done <- fmt.Errorf("panic: %v", v)
+			}
+		}()
+		done <- e.check.Func(checkCtx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-checkCtx.Done():
+		err = fmt.Errorf("%s 以内に応答がありません", timeout)
+	}
+
+	res := Result{
+		Name:       e.check.Name,
+		Status:     StatusOK,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt:  start,
+	}
+	if err != nil && !errors.Is(err, ErrNotSupported) {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+	// 呼び出し側が切断して中断された結果はキャッシュしない
+	if ctx.Err() == nil {
+		e.last = res
+	}
+	return res
+}
+
+// ========== HTTP ==========
+
+// LivezHandler は GET /livez のハンドラー
+func (r *Registry) LivezHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		respond(w, req, r.Liveness(req.Context()))
+	}
+}
+
+// ReadyzHandler は GET /readyz のハンドラー
+func (r *Registry) ReadyzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		respond(w, req, r.Readiness(req.Context()))
+	}
+}
+
+// respond は成功なら 200、失敗なら 503 を返す。
+// 既定では状態だけを返し、?verbose（または ?verbose=1）でチェックごとの結果を含める
+func respond(w http.ResponseWriter, req *http.Request, report Report) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+	}
+	if !verbose(req) {
+		// 詳細を返さないときも、失敗したチェックの名前は分かるようにする
+		var failed []string
+		for _, res := range report.Checks {
+			if res.Status != StatusOK {
+				failed = append(failed, res.Name)
+			}
+		}
+		report.Checks = nil
+		if len(failed) > 0 {
+			w.Header().Set("X-Health-Failed", strings.Join(failed, ","))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if req.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+func verbose(req *http.Request) bool {
+	q := req.URL.Query()
+	if !q.Has("verbose") {
+		return false
+	}
+	v := q.Get("verbose")
+	return v == "" || v == "1" || v == "true"
+}
+
+// ========== よく使うチェック ==========
+
+// DiskSpace は path のあるファイルシステムの空き容量が minFree バイト以上あるかを確認する
+func DiskSpace(path string, minFree uint64) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		free, err := freeBytes(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if free < minFree {
+			return fmt.Errorf("%s の空き容量が不足しています（%d MiB、必要 %d MiB）", path, free>>20, minFree>>20)
+		}
+		return nil
+	}
+}
+
+// QueueBacklog は待ち行列の長さが容量の ratio（0〜1）未満かを確認する。
+// 一杯になるとタスクの追加でリクエストが止まるので、その前に readiness を落とす
+func QueueBacklog(pending, capacity func() int, ratio float64) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		n, c := pending(), capacity()
+		if c > 0 && float64(n) >= float64(c)*ratio {
+			return fmt.Errorf("待ち行列が詰まっています（%d / %d）", n, c)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,214 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckResults(t *testing.T) {
+	// ctx を見ずに止まり続けるチェック（テストの最後に解放する）
+	release := make(chan struct{})
+	defer close(release)
+
+	tests := []struct {
+		name       string
+		fn         func(ctx context.Context) error
+		timeout    time.Duration
+		wantStatus string
+		wantError  string
+	}{
+		{"ok", func(ctx context.Context) error { return nil }, 0, StatusOK, ""},
+		{"error", func(ctx context.Context) error { return errors.New("接続できません") }, 0, StatusFail, "接続できません"},
+		{"not supported is not a failure", func(ctx context.Context) error { return ErrNotSupported }, 0, StatusOK, ""},
+		{"panic", func(ctx context.Context) error { panic("boom") }, 0, StatusFail, "panic: boom"},
+		{"ignores the deadline", func(ctx context.Context) error { <-release; return nil }, 20 * time.Millisecond, StatusFail, "20ms 以内に応答がありません"},
+		{"returns the context error", func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() }, 20 * time.Millisecond, StatusFail, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := NewRegistry()
+			reg.AddReadiness(Check{Name: "db", Func: tt.fn, Timeout: tt.timeout})
+
+			start := time.Now()
+			report := reg.Readiness(context.Background())
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Readiness took %v, want it bounded by the timeout", elapsed)
+			}
+			if len(report.Checks) != 1 {
+				t.Fatalf("len(Checks) = %d, want 1", len(report.Checks))
+			}
+			res := report.Checks[0]
+			if res.Status != tt.wantStatus || report.Status != tt.wantStatus {
+				t.Errorf("status = %s (report %s), want %s", res.Status, report.Status, tt.wantStatus)
+			}
+			if !strings.Contains(res.Error, tt.wantError) || (tt.wantStatus == StatusOK && res.Error != "") {
+				t.Errorf("error = %q, want %q", res.Error, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		wantCalls int32
+		wantCache bool
+	}{
+		{"no cache", 0, 2, false},
+		{"cached within ttl", time.Hour, 1, true},
+		{"expired", time.Nanosecond, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			reg := NewRegistry()
+			reg.AddLiveness(Check{Name: "counter", CacheTTL: tt.ttl, Func: func(ctx context.Context) error {
+				calls.Add(1)
+				return nil
+			}})
+
+			first := reg.Liveness(context.Background())
+			second := reg.Liveness(context.Background())
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", n, tt.wantCalls)
+			}
+			if first.Checks[0].Cached {
+				t.Error("first result is marked cached")
+			}
+			if got := second.Checks[0].Cached; got != tt.wantCache {
+				t.Errorf("second Cached = %v, want %v", got, tt.wantCache)
+			}
+		})
+	}
+}
+
+// TestCacheSkipsCanceledCaller は呼び出し側が切断したときの結果をキャッシュしないことを確かめる
+func TestCacheSkipsCanceledCaller(t *testing.T) {
+	reg := NewRegistry()
+	reg.AddReadiness(Check{Name: "db", CacheTTL: time.Hour, Func: func(ctx context.Context) error {
+		return ctx.Err()
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if report := reg.Readiness(ctx); report.OK() {
+		t.Fatal("canceled check reported ok")
+	}
+	if report := reg.Readiness(context.Background()); !report.OK() || report.Checks[0].Cached {
+		t.Errorf("after a canceled run: %+v, want a fresh ok result", report.Checks[0])
+	}
+}
+
+func TestShutdownFlip(t *testing.T) {
+	reg := NewRegistry()
+	reg.AddLiveness(Check{Name: "goroutines", Func: func(ctx context.Context) error { return nil }})
+	reg.AddReadiness(Check{Name: "db", Func: func(ctx context.Context) error { return nil }})
+
+	get := func(h http.HandlerFunc, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	if rec := get(reg.ReadyzHandler(), "/readyz"); rec.Code != http.StatusOK {
+		t.Fatalf("before shutdown: /readyz = %d, want 200", rec.Code)
+	}
+
+	reg.SetShuttingDown()
+
+	rec := get(reg.ReadyzHandler(), "/readyz?verbose")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("after shutdown: /readyz = %d, want 503", rec.Code)
+	}
+	var report Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Checks) != 1 || report.Checks[0].Name != "shutdown" {
+		t.Errorf("checks = %+v, want only the shutdown check", report.Checks)
+	}
+	if got := get(reg.ReadyzHandler(), "/readyz").Header().Get("X-Health-Failed"); got != "shutdown" {
+		t.Errorf("X-Health-Failed = %q, want shutdown", got)
+	}
+	// liveness は停止中でも成功のまま（失敗させると停止処理の途中で再起動されてしまう）
+	if rec := get(reg.LivezHandler(), "/livez"); rec.Code != http.StatusOK {
+		t.Errorf("after shutdown: /livez = %d, want 200", rec.Code)
+	}
+}
+
+func TestHandlerResponses(t *testing.T) {
+	reg := NewRegistry()
+	reg.AddReadiness(Check{Name: "db", Func: func(ctx context.Context) error { return nil }})
+	reg.AddReadiness(Check{Name: "cache", Func: func(ctx context.Context) error { return errors.New("down") }})
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantCode   int
+		wantChecks int
+		wantBody   bool
+	}{
+		{"summary", http.MethodGet, "/readyz", http.StatusServiceUnavailable, 0, true},
+		{"verbose", http.MethodGet, "/readyz?verbose=1", http.StatusServiceUnavailable, 2, true},
+		{"verbose=false", http.MethodGet, "/readyz?verbose=false", http.StatusServiceUnavailable, 0, true},
+		{"head has no body", http.MethodHead, "/readyz", http.StatusServiceUnavailable, 0, false},
+		{"post is not allowed", http.MethodPost, "/readyz", http.StatusMethodNotAllowed, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			reg.ReadyzHandler()(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if (rec.Body.Len() > 0) != tt.wantBody {
+				t.Errorf("body = %q, want body: %v", rec.Body.String(), tt.wantBody)
+			}
+			if tt.wantCode != http.StatusServiceUnavailable || tt.method == http.MethodHead {
+				return
+			}
+			if got := rec.Header().Get("X-Health-Failed"); tt.wantChecks == 0 && got != "cache" {
+				t.Errorf("X-Health-Failed = %q, want cache", got)
+			}
+			var report Report
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Checks) != tt.wantChecks {
+				t.Errorf("len(Checks) = %d, want %d", len(report.Checks), tt.wantChecks)
+			}
+			// 詳細は名前順に並ぶ
+			if tt.wantChecks == 2 && (report.Checks[0].Name != "cache" || report.Checks[1].Name != "db") {
+				t.Errorf("checks = %+v, want sorted by name", report.Checks)
+			}
+		})
+	}
+}
+
+func TestQueueBacklog(t *testing.T) {
+	tests := []struct {
+		pending, capacity int
+		ratio             float64
+		wantErr           bool
+	}{
+		{0, 100, 0.9, false},
+		{89, 100, 0.9, false},
+		{90, 100, 0.9, true},
+		{5, 0, 0.9, false}, // 容量が分からないときは失敗にしない
+	}
+	for _, tt := range tests {
+		check := QueueBacklog(func() int { return tt.pending }, func() int { return tt.capacity }, tt.ratio)
+		if err := check(context.Background()); (err != nil) != tt.wantErr {
+			t.Errorf("QueueBacklog(%d/%d, %v) = %v, want error: %v", tt.pending, tt.capacity, tt.ratio, err, tt.wantErr)
+		}
+	}
+}
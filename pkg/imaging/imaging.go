@@ -0,0 +1,365 @@
+// Package imaging は標準ライブラリの image パッケージだけで作るサムネイル生成
+//
+// 【学習ポイント】
+// 1. image.Decode は import したデコーダー（image/jpeg, image/png, image/gif）で形式を自動判定する
+// 2. image.DecodeConfig ならピクセルを展開せずに幅・高さだけ読めるので、巨大な画像を先に弾ける
+// 3. 縮小は「面積平均」（出力1ピクセルが覆う元画像の範囲を平均する）で、横→縦の2回に分けて計算する
+// 4. スマートフォンの JPEG は画素を回転させず、EXIF の Orientation タグで向きを指定していることが多い
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // GIF のデコーダーを登録する（image.Decode で使えるようにする）
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// ========== 定数 / エラー ==========
+
+// DefaultMaxPixels は展開を許す最大ピクセル数（約4000万画素）。
+// 小さなファイルでも巨大な画像に展開される「解凍爆弾」を防ぐ
+const DefaultMaxPixels = 40_000_000
+
+var (
+	ErrUnsupportedFormat = errors.New("imaging: 対応していない画像形式です")
+	ErrTooManyPixels     = errors.New("imaging: 画像が大きすぎます")
+)
+
+// ========== サムネイル ==========
+
+// Size はサムネイルが収まる枠（縦横比は保つ）
+type Size struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+// Result は生成したサムネイル
+type Result struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Decoded は読み込んだ元画像。1回だけデコードして複数のサイズを作る
+type Decoded struct {
+	img         *image.RGBA
+	format      string // "jpeg" / "png" / "gif"
+	orientation int
+}
+
+// Width / Height は向きを補正したあとの大きさ
+func (d *Decoded) Width() int {
+	if swapsAxes(d.orientation) {
+		return d.img.Bounds().Dy()
+	}
+	return d.img.Bounds().Dx()
+}
+
+func (d *Decoded) Height() int {
+	if swapsAxes(d.orientation) {
+		return d.img.Bounds().Dx()
+	}
+	return d.img.Bounds().Dy()
+}
+
+// Decode は JPEG / PNG / GIF（最初のフレーム）を読み込む。
+// maxPixels を超える画像はピクセルを展開する前にエラーにする
+func Decode(data []byte, maxPixels int) (*Decoded, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, ErrUnsupportedFormat
+		}
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Decoded{img: toRGBA(img), format: format, orientation: 1}
+	if format == "jpeg" {
+		d.orientation = Orientation(data)
+	}
+	return d, nil
+}
+
+// Thumbnail は size の枠に収まるように縮小し、向きを補正してエンコードする。
+// 元画像が枠より小さければ拡大はしない。
+// JPEG は JPEG のまま、PNG / GIF は透過を残すため PNG で出力する
+func (d *Decoded) Thumbnail(size Size) (*Result, error) {
+	w, h := Fit(d.Width(), d.Height(), size.Width, size.Height)
+
+	// 縮小してから回転する（回転するピクセル数が少なくて済む）
+	rw, rh := w, h
+	if swapsAxes(d.orientation) {
+		rw, rh = h, w
+	}
+	img := Orient(Resize(d.img, rw, rh), d.orientation)
+
+	var buf bytes.Buffer
+	result := &Result{Width: w, Height: h}
+	if d.format == "jpeg" {
+		result.ContentType = "image/jpeg"
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+	} else {
+		result.ContentType = "image/png"
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	}
+	result.Data = buf.Bytes()
+	return result, nil
+}
+
+// Fit は縦横比を保ったまま (maxW, maxH) に収まる大きさを返す（拡大はしない）
+func Fit(w, h, maxW, maxH int) (int, int) {
+	if w <= maxW && h <= maxH {
+		return w, h
+	}
+	// w/h と maxW/maxH を比べ、はみ出し方が大きい辺に合わせる
+	if w*maxH > h*maxW {
+		return maxW, max(1, h*maxW/w)
+	}
+	return max(1, w*maxH/h), maxH
+}
+
+// toRGBA は任意の image.Image を *image.RGBA に変換する。
+// draw.Draw には YCbCr（JPEG）やパレット（GIF）からの高速な変換がある
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// ========== 縮小（面積平均） ==========
+
+// contribution は出力1ピクセルに対する元ピクセルの寄与
+type contribution struct {
+	index  int
+	weight float64
+}
+
+// weights は長さ srcLen を dstLen に縮めるときの、出力位置ごとの寄与の一覧。
+// 出力 i は元の区間 [i*scale, (i+1)*scale) を覆い、重なった長さに比例して重み付けする
+func weights(srcLen, dstLen int) [][]contribution {
+	scale := float64(srcLen) / float64(dstLen)
+	result := make([][]contribution, dstLen)
+	for i := range result {
+		start := float64(i) * scale
+		end := start + scale
+		for j := int(start); j < srcLen && float64(j) < end; j++ {
+			overlap := min(end, float64(j+1)) - max(start, float64(j))
+			if overlap > 0 {
+				result[i] = append(result[i], contribution{index: j, weight: overlap / scale})
+			}
+		}
+	}
+	return result
+}
+
+// Resize は src を w×h に縮小する（拡大にも使えるが、最近傍と同じ結果になる）。
+// RGBA はアルファ乗算済みなので、そのまま平均すれば透過部分の色が滲まない
+func Resize(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if sw == w && sh == h {
+		return src
+	}
+
+	// 1回目: 横方向だけ縮める（w × sh、float で保持して丸め誤差を溜めない）
+	cols := weights(sw, w)
+	tmp := make([]float64, w*sh*4)
+	for y := 0; y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, contribs := range cols {
+			var r, g, b, a float64
+			for _, c := range contribs {
+				p := row[c.index*4:]
+				r += float64(p[0]) * c.weight
+				g += float64(p[1]) * c.weight
+				b += float64(p[2]) * c.weight
+				a += float64(p[3]) * c.weight
+			}
+			t := tmp[(y*w+x)*4:]
+			t[0], t[1], t[2], t[3] = r, g, b, a
+		}
+	}
+
+	// 2回目: 縦方向を縮める
+	rows := weights(sh, h)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, contribs := range rows {
+		for x := 0; x < w; x++ {
+			var r, g, b, a float64
+			for _, c := range contribs {
+				t := tmp[(c.index*w+x)*4:]
+				r += t[0] * c.weight
+				g += t[1] * c.weight
+				b += t[2] * c.weight
+				a += t[3] * c.weight
+			}
+			p := dst.Pix[y*dst.Stride+x*4:]
+			p[0], p[1], p[2], p[3] = clamp(r), clamp(g), clamp(b), clamp(a)
+		}
+	}
+	return dst
+}
+
+func clamp(v float64) uint8 {
+	v += 0.5
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// ========== EXIF の向き ==========
+
+// swapsAxes は縦横が入れ替わる向き（5〜8 は 90° 回転を含む）か
+func swapsAxes(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// Orient は EXIF の Orientation（1〜8）に従って画像を回転・反転する。
+//
+//	1: そのまま  2: 左右反転  3: 180°回転  4: 上下反転
+//	5: 転置      6: 時計回りに90°  7: 反転転置  8: 反時計回りに90°
+func Orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := sw, sh
+	if swapsAxes(orientation) {
+		dw, dh = sh, sw
+	}
+
+	// 出力の各ピクセル (dx, dy) が元画像のどこから来るかを求める
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		for dx := 0; dx < dw; dx++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = sw-1-dx, dy
+			case 3:
+				sx, sy = sw-1-dx, sh-1-dy
+			case 4:
+				sx, sy = dx, sh-1-dy
+			case 5:
+				sx, sy = dy, dx
+			case 6:
+				sx, sy = dy, sh-1-dx
+			case 7:
+				sx, sy = sw-1-dy, sh-1-dx
+			case 8:
+				sx, sy = sw-1-dy, dx
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], src.Pix[sy*src.Stride+sx*4:])
+		}
+	}
+	return dst
+}
+
+// Orientation は JPEG の EXIF（APP1 セグメント）から Orientation タグを読む。
+// 見つからない・壊れている場合は 1（そのまま）を返す
+func Orientation(jpegData []byte) int {
+	o, err := readOrientation(bytes.NewReader(jpegData))
+	if err != nil || o < 1 || o > 8 {
+		return 1
+	}
+	return o
+}
+
+func readOrientation(r io.Reader) (int, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return 0, errors.New("not a jpeg")
+	}
+
+	// マーカー（0xFF xx）と長さ（自分自身の2バイトを含む）が並ぶ。
+	// EXIF は画像データ（SOS）より前の APP1 にある
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return 0, err
+		}
+		if marker[0] != 0xFF {
+			return 0, errors.New("invalid marker")
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return 0, errors.New("invalid segment length")
+		}
+		switch marker[1] {
+		case 0xDA, 0xD9: // SOS / EOI
+			return 0, errors.New("exif not found")
+		case 0xE1: // APP1
+			segment := make([]byte, length)
+			if _, err := io.ReadFull(r, segment); err != nil {
+				return 0, err
+			}
+			if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				return orientationFromTIFF(segment[6:])
+			}
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// orientationFromTIFF は TIFF 形式の IFD0 から 0x0112（Orientation）を探す
+func orientationFromTIFF(tiff []byte) (int, error) {
+	if len(tiff) < 8 {
+		return 0, errors.New("short tiff header")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, fmt.Errorf("unknown byte order %q", tiff[:2])
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return 0, errors.New("invalid ifd offset")
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	// 各エントリは 12 バイト: タグ(2) 型(2) 個数(4) 値またはオフセット(4)
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:])), nil // SHORT は値の先頭2バイト
+		}
+	}
+	return 0, errors.New("orientation not found")
+}
@@ -0,0 +1,239 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func solid(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// exifJPEG は Orientation タグだけを持つ EXIF（APP1）を SOI の直後に差し込んだ JPEG を作る
+func exifJPEG(t *testing.T, img image.Image, order binary.ByteOrder, orientation uint16) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8) // IFD0 の位置
+	order.PutUint16(tiff[8:], 1) // エントリ数
+	entry := tiff[10:]
+	order.PutUint16(entry[0:], 0x0112) // Orientation
+	order.PutUint16(entry[2:], 3)      // SHORT
+	order.PutUint32(entry[4:], 1)
+	order.PutUint16(entry[8:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(payload)+2))
+	app1 = append(app1, payload...)
+
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name             string
+		w, h, maxW, maxH int
+		wantW, wantH     int
+	}{
+		{"already fits", 100, 50, 200, 200, 100, 50},
+		{"wide", 400, 200, 100, 100, 100, 50},
+		{"tall", 200, 400, 100, 100, 50, 100},
+		{"square into wide box", 300, 300, 200, 100, 100, 100},
+		{"very thin keeps one pixel", 10000, 1, 100, 100, 100, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h := Fit(tt.w, tt.h, tt.maxW, tt.maxH)
+			if w != tt.wantW || h != tt.wantH {
+				t.Errorf("Fit(%d, %d, %d, %d) = %d×%d, want %d×%d", tt.w, tt.h, tt.maxW, tt.maxH, w, h, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	// 左半分が黒、右半分が白の 4×2 を 1×1 にすると灰色（平均）になる
+	src := solid(4, 2, color.RGBA{255, 255, 255, 255})
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			src.SetRGBA(x, y, color.RGBA{0, 0, 0, 255})
+		}
+	}
+	got := Resize(src, 1, 1).RGBAAt(0, 0)
+	if want := (color.RGBA{128, 128, 128, 255}); got != want {
+		t.Errorf("Resize = %v, want %v", got, want)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 2×3 の画像の左上だけを赤にし、向きを補正したあと赤がどこに来るかを調べる
+	red := color.RGBA{255, 0, 0, 255}
+	src := solid(2, 3, color.RGBA{0, 0, 255, 255})
+	src.SetRGBA(0, 0, red)
+
+	tests := []struct {
+		orientation  int
+		wantW, wantH int
+		redX, redY   int
+	}{
+		{1, 2, 3, 0, 0},
+		{2, 2, 3, 1, 0},
+		{3, 2, 3, 1, 2},
+		{4, 2, 3, 0, 2},
+		{5, 3, 2, 0, 0},
+		{6, 3, 2, 2, 0},
+		{7, 3, 2, 2, 1},
+		{8, 3, 2, 0, 1},
+		{9, 2, 3, 0, 0}, // 範囲外はそのまま
+	}
+	for _, tt := range tests {
+		got := Orient(src, tt.orientation)
+		if b := got.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("orientation %d: size = %d×%d, want %d×%d", tt.orientation, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			continue
+		}
+		if c := got.RGBAAt(tt.redX, tt.redY); c != red {
+			t.Errorf("orientation %d: pixel (%d,%d) = %v, want red", tt.orientation, tt.redX, tt.redY, c)
+		}
+	}
+}
+
+func TestOrientation(t *testing.T) {
+	img := solid(8, 8, color.RGBA{10, 20, 30, 255})
+	var plain bytes.Buffer
+	if err := jpeg.Encode(&plain, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"little endian", exifJPEG(t, img, binary.LittleEndian, 6), 6},
+		{"big endian", exifJPEG(t, img, binary.BigEndian, 8), 8},
+		{"out of range", exifJPEG(t, img, binary.BigEndian, 42), 1},
+		{"no exif", plain.Bytes(), 1},
+		{"not a jpeg", encodePNG(t, img), 1},
+		{"truncated", exifJPEG(t, img, binary.LittleEndian, 6)[:12], 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Orientation(tt.data); got != tt.want {
+				t.Errorf("Orientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		maxPixels int
+		wantErr   error
+	}{
+		{"text", []byte("hello, world"), DefaultMaxPixels, ErrUnsupportedFormat},
+		{"over the pixel limit", encodePNG(t, solid(100, 100, color.RGBA{A: 255})), 9999, ErrTooManyPixels},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.data, tt.maxPixels); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	var gifData bytes.Buffer
+	if err := gif.Encode(&gifData, solid(60, 30, color.RGBA{0, 255, 0, 255}), nil); err != nil {
+		t.Fatal(err)
+	}
+	img := solid(400, 200, color.RGBA{200, 100, 50, 255})
+
+	tests := []struct {
+		name         string
+		data         []byte
+		size         Size
+		wantType     string
+		wantW, wantH int
+		wantDecodedW int
+		wantDecodedH int
+	}{
+		{
+			name: "png stays png", data: encodePNG(t, img), size: Size{Name: "small", Width: 100, Height: 100},
+			wantType: "image/png", wantW: 100, wantH: 50, wantDecodedW: 400, wantDecodedH: 200,
+		},
+		{
+			name: "jpeg rotated by exif", data: exifJPEG(t, img, binary.LittleEndian, 6), size: Size{Name: "small", Width: 100, Height: 100},
+			wantType: "image/jpeg", wantW: 50, wantH: 100, wantDecodedW: 200, wantDecodedH: 400,
+		},
+		{
+			name: "gif becomes png and is not enlarged", data: gifData.Bytes(), size: Size{Name: "large", Width: 800, Height: 800},
+			wantType: "image/png", wantW: 60, wantH: 30, wantDecodedW: 60, wantDecodedH: 30,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Decode(tt.data, DefaultMaxPixels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Width() != tt.wantDecodedW || d.Height() != tt.wantDecodedH {
+				t.Errorf("decoded size = %d×%d, want %d×%d", d.Width(), d.Height(), tt.wantDecodedW, tt.wantDecodedH)
+			}
+
+			result, err := d.Thumbnail(tt.size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ContentType != tt.wantType || result.Width != tt.wantW || result.Height != tt.wantH {
+				t.Errorf("thumbnail = %s %d×%d, want %s %d×%d", result.ContentType, result.Width, result.Height, tt.wantType, tt.wantW, tt.wantH)
+			}
+
+			// 出力をもう一度読み、実際の大きさと形式が Result と一致することを確かめる
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(result.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != tt.wantW || cfg.Height != tt.wantH || "image/"+format != tt.wantType {
+				t.Errorf("encoded = %s %d×%d, want %s %d×%d", format, cfg.Width, cfg.Height, tt.wantType, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+// Package jsonbody はリクエストボディの JSON を厳密にデコードし、
+// どこがどう間違っているか（フィールド・型・バイト位置）をクライアントに返せるエラーにする
+//
+// 【学習ポイント】
+// 1. Content-Type を確認し、フォームなど JSON 以外のボディを JSON として読まない
+// 2. http.MaxBytesReader で大きさを制限する（制限しないと巨大なボディでメモリを使い切られる）
+// 3. DisallowUnknownFields でスペルミスのフィールドを黙って無視しない
+// 4. 1つ目の値の後に続くデータ（{"a":1}{"b":2} や末尾のごみ）もエラーにする
+// 5. json.SyntaxError / json.UnmarshalTypeError の Offset で、何バイト目がおかしいかを返す
+package jsonbody
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxBytes は Options.MaxBytes を省略したときの上限（1MB）
+const DefaultMaxBytes = 1 << 20
+
+// Options はデコードの設定
+type Options struct {
+	MaxBytes           int64 // ボディの上限（0 なら DefaultMaxBytes）
+	AllowUnknownFields bool  // true なら知らないフィールドを無視する
+}
+
+// Error はクライアントに返すエラー。Details はフィールド名（または "body" / "content_type" / "offset"）ごとの説明
+type Error struct {
+	Status  int
+	Message string
+	Details map[string]string
+	Err     error // 元のエラー（ログ用）
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Decode は既定の設定で r のボディを dst にデコードする。失敗したら *Error を返す
+func Decode(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	return DecodeWith(w, r, dst, Options{})
+}
+
+// DecodeWith は opts に従って r のボディを dst にデコードする。
+// w は http.MaxBytesReader に渡す（上限を超えたら接続を閉じるよう net/http に伝えるため）
+func DecodeWith(w http.ResponseWriter, r *http.Request, dst interface{}, opts Options) error {
+	if err := checkContentType(r.Header.Get("Content-Type")); err != nil {
+		return err
+	}
+
+	limit := opts.MaxBytes
+	if limit <= 0 {
+		limit = DefaultMaxBytes
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	// 上限までは先に全部読む。途中で切れた JSON のエラーに、どこまで届いたかを出すため
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return translate(err, nil, 0, limit)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if !opts.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(dst); err != nil {
+		return translate(err, dec, len(data), limit)
+	}
+
+	// 1つの値だけを受け付ける。後ろに空白以外が続いていたらエラー
+	var extra json.RawMessage
+	switch err := dec.Decode(&extra); {
+	case errors.Is(err, io.EOF):
+		return nil
+	case err == nil:
+		return &Error{
+			Status:  http.StatusBadRequest,
+			Message: "Request body must contain a single JSON value",
+			Details: map[string]string{
+				"body":   "Unexpected data after the JSON value",
+				"offset": strconv.FormatInt(dec.InputOffset()-int64(len(extra)), 10),
+			},
+		}
+	default:
+		return translate(err, dec, len(data), limit)
+	}
+}
+
+// checkContentType は application/json か、+json で終わるメディアタイプ（application/merge-patch+json など）だけを許す
+func checkContentType(contentType string) error {
+	unsupported := func(detail string) error {
+		return &Error{
+			Status:  http.StatusUnsupportedMediaType,
+			Message: "Content-Type must be application/json",
+			Details: map[string]string{"content_type": detail},
+		}
+	}
+	if contentType == "" {
+		return unsupported("Missing Content-Type header")
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return unsupported("Malformed Content-Type header")
+	}
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return unsupported(fmt.Sprintf("Got %s", mediaType))
+	}
+	// JSON は UTF-8（RFC 8259）。それ以外の charset は読めない
+	if cs, ok := params["charset"]; ok && !strings.EqualFold(cs, "utf-8") {
+		return unsupported(fmt.Sprintf("Unsupported charset %s", cs))
+	}
+	return nil
+}
+
+// translate は encoding/json のエラーを、フィールドと位置の分かる *Error に変換する
+func translate(err error, dec *json.Decoder, bodyLen int, limit int64) error {
+	var (
+		syntaxErr   *json.SyntaxError
+		typeErr     *json.UnmarshalTypeError
+		maxBytesErr *http.MaxBytesError
+		invalidErr  *json.InvalidUnmarshalError
+		timeErr     *time.ParseError
+	)
+	badRequest := func(message string, details map[string]string) error {
+		return &Error{Status: http.StatusBadRequest, Message: message, Details: details, Err: err}
+	}
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return &Error{
+			Status:  http.StatusRequestEntityTooLarge,
+			Message: "Request body is too large",
+			Details: map[string]string{"body": fmt.Sprintf("Must not exceed %d bytes", limit)},
+			Err:     err,
+		}
+
+	case dec == nil:
+		// 上限以外の読み込みエラー（クライアントの切断など）
+		return badRequest("Failed to read request body", nil)
+
+	case errors.As(err, &syntaxErr):
+		return badRequest("Malformed JSON", map[string]string{
+			"body":   syntaxErr.Error(),
+			"offset": strconv.FormatInt(syntaxErr.Offset, 10),
+		})
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		// 途中で終わった JSON は SyntaxError ではなくこのエラーになる。位置はボディの末尾
+		return badRequest("Malformed JSON", map[string]string{
+			"body":   "Unexpected end of JSON input",
+			"offset": strconv.Itoa(bodyLen),
+		})
+
+	case errors.Is(err, io.EOF):
+		return badRequest("Request body must not be empty", map[string]string{
+			"body": "Expected a JSON value",
+		})
+
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "body" // トップレベルの型が違う（オブジェクトのはずが配列など）
+		}
+		return badRequest("Invalid field type", map[string]string{
+			field:    fmt.Sprintf("Must be %s (got %s)", describeType(typeErr.Type), typeErr.Value),
+			"offset": strconv.FormatInt(typeErr.Offset, 10),
+		})
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json は専用のエラー型を用意していないので、メッセージから名前を取り出す
+		name, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return badRequest("Unknown field", map[string]string{
+			name:     "Unknown field",
+			"offset": strconv.FormatInt(dec.InputOffset(), 10),
+		})
+
+	case errors.As(err, &timeErr):
+		// time.Time の UnmarshalJSON のエラーにはフィールド名が入らないので、位置で示す
+		return badRequest("Invalid field value", map[string]string{
+			"body":   fmt.Sprintf("%q is not an RFC 3339 timestamp", timeErr.Value),
+			"offset": strconv.FormatInt(dec.InputOffset(), 10),
+		})
+
+	case errors.As(err, &invalidErr):
+		// dst にポインター以外を渡した（プログラムの誤り）
+		panic(err)
+	}
+
+	// UnmarshalJSON を実装した型が返したエラー
+	return badRequest("Invalid field value", map[string]string{
+		"body":   err.Error(),
+		"offset": strconv.FormatInt(dec.InputOffset(), 10),
+	})
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// describeType は Go の型を JSON の言葉で説明する
+func describeType(t reflect.Type) string {
+	if t == nil {
+		return "a valid value"
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return "an RFC 3339 timestamp string"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return "a " + t.String()
+}
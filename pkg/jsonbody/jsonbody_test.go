@@ -0,0 +1,94 @@
+package jsonbody
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type payload struct {
+	Title   string     `json:"title"`
+	Count   int        `json:"count"`
+	Tags    []string   `json:"tags"`
+	Publish *time.Time `json:"publish_at"`
+	Author  struct {
+		Name string `json:"name"`
+	} `json:"author"`
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		opts        Options
+		wantStatus  int               // 0 なら成功
+		wantDetails map[string]string // 含まれているべき details（値が空ならキーがあればよい）
+	}{
+		{"ok", "application/json", `{"title":"a","count":1,"tags":["x"]}`, Options{}, 0, nil},
+		{"charset utf-8", "application/json; charset=UTF-8", `{"title":"a"}`, Options{}, 0, nil},
+		{"+json suffix", "application/merge-patch+json", `{"title":"a"}`, Options{}, 0, nil},
+		{"trailing whitespace", "application/json", "{\"title\":\"a\"}\n  ", Options{}, 0, nil},
+		{"unknown allowed", "application/json", `{"title":"a","extra":1}`, Options{AllowUnknownFields: true}, 0, nil},
+
+		{"missing content type", "", `{}`, Options{}, 415, map[string]string{"content_type": "Missing Content-Type header"}},
+		{"form", "application/x-www-form-urlencoded", `title=a`, Options{}, 415, map[string]string{"content_type": "Got application/x-www-form-urlencoded"}},
+		{"other charset", "application/json; charset=shift_jis", `{}`, Options{}, 415, map[string]string{"content_type": ""}},
+		{"too large", "application/json", `{"title":"` + strings.Repeat("a", 100) + `"}`, Options{MaxBytes: 50}, 413, map[string]string{"body": "Must not exceed 50 bytes"}},
+		{"empty", "application/json", ``, Options{}, 400, map[string]string{"body": "Expected a JSON value"}},
+		{"syntax error", "application/json", `{"title":"a",}`, Options{}, 400, map[string]string{"offset": "14"}},
+		{"truncated", "application/json", `{"title":"a"`, Options{}, 400, map[string]string{"body": "Unexpected end of JSON input", "offset": "12"}},
+		{"wrong type", "application/json", `{"count":"1"}`, Options{}, 400, map[string]string{"count": "Must be an integer (got string)"}},
+		{"nested wrong type", "application/json", `{"author":{"name":3}}`, Options{}, 400, map[string]string{"author.name": "Must be a string (got number)"}},
+		{"top level array", "application/json", `[1]`, Options{}, 400, map[string]string{"body": "Must be an object (got array)"}},
+		{"unknown field", "application/json", `{"titel":"a"}`, Options{}, 400, map[string]string{"titel": "Unknown field"}},
+		{"bad timestamp", "application/json", `{"publish_at":"tomorrow"}`, Options{}, 400, map[string]string{"body": `"tomorrow" is not an RFC 3339 timestamp`}},
+		{"two values", "application/json", `{"title":"a"}{"title":"b"}`, Options{}, 400, map[string]string{"offset": "13"}},
+		{"trailing garbage", "application/json", `{"title":"a"} x`, Options{}, 400, map[string]string{"offset": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			var dst payload
+			err := DecodeWith(httptest.NewRecorder(), r, &dst, tt.opts)
+
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var jerr *Error
+			if !errors.As(err, &jerr) {
+				t.Fatalf("err = %v, want *Error", err)
+			}
+			if jerr.Status != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%v)", jerr.Status, tt.wantStatus, jerr)
+			}
+			for k, want := range tt.wantDetails {
+				got, ok := jerr.Details[k]
+				if !ok || (want != "" && got != want) {
+					t.Errorf("details[%q] = %q, want %q (all: %v)", k, got, want, jerr.Details)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeFillsDestination(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"title":"こんにちは","publish_at":"2024-01-02T03:04:05Z","author":{"name":"太郎"}}`))
+	r.Header.Set("Content-Type", "application/json")
+	var dst payload
+	if err := Decode(httptest.NewRecorder(), r, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Title != "こんにちは" || dst.Author.Name != "太郎" || dst.Publish == nil || dst.Publish.Year() != 2024 {
+		t.Errorf("decoded = %+v", dst)
+	}
+}
@@ -0,0 +1,460 @@
+// Package jsonrpc は HTTP 上で動く JSON-RPC 2.0 サーバー
+//
+// 【学習ポイント】
+// 1. リクエストは {"jsonrpc":"2.0","method":"...","params":...,"id":...} の形
+// 2. id のないリクエストは「通知」で、レスポンスを返さない
+// 3. 配列で送るとバッチとして処理し、通知以外のレスポンスを配列で返す
+// 4. エラーは HTTP ステータスではなく、仕様で決まったエラーコードで表す
+// 5. メソッドを登録表（レジストリ）で管理し、rpc.discover で一覧を返す
+//
+// 仕様: https://www.jsonrpc.org/specification
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// ========== 定数 ==========
+
+// Version は jsonrpc メンバーに入れる固定の値
+const Version = "2.0"
+
+// 仕様で予約されたエラーコード。
+// -32000〜-32099 はサーバー側で自由に定義してよい
+const (
+	CodeParseError     = -32700 // JSON として読めない
+	CodeInvalidRequest = -32600 // リクエストの形が正しくない
+	CodeMethodNotFound = -32601 // メソッドが存在しない
+	CodeInvalidParams  = -32602 // 引数が正しくない
+	CodeInternalError  = -32603 // サーバー内部のエラー
+)
+
+const (
+	// DefaultMaxBatch は1回のバッチで受け付けるリクエスト数の上限
+	DefaultMaxBatch = 50
+	// DiscoverMethod はメソッド一覧を返す組み込みメソッド（OpenRPC の慣習）
+	DiscoverMethod = "rpc.discover"
+
+	maxBodySize = 1 << 20
+)
+
+// ========== エラー ==========
+
+// Error はレスポンスの error メンバー
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: %s (%d)", e.Message, e.Code)
+}
+
+// NewError はサーバー定義のエラーを作る
+func NewError(code int, message string, data interface{}) *Error {
+	return &Error{Code: code, Message: message, Data: data}
+}
+
+// InvalidParams は引数エラー。data にはフィールドごとのエラーなどを入れる
+func InvalidParams(message string, data interface{}) *Error {
+	return &Error{Code: CodeInvalidParams, Message: message, Data: data}
+}
+
+// ========== リクエスト / レスポンス ==========
+
+// Request は1件の呼び出し
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	// ID は文字列・数値・null のいずれか。メンバー自体がなければ通知
+	ID json.RawMessage `json:"id,omitempty"`
+}
+
+// IsNotification は id のない（レスポンス不要の）リクエストか
+func (r *Request) IsNotification() bool {
+	return len(r.ID) == 0
+}
+
+// Response は1件の結果。result と error はどちらか一方だけが入る
+type Response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+	ID      json.RawMessage  `json:"id"`
+}
+
+var nullID = json.RawMessage("null")
+
+func errorResponse(id json.RawMessage, err *Error) *Response {
+	if len(id) == 0 {
+		id = nullID
+	}
+	return &Response{JSONRPC: Version, Error: err, ID: id}
+}
+
+// Params は名前付きの引数（JSON オブジェクト）。
+// 配列（位置指定）で渡された場合も、登録された引数名を使ってオブジェクトに変換してから渡す
+type Params json.RawMessage
+
+// Bind は引数を構造体に読み込む。知らない引数や型の違いは InvalidParams になる
+func (p Params) Bind(v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return InvalidParams("Invalid params", err.Error())
+	}
+	return nil
+}
+
+// ========== メソッドの登録 ==========
+
+// HandlerFunc はメソッドの実装。
+// *Error を返すとそのままレスポンスになり、それ以外のエラーは Server.MapError で変換する
+type HandlerFunc func(r *http.Request, params Params) (interface{}, error)
+
+// Param は引数や戻り値の説明（rpc.discover の一覧と、必須チェックに使う）
+type Param struct {
+	Name        string
+	Type        string // JSON Schema の type（integer / string / boolean / object / array）
+	Description string
+	Required    bool
+}
+
+// Method はレジストリに登録するメソッド
+type Method struct {
+	Name        string
+	Description string
+	Params      []Param
+	Result      Param
+	Handler     HandlerFunc
+}
+
+// Server はメソッドのレジストリで、http.Handler として /rpc などに登録する
+type Server struct {
+	Title    string
+	Version  string
+	MaxBatch int
+	// MapError はハンドラーが返したアプリケーションのエラーを JSON-RPC のエラーに変換する。
+	// nil を返した場合（または MapError が nil の場合）は Internal error になる
+	MapError func(err error) *Error
+
+	mu      sync.RWMutex
+	methods map[string]*Method
+	names   []string // 登録順（rpc.discover の並び順）
+}
+
+// NewServer はサーバーを作る。title / version は rpc.discover の info に入る
+func NewServer(title, version string) *Server {
+	s := &Server{
+		Title:    title,
+		Version:  version,
+		MaxBatch: DefaultMaxBatch,
+		methods:  make(map[string]*Method),
+	}
+	s.methods[DiscoverMethod] = &Method{
+		Name:        DiscoverMethod,
+		Description: "このサーバーのメソッド一覧（OpenRPC 形式）",
+		Result:      Param{Name: "document", Type: "object"},
+		Handler: func(*http.Request, Params) (interface{}, error) {
+			return s.discover(), nil
+		},
+	}
+	return s
+}
+
+// Register はメソッドを登録する。名前の重複や rpc. で始まる名前はプログラムの誤りなので panic する
+func (s *Server) Register(m Method) {
+	if m.Name == "" || m.Handler == nil {
+		panic("jsonrpc: メソッド名とハンドラーは必須です")
+	}
+	if len(m.Name) >= 4 && m.Name[:4] == "rpc." {
+		panic("jsonrpc: rpc. で始まるメソッド名は予約されています: " + m.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.methods[m.Name]; exists {
+		panic("jsonrpc: メソッドが重複しています: " + m.Name)
+	}
+	s.methods[m.Name] = &m
+	s.names = append(s.names, m.Name)
+}
+
+// ========== HTTP ==========
+
+// ServeHTTP は POST で送られた1件またはバッチのリクエストを処理する。
+// JSON-RPC のエラーもレスポンスボディで返すため、ステータスは常に 200（通知だけなら 204）
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		writeJSON(w, errorResponse(nil, &Error{Code: CodeParseError, Message: "Parse error"}))
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if !json.Valid(body) {
+		writeJSON(w, errorResponse(nil, &Error{Code: CodeParseError, Message: "Parse error"}))
+		return
+	}
+
+	// 先頭が [ ならバッチ
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeJSON(w, errorResponse(nil, &Error{Code: CodeParseError, Message: "Parse error"}))
+			return
+		}
+		if len(batch) == 0 {
+			writeJSON(w, errorResponse(nil, &Error{Code: CodeInvalidRequest, Message: "Invalid Request", Data: "empty batch"}))
+			return
+		}
+		if s.MaxBatch > 0 && len(batch) > s.MaxBatch {
+			writeJSON(w, errorResponse(nil, &Error{
+				Code:    CodeInvalidRequest,
+				Message: "Invalid Request",
+				Data:    fmt.Sprintf("batch size exceeds %d", s.MaxBatch),
+			}))
+			return
+		}
+
+		// 仕様上は並行に処理してもよいが、書き込みの順序が分かりやすいよう順番に処理する
+		responses := make([]*Response, 0, len(batch))
+		for _, raw := range batch {
+			if resp := s.handle(r, raw); resp != nil {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, responses)
+		return
+	}
+
+	resp := s.handle(r, body)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// handle は1件のリクエストを処理する。通知の場合は nil を返す
+func (s *Server) handle(r *http.Request, raw json.RawMessage) *Response {
+	var req Request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return errorResponse(nil, &Error{Code: CodeInvalidRequest, Message: "Invalid Request"})
+	}
+	if req.JSONRPC != Version || req.Method == "" || !validID(req.ID) {
+		// id が読めない場合は null で返す
+		id := req.ID
+		if !validID(id) {
+			id = nil
+		}
+		return errorResponse(id, &Error{Code: CodeInvalidRequest, Message: "Invalid Request"})
+	}
+
+	result, rpcErr := s.call(r, &req)
+	if req.IsNotification() {
+		return nil
+	}
+	if rpcErr != nil {
+		return errorResponse(req.ID, rpcErr)
+	}
+	return &Response{JSONRPC: Version, Result: &result, ID: req.ID}
+}
+
+func (s *Server) call(r *http.Request, req *Request) (result json.RawMessage, rpcErr *Error) {
+	s.mu.RLock()
+	m, ok := s.methods[req.Method]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, &Error{Code: CodeMethodNotFound, Message: "Method not found", Data: req.Method}
+	}
+
+	params, rpcErr := m.normalizeParams(req.Params)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	// ハンドラーの panic でサーバー全体が落ちないようにする
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("jsonrpc: %s で panic: %v", req.Method, v)
+			result, rpcErr = nil, &Error{Code: CodeInternalError, Message: "Internal error"}
+		}
+	}()
+
+	value, err := m.Handler(r, params)
+	if err != nil {
+		return nil, s.toError(err)
+	}
+	if result, err = json.Marshal(value); err != nil {
+		log.Printf("jsonrpc: %s の結果を JSON にできません: %v", req.Method, err)
+		return nil, &Error{Code: CodeInternalError, Message: "Internal error"}
+	}
+	return result, nil
+}
+
+func (s *Server) toError(err error) *Error {
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	if s.MapError != nil {
+		if mapped := s.MapError(err); mapped != nil {
+			return mapped
+		}
+	}
+	log.Printf("jsonrpc: %v", err)
+	return &Error{Code: CodeInternalError, Message: "Internal error"}
+}
+
+// normalizeParams は params をオブジェクトにそろえ、必須の引数があるか確認する
+func (m *Method) normalizeParams(raw json.RawMessage) (Params, *Error) {
+	named := make(map[string]json.RawMessage)
+
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, nullID):
+		// 省略
+	case raw[0] == '{':
+		if err := json.Unmarshal(raw, &named); err != nil {
+			return nil, InvalidParams("Invalid params", err.Error())
+		}
+	case raw[0] == '[':
+		var positional []json.RawMessage
+		if err := json.Unmarshal(raw, &positional); err != nil {
+			return nil, InvalidParams("Invalid params", err.Error())
+		}
+		if len(positional) > len(m.Params) {
+			return nil, InvalidParams("Invalid params", fmt.Sprintf("%s takes at most %d params", m.Name, len(m.Params)))
+		}
+		for i, v := range positional {
+			named[m.Params[i].Name] = v
+		}
+	default:
+		// params は構造化された値（オブジェクトか配列）でなければならない
+		return nil, &Error{Code: CodeInvalidRequest, Message: "Invalid Request", Data: "params must be an object or an array"}
+	}
+
+	missing := make(map[string]string)
+	for _, p := range m.Params {
+		if v, ok := named[p.Name]; p.Required && (!ok || bytes.Equal(v, nullID)) {
+			missing[p.Name] = "必須です"
+		}
+	}
+	if len(missing) > 0 {
+		return nil, InvalidParams("Invalid params", missing)
+	}
+
+	data, err := json.Marshal(named)
+	if err != nil {
+		return nil, InvalidParams("Invalid params", err.Error())
+	}
+	return Params(data), nil
+}
+
+// validID は id が文字列・数値・null のいずれか（または省略）か
+func validID(id json.RawMessage) bool {
+	if len(id) == 0 {
+		return true
+	}
+	switch c := id[0]; {
+	case c == '"', c == 'n', c == '-', c >= '0' && c <= '9':
+		return true
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ========== rpc.discover ==========
+
+type discoverDocument struct {
+	OpenRPC string        `json:"openrpc"`
+	Info    discoverInfo  `json:"info"`
+	Methods []methodEntry `json:"methods"`
+}
+
+type discoverInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type methodEntry struct {
+	Name           string       `json:"name"`
+	Description    string       `json:"description,omitempty"`
+	ParamStructure string       `json:"paramStructure"`
+	Params         []paramEntry `json:"params"`
+	Result         paramEntry   `json:"result"`
+}
+
+type paramEntry struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Required    bool              `json:"required,omitempty"`
+	Schema      map[string]string `json:"schema"`
+}
+
+func newParamEntry(p Param) paramEntry {
+	schema := map[string]string{}
+	if p.Type != "" {
+		schema["type"] = p.Type
+	}
+	return paramEntry{Name: p.Name, Description: p.Description, Required: p.Required, Schema: schema}
+}
+
+// discover は登録されたメソッドから一覧を組み立てる（登録表がそのままドキュメントになる）
+func (s *Server) discover() discoverDocument {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doc := discoverDocument{
+		OpenRPC: "1.2.6",
+		Info:    discoverInfo{Title: s.Title, Version: s.Version},
+		Methods: make([]methodEntry, 0, len(s.names)),
+	}
+	for _, name := range s.names {
+		m := s.methods[name]
+		entry := methodEntry{
+			Name:           m.Name,
+			Description:    m.Description,
+			ParamStructure: "either",
+			Params:         make([]paramEntry, 0, len(m.Params)),
+			Result:         newParamEntry(m.Result),
+		}
+		if entry.Result.Name == "" {
+			entry.Result.Name = "result"
+		}
+		for _, p := range m.Params {
+			entry.Params = append(entry.Params, newParamEntry(p))
+		}
+		doc.Methods = append(doc.Methods, entry)
+	}
+	return doc
+}
@@ -0,0 +1,163 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var errNotFound = errors.New("not found")
+
+func newTestServer() *Server {
+	s := NewServer("test", "1.0.0")
+	s.MaxBatch = 3
+	s.MapError = func(err error) *Error {
+		if errors.Is(err, errNotFound) {
+			return NewError(-32001, "Not found", nil)
+		}
+		return nil
+	}
+	s.Register(Method{
+		Name:   "add",
+		Params: []Param{{Name: "a", Type: "integer", Required: true}, {Name: "b", Type: "integer", Required: true}},
+		Result: Param{Name: "sum", Type: "integer"},
+		Handler: func(r *http.Request, params Params) (interface{}, error) {
+			var p struct{ A, B int }
+			if err := params.Bind(&p); err != nil {
+				return nil, err
+			}
+			return p.A + p.B, nil
+		},
+	})
+	s.Register(Method{Name: "missing", Handler: func(*http.Request, Params) (interface{}, error) { return nil, errNotFound }})
+	s.Register(Method{Name: "unmapped", Handler: func(*http.Request, Params) (interface{}, error) { return nil, errors.New("db down") }})
+	s.Register(Method{Name: "boom", Handler: func(*http.Request, Params) (interface{}, error) { panic("boom") }})
+	return s
+}
+
+// compact は比較のために JSON の空白を取り除く
+func compact(t *testing.T, s string) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(s)); err != nil {
+		t.Fatalf("invalid JSON %q: %v", s, err)
+	}
+	return buf.String()
+}
+
+func TestServeHTTP(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       string // 空ならボディなし
+	}{
+		{"named params", `{"jsonrpc":"2.0","method":"add","params":{"a":1,"b":2},"id":1}`, 200,
+			`{"jsonrpc":"2.0","result":3,"id":1}`},
+		{"positional params", `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":"x"}`, 200,
+			`{"jsonrpc":"2.0","result":3,"id":"x"}`},
+		{"notification", `{"jsonrpc":"2.0","method":"add","params":[1,2]}`, 204, ``},
+		{"parse error", `{"jsonrpc":`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{"wrong version", `{"jsonrpc":"1.0","method":"add","id":1}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":1}`},
+		{"object id", `{"jsonrpc":"2.0","method":"add","id":{}}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{"unknown method", `{"jsonrpc":"2.0","method":"nope","id":1}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found","data":"nope"},"id":1}`},
+		{"missing param", `{"jsonrpc":"2.0","method":"add","params":{"a":1},"id":1}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params","data":{"b":"必須です"}},"id":1}`},
+		{"too many positional", `{"jsonrpc":"2.0","method":"add","params":[1,2,3],"id":1}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params","data":"add takes at most 2 params"},"id":1}`},
+		{"scalar params", `{"jsonrpc":"2.0","method":"add","params":1,"id":1}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request","data":"params must be an object or an array"},"id":1}`},
+		{"mapped error", `{"jsonrpc":"2.0","method":"missing","id":1}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32001,"message":"Not found"},"id":1}`},
+		{"unmapped error", `{"jsonrpc":"2.0","method":"unmapped","id":1}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error"},"id":1}`},
+		{"panic", `{"jsonrpc":"2.0","method":"boom","id":1}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error"},"id":1}`},
+		{"batch", `[{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1},{"jsonrpc":"2.0","method":"add","params":[1,1]},1]`, 200,
+			`[{"jsonrpc":"2.0","result":3,"id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}]`},
+		{"batch of notifications", `[{"jsonrpc":"2.0","method":"add","params":[1,2]}]`, 204, ``},
+		{"empty batch", `[]`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request","data":"empty batch"},"id":null}`},
+		{"batch too large", `[1,2,3,4]`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request","data":"batch size exceeds 3"},"id":null}`},
+	}
+	s := newTestServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.want == "" {
+				if rec.Body.Len() != 0 {
+					t.Errorf("body = %q, want empty", rec.Body)
+				}
+				return
+			}
+			if got := compact(t, rec.Body.String()); got != compact(t, tt.want) {
+				t.Errorf("body\n got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeHTTPRejectsGet(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestServer().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rpc", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("status = %d, Allow = %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+// rpc.discover は登録順にメソッドを並べ、引数の説明を返す
+func TestDiscover(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestServer().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(`{"jsonrpc":"2.0","method":"rpc.discover","id":1}`)))
+
+	var resp struct {
+		Result discoverDocument `json:"result"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	doc := resp.Result
+	if doc.Info.Title != "test" || len(doc.Methods) != 4 || doc.Methods[0].Name != "add" {
+		t.Fatalf("document = %+v", doc)
+	}
+	add := doc.Methods[0]
+	if len(add.Params) != 2 || !add.Params[0].Required || add.Params[0].Schema["type"] != "integer" || add.Result.Name != "sum" {
+		t.Errorf("add = %+v", add)
+	}
+	if doc.Methods[1].Result.Name != "result" {
+		t.Errorf("default result name = %q", doc.Methods[1].Result.Name)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	tests := []struct {
+		name   string
+		method Method
+	}{
+		{"no handler", Method{Name: "x"}},
+		{"reserved name", Method{Name: "rpc.mine", Handler: func(*http.Request, Params) (interface{}, error) { return nil, nil }}},
+		{"duplicate", Method{Name: "add", Handler: func(*http.Request, Params) (interface{}, error) { return nil, nil }}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Register should panic")
+				}
+			}()
+			newTestServer().Register(tt.method)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Package mail は確認メールや招待メールを送る
+//
+// 【学習ポイント】
+// 1. Mailer インターフェースで送信方法を差し替える（開発中は LogMailer、本番は SMTPMailer）
+// 2. net/smtp の SendMail は EHLO → (STARTTLS) → AUTH → MAIL FROM → RCPT TO → DATA の順にやり取りする
+// 3. PlainAuth は TLS なしではパスワードを送らない（localhost への接続だけは例外）
+// 4. ヘッダーは ASCII しか書けないので、日本語の件名は MIME の Q エンコーディング（=?utf-8?q?...?=）にする
+// 5. 改行は CRLF。ヘッダーに改行が入るとヘッダーを追加されてしまうので、宛先と件名に CR / LF を許さない
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidHeader は宛先・件名に改行が含まれている
+var ErrInvalidHeader = errors.New("mail: header must not contain CR or LF")
+
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer はメール送信の抽象。開発中は LogMailer、本番は SMTPMailer を使う
+type Mailer interface {
+	Send(mail Mail) error
+}
+
+// LogMailer はメールを送信せずにファイル（空ならログ）へ書き出す
+type LogMailer struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewLogMailer(path string) *LogMailer {
+	return &LogMailer{path: path}
+}
+
+func (m *LogMailer) Send(mail Mail) error {
+	text := fmt.Sprintf("To: %s\nSubject: %s\nDate: %s\n\n%s\n", mail.To, mail.Subject, time.Now().Format(time.RFC1123Z), mail.Body)
+
+	if m.path == "" {
+		slog.Info("メール送信（ログ出力）", "to", mail.To, "subject", mail.Subject, "body", mail.Body)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(text + "----\n")
+	return err
+}
+
+// SMTPMailer は net/smtp でメールを送信する
+type SMTPMailer struct {
+	addr     string // host:port
+	from     string
+	username string // 空なら認証しない（ローカルのテスト用SMTPサーバー向け）
+	password string
+}
+
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	return &SMTPMailer{addr: addr, from: from, username: username, password: password}
+}
+
+func (m *SMTPMailer) Send(mail Mail) error {
+	msg, err := m.message(mail, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		host, _, err := net.SplitHostPort(m.addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.username, m.password, host)
+	}
+	return smtp.SendMail(m.addr, auth, m.from, []string{mail.To}, msg)
+}
+
+// message は送信する本文（ヘッダーを含む）を組み立てる
+func (m *SMTPMailer) message(mail Mail, now time.Time) ([]byte, error) {
+	if strings.ContainsAny(mail.To+mail.Subject, "\r\n") {
+		return nil, ErrInvalidHeader
+	}
+
+	// 件名は日本語を含むので MIME エンコードする
+	msg := "From: " + m.from + "\r\n" +
+		"To: " + mail.To + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", mail.Subject) + "\r\n" +
+		"Date: " + now.Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(mail.Body, "\n", "\r\n") + "\r\n"
+	return []byte(msg), nil
+}
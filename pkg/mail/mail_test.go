@@ -0,0 +1,147 @@
+package mail
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+)
+
+// session は偽の SMTP サーバーが受け取った内容
+type session struct {
+	auth string // AUTH PLAIN の資格情報（デコード済み）
+	from string
+	rcpt []string
+	data string
+}
+
+// fakeSMTP は1回分の接続だけを受け付ける最小限の SMTP サーバー。
+// net/smtp の SendMail が使うコマンドだけに応答する
+func fakeSMTP(t *testing.T) (addr string, result <-chan session) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan session, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var s session
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 fake ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250-fake")
+				reply("250 AUTH PLAIN")
+			case strings.HasPrefix(cmd, "AUTH PLAIN "):
+				raw, _ := base64.StdEncoding.DecodeString(line[len("AUTH PLAIN "):])
+				s.auth = string(raw)
+				reply("235 ok")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				s.from = line[len("MAIL FROM:"):]
+				reply("250 ok")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				s.rcpt = append(s.rcpt, line[len("RCPT TO:"):])
+				reply("250 ok")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				s.data = data.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				ch <- s
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return ln.Addr().String(), ch
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantAuth string
+	}{
+		{"without auth", "", "", ""},
+		{"with plain auth", "user", "pass", "\x00user\x00pass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, result := fakeSMTP(t)
+			m := NewSMTPMailer(addr, "noreply@example.com", tt.username, tt.password)
+
+			err := m.Send(Mail{
+				To:      "taro@example.com",
+				Subject: "メールアドレスの確認",
+				Body:    "こんにちは\nhttps://blog.example.com/api/auth/verify?token=abc",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := <-result
+
+			if s.auth != tt.wantAuth {
+				t.Errorf("auth = %q, want %q", s.auth, tt.wantAuth)
+			}
+			if s.from != "<noreply@example.com>" || len(s.rcpt) != 1 || s.rcpt[0] != "<taro@example.com>" {
+				t.Errorf("envelope from=%s rcpt=%v", s.from, s.rcpt)
+			}
+			for _, want := range []string{
+				"From: noreply@example.com\r\n",
+				"To: taro@example.com\r\n",
+				"Subject: =?utf-8?q?",
+				"Content-Type: text/plain; charset=UTF-8\r\n",
+				"\r\n\r\nこんにちは\r\nhttps://blog.example.com/api/auth/verify?token=abc\r\n",
+			} {
+				if !strings.Contains(s.data, want) {
+					t.Errorf("message does not contain %q:\n%s", want, s.data)
+				}
+			}
+		})
+	}
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	m := NewSMTPMailer("127.0.0.1:1", "noreply@example.com", "", "")
+	tests := []Mail{
+		{To: "taro@example.com", Subject: "hi\r\nBcc: evil@example.com"},
+		{To: "taro@example.com\nBcc: evil@example.com", Subject: "hi"},
+	}
+	for _, mail := range tests {
+		if err := m.Send(mail); err != ErrInvalidHeader {
+			t.Errorf("Send(%q) = %v, want ErrInvalidHeader", mail.To+"|"+mail.Subject, err)
+		}
+	}
+}
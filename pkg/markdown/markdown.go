@@ -0,0 +1,825 @@
+// Package markdown は Markdown を安全な HTML に変換する小さなレンダラー
+//
+// 対応している記法: 見出し（#）、段落、強調（* _ ** __ ~~）、インラインコード、
+// コードブロック（``` / ~~~）、リンク・画像、箇条書き・番号付きリスト（入れ子可）、
+// 引用（>）、水平線、表（GitHub 形式）
+//
+// 【学習ポイント】
+// 1. 「ブロック」（段落・リスト・表など行単位の構造）と「インライン」（強調・リンクなど行の中の構造）の2段階で解析する
+// 2. 入力に含まれる HTML はエスケープする。出力するタグと属性はこのパッケージが生成するものだけなので、<script> や onclick="..." は入り込まない
+// 3. href / src は属性値のエスケープだけでは不十分（javascript: など）。スキームを許可リストで確認する
+// 4. 利用者が書いた文章を処理するので、どんな入力でも長さに比例した時間で終わるようにする（区切り文字スタック）
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Render は Markdown を HTML に変換する
+func Render(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+	src = strings.ReplaceAll(src, "\t", "    ")
+	src = strings.ReplaceAll(src, "\x00", "\uFFFD")
+
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(src, "\n"), false)
+	return b.String()
+}
+
+// ========== ブロック ==========
+
+var (
+	headingPattern   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	rulePattern      = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	fencePattern     = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`]*)$")
+	listItemPattern  = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])(?:[ \t]+|$)`)
+	tableDelimiter   = regexp.MustCompile(`^ *\|? *:?-+:? *(?:\| *:?-+:? *)*\|? *$`)
+	languagePattern  = regexp.MustCompile(`^[A-Za-z0-9_+#.-]+$`)
+	blockquoteMarker = regexp.MustCompile(`^ {0,3}> ?`)
+)
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// renderBlocks は行の並びをブロックに分けて出力する。
+// tight はリスト項目の中で、段落を <p> で囲まない（空行のないリスト）
+func renderBlocks(b *strings.Builder, lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case isBlank(line):
+			i++
+
+		case fencePattern.MatchString(line):
+			i = renderFence(b, lines, i)
+
+		case headingPattern.MatchString(line):
+			m := headingPattern.FindStringSubmatch(line)
+			level := len(m[1])
+			fmt.Fprintf(b, "<h%d>%s</h%d>\n", level, renderInline(strings.TrimSpace(m[2])), level)
+			i++
+
+		case rulePattern.MatchString(line):
+			b.WriteString("<hr>\n")
+			i++
+
+		case blockquoteMarker.MatchString(line):
+			var quoted []string
+			for i < len(lines) && blockquoteMarker.MatchString(lines[i]) {
+				quoted = append(quoted, blockquoteMarker.ReplaceAllString(lines[i], ""))
+				i++
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted, false)
+			b.WriteString("</blockquote>\n")
+
+		case isTableStart(lines, i):
+			i = renderTable(b, lines, i)
+
+		case listItemPattern.MatchString(line):
+			i = renderList(b, lines, i)
+
+		default:
+			i = renderParagraph(b, lines, i, tight)
+		}
+	}
+}
+
+// startsBlock は段落を終わらせる行（別のブロックの始まり）か
+func startsBlock(lines []string, i int) bool {
+	line := lines[i]
+	return isBlank(line) || fencePattern.MatchString(line) || headingPattern.MatchString(line) ||
+		rulePattern.MatchString(line) || blockquoteMarker.MatchString(line) ||
+		listItemPattern.MatchString(line) || isTableStart(lines, i)
+}
+
+func renderParagraph(b *strings.Builder, lines []string, i int, tight bool) int {
+	var para []string
+	for i < len(lines) && (len(para) == 0 || !startsBlock(lines, i)) {
+		para = append(para, lines[i])
+		i++
+	}
+
+	// 行末の2つ以上の空白、またはバックスラッシュは改行（<br>）
+	var parts []string
+	for j, line := range para {
+		text := strings.TrimLeft(line, " ")
+		hardBreak := false
+		if j < len(para)-1 {
+			if strings.HasSuffix(text, "  ") {
+				hardBreak = true
+			} else if strings.HasSuffix(text, "\\") {
+				text = strings.TrimSuffix(text, "\\")
+				hardBreak = true
+			}
+		}
+		text = renderInline(strings.TrimRight(text, " "))
+		if hardBreak {
+			text += "<br>"
+		}
+		parts = append(parts, text)
+	}
+
+	content := strings.Join(parts, "\n")
+	if tight {
+		b.WriteString(content + "\n")
+	} else {
+		b.WriteString("<p>" + content + "</p>\n")
+	}
+	return i
+}
+
+// renderFence は ``` で囲まれたコードブロックを出力する。中身は一切解釈しない
+func renderFence(b *strings.Builder, lines []string, i int) int {
+	m := fencePattern.FindStringSubmatch(lines[i])
+	indent, fence, info := len(m[1]), m[2], strings.TrimSpace(m[3])
+	i++
+
+	var code []string
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			i++
+			break
+		}
+		// 開始のフェンスと同じだけの字下げを取り除く
+		line := lines[i]
+		for n := 0; n < indent && strings.HasPrefix(line, " "); n++ {
+			line = line[1:]
+		}
+		code = append(code, line)
+	}
+
+	// 情報文字列の最初の単語が言語名（```go など）
+	if fields := strings.Fields(info); len(fields) > 0 && languagePattern.MatchString(fields[0]) {
+		fmt.Fprintf(b, `<pre><code class="language-%s">`, html.EscapeString(fields[0]))
+	} else {
+		b.WriteString("<pre><code>")
+	}
+	for _, line := range code {
+		b.WriteString(html.EscapeString(line) + "\n")
+	}
+	b.WriteString("</code></pre>\n")
+	return i
+}
+
+// ========== リスト ==========
+
+type listMarker struct {
+	ordered bool
+	char    byte // '-' '*' '+'（箇条書き）または '.' ')'（番号付き）
+	start   int
+	indent  int // 項目の本文が始まる桁（続きの行はこれ以上字下げする）
+}
+
+func parseListMarker(line string) (listMarker, bool) {
+	m := listItemPattern.FindStringSubmatch(line)
+	if m == nil {
+		return listMarker{}, false
+	}
+	marker := m[2]
+	lm := listMarker{indent: len(m[0])}
+	if isBlank(line[len(m[0]):]) {
+		lm.indent = len(m[1]) + len(marker) + 1
+	}
+	if marker[0] >= '0' && marker[0] <= '9' {
+		lm.ordered = true
+		lm.char = marker[len(marker)-1]
+		lm.start, _ = strconv.Atoi(marker[:len(marker)-1])
+	} else {
+		lm.char = marker[0]
+	}
+	return lm, true
+}
+
+// renderList は同じ種類のマーカーが続く間を1つのリストとして出力する
+func renderList(b *strings.Builder, lines []string, i int) int {
+	first, _ := parseListMarker(lines[i])
+	var items [][]string
+	loose := false
+
+	for i < len(lines) {
+		marker, ok := parseListMarker(lines[i])
+		if !ok || marker.ordered != first.ordered || marker.char != first.char {
+			break
+		}
+		// 水平線（- - -）はリストではない
+		if rulePattern.MatchString(lines[i]) {
+			break
+		}
+
+		item := []string{lines[i][min(marker.indent, len(lines[i])):]}
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			if isBlank(line) {
+				// 空行の次が字下げされた続きなら、同じ項目の中の空行
+				if i+1 < len(lines) && indentOf(lines[i+1]) >= marker.indent {
+					item = append(item, "")
+					loose = true
+					i++
+					continue
+				}
+				break
+			}
+			if indentOf(line) >= marker.indent {
+				item = append(item, line[marker.indent:])
+				i++
+				continue
+			}
+			// 字下げのない行は、別のブロックが始まらない限り段落の続き
+			if startsBlock(lines, i) {
+				break
+			}
+			item = append(item, line)
+			i++
+		}
+		items = append(items, item)
+
+		// 項目間の空行はリスト全体を「ゆるい」リストにする（段落を <p> で囲む）
+		if i+1 < len(lines) && isBlank(lines[i]) {
+			if next, ok := parseListMarker(lines[i+1]); ok && next.ordered == first.ordered && next.char == first.char {
+				loose = true
+				i++
+			}
+		}
+	}
+
+	tag := "ul"
+	if first.ordered {
+		tag = "ol"
+	}
+	if first.ordered && first.start != 1 {
+		fmt.Fprintf(b, "<ol start=\"%d\">\n", first.start)
+	} else {
+		b.WriteString("<" + tag + ">\n")
+	}
+	for _, item := range items {
+		var li strings.Builder
+		renderBlocks(&li, item, !loose)
+		b.WriteString("<li>" + strings.TrimSuffix(li.String(), "\n") + "</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// ========== 表 ==========
+
+func isTableStart(lines []string, i int) bool {
+	if i+1 >= len(lines) || !strings.Contains(lines[i], "|") || !tableDelimiter.MatchString(lines[i+1]) {
+		return false
+	}
+	// 見出しの列数と区切り行の列数が一致するときだけ表とみなす
+	return len(splitRow(lines[i])) == len(splitRow(lines[i+1]))
+}
+
+// splitRow は | で列に分ける（両端の | は省略可、\| は列の区切りにしない）
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	for j := 0; j < len(line); j++ {
+		switch {
+		case line[j] == '\\' && j+1 < len(line) && line[j+1] == '|':
+			cell.WriteByte('|')
+			j++
+		case line[j] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[j])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+func renderTable(b *strings.Builder, lines []string, i int) int {
+	header := splitRow(lines[i])
+	aligns := make([]string, len(header))
+	for j, d := range splitRow(lines[i+1]) {
+		left, right := strings.HasPrefix(d, ":"), strings.HasSuffix(d, ":")
+		switch {
+		case left && right:
+			aligns[j] = "center"
+		case right:
+			aligns[j] = "right"
+		case left:
+			aligns[j] = "left"
+		}
+	}
+	i += 2
+
+	// 揃え方は属性の値として固定の文字列だけを出力する
+	cell := func(w *strings.Builder, tag string, j int, text string) {
+		if aligns[j] != "" {
+			fmt.Fprintf(w, `<%s align="%s">`, tag, aligns[j])
+		} else {
+			w.WriteString("<" + tag + ">")
+		}
+		w.WriteString(renderInline(text) + "</" + tag + ">")
+	}
+
+	b.WriteString("<table>\n<thead>\n<tr>")
+	for j, h := range header {
+		cell(b, "th", j, h)
+	}
+	b.WriteString("</tr>\n</thead>\n")
+
+	var body strings.Builder
+	for ; i < len(lines) && !isBlank(lines[i]) && strings.Contains(lines[i], "|"); i++ {
+		row := splitRow(lines[i])
+		body.WriteString("<tr>")
+		// 列数は見出しに合わせる（足りなければ空のセル、多ければ捨てる）
+		for j := range header {
+			text := ""
+			if j < len(row) {
+				text = row[j]
+			}
+			cell(&body, "td", j, text)
+		}
+		body.WriteString("</tr>\n")
+	}
+	if body.Len() > 0 {
+		b.WriteString("<tbody>\n" + body.String() + "</tbody>\n")
+	}
+	b.WriteString("</table>\n")
+	return i
+}
+
+// ========== インライン ==========
+
+// インラインは CommonMark と同じく、1回の走査でテキスト・区切り文字（* _ ~~）・角括弧を並べ、
+// ] を見つけたときにリンクを、最後に強調を組み立てる。
+// 区切り文字ごとに残りの文字列を探し直すと、閉じられない * が並んだ入力で時間が文字数の2乗になる。
+// 各区切り文字は一度スタックに積まれて一度取り除かれるだけなので、全体で文字数に比例した時間で済む
+
+var autolinkPattern = regexp.MustCompile(`^<((?:https?://|mailto:)[^\s<>]+)>`)
+
+// inlineNode は出力の断片。区切り文字の断片は、強調として使われなかった文字数（count）と、
+// 前後に付いたタグ（before は閉じタグ、after は開きタグ）を持つ
+type inlineNode struct {
+	text   string
+	delim  byte
+	count  int
+	before string
+	after  string
+}
+
+func (n *inlineNode) String() string {
+	if n.delim == 0 {
+		return n.text
+	}
+	return n.before + strings.Repeat(string(n.delim), n.count) + n.after
+}
+
+// delimiter は強調の候補。スタックは双方向リストで持ち、途中の要素を定数時間で取り除く
+type delimiter struct {
+	node       int // inlineParser.nodes の添字
+	char       byte
+	canOpen    bool
+	canClose   bool
+	prev, next *delimiter
+}
+
+// bracket は [ または ![ の開き
+type bracket struct {
+	node   int
+	image  bool
+	pos    int        // 元の文字列での [ の位置（画像の alt に使う）
+	bottom *delimiter // [ より前にあった最後の区切り文字
+}
+
+type inlineParser struct {
+	text     string
+	nodes    []inlineNode
+	last     *delimiter // 区切り文字スタックの一番上
+	brackets []bracket
+	// リンクの中にリンクは作らないので、リンクができたら、それより前の [ は閉じてもリンクにしない。
+	// 1つずつ印を付けると [ が多い入力で2乗になるので、「ここより下は無効」という位置だけを持つ
+	linkFloor int
+
+	parens      []int         // ( の位置 → 対応する ) の位置（なければ -1）
+	ticks       map[int][]int // バッククォートの並びの長さ → 位置（昇順）
+	tickCursors map[int]int
+}
+
+// renderInline は1つのブロックの中の文字列を変換する。
+// 記法に当てはまらない文字はすべて HTML エスケープして出力する
+func renderInline(text string) string {
+	p := &inlineParser{text: text, tickCursors: make(map[int]int)}
+	p.scanPairs()
+	p.parse()
+	p.processEmphasis(nil)
+
+	var b strings.Builder
+	for i := range p.nodes {
+		b.WriteString(p.nodes[i].String())
+	}
+	return b.String()
+}
+
+// scanPairs は ( と ) の対応と、バッククォートの並びの位置を先に一度だけ調べておく。
+// リンクやコードの閉じ側をその都度探すと、閉じられない [a]( や ` が並んだ入力で2乗の時間がかかる
+func (p *inlineParser) scanPairs() {
+	text := p.text
+	p.parens = make([]int, len(text))
+	p.ticks = make(map[int][]int)
+	var open []int
+	for i := 0; i < len(text); i++ {
+		p.parens[i] = -1
+		switch text[i] {
+		case '\\':
+			if i+1 < len(text) {
+				p.parens[i+1] = -1
+			}
+			i++
+		case '(':
+			open = append(open, i)
+		case ')':
+			if len(open) > 0 {
+				p.parens[open[len(open)-1]] = i
+				open = open[:len(open)-1]
+			}
+		case '`':
+			run := tickRun(text, i)
+			p.ticks[run] = append(p.ticks[run], i)
+			for j := i + 1; j < i+run; j++ {
+				p.parens[j] = -1
+			}
+			i += run - 1
+		}
+	}
+}
+
+func tickRun(text string, i int) int {
+	j := i
+	for j < len(text) && text[j] == '`' {
+		j++
+	}
+	return j - i
+}
+
+// closingTicks は i から始まる長さ run のバッククォートを閉じる、同じ長さの並びの位置を返す。
+// 開きは左から順に現れるので、長さごとのカーソルを前に進めるだけでよい
+func (p *inlineParser) closingTicks(i, run int) int {
+	positions := p.ticks[run]
+	c := p.tickCursors[run]
+	for c < len(positions) && positions[c] <= i {
+		c++
+	}
+	p.tickCursors[run] = c
+	if c < len(positions) {
+		return positions[c]
+	}
+	return -1
+}
+
+func (p *inlineParser) addText(s string) {
+	p.nodes = append(p.nodes, inlineNode{text: s})
+}
+
+func (p *inlineParser) parse() {
+	text := p.text
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		// \* のようにバックスラッシュの後の記号はそのまま
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_{}[]()#+-.!|~<>\"'", text[i+1]) >= 0:
+			p.addText(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+
+		case c == '`':
+			run := tickRun(text, i)
+			if end := p.closingTicks(i, run); end >= 0 {
+				p.addText("<code>" + html.EscapeString(codeContent(text[i+run:end])) + "</code>")
+				i = end + run
+				continue
+			}
+			p.addText(text[i : i+run])
+			i += run
+
+		case c == '!' && i+1 < len(text) && text[i+1] == '[':
+			p.pushBracket(i, true)
+			i += 2
+
+		case c == '[':
+			p.pushBracket(i, false)
+			i++
+
+		case c == ']':
+			i = p.closeBracket(i)
+
+		case c == '<':
+			if m := autolinkPattern.FindStringSubmatch(text[i:]); m != nil {
+				if href, ok := safeURL(m[1], true); ok {
+					p.addText(fmt.Sprintf(`<a href="%s" rel="nofollow noopener">%s</a>`, html.EscapeString(href), html.EscapeString(m[1])))
+					i += len(m[0])
+					continue
+				}
+			}
+			p.addText("&lt;")
+			i++
+
+		case c == '*' || c == '_' || c == '~':
+			i = p.pushDelimiter(i)
+
+		default:
+			// 次の記号までをまとめてエスケープする
+			j := i + 1
+			for j < len(text) && strings.IndexByte("\\`![]<*_~", text[j]) < 0 {
+				j++
+			}
+			p.addText(html.EscapeString(text[i:j]))
+			i = j
+		}
+	}
+}
+
+// codeSpan の中身は改行を空白にし、両端の空白を1つずつ取り除く
+func codeContent(code string) string {
+	code = strings.ReplaceAll(code, "\n", " ")
+	if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+		code = code[1 : len(code)-1]
+	}
+	return code
+}
+
+// ========== 強調 ==========
+
+// pushDelimiter は * _ ~ の並びを区切り文字スタックに積む。
+// 直後が空白なら開けず、直前が空白なら閉じられない（「2 * 3 * 4」を強調にしない）。
+// _ は単語の途中（snake_case など）では開きも閉じもしない。~ は2つ以上のときだけ取り消し線
+func (p *inlineParser) pushDelimiter(i int) int {
+	text := p.text
+	c := text[i]
+	run := 1
+	for i+run < len(text) && text[i+run] == c {
+		run++
+	}
+	end := i + run
+
+	if c == '~' && run < 2 {
+		p.addText("~")
+		return end
+	}
+
+	before, after := byte(' '), byte(' ')
+	if i > 0 {
+		before = text[i-1]
+	}
+	if end < len(text) {
+		after = text[end]
+	}
+	canOpen := after != ' ' && after != '\n'
+	canClose := before != ' ' && before != '\n'
+	if c == '_' {
+		canOpen = canOpen && !isWordByte(before)
+		canClose = canClose && !isWordByte(after)
+	}
+
+	p.nodes = append(p.nodes, inlineNode{delim: c, count: run})
+	if canOpen || canClose {
+		d := &delimiter{node: len(p.nodes) - 1, char: c, canOpen: canOpen, canClose: canClose, prev: p.last}
+		if p.last != nil {
+			p.last.next = d
+		}
+		p.last = d
+	}
+	return end
+}
+
+func (p *inlineParser) removeDelimiter(d *delimiter) {
+	if d.prev != nil {
+		d.prev.next = d.next
+	}
+	if d.next != nil {
+		d.next.prev = d.prev
+	} else {
+		p.last = d.prev
+	}
+}
+
+// processEmphasis は bottom より上の区切り文字を、閉じ側から近い開き側と組にしてタグにする。
+// 組にできなかった区切り文字は文字のまま残り、処理した区切り文字はスタックから取り除く
+func (p *inlineParser) processEmphasis(bottom *delimiter) {
+	// 閉じ側の文字ごとに「ここより下に開き側はない」位置を覚え、同じ範囲を探し直さない
+	openersBottom := map[byte]*delimiter{'*': bottom, '_': bottom, '~': bottom}
+
+	first := p.last
+	for first != nil && first.prev != bottom {
+		first = first.prev
+	}
+
+	for closer := first; closer != nil; {
+		if !closer.canClose {
+			closer = closer.next
+			continue
+		}
+
+		var opener *delimiter
+		for o := closer.prev; o != nil && o != bottom && o != openersBottom[closer.char]; o = o.prev {
+			if o.char == closer.char && o.canOpen {
+				opener = o
+				break
+			}
+		}
+		if opener == nil {
+			openersBottom[closer.char] = closer.prev
+			next := closer.next
+			if !closer.canOpen {
+				p.removeDelimiter(closer)
+			}
+			closer = next
+			continue
+		}
+
+		on, cn := &p.nodes[opener.node], &p.nodes[closer.node]
+		n, tag := 1, "em"
+		switch {
+		case closer.char == '~':
+			n, tag = 2, "del"
+		case on.count >= 2 && cn.count >= 2:
+			n, tag = 2, "strong"
+		}
+		on.count -= n
+		cn.count -= n
+		// 内側の組から先に作られるので、開きタグは前に、閉じタグは後ろに足していく
+		on.after = "<" + tag + ">" + on.after
+		cn.before += "</" + tag + ">"
+
+		// 間にある区切り文字は、もう組にならない（入れ子が交差しないように）
+		for d := closer.prev; d != opener; d = d.prev {
+			p.removeDelimiter(d)
+		}
+		if on.count < minRun(opener.char) {
+			p.removeDelimiter(opener)
+		}
+		if cn.count < minRun(closer.char) {
+			next := closer.next
+			p.removeDelimiter(closer)
+			closer = next
+		}
+	}
+
+	// 残った区切り文字は文字として出力する
+	for p.last != nil && p.last != bottom {
+		p.removeDelimiter(p.last)
+	}
+}
+
+// minRun は強調に使える最小の文字数（~ は2つで1組）
+func minRun(c byte) int {
+	if c == '~' {
+		return 2
+	}
+	return 1
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// ========== リンク・画像 ==========
+
+func (p *inlineParser) pushBracket(i int, image bool) {
+	if image {
+		p.addText("![")
+	} else {
+		p.addText("[")
+	}
+	p.brackets = append(p.brackets, bracket{node: len(p.nodes) - 1, image: image, pos: i, bottom: p.last})
+}
+
+// closeBracket は ] の後ろが (dest "title") なら、対応する [ からをリンク（画像）にする
+func (p *inlineParser) closeBracket(i int) int {
+	if len(p.brackets) == 0 {
+		p.addText("]")
+		return i + 1
+	}
+	top := p.brackets[len(p.brackets)-1]
+	p.brackets = p.brackets[:len(p.brackets)-1]
+	if p.linkFloor > len(p.brackets) {
+		p.linkFloor = len(p.brackets)
+	}
+	// リンクの中の [ はリンクにしない（画像の中は可）
+	inactive := !top.image && len(p.brackets) < p.linkFloor
+
+	end, dest, title, ok := p.linkTail(i + 1)
+	if inactive || !ok {
+		p.addText("]")
+		return i + 1
+	}
+
+	opener := &p.nodes[top.node]
+	if top.image {
+		// alt は記法を取り除いた文字列にするので、中の断片と区切り文字は捨てる
+		label := p.text[top.pos+2 : i]
+		for p.last != nil && p.last != top.bottom {
+			p.removeDelimiter(p.last)
+		}
+		p.nodes = p.nodes[:top.node+1]
+		if src, ok := safeURL(dest, false); ok {
+			opener.text = fmt.Sprintf(`<img src="%s" alt="%s"`, html.EscapeString(src), html.EscapeString(plainText(label)))
+			if title != "" {
+				opener.text += fmt.Sprintf(` title="%s"`, html.EscapeString(title))
+			}
+			opener.text += ">"
+		} else {
+			opener.text = html.EscapeString(plainText(label))
+		}
+		return end
+	}
+
+	// 強調はリンクの中だけで組にする（<em> が <a> をまたがないように）
+	p.processEmphasis(top.bottom)
+	if href, ok := safeURL(dest, true); ok {
+		opener.text = fmt.Sprintf(`<a href="%s"`, html.EscapeString(href))
+		if title != "" {
+			opener.text += fmt.Sprintf(` title="%s"`, html.EscapeString(title))
+		}
+		opener.text += ` rel="nofollow noopener">`
+		p.addText("</a>")
+	} else {
+		opener.text = "" // 危険なリンクは文字だけ残す
+	}
+	p.linkFloor = len(p.brackets)
+	return end
+}
+
+// linkTail は ] の直後の (dest "title") を読み、その後ろの位置を返す。
+// 閉じ括弧は scanPairs で調べた対応を使う（URL に括弧が含まれることがある）
+func (p *inlineParser) linkTail(j int) (end int, dest, title string, ok bool) {
+	if j >= len(p.text) || p.text[j] != '(' || p.parens[j] < 0 {
+		return 0, "", "", false
+	}
+	close := p.parens[j]
+
+	inner := strings.TrimSpace(p.text[j+1 : close])
+	dest = inner
+	if k := strings.IndexAny(inner, " \t\n"); k >= 0 {
+		dest = inner[:k]
+		rest := strings.TrimSpace(inner[k:])
+		if len(rest) >= 2 && (rest[0] == '"' || rest[0] == '\'') && rest[len(rest)-1] == rest[0] {
+			title = rest[1 : len(rest)-1]
+		} else {
+			return 0, "", "", false
+		}
+	}
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	return close + 1, dest, title, true
+}
+
+// plainText は画像の alt 用に、記法の記号を取り除いた文字列を返す
+func plainText(s string) string {
+	return strings.NewReplacer("*", "", "_", "", "`", "", "~", "", "[", "", "]", "").Replace(s)
+}
+
+// ========== URL の検査 ==========
+
+// safeURL は href / src に使ってよい URL かを調べる。
+// スキームがあれば http / https（リンクなら mailto も）のみ許可し、
+// javascript: や data: などは拒否する。相対 URL（/path, #id, page.html）は許可
+func safeURL(raw string, link bool) (string, bool) {
+	u := strings.TrimSpace(raw)
+	if u == "" {
+		return "", false
+	}
+	for _, r := range u {
+		if r < 0x20 || r == 0x7f || r == ' ' {
+			return "", false
+		}
+	}
+
+	// 最初の / ? # より前に : があればスキーム付き
+	end := strings.IndexAny(u, "/?#")
+	if end < 0 {
+		end = len(u)
+	}
+	colon := strings.IndexByte(u[:end], ':')
+	if colon < 0 {
+		return u, true
+	}
+	switch strings.ToLower(u[:colon]) {
+	case "http", "https":
+		return u, true
+	case "mailto":
+		return u, link
+	}
+	return "", false
+}
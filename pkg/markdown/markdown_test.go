@@ -0,0 +1,82 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"heading", "# 見出し #", "<h1>見出し</h1>\n"},
+		{"paragraphs", "段落1\n続き\n\n段落2", "<p>段落1\n続き</p>\n<p>段落2</p>\n"},
+		{"inline", "*em* **strong** ~~del~~ `code`", "<p><em>em</em> <strong>strong</strong> <del>del</del> <code>code</code></p>\n"},
+		{"unclosed emphasis", "**unclosed", "<p>**unclosed</p>\n"},
+		{"underscore inside word", "a_b_c", "<p>a_b_c</p>\n"},
+		{"code span with backticks", "` `` `", "<p><code>``</code></p>\n"},
+		{"link", `[link](https://example.com "t")`, `<p><a href="https://example.com" title="t" rel="nofollow noopener">link</a></p>` + "\n"},
+		{"autolink", "<https://example.com>", `<p><a href="https://example.com" rel="nofollow noopener">https://example.com</a></p>` + "\n"},
+		{"image", "![img](/a.png)", `<p><img src="/a.png" alt="img"></p>` + "\n"},
+		{"bullet list", "- a\n- b\n  - c", "<ul>\n<li>a</li>\n<li>b\n<ul>\n<li>c</li>\n</ul></li>\n</ul>\n"},
+		{"ordered list", "1. one\n2. two", "<ol>\n<li>one</li>\n<li>two</li>\n</ol>\n"},
+		{"blockquote", "> quote\n> more", "<blockquote>\n<p>quote\nmore</p>\n</blockquote>\n"},
+		{"rule", "---", "<hr>\n"},
+		{"fence", "```go\nfmt.Println(\"<x>\")\n```", `<pre><code class="language-go">fmt.Println(&#34;&lt;x&gt;&#34;)` + "\n</code></pre>\n"},
+		{"table", "| a | b |\n|---|:-:|\n| 1 | 2 |", "<table>\n<thead>\n<tr><th>a</th><th align=\"center\">b</th></tr>\n</thead>\n<tbody>\n<tr><td>1</td><td align=\"center\">2</td></tr>\n</tbody>\n</table>\n"},
+		{"crlf", "a\r\nb", "<p>a\nb</p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.in); got != tt.want {
+				t.Errorf("Render(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// 入力の HTML や危険な URL は、タグや属性として出力されない
+func TestRenderIsSafe(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"html is escaped", "a<b>&c", "<p>a&lt;b&gt;&amp;c</p>\n"},
+		{"script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"javascript link", "[x](javascript:alert(1))", "<p>x</p>\n"},
+		{"data image", "![i](data:image/png;base64,xx)", "<p>i</p>\n"},
+		{"quote in url", `[x](https://a.com/" onclick="x)`, "<p>[x](https://a.com/&#34; onclick=&#34;x)</p>\n"},
+		{"quote in title", `[a](<x> "onclick=")`, `<p><a href="x" title="onclick=" rel="nofollow noopener">a</a></p>` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.in); got != tt.want {
+				t.Errorf("Render(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// 閉じられない区切り文字が大量にあっても、長さに比例した時間で終わる
+func TestRenderLinearTime(t *testing.T) {
+	inputs := map[string]string{
+		"emphasis":    strings.Repeat("*a", 50000),
+		"brackets":    strings.Repeat("[", 50000) + strings.Repeat("](x)", 10),
+		"backticks":   strings.Repeat("`a", 50000),
+		"nested list": strings.Repeat("- a\n  ", 2000),
+		"blockquote":  strings.Repeat(">", 5000) + " a",
+	}
+	for name, in := range inputs {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			Render(in)
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("Render took %v", d)
+			}
+		})
+	}
+}
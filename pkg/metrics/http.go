@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"learn-go/pkg/recorder"
+)
+
+// HTTPMetrics は HTTP サーバーのリクエスト数・処理時間・処理中の数
+type HTTPMetrics struct {
+	requests *CounterVec
+	duration *HistogramVec
+	inFlight *Gauge
+}
+
+// NewHTTPMetrics は http_requests_total / http_request_duration_seconds / http_requests_in_flight を登録する
+func (r *Registry) NewHTTPMetrics() *HTTPMetrics {
+	return &HTTPMetrics{
+		requests: r.CounterVec("http_requests_total", "HTTP リクエスト数", "route", "method", "status"),
+		duration: r.HistogramVec("http_request_duration_seconds", "HTTP リクエストの処理時間（秒）", nil, "route", "method"),
+		inFlight: r.Gauge("http_requests_in_flight", "処理中の HTTP リクエスト数"),
+	}
+}
+
+// Middleware はリクエストを計測するミドルウェアを返す。
+// route はラベルにする経路（"/api/posts/" のような登録パターン）を返す関数。
+// URL をそのままラベルにすると ID ごとに系列が増えてしまうので、パターンにまとめる
+func (m *HTTPMetrics) Middleware(route func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.inFlight.Inc()
+			defer m.inFlight.Dec()
+
+			rec := recorder.New(w)
+			start := time.Now()
+			next.ServeHTTP(rec, r)
+
+			label, method := route(r), methodLabel(r.Method)
+			m.requests.With(label, method, strconv.Itoa(rec.Status())).Inc()
+			m.duration.With(label, method).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// MuxRoute は mux に登録されたパターンを経路のラベルにする（どれにも一致しなければ "other"）
+func MuxRoute(mux *http.ServeMux) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			return pattern
+		}
+		return "other"
+	}
+}
+
+// methodLabel は任意のメソッド名で系列が増えないよう、標準のメソッド以外を OTHER にまとめる
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
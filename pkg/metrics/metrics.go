@@ -0,0 +1,373 @@
+// Package metrics はカウンター・ゲージ・ヒストグラムを集計し、Prometheus のテキスト形式で公開する
+//
+// 【学習ポイント】
+// 1. カウンターは増えるだけの値（リクエスト数など）、ゲージは上下する値（処理中の数など）
+// 2. ヒストグラムは「le 以下だった回数」をバケットごとに累積で数える（パーセンタイルは Prometheus 側で計算する）
+// 3. ラベルの組み合わせごとに別の系列になる。URL そのものやユーザーIDをラベルにすると系列が増え続けるので避ける
+// 4. 値の更新は sync/atomic で行い、リクエストの処理をロックで待たせない
+//
+// 形式: https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets はレイテンシ（秒）向けの既定のバケット
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// collector は /metrics を出力するたびに呼ばれる
+type collector interface {
+	metricName() string
+	write(w *bufio.Writer)
+}
+
+// Registry は登録されたメトリクスの一覧
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// register は同じ名前の二重登録を防ぐ（プログラムの誤りなので panic する）
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.collectors[c.metricName()]; dup {
+		panic("metrics: " + c.metricName() + " は登録済みです")
+	}
+	r.collectors[c.metricName()] = c
+}
+
+// ========== 値 ==========
+
+// value は float64 を atomic に読み書きする（ビット列を uint64 として扱う）
+type value struct {
+	bits uint64
+}
+
+func (v *value) Load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+func (v *value) Store(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+// Add は比較して交換（CAS）を成功するまで繰り返す
+func (v *value) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, next) {
+			return
+		}
+	}
+}
+
+// Counter は増えるだけの値
+type Counter struct{ v value }
+
+func (c *Counter) Inc() { c.v.Add(1) }
+
+// Add は delta を足す。負の値は無視する（カウンターは減らない）
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.v.Add(delta)
+	}
+}
+
+// Gauge は上下する値
+type Gauge struct{ v value }
+
+func (g *Gauge) Set(f float64)     { g.v.Store(f) }
+func (g *Gauge) Add(delta float64) { g.v.Add(delta) }
+func (g *Gauge) Inc()              { g.v.Add(1) }
+func (g *Gauge) Dec()              { g.v.Add(-1) }
+
+// Histogram は観測値をバケットごとに数える
+type Histogram struct {
+	upper  []float64 // バケットの上限（昇順）
+	counts []uint64  // バケットごとの回数（累積ではない。出力時に足し合わせる）
+	count  uint64
+	sum    value
+}
+
+func (h *Histogram) Observe(f float64) {
+	// f 以上の最初の上限のバケットに入れる。どれにも入らなければ +Inf（count だけ増える）
+	if i := sort.SearchFloat64s(h.upper, f); i < len(h.upper) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.Add(f)
+}
+
+// ========== ファミリー（同じ名前・ラベル名を持つ系列の集まり） ==========
+
+type family struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	newFn  func() interface{} // 系列の値（*Counter / *Gauge / *Histogram）を作る
+
+	mu     sync.RWMutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	metric      interface{}
+}
+
+func newFamily(name, help, typ string, labels []string, newFn func() interface{}) *family {
+	return &family{name: name, help: help, typ: typ, labels: labels, newFn: newFn, series: make(map[string]*series)}
+}
+
+func (f *family) metricName() string { return f.name }
+
+// get はラベルの値に対応する系列を返す（なければ作る）
+func (f *family) get(labelValues []string) interface{} {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s のラベルは %d 個です（%d 個渡されました）", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s.metric
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.series[key]; ok {
+		return s.metric
+	}
+	s = &series{labelValues: append([]string(nil), labelValues...), metric: f.newFn()}
+	f.series[key] = s
+	return s.metric
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.RLock()
+	list := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		list = append(list, s)
+	}
+	f.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].labelValues, "\xff") < strings.Join(list[j].labelValues, "\xff")
+	})
+
+	writeHeader(w, f.name, f.help, f.typ)
+	for _, s := range list {
+		switch m := s.metric.(type) {
+		case *Counter:
+			writeSample(w, f.name, f.labels, s.labelValues, "", "", m.v.Load())
+		case *Gauge:
+			writeSample(w, f.name, f.labels, s.labelValues, "", "", m.v.Load())
+		case *Histogram:
+			var cumulative uint64
+			for i, upper := range m.upper {
+				cumulative += atomic.LoadUint64(&m.counts[i])
+				writeSample(w, f.name+"_bucket", f.labels, s.labelValues, "le", formatFloat(upper), float64(cumulative))
+			}
+			count := atomic.LoadUint64(&m.count)
+			writeSample(w, f.name+"_bucket", f.labels, s.labelValues, "le", "+Inf", float64(count))
+			writeSample(w, f.name+"_sum", f.labels, s.labelValues, "", "", m.sum.Load())
+			writeSample(w, f.name+"_count", f.labels, s.labelValues, "", "", float64(count))
+		}
+	}
+}
+
+// CounterVec はラベル付きのカウンター
+type CounterVec struct{ f *family }
+
+// With はラベルの値（登録したラベル名の順）に対応するカウンターを返す
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return v.f.get(labelValues).(*Counter)
+}
+
+// GaugeVec はラベル付きのゲージ
+type GaugeVec struct{ f *family }
+
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return v.f.get(labelValues).(*Gauge)
+}
+
+// HistogramVec はラベル付きのヒストグラム
+type HistogramVec struct{ f *family }
+
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return v.f.get(labelValues).(*Histogram)
+}
+
+// ========== 登録 ==========
+
+func (r *Registry) Counter(name, help string) *Counter {
+	return r.CounterVec(name, help).With()
+}
+
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	f := newFamily(name, help, typeCounter, labels, func() interface{} { return &Counter{} })
+	r.register(f)
+	return &CounterVec{f: f}
+}
+
+func (r *Registry) Gauge(name, help string) *Gauge {
+	return r.GaugeVec(name, help).With()
+}
+
+func (r *Registry) GaugeVec(name, help string, labels ...string) *GaugeVec {
+	f := newFamily(name, help, typeGauge, labels, func() interface{} { return &Gauge{} })
+	r.register(f)
+	return &GaugeVec{f: f}
+}
+
+// HistogramVec はラベル付きのヒストグラムを登録する。buckets が nil なら DefBuckets
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	upper := append([]float64(nil), buckets...)
+	sort.Float64s(upper)
+	f := newFamily(name, help, typeHistogram, labels, func() interface{} {
+		return &Histogram{upper: upper, counts: make([]uint64, len(upper))}
+	})
+	r.register(f)
+	return &HistogramVec{f: f}
+}
+
+// funcMetric は出力のたびに関数を呼んで値を得る（キューの長さなど、別の場所にある値向け）
+type funcMetric struct {
+	name, help, typ string
+	fn              func() float64
+}
+
+func (m *funcMetric) metricName() string { return m.name }
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	writeHeader(w, m.name, m.help, m.typ)
+	writeSample(w, m.name, nil, nil, "", "", m.fn())
+}
+
+// GaugeFunc は値を fn から読むゲージを登録する
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, typ: typeGauge, fn: fn})
+}
+
+// CounterFunc は値を fn から読むカウンターを登録する（fn は減らない値を返すこと）
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, typ: typeCounter, fn: fn})
+}
+
+// ========== 出力 ==========
+
+// WriteTo は全メトリクスを名前順に Prometheus のテキスト形式で書き出す
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	list := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		list = append(list, c)
+	}
+	r.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].metricName() < list[j].metricName() })
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range list {
+		c.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler は GET /metrics のハンドラー
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		r.WriteTo(w)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// writeSample は「名前{ラベル="値",...} 値」の1行を書く。extraName はヒストグラムの le 用
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, l, escapeLabel(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// HELP では \ と改行を、ラベルの値ではさらに " をエスケープする
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.CounterVec("app_requests_total", "リクエスト数\n改行と \\ を含む説明", "path", "code")
+	requests.With("/a", "200").Inc()
+	requests.With("/a", "200").Inc()
+	requests.With("say \"hi\"\\\n", "500").Add(1.5)
+	requests.With("/a", "200").Add(-10) // カウンターは減らない
+
+	reg.Gauge("app_temperature", "温度").Set(-2.5)
+	reg.GaugeFunc("app_queue_length", "待ち行列の長さ", func() float64 { return 3 })
+
+	// バケットは昇順に並べ替えられ、上限ちょうどの値はそのバケットに入る
+	latency := reg.HistogramVec("app_latency_seconds", "処理時間", []float64{1, 0.125}, "route")
+	for _, v := range []float64{0.0625, 0.125, 0.5, 4} {
+		latency.With("/b").Observe(v)
+	}
+
+	want := `# HELP app_latency_seconds 処理時間
+# TYPE app_latency_seconds histogram
+app_latency_seconds_bucket{route="/b",le="0.125"} 2
+app_latency_seconds_bucket{route="/b",le="1"} 3
+app_latency_seconds_bucket{route="/b",le="+Inf"} 4
+app_latency_seconds_sum{route="/b"} 4.6875
+app_latency_seconds_count{route="/b"} 4
+# HELP app_queue_length 待ち行列の長さ
+# TYPE app_queue_length gauge
+app_queue_length 3
+# HELP app_requests_total リクエスト数\n改行と \\ を含む説明
+# TYPE app_requests_total counter
+app_requests_total{path="/a",code="200"} 2
+app_requests_total{path="say \"hi\"\\\n",code="500"} 1.5
+# HELP app_temperature 温度
+# TYPE app_temperature gauge
+app_temperature -2.5
+`
+	var buf strings.Builder
+	n, err := reg.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want {
+		t.Errorf("WriteTo =\n%s\nwant\n%s", buf.String(), want)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, wrote %d bytes", n, buf.Len())
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, "0"},
+		{1.5, "1.5"},
+		{-2, "-2"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.in); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestConcurrentAdd は CAS による float の加算が同時に呼ばれても値を取りこぼさないことを確かめる（-race でも実行する）
+func TestConcurrentAdd(t *testing.T) {
+	const goroutines, perGoroutine = 8, 1000
+
+	reg := NewRegistry()
+	counter := reg.Counter("c_total", "")
+	gauge := reg.Gauge("g", "")
+	hist := reg.HistogramVec("h", "", []float64{1}).With()
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perGoroutine; j++ {
+				counter.Add(0.5)
+				gauge.Inc()
+				gauge.Dec()
+				hist.Observe(0.25)
+			}
+		}()
+	}
+	wg.Wait()
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"counter", counter.v.Load(), goroutines * perGoroutine * 0.5},
+		{"gauge", gauge.v.Load(), 0},
+		{"histogram sum", hist.sum.Load(), goroutines * perGoroutine * 0.25},
+		{"histogram count", float64(hist.count), goroutines * perGoroutine},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestRegistrationPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(reg *Registry)
+	}{
+		{"duplicate name", func(reg *Registry) {
+			reg.Counter("dup", "")
+			reg.Gauge("dup", "")
+		}},
+		{"wrong number of labels", func(reg *Registry) {
+			reg.CounterVec("labeled", "", "a", "b").With("only-one")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("want a panic")
+				}
+			}()
+			tt.fn(NewRegistry())
+		})
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	reg := NewRegistry()
+	m := reg.NewHTTPMetrics()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/posts/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/posts/404" {
+			http.NotFound(w, r)
+		}
+	})
+	handler := m.Middleware(MuxRoute(mux))(mux)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/api/posts/1", nil),
+		httptest.NewRequest(http.MethodGet, "/api/posts/2", nil),
+		httptest.NewRequest(http.MethodGet, "/api/posts/404", nil),
+		httptest.NewRequest("BREW", "/unknown", nil),
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		// ID ごとではなく登録パターンごとの系列になる
+		`http_requests_total{route="/api/posts/",method="GET",status="200"} 2`,
+		`http_requests_total{route="/api/posts/",method="GET",status="404"} 1`,
+		// 知らないメソッドと一致しない経路はまとめる
+		`http_requests_total{route="other",method="OTHER",status="404"} 1`,
+		`http_request_duration_seconds_count{route="/api/posts/",method="GET"} 3`,
+		"http_requests_in_flight 0",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("/metrics does not contain %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"bufio"
+	"runtime"
+	"time"
+)
+
+// runtimeCollector は Go ランタイムの統計を出力する。
+// runtime.ReadMemStats は全ゴルーチンを一瞬止めるので、1回の出力につき1回だけ呼ぶ
+type runtimeCollector struct{}
+
+func (runtimeCollector) metricName() string { return "go_" }
+
+func (runtimeCollector) write(w *bufio.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	gauges := []struct {
+		name, help string
+		v          float64
+	}{
+		{"go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine())},
+		{"go_sched_gomaxprocs_threads", "Current GOMAXPROCS setting (threads that can execute Go code simultaneously).", float64(runtime.GOMAXPROCS(0))},
+		{"go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(ms.Alloc)},
+		{"go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(ms.HeapInuse)},
+		{"go_memstats_heap_objects", "Number of allocated objects.", float64(ms.HeapObjects)},
+		{"go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(ms.Sys)},
+		{"go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", float64(ms.NextGC)},
+		{"go_memstats_last_gc_time_seconds", "Number of seconds since 1970 of last garbage collection.", float64(ms.LastGC) / 1e9},
+	}
+	for _, g := range gauges {
+		writeHeader(w, g.name, g.help, typeGauge)
+		writeSample(w, g.name, nil, nil, "", "", g.v)
+	}
+
+	counters := []struct {
+		name, help string
+		v          float64
+	}{
+		{"go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", float64(ms.TotalAlloc)},
+		{"go_memstats_mallocs_total", "Total number of mallocs.", float64(ms.Mallocs)},
+		{"go_gc_cycles_total", "Number of completed GC cycles.", float64(ms.NumGC)},
+		{"go_gc_pause_seconds_total", "Total GC stop-the-world pause time.", float64(ms.PauseTotalNs) / 1e9},
+	}
+	for _, c := range counters {
+		writeHeader(w, c.name, c.help, typeCounter)
+		writeSample(w, c.name, nil, nil, "", "", c.v)
+	}
+
+	writeHeader(w, "go_info", "Information about the Go environment.", typeGauge)
+	writeSample(w, "go_info", []string{"version"}, []string{runtime.Version()}, "", "", 1)
+}
+
+// RegisterRuntime は Go ランタイムの統計（ゴルーチン数・メモリ・GC）とプロセスの起動時刻を登録する
+func (r *Registry) RegisterRuntime() {
+	r.register(runtimeCollector{})
+
+	start := float64(time.Now().UnixNano()) / 1e9
+	r.GaugeFunc("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", func() float64 { return start })
+}
@@ -0,0 +1,114 @@
+// Package password はパスワードをソルト付きの PBKDF2-HMAC-SHA256 でハッシュ化して保存・照合する
+//
+// 【学習ポイント】
+//  1. パスワードは平文でも SHA-256 一回きりでも保存しない。ファイルが漏れると辞書攻撃ですぐに元へ戻される
+//  2. ユーザーごとにランダムなソルトを付け、同じパスワードでも別のハッシュにする（レインボーテーブル対策）
+//  3. 反復回数で1回の計算をわざと遅くし、総当たりの速度を落とす
+//  4. 方式・反復回数・ソルトをハッシュと一緒に書いておけば、後から回数を増やしても古いハッシュを照合できる
+//  5. 比較は subtle.ConstantTimeCompare で行う（一致した長さで応答時間が変わらない）
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultIterations は Hash が使う反復回数（OWASP の PBKDF2-HMAC-SHA256 の推奨値）
+const DefaultIterations = 600_000
+
+const (
+	scheme  = "pbkdf2-sha256"
+	saltLen = 16
+	keyLen  = 32
+)
+
+// ErrMalformed はハッシュの形式が読めないときに返す
+var ErrMalformed = errors.New("パスワードハッシュの形式が不正です")
+
+// Hash は plain を DefaultIterations 回でハッシュ化する
+// 形式: pbkdf2-sha256$反復回数$base64(ソルト)$base64(ハッシュ)
+func Hash(plain string) (string, error) {
+	return HashWithIterations(plain, DefaultIterations)
+}
+
+// HashWithIterations は反復回数を指定してハッシュ化する（テストで速くしたいときなど）
+func HashWithIterations(plain string, iterations int) (string, error) {
+	if iterations < 1 {
+		return "", fmt.Errorf("反復回数は1以上にしてください: %d", iterations)
+	}
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2([]byte(plain), salt, iterations, keyLen)
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", scheme, iterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// Verify は plain が encoded と一致するかを返す。形式が不正なときも false
+func Verify(encoded, plain string) bool {
+	iterations, salt, key, err := parse(encoded)
+	if err != nil {
+		return false
+	}
+	got := pbkdf2([]byte(plain), salt, iterations, len(key))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+// IsHash は s が Hash の形式かどうかを返す（平文で保存された古いデータの移行に使う）
+func IsHash(s string) bool {
+	_, _, _, err := parse(s)
+	return err == nil
+}
+
+func parse(encoded string) (iterations int, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != scheme {
+		return 0, nil, nil, ErrMalformed
+	}
+	iterations, err = strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return 0, nil, nil, ErrMalformed
+	}
+	enc := base64.RawStdEncoding
+	if salt, err = enc.DecodeString(parts[2]); err != nil {
+		return 0, nil, nil, ErrMalformed
+	}
+	if key, err = enc.DecodeString(parts[3]); err != nil || len(key) == 0 {
+		return 0, nil, nil, ErrMalformed
+	}
+	return iterations, salt, key, nil
+}
+
+// pbkdf2 は RFC 8018 の PBKDF2 を HMAC-SHA256 で計算する
+// ブロック i ごとに U1 = HMAC(P, S || i), Uj = HMAC(P, Uj-1) を求め、全部の XOR をつなげる
+func pbkdf2(password, salt []byte, iterations, length int) []byte {
+	prf := hmac.New(sha256.New, password)
+	size := prf.Size()
+	out := make([]byte, 0, (length+size-1)/size*size)
+	u := make([]byte, size)
+	for block := 1; len(out) < length; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u = prf.Sum(u[:0])
+		t := append([]byte(nil), u...)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:length]
+}
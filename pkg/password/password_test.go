@@ -0,0 +1,82 @@
+package password
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// テストでは反復回数を小さくして速く回す
+const testIterations = 1000
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 11章 と RFC 6070 を SHA-256 にしたテストベクタ
+	tests := []struct {
+		password, salt string
+		iterations     int
+		length         int
+		want           string
+	}{
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, 64, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+		{"password", "salt", 4096, 20, "c5e478d59288c841aa530db6845c4c8d962893a0"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2([]byte(tt.password), []byte(tt.salt), tt.iterations, tt.length))
+		if got != tt.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func TestHashAndVerify(t *testing.T) {
+	hash, err := HashWithIterations("password123", testIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(hash, "password123") {
+		t.Fatalf("hash %q contains the plaintext", hash)
+	}
+	if !IsHash(hash) {
+		t.Errorf("IsHash(%q) = false", hash)
+	}
+
+	// 同じパスワードでもソルトが違うので別のハッシュになる
+	again, err := HashWithIterations("password123", testIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == hash {
+		t.Error("two hashes of the same password are equal")
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		plain   string
+		want    bool
+	}{
+		{"correct", hash, "password123", true},
+		{"second hash", again, "password123", true},
+		{"wrong password", hash, "password124", false},
+		{"empty password", hash, "", false},
+		{"plaintext stored", "password123", "password123", false},
+		{"unknown scheme", strings.Replace(hash, "pbkdf2-sha256", "md5", 1), "password123", false},
+		{"bad iterations", "pbkdf2-sha256$0$c2FsdA$a2V5", "password123", false},
+		{"bad base64", "pbkdf2-sha256$1000$!!$a2V5", "password123", false},
+		{"empty key", "pbkdf2-sha256$1000$c2FsdA$", "password123", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.encoded, tt.plain); got != tt.want {
+				t.Errorf("Verify(%q, %q) = %v, want %v", tt.encoded, tt.plain, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashRejectsZeroIterations(t *testing.T) {
+	if _, err := HashWithIterations("x", 0); err == nil {
+		t.Error("want an error for 0 iterations")
+	}
+}
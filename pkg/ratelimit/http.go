@@ -0,0 +1,242 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ========== キー ==========
+
+// KeyFunc はリクエストから制限のキーを取り出す。空文字を返したら、この関数では決められないという意味
+type KeyFunc func(r *http.Request) string
+
+// ByIP はクライアントの IP アドレスをキーにする。proxies が信頼するプロキシ経由なら X-Forwarded-For を見る。
+// IPv6 は /64 単位にまとめる（1つの契約者が /64 を丸ごと持つのが普通で、アドレスを変えれば回避できてしまうため）
+func ByIP(proxies *TrustedProxies) KeyFunc {
+	return func(r *http.Request) string {
+		ip := proxies.ClientIP(r)
+		if ip == nil {
+			return ""
+		}
+		if ip.To4() == nil {
+			ip = ip.Mask(net.CIDRMask(64, 128))
+		}
+		return "ip:" + ip.String()
+	}
+}
+
+// ByHeader はヘッダーの値（X-API-Key など）をキーにする。
+// 値は秘密のことが多いので、そのままメモリに持たずハッシュにする。
+// クライアントは好きな値を送れるので、値を変えるだけで別のキーになる。
+// 手前の認証ミドルウェアで検証済みのヘッダーにだけ使うこと（そうでなければ ByUser で検証後の ID を使う）
+func ByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		v := r.Header.Get(name)
+		if v == "" {
+			return ""
+		}
+		sum := sha256.Sum256([]byte(v))
+		return "hdr:" + strings.ToLower(name) + ":" + hex.EncodeToString(sum[:12])
+	}
+}
+
+// ByUser は user が返すユーザーIDをキーにする（ログインしていなければ空を返す関数を渡す）
+func ByUser(user func(r *http.Request) string) KeyFunc {
+	return func(r *http.Request) string {
+		id := user(r)
+		if id == "" {
+			return ""
+		}
+		return "user:" + id
+	}
+}
+
+// First は空でないキーを返した最初の関数の結果を使う（ユーザー → IP の順に試す、など）
+func First(fns ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		for _, fn := range fns {
+			if key := fn(r); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+}
+
+// ========== 信頼するプロキシ ==========
+
+// TrustedProxies はリバースプロキシ（ロードバランサー）のアドレス範囲。
+// X-Forwarded-For はクライアントが好きに書けるので、信頼するプロキシが付け足した部分だけを使う
+type TrustedProxies struct {
+	nets []*net.IPNet
+}
+
+// ParseTrustedProxies は "10.0.0.0/8" のような CIDR か、"127.0.0.1" のような単独のアドレスを受け取る
+func ParseTrustedProxies(cidrs ...string) (*TrustedProxies, error) {
+	t := &TrustedProxies{}
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("ratelimit: invalid proxy address %q", c)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			c = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("ratelimit: invalid proxy CIDR %q: %w", c, err)
+		}
+		t.nets = append(t.nets, n)
+	}
+	return t, nil
+}
+
+// Contains は ip が信頼するプロキシか
+func (t *TrustedProxies) Contains(ip net.IP) bool {
+	if t == nil || ip == nil {
+		return false
+	}
+	for _, n := range t.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP はクライアントの IP アドレスを返す。
+// 直接の接続元が信頼するプロキシなら、X-Forwarded-For を右（自分に近い側）から見て、
+// 信頼するプロキシでない最初のアドレスを使う。左側はクライアントが偽造できるので、その先は見ない。
+// t が nil なら常に直接の接続元
+func (t *TrustedProxies) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if !t.Contains(ip) {
+		return ip
+	}
+
+	// X-Forwarded-For は複数行に分かれていることもある。すべてを1つのリストとして扱う
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// 読めない値より左は信用できない。ここまでで分かっている一番遠いアドレスを使う
+			return ip
+		}
+		ip = hop
+		if !t.Contains(ip) {
+			return ip
+		}
+	}
+	// すべて信頼するプロキシ（内部からのリクエスト）
+	return ip
+}
+
+// ========== ミドルウェア ==========
+
+// Policy は制限の内容（どのアルゴリズムで、何をキーにするか）
+type Policy struct {
+	Name    string // ログやヘッダーでの区別用
+	Limiter Limiter
+	Key     KeyFunc
+}
+
+// Rule はどのリクエストにどの Policy を使うか
+type Rule struct {
+	Method string  // 空ならすべてのメソッド
+	Prefix string  // パスの前方一致。空ならすべてのパス
+	Policy *Policy // nil なら制限しない（ヘルスチェックを外す、など）
+}
+
+func (rule Rule) matches(r *http.Request) bool {
+	if rule.Method != "" && rule.Method != r.Method {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, rule.Prefix)
+}
+
+// NewMiddleware は rules のうち最初に当てはまった Policy で制限するミドルウェアを返す。
+// どれにも当てはまらない・キーが空のリクエストは制限しない。
+// 通したときも拒否したときも RateLimit-* ヘッダーを付け、拒否したときは Retry-After を付けて onLimited を呼ぶ
+// （nil なら 429 Too Many Requests のテキスト）
+func NewMiddleware(rules []Rule, onLimited http.Handler) func(http.Handler) http.Handler {
+	if onLimited == nil {
+		onLimited = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		})
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := match(rules, r)
+			if policy == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := policy.Key(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// 同じ Limiter を複数の Policy で使ってもキーがぶつからないよう、Policy の名前を付ける
+			d := policy.Limiter.Allow(policy.Name + "|" + key)
+			SetHeaders(w.Header(), d)
+			if !d.Allowed {
+				onLimited.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func match(rules []Rule, r *http.Request) *Policy {
+	for _, rule := range rules {
+		if rule.matches(r) {
+			return rule.Policy
+		}
+	}
+	return nil
+}
+
+// SetHeaders は判定結果を IETF の RateLimit ヘッダー（draft-ietf-httpapi-ratelimit-headers）で伝える。
+// 拒否したときは Retry-After（秒）も付ける
+func SetHeaders(h http.Header, d Decision) {
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", d.Limit, ceilSeconds(d.Window)))
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(d.Reset), 10))
+	if !d.Allowed {
+		retry := ceilSeconds(d.RetryAfter)
+		if retry < 1 {
+			retry = 1 // 0 だとすぐに再試行されてまた拒否される
+		}
+		h.Set("Retry-After", strconv.FormatInt(retry, 10))
+	}
+}
+
+// ceilSeconds は切り上げた秒数（0.2秒後を 0 と伝えると早すぎる）
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
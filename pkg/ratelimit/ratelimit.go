@@ -0,0 +1,227 @@
+// Package ratelimit はキー（IP・ユーザー・APIキー）ごとにリクエスト数を制限する
+//
+// 【学習ポイント】
+// 1. トークンバケットは「一定の速さで溜まるトークンを1つ使って1リクエスト」。短い集中（バースト）を許しつつ平均の速さを抑える
+// 2. スライディングウィンドウは直前の窓の数を経過時間で按分して足す。固定窓の境目で2倍通ってしまう問題を防ぐ
+// 3. キーごとの状態は map に持ち、しばらく使われていないキーは消す（消さないと攻撃者が作ったキーでメモリが増え続ける）
+// 4. 複数のゴルーチンから同時に呼ばれるので、状態の読み書きは Mutex で守る
+// 5. 拒否したときは 429 と Retry-After を返し、いつ再試行すればよいかをクライアントに伝える
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Decision は1回の判定結果
+type Decision struct {
+	Allowed    bool
+	Limit      int           // 窓あたりの上限（トークンバケットでは容量）
+	Remaining  int           // 残りの回数
+	Window     time.Duration // 上限の単位になる時間
+	Reset      time.Duration // 残りが上限まで戻るまで（スライディングウィンドウでは今の窓の終わりまで）
+	RetryAfter time.Duration // 拒否したとき、次に通るまでの時間
+}
+
+// Limiter はキーごとの判定を行う。同時に呼んでよい
+type Limiter interface {
+	Allow(key string) Decision
+}
+
+// ========== キーごとの状態 ==========
+
+// entry はキー1つの状態（トークンバケットとスライディングウィンドウで使う項目が違う）
+type entry struct {
+	lastSeen time.Time
+
+	// トークンバケット
+	tokens   float64
+	refilled time.Time // 最後に補充した時刻
+
+	// スライディングウィンドウ
+	windowStart time.Time
+	prev, curr  int
+}
+
+// keyMap はキーごとの状態の表。idleTTL より長く使われていないキーは消す
+type keyMap struct {
+	mu        sync.Mutex
+	entries   map[string]*entry
+	idleTTL   time.Duration
+	lastSweep time.Time
+}
+
+func newKeyMap(idleTTL time.Duration) keyMap {
+	return keyMap{entries: make(map[string]*entry), idleTTL: idleTTL}
+}
+
+// getLocked はキーの状態を返す（なければ作って created=true）。
+// 専用のゴルーチンを持たないよう、ときどき呼び出しのついでに古いキーを消す
+func (m *keyMap) getLocked(key string, now time.Time) (e *entry, created bool) {
+	if now.Sub(m.lastSweep) >= m.idleTTL/2 {
+		for k, e := range m.entries {
+			if now.Sub(e.lastSeen) > m.idleTTL {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	e, ok := m.entries[key]
+	if !ok {
+		e = &entry{}
+		m.entries[key] = e
+	}
+	e.lastSeen = now
+	return e, !ok
+}
+
+// Len は保持しているキーの数
+func (m *keyMap) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// ========== トークンバケット ==========
+
+// TokenBucket はキーごとに容量 burst のバケットを持ち、1秒に rate 個のトークンを補充する
+type TokenBucket struct {
+	rate  float64
+	burst int
+	keys  keyMap
+}
+
+// Per は「per あたり n 回」を1秒あたりの回数にする（NewTokenBucket(Per(60, time.Minute), 10) など）
+func Per(n int, per time.Duration) float64 {
+	return float64(n) / per.Seconds()
+}
+
+// NewTokenBucket は1秒に rate 回、最大 burst 回まで続けて通す TokenBucket を作る
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	// 満タンになるまでの時間を過ぎたキーは、消しても作り直しても同じ状態（満タン）
+	fill := time.Duration(float64(burst) / rate * float64(time.Second))
+	if fill < time.Minute {
+		fill = time.Minute
+	}
+	return &TokenBucket{rate: rate, burst: burst, keys: newKeyMap(fill)}
+}
+
+func (b *TokenBucket) Allow(key string) Decision {
+	now := time.Now()
+	b.keys.mu.Lock()
+	defer b.keys.mu.Unlock()
+
+	e, created := b.keys.getLocked(key, now)
+	if created {
+		e.tokens = float64(b.burst)
+	} else {
+		e.tokens = math.Min(float64(b.burst), e.tokens+now.Sub(e.refilled).Seconds()*b.rate)
+	}
+	e.refilled = now
+
+	d := Decision{
+		Limit:  b.burst,
+		Window: b.seconds(float64(b.burst)),
+	}
+	if e.tokens >= 1 {
+		e.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = b.seconds(1 - e.tokens)
+	}
+	d.Remaining = int(e.tokens)
+	d.Reset = b.seconds(float64(b.burst) - e.tokens)
+	return d
+}
+
+// seconds はトークン n 個が溜まるまでの時間
+func (b *TokenBucket) seconds(n float64) time.Duration {
+	return time.Duration(n / b.rate * float64(time.Second))
+}
+
+// Len は保持しているキーの数（古いキーが消えているかの確認用）
+func (b *TokenBucket) Len() int { return b.keys.Len() }
+
+// ========== スライディングウィンドウ ==========
+
+// SlidingWindow は window あたり limit 回までに制限する。
+// 今の窓の回数に、直前の窓の回数を「まだ重なっている割合」だけ足して数える（近似だが、キーごとに整数2つで済む）
+type SlidingWindow struct {
+	limit  int
+	window time.Duration
+	keys   keyMap
+}
+
+// NewSlidingWindow は window あたり limit 回までの SlidingWindow を作る
+func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow {
+	if limit < 1 {
+		limit = 1
+	}
+	// 2つ前の窓より古い回数はもう数えないので、それより長く使われていないキーは消してよい
+	return &SlidingWindow{limit: limit, window: window, keys: newKeyMap(2 * window)}
+}
+
+func (s *SlidingWindow) Allow(key string) Decision {
+	now := time.Now()
+	s.keys.mu.Lock()
+	defer s.keys.mu.Unlock()
+
+	e, _ := s.keys.getLocked(key, now)
+	start := now.Truncate(s.window)
+	if !e.windowStart.Equal(start) {
+		if start.Sub(e.windowStart) == s.window {
+			e.prev = e.curr // 直前の窓
+		} else {
+			e.prev = 0 // 2つ以上前の窓は数えない
+		}
+		e.curr = 0
+		e.windowStart = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(s.window)
+	count := float64(e.prev)*weight + float64(e.curr)
+
+	d := Decision{
+		Limit:  s.limit,
+		Window: s.window,
+		Reset:  s.window - elapsed,
+	}
+	if count+1 <= float64(s.limit) {
+		e.curr++
+		count++
+		d.Allowed = true
+	} else {
+		d.RetryAfter = s.retryAfter(e, elapsed)
+	}
+	d.Remaining = s.limit - int(math.Ceil(count))
+	if d.Remaining < 0 {
+		d.Remaining = 0
+	}
+	return d
+}
+
+// retryAfter は数え直した回数が limit-1 以下（もう1回通る）になるまでの時間
+func (s *SlidingWindow) retryAfter(e *entry, elapsed time.Duration) time.Duration {
+	w := float64(s.window)
+	room := float64(s.limit - 1)
+	if float64(e.curr) > room {
+		// 今の窓だけで上限に達している。次の窓で、今の窓の回数が按分で減るのを待つ
+		t := w * (1 - room/float64(e.curr))
+		return s.window - elapsed + time.Duration(t)
+	}
+	// 直前の窓の回数が按分で減れば通る: prev*(1-(elapsed+t)/w) + curr <= limit-1
+	t := w*(1-(room-float64(e.curr))/float64(e.prev)) - float64(elapsed)
+	if t < 0 {
+		t = 0
+	}
+	return time.Duration(t)
+}
+
+// Len は保持しているキーの数
+func (s *SlidingWindow) Len() int { return s.keys.Len() }
@@ -0,0 +1,195 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiters(t *testing.T) {
+	// 補充がほぼ起きない速さにして、時刻に左右されないようにする
+	tests := []struct {
+		name    string
+		limiter Limiter
+		limit   int
+	}{
+		{"token bucket", NewTokenBucket(Per(1, time.Hour), 3), 3},
+		{"sliding window", NewSlidingWindow(3, time.Hour), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < tt.limit; i++ {
+				d := tt.limiter.Allow("a")
+				if !d.Allowed {
+					t.Fatalf("request %d was rejected", i+1)
+				}
+				if want := tt.limit - i - 1; d.Remaining != want {
+					t.Errorf("request %d: remaining = %d, want %d", i+1, d.Remaining, want)
+				}
+			}
+
+			d := tt.limiter.Allow("a")
+			if d.Allowed {
+				t.Fatal("request over the limit was allowed")
+			}
+			if d.Remaining != 0 || d.RetryAfter <= 0 || d.Limit != tt.limit {
+				t.Errorf("rejected decision = %+v", d)
+			}
+
+			// キーが違えば別に数える
+			if !tt.limiter.Allow("b").Allowed {
+				t.Error("another key was rejected")
+			}
+		})
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	b := NewTokenBucket(1000, 1) // 1ms で1つ溜まる
+	if !b.Allow("k").Allowed {
+		t.Fatal("first request was rejected")
+	}
+	d := b.Allow("k")
+	if d.Allowed || d.RetryAfter > time.Millisecond {
+		t.Fatalf("second request: %+v", d)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if !b.Allow("k").Allowed {
+		t.Error("request after refill was rejected")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		proxies *TrustedProxies
+		remote  string
+		xff     []string
+		want    string
+	}{
+		{"direct", proxies, "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted peer ignores header", proxies, "203.0.113.5:1234", []string{"1.2.3.4"}, "203.0.113.5"},
+		{"nil proxies ignore header", nil, "10.0.0.1:1234", []string{"1.2.3.4"}, "10.0.0.1"},
+		{"through proxy", proxies, "10.0.0.1:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"spoofed left side is ignored", proxies, "10.0.0.1:1234", []string{"1.1.1.1, 198.51.100.7"}, "198.51.100.7"},
+		{"chain of proxies", proxies, "10.0.0.1:1234", []string{"198.51.100.7, 192.0.2.1", "10.1.1.1"}, "198.51.100.7"},
+		{"garbage stops the walk", proxies, "10.0.0.1:1234", []string{"198.51.100.7, junk, 10.2.2.2"}, "10.2.2.2"},
+		{"all trusted", proxies, "10.0.0.1:1234", []string{"10.3.3.3"}, "10.3.3.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := tt.proxies.ClientIP(r).String(); got != tt.want {
+				t.Errorf("ClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"not-an-ip", "10.0.0.0/99"} {
+		if _, err := ParseTrustedProxies(bad); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) should fail", bad)
+		}
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	user := func(r *http.Request) string { return r.Header.Get("X-Test-User") }
+	byUserOrIP := First(ByUser(user), ByIP(nil))
+
+	tests := []struct {
+		name   string
+		key    KeyFunc
+		remote string
+		header map[string]string
+		want   string
+	}{
+		{"ipv4", ByIP(nil), "203.0.113.5:1", nil, "ip:203.0.113.5"},
+		{"ipv6 is grouped by /64", ByIP(nil), "[2001:db8:1:2:aaaa::1]:1", nil, "ip:2001:db8:1:2::"},
+		{"user first", byUserOrIP, "203.0.113.5:1", map[string]string{"X-Test-User": "7"}, "user:7"},
+		{"falls back to ip", byUserOrIP, "203.0.113.5:1", nil, "ip:203.0.113.5"},
+		{"missing header", ByHeader("X-API-Key"), "203.0.113.5:1", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			if got := tt.key(r); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// ヘッダーの値はハッシュにして持つ
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-API-Key", "secret-value")
+	if key := ByHeader("X-API-Key")(r); !strings.HasPrefix(key, "hdr:x-api-key:") || strings.Contains(key, "secret-value") {
+		t.Errorf("header key = %q", key)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	strict := &Policy{Name: "login", Limiter: NewSlidingWindow(1, time.Hour), Key: ByIP(nil)}
+	loose := &Policy{Name: "api", Limiter: NewSlidingWindow(100, time.Hour), Key: ByIP(nil)}
+	handler := NewMiddleware([]Rule{
+		{Prefix: "/healthz"}, // 制限しない
+		{Method: http.MethodPost, Prefix: "/login", Policy: strict},
+		{Policy: loose},
+	}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantLimit    string
+	}{
+		{http.MethodPost, "/login", 200, "1"},
+		{http.MethodPost, "/login", 429, "1"},
+		{http.MethodGet, "/login", 200, "100"}, // メソッドが違うので次のルール
+		{http.MethodGet, "/healthz", 200, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.wantStatus || rec.Header().Get("RateLimit-Limit") != tt.wantLimit {
+			t.Errorf("%s %s: status = %d, RateLimit-Limit = %q", tt.method, tt.path, rec.Code, rec.Header().Get("RateLimit-Limit"))
+		}
+		if tt.wantStatus == 429 && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s %s: Retry-After is missing", tt.method, tt.path)
+		}
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		d         Decision
+		wantReset string
+		wantRetry string
+	}{
+		{"allowed", Decision{Allowed: true, Limit: 10, Remaining: 9, Window: time.Minute, Reset: 1500 * time.Millisecond}, "2", ""},
+		{"retry is rounded up", Decision{Limit: 10, Window: time.Minute, RetryAfter: 2100 * time.Millisecond}, "0", "3"},
+		{"retry is at least 1", Decision{Limit: 10, Window: time.Minute}, "0", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			SetHeaders(h, tt.d)
+			if h.Get("RateLimit-Policy") != "10;w=60" || h.Get("RateLimit-Reset") != tt.wantReset || h.Get("Retry-After") != tt.wantRetry {
+				t.Errorf("headers = %v", h)
+			}
+		})
+	}
+}
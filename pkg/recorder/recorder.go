@@ -0,0 +1,75 @@
+// Package recorder は http.ResponseWriter を包み、ハンドラーが返したステータスコードと本文のバイト数を記録する
+//
+// 【学習ポイント】
+//  1. ミドルウェアは ResponseWriter を埋め込んだ構造体で包み、WriteHeader / Write だけを横取りする
+//  2. 包むと http.Flusher や http.Hijacker の型アサーションが外側で止まってしまう。
+//     Flush / Hijack を用意し、http.ResponseController で内側まで届ける（SSE・WebSocket のため）
+//  3. Unwrap を用意すると、http.ResponseController（SetWriteDeadline など）が元の ResponseWriter を辿れる
+//  4. WriteHeader を呼ばずに Write すると net/http は 200 を送るので、記録も 200 にする
+//  5. メトリクス・トレース・アクセスログのように何重にも包んでも、それぞれが同じ方法で内側へ届く
+package recorder
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// Recorder はステータスコードと書き込んだバイト数を記録する http.ResponseWriter
+type Recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// New は w を包んだ Recorder を返す
+func New(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w}
+}
+
+func (rec *Recorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *Recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Flush は SSE などで書いた分をすぐに送る（内側が対応していなければ何もしない）
+func (rec *Recorder) Flush() {
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+// Hijack は WebSocket のハンドシェイクで呼ばれる。以降のステータスは 101 として記録する
+func (rec *Recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+func (rec *Recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Status は記録したステータスコード（何も書かなかったときは net/http と同じく 200）
+func (rec *Recorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// Bytes は書き込んだ本文のバイト数
+func (rec *Recorder) Bytes() int64 {
+	return rec.bytes
+}
@@ -0,0 +1,90 @@
+package recorder
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    func(w http.ResponseWriter)
+		wantStatus int
+		wantBytes  int64
+	}{
+		{"nothing written", func(w http.ResponseWriter) {}, 200, 0},
+		{"write without header", func(w http.ResponseWriter) { w.Write([]byte("hello")) }, 200, 5},
+		{"explicit status", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("nope"))
+		}, 404, 4},
+		{"first status wins", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusInternalServerError) // net/http も2回目は無視する
+		}, 201, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := New(httptest.NewRecorder())
+			tt.handler(rec)
+			if rec.Status() != tt.wantStatus || rec.Bytes() != tt.wantBytes {
+				t.Errorf("status=%d bytes=%d, want %d and %d", rec.Status(), rec.Bytes(), tt.wantStatus, tt.wantBytes)
+			}
+		})
+	}
+}
+
+// 何重に包んでも Flush が一番内側まで届く
+func TestFlushReachesInnerWriter(t *testing.T) {
+	inner := httptest.NewRecorder()
+	w := New(New(inner))
+	w.Write([]byte("data: x\n\n"))
+	if f, ok := http.ResponseWriter(w).(http.Flusher); !ok {
+		t.Fatal("Recorder does not implement http.Flusher")
+	} else {
+		f.Flush()
+	}
+	if !inner.Flushed {
+		t.Error("Flush did not reach the inner ResponseWriter")
+	}
+}
+
+// hijackWriter は Hijack に対応した ResponseWriter（httptest.ResponseRecorder は対応していない）
+type hijackWriter struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (h *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+func TestHijackRecordsSwitchingProtocols(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	rec := New(New(&hijackWriter{ResponseRecorder: httptest.NewRecorder(), conn: server}))
+	conn, _, err := http.NewResponseController(rec).Hijack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn != server {
+		t.Error("Hijack did not return the inner connection")
+	}
+	if rec.Status() != http.StatusSwitchingProtocols {
+		t.Errorf("status = %d, want 101", rec.Status())
+	}
+
+	// 内側が Hijack に対応していなければエラーになり、ステータスは変わらない
+	plain := New(httptest.NewRecorder())
+	if _, _, err := plain.Hijack(); err == nil {
+		t.Error("Hijack on a plain recorder should fail")
+	}
+	if plain.Status() != http.StatusOK {
+		t.Errorf("status = %d, want 200", plain.Status())
+	}
+}
@@ -0,0 +1,90 @@
+// Package signedtoken は HMAC-SHA256 で署名した有効期限付きトークンを発行・検証する
+//
+// 【学習ポイント】
+//  1. 中身（用途・ユーザーID・有効期限・追加情報）は平文のまま。秘密にするのではなく、改ざんを署名で検出する
+//  2. 署名の比較は hmac.Equal で行う（== だと一致した長さで時間が変わり、タイミング攻撃の手がかりになる）
+//  3. 用途（purpose）を署名に含めると、メール確認用のトークンをログインに使い回すような攻撃を防げる
+//  4. 追加情報に資格情報のバージョン（パスワード変更で増やす番号）などを入れると、パスワード変更で発行済みのトークンをまとめて無効にできる
+//  5. サーバーに状態を持たないので、鍵を知っている複数のサーバーで同じトークンを検証できる
+package signedtoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// エラーの文言はそのままクライアントへ返すことがある
+var (
+	ErrInvalid = errors.New("トークンが不正です")
+	ErrExpired = errors.New("トークンの有効期限が切れています")
+)
+
+// Signer は署名付きトークンを発行する
+// 形式: base64url("用途|ユーザーID|有効期限|追加情報") + "." + base64url(署名)
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// Sign は purpose 用のトークンを発行する。ttl が過ぎると Verify は ErrExpired を返す
+func (s *Signer) Sign(purpose string, userID int, extra string, ttl time.Duration) string {
+	payload := fmt.Sprintf("%s|%d|%d|%s", purpose, userID, time.Now().Add(ttl).Unix(), extra)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+// Verify は署名・用途・有効期限を確認し、ユーザーIDと追加情報を返す
+func (s *Signer) Verify(token, purpose string) (int, string, error) {
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, "", ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return 0, "", ErrInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return 0, "", ErrInvalid
+	}
+
+	// タイミング攻撃を避けるため hmac.Equal で比較する
+	if !hmac.Equal(sig, s.mac(string(payload))) {
+		return 0, "", ErrInvalid
+	}
+
+	parts := strings.SplitN(string(payload), "|", 4)
+	if len(parts) != 4 || parts[0] != purpose {
+		return 0, "", ErrInvalid
+	}
+
+	userID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, "", ErrInvalid
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalid
+	}
+	if time.Now().Unix() > expires {
+		return 0, "", ErrExpired
+	}
+
+	return userID, parts[3], nil
+}
+
+func (s *Signer) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
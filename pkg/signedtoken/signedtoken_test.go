@@ -0,0 +1,47 @@
+package signedtoken
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	valid := signer.Sign("session", 42, "fp|with|bars", time.Hour)
+
+	// 署名はそのままで中身だけ書き換えたトークン
+	_, sig, _ := strings.Cut(valid, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte("session|1|9999999999|fp")) + "." + sig
+
+	tests := []struct {
+		name      string
+		token     string
+		purpose   string
+		wantID    int
+		wantExtra string
+		wantErr   error
+	}{
+		{"valid", valid, "session", 42, "fp|with|bars", nil},
+		{"wrong purpose", valid, "reset", 0, "", ErrInvalid},
+		{"other key", NewSigner([]byte("other")).Sign("session", 42, "", time.Hour), "session", 0, "", ErrInvalid},
+		{"forged payload", forged, "session", 0, "", ErrInvalid},
+		{"expired", signer.Sign("session", 42, "", -time.Minute), "session", 0, "", ErrExpired},
+		{"no separator", "abc", "session", 0, "", ErrInvalid},
+		{"bad base64", "!!!.???", "session", 0, "", ErrInvalid},
+		{"empty", "", "session", 0, "", ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, extra, err := signer.Verify(tt.token, tt.purpose)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if id != tt.wantID || extra != tt.wantExtra {
+				t.Errorf("got (%d, %q), want (%d, %q)", id, extra, tt.wantID, tt.wantExtra)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+// Package slugify はタイトルから URL に使える文字列（スラッグ）を作る
+//
+// 【学習ポイント】
+// 1. スラッグは英小文字・数字・ハイフンだけにすると、エスケープせずに URL に入れられる
+// 2. ひらがな・カタカナはローマ字（ヘボン式に近い形）に変換する。カタカナはひらがなと同じ並びなので 0x60 ずらせば同じ表で引ける
+// 3. 漢字は辞書がないと読めないので、読めない文字があればタイトルの短いハッシュを付けて区別する
+package slugify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxLength はスラッグの最大長
+const MaxLength = 60
+
+// Make はタイトルからスラッグを作る。
+// 例: "Hello, World!" → "hello-world"、"ゴルーチン入門" → "goruchin-a1b2c3"、"日本語" → "post-a1b2c3d4"
+func Make(title string) string {
+	base, lossy := romanize(title)
+
+	sum := sha256.Sum256([]byte(title))
+	hash := hex.EncodeToString(sum[:])
+	if base == "" {
+		return "post-" + hash[:8]
+	}
+
+	suffix := ""
+	if lossy {
+		// 読めなかった文字があるタイトル同士が同じスラッグにならないよう、ハッシュで区別する
+		suffix = "-" + hash[:6]
+	}
+	return truncate(base, MaxLength-len(suffix)) + suffix
+}
+
+// Valid は手動で指定されたスラッグが使えるか（英小文字・数字と、途中のハイフンのみ）
+func Valid(s string) bool {
+	if s == "" || len(s) > MaxLength || s[0] == '-' || s[len(s)-1] == '-' || strings.Contains(s, "--") {
+		return false
+	}
+	return strings.Trim(s, "abcdefghijklmnopqrstuvwxyz0123456789-") == ""
+}
+
+// truncate は単語（ハイフン）の区切りで max 文字以内に縮める
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	s = s[:max]
+	if i := strings.LastIndexByte(s, '-'); i > 0 {
+		s = s[:i]
+	}
+	return strings.Trim(s, "-")
+}
+
+// romanize はタイトルを英小文字・数字・ハイフンに変換する。
+// 記号や空白はハイフンに、ローマ字にできない文字（漢字など）は取り除いて lossy を true にする
+func romanize(title string) (slug string, lossy bool) {
+	var b strings.Builder
+	pendingHyphen := false
+	write := func(s string) {
+		if pendingHyphen && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		pendingHyphen = false
+		b.WriteString(s)
+	}
+
+	runes := []rune(title)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		// 全角英数字（Ａ, ０ など）は半角にする
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			write(string(r))
+		case r >= 'A' && r <= 'Z':
+			write(string(r + 'a' - 'A'))
+		case r == '\'' || r == '’':
+			// don't → dont
+		case latin[r] != "":
+			write(latin[r])
+		case isKana(r):
+			// 漢字に挟まれた1文字のかな（「最初の投稿」の「の」など）は、読めても意味をなさないので捨てる
+			single := (i == 0 || !isKana(runes[i-1])) && (i+1 == len(runes) || !isKana(runes[i+1]))
+			nearKanji := i > 0 && isKanji(runes[i-1]) || i+1 < len(runes) && isKanji(runes[i+1])
+			if single && nearKanji {
+				lossy = true
+				pendingHyphen = true
+				continue
+			}
+			kana, n := romanizeKana(runes[i:])
+			if kana != "" {
+				write(kana)
+			}
+			i += n - 1
+		case r == 'ー':
+			// 長音は省略する（サーバー → saba）
+		case r < utf8.RuneSelf || isSeparator(r):
+			pendingHyphen = true
+		default:
+			lossy = true
+			pendingHyphen = true
+		}
+	}
+	return b.String(), lossy
+}
+
+// isKanji は CJK 統合漢字（と「々」）か
+func isKanji(r rune) bool {
+	return r >= 0x4E00 && r <= 0x9FFF || r >= 0x3400 && r <= 0x4DBF || r == '々'
+}
+
+// isSeparator は単語の区切りとして扱う全角の記号・空白か
+func isSeparator(r rune) bool {
+	return r == '　' || r >= 0x3001 && r <= 0x303F || r >= 0xFF01 && r <= 0xFF65 || r == '・'
+}
+
+// アクセント付きのラテン文字
+var latin = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae",
+	'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ñ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'œ': "oe",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y", 'ÿ': "y", 'ß': "ss",
+	'À': "a", 'Á': "a", 'Â': "a", 'Ã': "a", 'Ä': "a", 'Å': "a", 'Æ': "ae",
+	'Ç': "c", 'È': "e", 'É': "e", 'Ê': "e", 'Ë': "e",
+	'Ì': "i", 'Í': "i", 'Î': "i", 'Ï': "i", 'Ñ': "n",
+	'Ò': "o", 'Ó': "o", 'Ô': "o", 'Õ': "o", 'Ö': "o", 'Ø': "o", 'Œ': "oe",
+	'Ù': "u", 'Ú': "u", 'Û': "u", 'Ü': "u", 'Ý': "y",
+}
+
+// ========== かな → ローマ字 ==========
+
+// ひらがなの表（カタカナはひらがなに直してから引く）
+var hiragana = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+	// 小書きの文字（単独で現れたとき）
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+	'ゃ': "ya", 'ゅ': "yu", 'ょ': "yo", 'ゎ': "wa",
+}
+
+// 拗音の小書き文字と、外来語の小書きの母音
+var (
+	smallY      = map[rune]string{'ゃ': "a", 'ゅ': "u", 'ょ': "o"}
+	smallVowels = map[rune]string{'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o"}
+)
+
+func isKana(r rune) bool {
+	return r >= 'ぁ' && r <= 'ゖ' || r >= 'ァ' && r <= 'ヶ'
+}
+
+// toHiragana はカタカナをひらがなにする（ヷ〜ヺなどの表にない文字はそのまま）
+func toHiragana(r rune) rune {
+	if r >= 'ァ' && r <= 'ヶ' {
+		return r - 0x60
+	}
+	return r
+}
+
+// romanizeKana は先頭のかな1文字（拗音「きゃ」などは2文字）をローマ字にし、読んだ文字数を返す
+func romanizeKana(runes []rune) (string, int) {
+	r := toHiragana(runes[0])
+
+	// 促音「っ」は次の子音を重ねる（がっこう → gakkou、まっち → matchi）
+	if r == 'っ' {
+		if len(runes) > 1 && isKana(runes[1]) {
+			next, n := romanizeKana(runes[1:])
+			if next != "" && !strings.ContainsRune("aiueon", rune(next[0])) {
+				double := next[:1]
+				if strings.HasPrefix(next, "ch") {
+					double = "t"
+				}
+				return double + next, n + 1
+			}
+			return next, n + 1
+		}
+		return "", 1
+	}
+
+	roman, ok := hiragana[r]
+	if !ok {
+		return "", 1
+	}
+	if len(runes) < 2 {
+		return roman, 1
+	}
+
+	// 拗音: い段 + ゃゅょ（きゃ → kya、しゃ → sha、ちゃ → cha、じゃ → ja）
+	next := toHiragana(runes[1])
+	if small := smallY[next]; small != "" && strings.HasSuffix(roman, "i") && len(roman) > 1 {
+		stem := strings.TrimSuffix(roman, "i")
+		switch stem {
+		case "sh", "ch", "j":
+			return stem + small, 2
+		}
+		return stem + "y" + small, 2
+	}
+
+	// 外来語の小さい母音: ファ → fa、ティ → ti、ウィ → wi、ヴァ → va
+	if small := smallVowels[next]; small != "" {
+		stem := roman[:len(roman)-1]
+		switch roman {
+		case "u":
+			stem = "w"
+		case "fu":
+			stem = "f"
+		case "vu":
+			stem = "v"
+		case "tsu":
+			stem = "ts"
+		case "shi":
+			stem = "sh"
+		case "chi":
+			stem = "ch"
+		case "ji":
+			stem = "j"
+		}
+		return stem + small, 2
+	}
+	return roman, 1
+}
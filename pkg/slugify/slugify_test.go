@@ -0,0 +1,106 @@
+package slugify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// hashOf は Make がタイトルから付けるハッシュの先頭 n 文字
+func hashOf(title string, n int) string {
+	sum := sha256.Sum256([]byte(title))
+	return hex.EncodeToString(sum[:])[:n]
+}
+
+func TestMake(t *testing.T) {
+	long := strings.Repeat("word ", 20)
+
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{"ascii", "Hello, World!", "hello-world"},
+		{"numbers", "Go 1.21 released", "go-1-21-released"},
+		{"trims separators", "  --Leading and trailing--  ", "leading-and-trailing"},
+		{"apostrophe is dropped", "Don't panic", "dont-panic"},
+		{"accents", "Café Crème", "cafe-creme"},
+		{"full-width alphanumerics", "ＧＯ１２３", "go123"},
+		{"full-width separators", "Go　入門、基礎", "go-" + hashOf("Go　入門、基礎", 6)},
+
+		{"katakana with long vowel", "ゴルーチン", "goruchin"},
+		{"long vowel at the end", "サーバー", "saba"},
+		{"sokuon", "がっこう", "gakkou"},
+		{"sokuon before chi", "マッチ", "matchi"},
+		{"youon", "きゃりー", "kyari"},
+		{"youon sh ch j", "しゃちゅじょ", "shachujo"},
+		{"small vowels", "ファイル", "fairu"},
+		{"ti", "ティー", "ti"},
+		{"wi", "ウィキ", "wiki"},
+		{"vu", "ヴァイオリン", "vaiorin"},
+		{"lone sokuon", "っ", "post-" + hashOf("っ", 8)},
+		{"kana words", "はじめての ごー", "hajimeteno-go"},
+
+		{"kanji only falls back to a hash", "日本語", "post-" + hashOf("日本語", 8)},
+		{"single kana between kanji is dropped", "最初の投稿", "post-" + hashOf("最初の投稿", 8)},
+		{"partly readable gets a short hash", "Go言語の入門", "go-" + hashOf("Go言語の入門", 6)},
+		{"kana next to kanji is kept when it joins a kana word", "並行処理とゴルーチン", "togoruchin-" + hashOf("並行処理とゴルーチン", 6)},
+		{"emoji", "Go 🚀", "go-" + hashOf("Go 🚀", 6)},
+
+		{"truncated at a word boundary", long, strings.TrimSuffix(strings.Repeat("word-", 12), "-")},
+		{"hash suffix fits in the limit", long + "日本", strings.TrimSuffix(strings.Repeat("word-", 10), "-") + "-" + hashOf(long+"日本", 6)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Make(tt.title)
+			if got != tt.want {
+				t.Errorf("Make(%q) = %q, want %q", tt.title, got, tt.want)
+			}
+			if !Valid(got) {
+				t.Errorf("Make(%q) = %q is not Valid", tt.title, got)
+			}
+		})
+	}
+}
+
+// TestMakeDistinguishesUnreadableTitles は読めない文字だけが違うタイトルが別のスラッグになることを確かめる
+func TestMakeDistinguishesUnreadableTitles(t *testing.T) {
+	pairs := [][2]string{
+		{"Go言語", "Go入門"},
+		{"日本", "東京"},
+	}
+	for _, p := range pairs {
+		if a, b := Make(p[0]), Make(p[1]); a == b {
+			t.Errorf("Make(%q) and Make(%q) are both %q", p[0], p[1], a)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		slug string
+		want bool
+	}{
+		{"hello-world", true},
+		{"a", true},
+		{"go-1-21", true},
+		{strings.Repeat("a", MaxLength), true},
+
+		{"", false},
+		{strings.Repeat("a", MaxLength+1), false},
+		{"-hello", false},
+		{"hello-", false},
+		{"hello--world", false},
+		{"Hello", false},
+		{"hello_world", false},
+		{"hello world", false},
+		{"../etc", false},
+		{"日本", false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.slug); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.slug, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Package trace は W3C Trace Context（traceparent / tracestate）を受け渡し、
+// プロセス内のスパン（処理の区間）を記録して、終わったトレースを Exporter に渡す
+//
+// 【学習ポイント】
+// 1. traceparent は「バージョン-トレースID-親スパンID-フラグ」。サービスをまたいでも同じトレースIDを引き継ぐ
+// 2. スパンは context.Context で受け渡す。子のスパンは context の中のスパンを親にする
+// 3. 受け取ったヘッダーが不正なら捨てて新しいトレースを始める（エラーにはしない）
+// 4. 送信側は http.RoundTripper で包み、呼び出し側のコードを変えずにヘッダーを付ける
+//
+// 仕様: https://www.w3.org/TR/trace-context/
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ヘッダー名
+const (
+	TraceparentHeader   = "traceparent"
+	TracestateHeader    = "tracestate"
+	TraceresponseHeader = "traceresponse" // Trace Context Level 2。サーバーが使ったトレースIDを返す
+)
+
+// FlagSampled は traceparent の trace-flags の「記録する」ビット
+const FlagSampled byte = 0x01
+
+// maxTracestateMembers は tracestate に入れられる要素の数（仕様の上限）
+const maxTracestateMembers = 32
+
+// TraceID はトレース全体の ID（16バイト）
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// IsValid はすべて 0 ではないか（0 は仕様で不正）
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// SpanID はスパンの ID（8バイト）
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext はプロセスをまたいで受け渡す情報
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string // ベンダーごとの情報。中身は解釈せずにそのまま次へ渡す
+}
+
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+func (sc SpanContext) Sampled() bool { return sc.Flags&FlagSampled != 0 }
+
+// Traceparent は traceparent ヘッダーの値を返す
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+var errTraceparent = errors.New("trace: traceparent の形式が不正です")
+
+// ParseTraceparent は traceparent ヘッダーを解析する。
+// バージョン 00 は長さが厳密に 55 文字。未知の新しいバージョンは、後ろに "-" で続く部分を無視して先頭の4項目だけを読む
+func ParseTraceparent(s string) (SpanContext, error) {
+	if len(s) < 55 {
+		return SpanContext{}, errTraceparent
+	}
+	version, ok := parseHex(s[0:2])
+	if !ok || version == 0xff || s[2] != '-' {
+		return SpanContext{}, errTraceparent
+	}
+	if version == 0 && len(s) != 55 {
+		return SpanContext{}, errTraceparent
+	}
+	if version > 0 && len(s) > 55 && s[55] != '-' {
+		return SpanContext{}, errTraceparent
+	}
+	if s[35] != '-' || s[52] != '-' {
+		return SpanContext{}, errTraceparent
+	}
+
+	var sc SpanContext
+	if !decodeLowerHex(sc.TraceID[:], s[3:35]) || !decodeLowerHex(sc.SpanID[:], s[36:52]) {
+		return SpanContext{}, errTraceparent
+	}
+	flags, ok := parseHex(s[53:55])
+	if !ok {
+		return SpanContext{}, errTraceparent
+	}
+	sc.Flags = flags
+	if !sc.IsValid() {
+		return SpanContext{}, errTraceparent
+	}
+	return sc, nil
+}
+
+// ParseTracestate は tracestate ヘッダーを検証して正規化する。
+// 空の要素は取り除き、不正な要素が1つでもあればヘッダー全体を捨てる（仕様どおり）
+func ParseTracestate(s string) string {
+	var members []string
+	seen := make(map[string]bool)
+	for _, m := range strings.Split(s, ",") {
+		m = strings.Trim(m, " \t")
+		if m == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(m, "=")
+		if !ok || !validTracestateKey(key) || !validTracestateValue(value) || seen[key] {
+			return ""
+		}
+		seen[key] = true
+		members = append(members, m)
+	}
+	if len(members) > maxTracestateMembers {
+		return ""
+	}
+	return strings.Join(members, ",")
+}
+
+// validTracestateKey は小文字・数字・_-*/ と、マルチテナント用の "テナント@システム" を許す
+func validTracestateKey(key string) bool {
+	if key == "" || len(key) > 256 {
+		return false
+	}
+	tenant, system, multi := strings.Cut(key, "@")
+	if multi && (tenant == "" || system == "" || len(tenant) > 241 || len(system) > 14) {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '_' || c == '-' || c == '*' || c == '/' || c == '@':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validTracestateValue は印字可能な ASCII（"," と "=" を除く）で、最後が空白でないこと
+func validTracestateValue(value string) bool {
+	if value == "" || len(value) > 256 || value[len(value)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c > 0x7e || c == ',' || c == '=' {
+			return false
+		}
+	}
+	return true
+}
+
+func parseHex(s string) (byte, bool) {
+	var b [1]byte
+	if !decodeLowerHex(b[:], s) {
+		return 0, false
+	}
+	return b[0], true
+}
+
+// decodeLowerHex は小文字の16進数だけを受け付ける（仕様で大文字は不正）
+func decodeLowerHex(dst []byte, s string) bool {
+	if len(s) != len(dst)*2 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
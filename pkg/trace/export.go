@@ -0,0 +1,155 @@
+package trace
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ========== リングバッファ ==========
+
+// RingBuffer は直近のトレースをメモリに保持する Exporter。古いものから上書きする
+type RingBuffer struct {
+	mu     sync.RWMutex
+	traces []Trace
+	next   int
+	full   bool
+}
+
+// NewRingBuffer は size 件まで保持する RingBuffer を作る
+func NewRingBuffer(size int) *RingBuffer {
+	if size <= 0 {
+		size = 1
+	}
+	return &RingBuffer{traces: make([]Trace, size)}
+}
+
+func (b *RingBuffer) Export(t Trace) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.traces[b.next] = t
+	b.next = (b.next + 1) % len(b.traces)
+	if b.next == 0 {
+		b.full = true
+	}
+	return nil
+}
+
+// Traces は保持しているトレースを新しい順に返す
+func (b *RingBuffer) Traces() []Trace {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n := b.next
+	if b.full {
+		n = len(b.traces)
+	}
+	out := make([]Trace, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, b.traces[(b.next-i+len(b.traces))%len(b.traces)])
+	}
+	return out
+}
+
+// Find はトレースIDで探す（同じトレースが複数回書き出されていれば新しいもの）
+func (b *RingBuffer) Find(traceID string) (Trace, bool) {
+	for _, t := range b.Traces() {
+		if t.TraceID == traceID {
+			return t, true
+		}
+	}
+	return Trace{}, false
+}
+
+// traceSummary は一覧に出す項目（スパンは含めない）
+type traceSummary struct {
+	TraceID    string    `json:"trace_id"`
+	Root       string    `json:"root"`
+	Start      time.Time `json:"start"`
+	DurationMS float64   `json:"duration_ms"`
+	Status     string    `json:"status"`
+	Spans      int       `json:"spans"`
+}
+
+// Handler は保持しているトレースを JSON で返すハンドラー。
+// 既定では新しい順の一覧（?limit=、?status=error で絞り込み）、?trace_id= で1件のスパンまで返す
+func (b *RingBuffer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+
+		q := r.URL.Query()
+		if id := q.Get("trace_id"); id != "" {
+			t, ok := b.Find(id)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "trace not found"})
+				return
+			}
+			json.NewEncoder(w).Encode(t)
+			return
+		}
+
+		limit := 50
+		if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+			limit = n
+		}
+		status := q.Get("status")
+		summaries := []traceSummary{}
+		for _, t := range b.Traces() {
+			if len(summaries) >= limit {
+				break
+			}
+			if status != "" && t.Status != status {
+				continue
+			}
+			summaries = append(summaries, traceSummary{
+				TraceID:    t.TraceID,
+				Root:       t.Root,
+				Start:      t.Start,
+				DurationMS: t.DurationMS,
+				Status:     t.Status,
+				Spans:      len(t.Spans),
+			})
+		}
+		json.NewEncoder(w).Encode(summaries)
+	})
+}
+
+// ========== JSON ファイル ==========
+
+// JSONFileExporter はトレースを1行1件の JSON（JSON Lines）でファイルに追記する
+type JSONFileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// OpenJSONFile は path を追記モードで開く
+func OpenJSONFile(path string) (*JSONFileExporter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONFileExporter{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (e *JSONFileExporter) Export(t Trace) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(t)
+}
+
+func (e *JSONFileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
@@ -0,0 +1,104 @@
+package trace
+
+import (
+	"fmt"
+	"net/http"
+
+	"learn-go/pkg/recorder"
+)
+
+// ========== サーバー ==========
+
+// Middleware は受け取った traceparent / tracestate を親にしてサーバーのスパンを作るミドルウェアを返す。
+// ヘッダーがない・不正なときは新しいトレースを始める。
+// route はスパン名に使う経路（URL そのものだと名前が増え続けるので、登録パターンにまとめる）
+func (t *Tracer) Middleware(route func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var opts []SpanOption
+			if remote, err := ParseTraceparent(r.Header.Get(TraceparentHeader)); err == nil {
+				// tracestate は traceparent が正しいときだけ引き継ぐ
+				remote.TraceState = ParseTracestate(r.Header.Get(TracestateHeader))
+				opts = append(opts, WithRemoteParent(remote))
+			}
+			ctx, span := t.Start(r.Context(), r.Method, append(opts, WithKind(KindServer))...)
+			defer span.End()
+
+			w.Header().Set(TraceresponseHeader, span.Context().Traceparent())
+			rec := recorder.New(w)
+			r = r.WithContext(ctx)
+			next.ServeHTTP(rec, r)
+
+			label := route(r)
+			span.mu.Lock()
+			span.data.Name = r.Method + " " + label
+			span.mu.Unlock()
+			span.SetAttr("http.method", r.Method)
+			span.SetAttr("http.route", label)
+			span.SetAttr("http.target", r.URL.Path)
+			span.SetAttr("http.status_code", rec.Status())
+			if rec.Status() >= 500 {
+				span.SetError(fmt.Errorf("HTTP %d", rec.Status()))
+			}
+		})
+	}
+}
+
+// ========== クライアント ==========
+
+// Transport は送信するリクエストに traceparent / tracestate を付ける http.RoundTripper。
+// リクエストの context にスパンがあればその子、なければ Tracer で新しいトレースを始める。
+// Tracer も nil なら記録はせず、新しい ID のヘッダーだけを付ける（受け取った側のログと突き合わせられる）
+type Transport struct {
+	Base   http.RoundTripper // nil なら http.DefaultTransport
+	Tracer *Tracer
+}
+
+// NewTransport は base を包んだ Transport を返す
+func NewTransport(base http.RoundTripper, tracer *Tracer) *Transport {
+	return &Transport{Base: base, Tracer: tracer}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := "HTTP " + req.Method + " " + req.URL.Host
+	ctx := req.Context()
+
+	var span *Span
+	switch {
+	case SpanFromContext(ctx) != nil:
+		ctx, span = Start(ctx, name, WithKind(KindClient))
+	case t.Tracer != nil:
+		ctx, span = t.Tracer.Start(ctx, name, WithKind(KindClient))
+	default:
+		ctx, span = NewTracer(nil).Start(ctx, name, WithKind(KindClient))
+	}
+	defer span.End()
+
+	// RoundTripper は受け取ったリクエストを書き換えてはいけないので、複製してヘッダーを付ける
+	out := req.Clone(ctx)
+	sc := span.Context()
+	out.Header.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		out.Header.Set(TracestateHeader, sc.TraceState)
+	} else {
+		out.Header.Del(TracestateHeader)
+	}
+
+	span.SetAttr("http.method", req.Method)
+	span.SetAttr("http.url", req.URL.Redacted())
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(out)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttr("http.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		span.SetError(fmt.Errorf("HTTP %d", resp.StatusCode))
+	}
+	return resp, nil
+}
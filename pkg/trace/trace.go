@@ -0,0 +1,272 @@
+package trace
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// MaxSpansPerTrace は1つのトレースに記録するスパンの上限（超えた分は数だけ数える）
+const MaxSpansPerTrace = 1000
+
+// スパンの種類
+const (
+	KindServer   = "server"   // 受け取ったリクエストの処理
+	KindClient   = "client"   // 外部への呼び出し
+	KindInternal = "internal" // プロセス内の処理
+)
+
+// スパンの状態
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// SpanData は終わったスパン1つの記録
+type SpanData struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	Start      time.Time              `json:"start"`
+	DurationMS float64                `json:"duration_ms"`
+	Status     string                 `json:"status"`
+	Error      string                 `json:"error,omitempty"`
+	Attrs      map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// Trace はこのプロセスで記録した1つのトレース（最初のスパンとその子孫）
+type Trace struct {
+	TraceID      string     `json:"trace_id"`
+	Root         string     `json:"root"`
+	Start        time.Time  `json:"start"`
+	DurationMS   float64    `json:"duration_ms"`
+	Status       string     `json:"status"`
+	Spans        []SpanData `json:"spans"`
+	DroppedSpans int        `json:"dropped_spans,omitempty"`
+}
+
+// Exporter は終わったトレースの送り先
+type Exporter interface {
+	Export(t Trace) error
+}
+
+// Tracer はスパンを作り、トレースが終わったら Exporter に渡す
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer は exp に書き出す Tracer を作る（exp が nil なら ID の受け渡しだけを行い、記録しない）
+func NewTracer(exp Exporter) *Tracer {
+	return &Tracer{exporter: exp}
+}
+
+// recording はプロセス内で1つのトレースに属するスパンを集める
+type recording struct {
+	mu       sync.Mutex
+	spans    []SpanData
+	dropped  int
+	exported bool
+}
+
+// Span は記録中の区間。nil でもメソッドを呼べる（何もしない）
+type Span struct {
+	tracer *Tracer
+	rec    *recording // nil なら記録しない（サンプリングされなかった）
+	root   bool       // このプロセスでの最初のスパン。終わったらトレースを書き出す
+	sc     SpanContext
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanOption は Start の設定
+type SpanOption func(*spanConfig)
+
+type spanConfig struct {
+	kind   string
+	remote SpanContext
+}
+
+// WithKind はスパンの種類（KindServer / KindClient / KindInternal）を指定する
+func WithKind(kind string) SpanOption {
+	return func(c *spanConfig) { c.kind = kind }
+}
+
+// WithRemoteParent は他のプロセスから受け取った SpanContext を親にする
+func WithRemoteParent(sc SpanContext) SpanOption {
+	return func(c *spanConfig) { c.remote = sc }
+}
+
+type spanKey struct{}
+
+// SpanFromContext は ctx のスパンを返す（なければ nil）
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// ContextWithSpan は span を入れた context を返す
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// Start は ctx のスパンの子を作る。ctx にスパンがなければ何も記録しない（nil を返す）。
+// リポジトリなど、トレースの有無を気にせずに呼べるようにするための関数
+func Start(ctx context.Context, name string, opts ...SpanOption) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, opts...)
+}
+
+// Start はスパンを開始する。親は ctx のスパン、なければ WithRemoteParent、どちらもなければ新しいトレースを始める。
+// 受け取った traceparent が「記録しない」（sampled=0）なら、ID は引き継ぐが記録はしない
+func (t *Tracer) Start(ctx context.Context, name string, opts ...SpanOption) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cfg := spanConfig{kind: KindInternal}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	span := &Span{tracer: t}
+	var parentID SpanID
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.sc = parent.sc
+		span.rec = parent.rec
+		parentID = parent.sc.SpanID
+	} else if cfg.remote.IsValid() {
+		span.sc = cfg.remote
+		span.root = true
+		parentID = cfg.remote.SpanID
+		if cfg.remote.Sampled() && t.exporter != nil {
+			span.rec = &recording{}
+		}
+	} else {
+		span.sc = SpanContext{TraceID: newTraceID(), Flags: FlagSampled}
+		span.root = true
+		if t.exporter != nil {
+			span.rec = &recording{}
+		}
+	}
+	span.sc.SpanID = newSpanID()
+
+	span.data = SpanData{
+		TraceID: span.sc.TraceID.String(),
+		SpanID:  span.sc.SpanID.String(),
+		Name:    name,
+		Kind:    cfg.kind,
+		Start:   time.Now(),
+		Status:  StatusOK,
+	}
+	if parentID.IsValid() {
+		span.data.ParentID = parentID.String()
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// Context はヘッダーで送る SpanContext を返す
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// TraceID はトレースIDの文字列（スパンがなければ空）。ログに載せて突き合わせに使う
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.data.TraceID
+}
+
+// SetAttr は属性を設定する
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil || s.rec == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attrs == nil {
+		s.data.Attrs = make(map[string]interface{})
+	}
+	s.data.Attrs[key] = value
+}
+
+// SetError はスパンを失敗にする（err が nil なら何もしない）
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status = StatusError
+	s.data.Error = err.Error()
+}
+
+// End はスパンを終える。2回目以降の呼び出しは無視する。
+// このプロセスでの最初のスパンが終わるとトレースを書き出すので、それより後に終わった子のスパンは記録されない
+// （リクエストの後も続くバックグラウンド処理は、別のトレースとして始める）
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.DurationMS = float64(time.Since(s.data.Start).Microseconds()) / 1000
+	data := s.data
+	s.mu.Unlock()
+
+	if s.rec == nil {
+		return
+	}
+	s.rec.mu.Lock()
+	if s.rec.exported {
+		s.rec.mu.Unlock()
+		return
+	}
+	if len(s.rec.spans) < MaxSpansPerTrace {
+		s.rec.spans = append(s.rec.spans, data)
+	} else {
+		s.rec.dropped++
+	}
+	if !s.root {
+		s.rec.mu.Unlock()
+		return
+	}
+	s.rec.exported = true
+	t := Trace{
+		TraceID:      data.TraceID,
+		Root:         data.Name,
+		Start:        data.Start,
+		DurationMS:   data.DurationMS,
+		Status:       StatusOK,
+		Spans:        s.rec.spans,
+		DroppedSpans: s.rec.dropped,
+	}
+	s.rec.spans = nil
+	s.rec.mu.Unlock()
+
+	for _, sp := range t.Spans {
+		if sp.Status == StatusError {
+			t.Status = StatusError
+		}
+	}
+	if err := s.tracer.exporter.Export(t); err != nil {
+		slog.Warn("トレースの書き出しに失敗しました", "trace_id", t.TraceID, "err", err)
+	}
+}
@@ -0,0 +1,219 @@
+package trace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		wantErr     bool
+		wantSampled bool
+	}{
+		{"sampled", "00-" + testTraceID + "-" + testSpanID + "-01", false, true},
+		{"not sampled", "00-" + testTraceID + "-" + testSpanID + "-00", false, false},
+		{"unknown flag bits are kept", "00-" + testTraceID + "-" + testSpanID + "-09", false, true},
+		{"future version", "cc-" + testTraceID + "-" + testSpanID + "-01", false, true},
+		{"future version with more fields", "cc-" + testTraceID + "-" + testSpanID + "-01-what-the-future-holds", false, true},
+
+		{"empty", "", true, false},
+		{"too short", "00-" + testTraceID + "-" + testSpanID + "-1", true, false},
+		{"version 00 with extra fields", "00-" + testTraceID + "-" + testSpanID + "-01-extra", true, false},
+		{"future version without a separator", "cc-" + testTraceID + "-" + testSpanID + "-01extra", true, false},
+		{"version ff", "ff-" + testTraceID + "-" + testSpanID + "-01", true, false},
+		{"uppercase hex", "00-" + strings.ToUpper(testTraceID) + "-" + testSpanID + "-01", true, false},
+		{"zero trace id", "00-00000000000000000000000000000000-" + testSpanID + "-01", true, false},
+		{"zero span id", "00-" + testTraceID + "-0000000000000000-01", true, false},
+		{"wrong separator", "00_" + testTraceID + "-" + testSpanID + "-01", true, false},
+		{"non-hex flags", "00-" + testTraceID + "-" + testSpanID + "-0g", true, false},
+		{"non-hex trace id", "00-" + strings.Replace(testTraceID, "4", "x", 1) + "-" + testSpanID + "-01", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.header)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseTraceparent(%q) = %+v, want an error", tt.header, sc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTraceparent(%q): %v", tt.header, err)
+			}
+			if sc.TraceID.String() != testTraceID || sc.SpanID.String() != testSpanID {
+				t.Errorf("ids = %s / %s, want %s / %s", sc.TraceID, sc.SpanID, testTraceID, testSpanID)
+			}
+			if sc.Sampled() != tt.wantSampled {
+				t.Errorf("Sampled() = %v, want %v", sc.Sampled(), tt.wantSampled)
+			}
+			// 送り出すときはいつもバージョン 00 で、先頭の4項目だけになる
+			if got, want := sc.Traceparent(), "00"+tt.header[2:55]; got != want {
+				t.Errorf("Traceparent() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseTracestate(t *testing.T) {
+	many := func(n int) string {
+		members := make([]string, n)
+		for i := range members {
+			members[i] = "k" + strings.Repeat("x", i) + "=v"
+		}
+		return strings.Join(members, ",")
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"two vendors", "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7"},
+		{"spaces and empty members are dropped", " congo=t61rcWkgMzE ,, \trojo=1 ", "congo=t61rcWkgMzE,rojo=1"},
+		{"multi-tenant key", "tenant@vendor=abc", "tenant@vendor=abc"},
+		{"value with spaces inside", "a=b c", "a=b c"},
+		{"empty", "", ""},
+		{"32 members", many(32), many(32)},
+
+		{"33 members", many(33), ""},
+		{"uppercase key", "Congo=1", ""},
+		{"duplicate key", "a=1,b=2,a=3", ""},
+		{"no equals sign", "a=1,b", ""},
+		{"equals in value", "a=b=c", ""},
+		{"empty value", "a=", ""},
+		{"empty tenant", "@vendor=1", ""},
+		{"system too long", "t@" + strings.Repeat("s", 15) + "=1", ""},
+		{"key too long", strings.Repeat("k", 257) + "=1", ""},
+		{"non-ascii value", "a=日本", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseTracestate(tt.header); got != tt.want {
+				t.Errorf("ParseTracestate(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPropagation は受け取った traceparent / tracestate が、サーバーのスパンと外向きの呼び出しに引き継がれることを確かめる
+func TestPropagation(t *testing.T) {
+	var outgoing http.Header
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outgoing = r.Header.Clone()
+	}))
+	defer downstream.Close()
+
+	tests := []struct {
+		name         string
+		traceparent  string
+		tracestate   string
+		wantTraceID  string // 空なら新しいトレース
+		wantState    string
+		wantRecorded bool
+		wantParentID string
+	}{
+		{
+			name:         "sampled parent",
+			traceparent:  "00-" + testTraceID + "-" + testSpanID + "-01",
+			tracestate:   "congo=t61rcWkgMzE, rojo=1",
+			wantTraceID:  testTraceID,
+			wantState:    "congo=t61rcWkgMzE,rojo=1",
+			wantRecorded: true,
+			wantParentID: testSpanID,
+		},
+		{
+			name:        "unsampled parent keeps the ids but is not recorded",
+			traceparent: "00-" + testTraceID + "-" + testSpanID + "-00",
+			tracestate:  "congo=t61rcWkgMzE",
+			wantTraceID: testTraceID,
+			wantState:   "congo=t61rcWkgMzE",
+		},
+		{
+			name:         "invalid tracestate is dropped",
+			traceparent:  "00-" + testTraceID + "-" + testSpanID + "-01",
+			tracestate:   "Bad=1",
+			wantTraceID:  testTraceID,
+			wantRecorded: true,
+			wantParentID: testSpanID,
+		},
+		{
+			name:         "invalid traceparent starts a new trace and drops tracestate",
+			traceparent:  "00-" + testTraceID + "-0000000000000000-01",
+			tracestate:   "congo=t61rcWkgMzE",
+			wantRecorded: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := NewRingBuffer(10)
+			tracer := NewTracer(buf)
+			client := &http.Client{Transport: NewTransport(nil, nil)}
+
+			handler := tracer.Middleware(func(r *http.Request) string { return "/api" })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, downstream.URL, nil)
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api", nil)
+			req.Header.Set(TraceparentHeader, tt.traceparent)
+			req.Header.Set(TracestateHeader, tt.tracestate)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			response, err := ParseTraceparent(rec.Header().Get(TraceresponseHeader))
+			if err != nil {
+				t.Fatalf("traceresponse: %v", err)
+			}
+			sent, err := ParseTraceparent(outgoing.Get(TraceparentHeader))
+			if err != nil {
+				t.Fatalf("outgoing traceparent: %v", err)
+			}
+			traceID := response.TraceID.String()
+			if tt.wantTraceID != "" && traceID != tt.wantTraceID {
+				t.Errorf("trace id = %s, want %s", traceID, tt.wantTraceID)
+			}
+			if tt.wantTraceID == "" && traceID == testTraceID {
+				t.Error("invalid traceparent was continued")
+			}
+			if sent.TraceID != response.TraceID || sent.SpanID == response.SpanID {
+				t.Errorf("outgoing %s, want a child of %s", sent.Traceparent(), response.Traceparent())
+			}
+			if got := outgoing.Get(TracestateHeader); got != tt.wantState {
+				t.Errorf("outgoing tracestate = %q, want %q", got, tt.wantState)
+			}
+
+			traces := buf.Traces()
+			if !tt.wantRecorded {
+				if len(traces) != 0 {
+					t.Errorf("recorded %d traces, want none", len(traces))
+				}
+				return
+			}
+			if len(traces) != 1 || len(traces[0].Spans) != 2 {
+				t.Fatalf("traces = %+v, want one trace with a server and a client span", traces)
+			}
+			// 子（クライアント）のスパンが先に終わり、サーバーのスパンが最後に記録される
+			clientSpan, server := traces[0].Spans[0], traces[0].Spans[1]
+			if server.Name != "GET /api" || server.Kind != KindServer || server.ParentID != tt.wantParentID {
+				t.Errorf("server span = %+v, want GET /api with parent %q", server, tt.wantParentID)
+			}
+			if clientSpan.Kind != KindClient || clientSpan.ParentID != server.SpanID || clientSpan.SpanID != sent.SpanID.String() {
+				t.Errorf("client span = %+v, want a child of %s sent as %s", clientSpan, server.SpanID, sent.SpanID)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+// Package webhook はイベントを外部の URL へ署名付きで POST し、失敗したら再試行する
+//
+// 【学習ポイント】
+// 1. 配信はワーカープールで非同期に行い、イベントを発生させたリクエストは送信を待たない
+// 2. キューへの登録も待たない（TryAdd）。一杯なら「キューが一杯」という試行として記録し、バックオフ後に登録し直す
+// 3. X-Webhook-Signature: sha256=HMAC(secret, "タイムスタンプ.ボディ")。受信側は同じ計算をして比べ、古いタイムスタンプは拒否する
+// 4. 失敗したら 1s, 2s, 4s... と待ち時間を倍にして再試行し（上限 MaxDelay）、試行ごとの結果を履歴に残す
+// 5. 配信先の一覧は Endpoints インターフェースで受け取るので、保存方法（メモリ / DB）を知らなくてよい
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"learn-go/pkg/trace"
+	"learn-go/pkg/workerpool"
+)
+
+// 配信の状態
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// 送信するヘッダー
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// ErrDeliveryNotFound は再配信しようとした配信が履歴にない
+var ErrDeliveryNotFound = errors.New("配信履歴が見つかりません")
+
+// Endpoint は配信先1つ
+type Endpoint struct {
+	ID     int
+	URL    string
+	Secret string
+}
+
+// Endpoints は配信先を探す。同時に呼ばれるので、実装は排他制御をすること
+type Endpoints interface {
+	// Subscribers はイベントを購読している配信先
+	Subscribers(event string) []Endpoint
+	// Lookup は ID の配信先（削除されていれば false）
+	Lookup(id int) (Endpoint, bool)
+}
+
+// Delivery は1回のイベント通知（再試行を含む）の記録
+type Delivery struct {
+	ID         string          `json:"id"`
+	EndpointID int             `json:"webhook_id"`
+	Event      string          `json:"event"`
+	Payload    json.RawMessage `json:"payload"`
+	Status     string          `json:"status"` // pending / succeeded / failed
+	Attempts   []Attempt       `json:"attempts"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// Attempt は1回分の送信の結果
+type Attempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// Options は Dispatcher の設定。0 の項目は既定値を使う
+type Options struct {
+	Workers     int           // 同時に送信する数（既定 4）
+	QueueSize   int           // 送信待ちの上限（既定 100）
+	MaxAttempts int           // 1つの配信で試す回数（既定 5）
+	BaseDelay   time.Duration // 最初の再試行までの時間（既定 1秒）
+	MaxDelay    time.Duration // 再試行までの時間の上限（既定 1時間）
+	MaxHistory  int           // 配信先ごとに残す履歴の数（既定 100）
+	Client      *http.Client  // 既定はタイムアウト 10秒、traceparent を付ける Transport
+	Tracer      *trace.Tracer // 配信ごとのスパンの記録先（nil なら記録しない）
+}
+
+// Dispatcher はイベントを購読中の配信先へワーカープールで配信する
+type Dispatcher struct {
+	endpoints Endpoints
+	opts      Options
+	pool      *workerpool.Pool
+
+	mu         sync.Mutex
+	deliveries map[int][]*Delivery      // 配信先ごとの配信履歴（新しいものが末尾）
+	timers     map[*time.Timer]struct{} // 再試行待ちのタイマー（停止時に止める）
+	stopped    bool
+}
+
+// New は Dispatcher を作る。Start で送信を始める
+func New(endpoints Endpoints, opts Options) *Dispatcher {
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 100
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = time.Second
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = time.Hour
+	}
+	if opts.MaxHistory <= 0 {
+		opts.MaxHistory = 100
+	}
+	if opts.Tracer == nil {
+		opts.Tracer = trace.NewTracer(nil)
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second, Transport: trace.NewTransport(nil, nil)}
+	}
+	return &Dispatcher{
+		endpoints:  endpoints,
+		opts:       opts,
+		pool:       workerpool.New(opts.Workers, opts.QueueSize),
+		deliveries: make(map[int][]*Delivery),
+		timers:     make(map[*time.Timer]struct{}),
+	}
+}
+
+func (d *Dispatcher) Start() {
+	d.pool.Start()
+}
+
+// Stop は再試行待ちを取り消し、実行中・送信待ちの配信が終わるのを待つ
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	d.stopped = true
+	for t := range d.timers {
+		t.Stop()
+	}
+	d.timers = nil
+	d.mu.Unlock()
+
+	d.pool.Stop()
+}
+
+// Pending は送信待ちの数（メトリクス・ヘルスチェック用）
+func (d *Dispatcher) Pending() int { return d.pool.Pending() }
+
+// Capacity は送信待ちの上限
+func (d *Dispatcher) Capacity() int { return d.pool.Capacity() }
+
+// Dispatch はイベントを購読している全配信先への配信を登録する。
+// 送信もキューへの登録も待たないので、リクエストの処理中から呼んでよい
+func (d *Dispatcher) Dispatch(event string, data interface{}) {
+	subs := d.endpoints.Subscribers(event)
+	if len(subs) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":      event,
+		"created_at": time.Now(),
+		"data":       data,
+	})
+	if err != nil {
+		slog.Error("Webhook ペイロードの作成に失敗しました", "event", event, "err", err)
+		return
+	}
+
+	for _, ep := range subs {
+		d.enqueue(d.newDelivery(ep.ID, event, payload))
+	}
+}
+
+// Redeliver は過去の配信と同じペイロードを新しい配信として送り直す
+func (d *Dispatcher) Redeliver(endpointID int, deliveryID string) (Delivery, error) {
+	d.mu.Lock()
+	var original *Delivery
+	for _, del := range d.deliveries[endpointID] {
+		if del.ID == deliveryID {
+			original = del
+		}
+	}
+	d.mu.Unlock()
+
+	if original == nil {
+		return Delivery{}, ErrDeliveryNotFound
+	}
+
+	del := d.newDelivery(endpointID, original.Event, original.Payload)
+	d.enqueue(del)
+	return d.snapshot(del), nil
+}
+
+// Deliveries は配信先の配信履歴を新しい順に返す
+func (d *Dispatcher) Deliveries(endpointID int) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	history := d.deliveries[endpointID]
+	result := make([]Delivery, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		result = append(result, d.copyLocked(history[i]))
+	}
+	return result
+}
+
+func (d *Dispatcher) newDelivery(endpointID int, event string, payload []byte) *Delivery {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	del := &Delivery{
+		ID:         hex.EncodeToString(b),
+		EndpointID: endpointID,
+		Event:      event,
+		Payload:    payload,
+		Status:     StatusPending,
+		CreatedAt:  time.Now(),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// 古い履歴は捨てる
+	history := append(d.deliveries[endpointID], del)
+	if len(history) > d.opts.MaxHistory {
+		history = history[len(history)-d.opts.MaxHistory:]
+	}
+	d.deliveries[endpointID] = history
+	return del
+}
+
+// enqueue は送信待ちに登録する。キューが一杯なら失敗した試行として記録し、後で登録し直す
+func (d *Dispatcher) enqueue(del *Delivery) {
+	switch err := d.pool.TryAdd(func() { d.attempt(del) }); err {
+	case nil:
+	case workerpool.ErrFull:
+		slog.Warn("Webhook の送信待ちが一杯です。後で再試行します", "delivery", del.ID, "pending", d.pool.Pending())
+		d.fail(del, Attempt{At: time.Now(), Error: "delivery queue is full"})
+	default:
+		slog.Warn("Webhook 配信を停止中のため破棄しました", "delivery", del.ID)
+	}
+}
+
+// attempt は1回分の送信を行い、失敗したらバックオフ後に再試行を予約する
+func (d *Dispatcher) attempt(del *Delivery) {
+	ep, ok := d.endpoints.Lookup(del.EndpointID)
+	if !ok {
+		d.finish(del, Attempt{At: time.Now(), Error: "webhook was deleted"}, StatusFailed)
+		return
+	}
+
+	// 配信はリクエストが終わった後に行うので、別のトレースとして記録する。
+	// 送信先には Transport が traceparent を付けるので、受け取った側のログと突き合わせられる
+	ctx, span := d.opts.Tracer.Start(context.Background(), "webhook.deliver")
+	defer span.End()
+	span.SetAttr("webhook_id", ep.ID)
+	span.SetAttr("delivery", del.ID)
+	span.SetAttr("event", del.Event)
+
+	start := time.Now()
+	result := Attempt{At: start}
+	statusCode, err := d.send(ctx, ep, del)
+	span.SetError(err)
+	result.DurationMs = time.Since(start).Milliseconds()
+	result.StatusCode = statusCode
+
+	if err == nil {
+		d.finish(del, result, StatusSucceeded)
+		return
+	}
+	result.Error = err.Error()
+	d.fail(del, result)
+}
+
+// fail は失敗した試行を記録し、上限に達していなければバックオフ後の再試行を予約する
+func (d *Dispatcher) fail(del *Delivery, result Attempt) {
+	d.mu.Lock()
+	attempts := len(del.Attempts) + 1
+	d.mu.Unlock()
+
+	if attempts >= d.opts.MaxAttempts {
+		slog.Error("Webhook 配信に失敗しました（再試行上限）", "webhook", del.EndpointID, "delivery", del.ID, "attempts", attempts, "err", result.Error)
+		d.finish(del, result, StatusFailed)
+		return
+	}
+
+	d.finish(del, result, StatusPending)
+	d.retryAfter(del, d.backoff(attempts))
+}
+
+// backoff は attempts 回失敗した後の待ち時間（BaseDelay から倍々にし、MaxDelay で頭打ち）
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.opts.BaseDelay
+	for i := 1; i < attempts && delay < d.opts.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.opts.MaxDelay {
+		delay = d.opts.MaxDelay
+	}
+	return delay
+}
+
+func (d *Dispatcher) retryAfter(del *Delivery, delay time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopped {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		d.mu.Lock()
+		delete(d.timers, timer)
+		d.mu.Unlock()
+		d.enqueue(del)
+	})
+	d.timers[timer] = struct{}{}
+}
+
+// send は署名付きで POST する。2xx 以外はエラー
+func (d *Dispatcher) send(ctx context.Context, ep Endpoint, del *Delivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(del.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "learn-go-webhook/1.0")
+	req.Header.Set(HeaderEvent, del.Event)
+	req.Header.Set(HeaderDelivery, del.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(ep.Secret, timestamp, del.Payload))
+
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) finish(del *Delivery, result Attempt, status string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	del.Attempts = append(del.Attempts, result)
+	del.Status = status
+}
+
+func (d *Dispatcher) snapshot(del *Delivery) Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.copyLocked(del)
+}
+
+func (d *Dispatcher) copyLocked(del *Delivery) Delivery {
+	c := *del
+	c.Attempts = append([]Attempt{}, del.Attempts...)
+	return c
+}
+
+// Sign は "タイムスタンプ.ボディ" の HMAC-SHA256 を16進文字列で返す。
+// 受信側は同じ計算をして X-Webhook-Signature と比較し、古いタイムスタンプは拒否する
+func Sign(secret, timestamp string, payload []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,291 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// endpointMap はテスト用の配信先一覧
+type endpointMap struct {
+	mu  sync.Mutex
+	eps map[int]Endpoint
+}
+
+func newEndpoints(eps ...Endpoint) *endpointMap {
+	m := &endpointMap{eps: make(map[int]Endpoint)}
+	for _, ep := range eps {
+		m.eps[ep.ID] = ep
+	}
+	return m
+}
+
+func (m *endpointMap) Subscribers(event string) []Endpoint {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []Endpoint
+	for _, ep := range m.eps {
+		result = append(result, ep)
+	}
+	return result
+}
+
+func (m *endpointMap) Lookup(id int) (Endpoint, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ep, ok := m.eps[id]
+	return ep, ok
+}
+
+func (m *endpointMap) delete(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.eps, id)
+}
+
+// waitFor は cond が true になるまで待つ
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// settled は配信先の履歴がすべて pending 以外になったか
+func settled(d *Dispatcher, endpointID, want int) func() bool {
+	return func() bool {
+		dels := d.Deliveries(endpointID)
+		if len(dels) != want {
+			return false
+		}
+		for _, del := range dels {
+			if del.Status == StatusPending {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func TestDispatchSignsPayload(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{r.Header.Clone(), body}
+	}))
+	defer srv.Close()
+
+	d := New(newEndpoints(Endpoint{ID: 1, URL: srv.URL, Secret: "s3cret"}), Options{})
+	d.Start()
+	defer d.Stop()
+
+	d.Dispatch("post.created", map[string]int{"id": 42})
+
+	var rec received
+	select {
+	case rec = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("receiver was not called")
+	}
+
+	if ev := rec.header.Get(HeaderEvent); ev != "post.created" {
+		t.Errorf("%s = %q, want post.created", HeaderEvent, ev)
+	}
+	ts := rec.header.Get(HeaderTimestamp)
+	want := "sha256=" + Sign("s3cret", ts, rec.body)
+	if sig := rec.header.Get(HeaderSignature); sig != want {
+		t.Errorf("%s = %q, want %q", HeaderSignature, sig, want)
+	}
+	if !strings.Contains(string(rec.body), `"data":{"id":42}`) {
+		t.Errorf("body = %s, want the event data", rec.body)
+	}
+
+	waitFor(t, "delivery to succeed", settled(d, 1, 1))
+	del := d.Deliveries(1)[0]
+	if del.Status != StatusSucceeded || del.ID != rec.header.Get(HeaderDelivery) {
+		t.Errorf("delivery = %+v, want succeeded with id %s", del, rec.header.Get(HeaderDelivery))
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int // 先頭から何回 500 を返すか
+		maxAttempts  int
+		wantStatus   string
+		wantAttempts int
+	}{
+		{"succeeds first time", 0, 3, StatusSucceeded, 1},
+		{"succeeds after retries", 2, 3, StatusSucceeded, 3},
+		{"gives up", 5, 3, StatusFailed, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if int(atomic.AddInt32(&calls, 1)) <= tt.failures {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}))
+			defer srv.Close()
+
+			d := New(newEndpoints(Endpoint{ID: 1, URL: srv.URL}), Options{
+				MaxAttempts: tt.maxAttempts,
+				BaseDelay:   time.Millisecond,
+			})
+			d.Start()
+			defer d.Stop()
+
+			d.Dispatch("post.updated", nil)
+			waitFor(t, "delivery to settle", settled(d, 1, 1))
+
+			del := d.Deliveries(1)[0]
+			if del.Status != tt.wantStatus || len(del.Attempts) != tt.wantAttempts {
+				t.Fatalf("status=%s attempts=%d, want %s and %d", del.Status, len(del.Attempts), tt.wantStatus, tt.wantAttempts)
+			}
+			if tt.failures > 0 && del.Attempts[0].StatusCode != http.StatusInternalServerError {
+				t.Errorf("first attempt status = %d, want 500", del.Attempts[0].StatusCode)
+			}
+		})
+	}
+}
+
+func TestDeletedEndpointFails(t *testing.T) {
+	eps := newEndpoints(Endpoint{ID: 1, URL: "http://127.0.0.1:1"})
+	d := New(eps, Options{})
+	// 送信前に配信先を消す（Start 前なのでキューに残る）
+	d.Dispatch("post.deleted", nil)
+	eps.delete(1)
+	d.Start()
+	defer d.Stop()
+
+	waitFor(t, "delivery to settle", settled(d, 1, 1))
+	if del := d.Deliveries(1)[0]; del.Status != StatusFailed || del.Attempts[0].Error != "webhook was deleted" {
+		t.Errorf("delivery = %+v, want failed because the webhook was deleted", del)
+	}
+}
+
+func TestRedeliver(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	d := New(newEndpoints(Endpoint{ID: 1, URL: srv.URL}), Options{})
+	d.Start()
+	defer d.Stop()
+
+	d.Dispatch("post.published", "hello")
+	waitFor(t, "first delivery", settled(d, 1, 1))
+	original := d.Deliveries(1)[0]
+
+	again, err := d.Redeliver(1, original.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID == original.ID {
+		t.Error("redelivery should get a new delivery ID")
+	}
+	waitFor(t, "redelivery", settled(d, 1, 2))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 || bodies[0] != bodies[1] {
+		t.Errorf("bodies = %q, want the same payload twice", bodies)
+	}
+
+	if _, err := d.Redeliver(1, "unknown"); err != ErrDeliveryNotFound {
+		t.Errorf("Redeliver(unknown) error = %v, want ErrDeliveryNotFound", err)
+	}
+}
+
+// 受信側が遅くキューが一杯でも、Dispatch はすぐに戻り、配信は後で届く
+func TestDispatchDoesNotBlockWhenQueueIsFull(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+	}))
+	defer srv.Close()
+
+	d := New(newEndpoints(Endpoint{ID: 1, URL: srv.URL}), Options{
+		Workers:     1,
+		QueueSize:   1,
+		MaxAttempts: 50,
+		BaseDelay:   5 * time.Millisecond,
+		MaxDelay:    20 * time.Millisecond,
+	})
+	d.Start()
+	defer d.Stop()
+
+	const events = 10
+	start := time.Now()
+	for i := 0; i < events; i++ {
+		d.Dispatch("post.created", i)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Dispatch blocked for %v", elapsed)
+	}
+
+	// ワーカーが止まっている間に、一杯で登録できなかった試行が記録される
+	waitFor(t, "a queue-full attempt", func() bool {
+		for _, del := range d.Deliveries(1) {
+			for _, a := range del.Attempts {
+				if a.Error == "delivery queue is full" {
+					return true
+				}
+			}
+		}
+		return false
+	})
+
+	close(release)
+	waitFor(t, "all deliveries", settled(d, 1, events))
+	for _, del := range d.Deliveries(1) {
+		if del.Status != StatusSucceeded {
+			t.Errorf("delivery %s status = %s, want succeeded", del.ID, del.Status)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != events {
+		t.Errorf("receiver called %d times, want %d", n, events)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := New(newEndpoints(), Options{BaseDelay: time.Second, MaxDelay: 5 * time.Second})
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
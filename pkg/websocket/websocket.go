@@ -0,0 +1,428 @@
+// Package websocket は net/http だけで動く RFC 6455 WebSocket のサーバー実装
+//
+// 【学習ポイント】
+// 1. ハンドシェイクは通常の HTTP リクエスト（Upgrade: websocket）で始まる
+// 2. http.Hijacker で TCP 接続を HTTP サーバーから取り上げ、以降はフレームを直接読み書きする
+// 3. クライアント→サーバーのフレームは必ずマスクされている（サーバー→クライアントはマスクしない）
+// 4. ping/pong と close は「制御フレーム」（125バイト以下、分割不可）
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ========== 定数 ==========
+
+// オペコード（RFC 6455 5.2）
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// クローズコード（RFC 6455 7.4.1）
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+// ハンドシェイクでキーに連結する固定の GUID
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const maxControlPayload = 125
+
+var (
+	ErrBadHandshake = errors.New("websocket: 不正なハンドシェイク")
+	ErrClosed       = errors.New("websocket: 接続は閉じられています")
+)
+
+// CloseError は相手からクローズフレームを受け取った（またはプロトコル違反で閉じた）ことを表す
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed (%d) %s", e.Code, e.Text)
+}
+
+// ========== ハンドシェイク ==========
+
+// Upgrader は HTTP リクエストを WebSocket 接続に切り替える
+type Upgrader struct {
+	// CheckOrigin が nil なら Origin ヘッダーの検査をしない
+	CheckOrigin func(r *http.Request) bool
+	// MaxMessageSize は受信する1メッセージの上限（0なら 64KB）
+	MaxMessageSize int64
+}
+
+// Upgrade はハンドシェイクを検証して 101 Switching Protocols を返し、Conn を作る。
+// 失敗した場合はエラーレスポンスを書き込み済み
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, ErrBadHandshake
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "WebSocket upgrade required", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if u.CheckOrigin != nil && !u.CheckOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return nil, ErrBadHandshake
+	}
+
+	// ResponseController はミドルウェアでラップされた ResponseWriter でも Unwrap して Hijack できる
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	// サーバーの ReadTimeout / WriteTimeout で設定された期限を解除する
+	netConn.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := netConn.Write([]byte(response)); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	maxSize := u.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = 64 << 10
+	}
+	return &Conn{
+		conn:    netConn,
+		br:      brw.Reader, // ハイジャック前に読み込まれたデータが残っている可能性がある
+		maxSize: maxSize,
+	}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContainsToken はカンマ区切りのヘッダー値に token が含まれるか調べる（大文字小文字は区別しない）
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ========== 接続 ==========
+
+// Conn は1本の WebSocket 接続。
+// 読み込みは1つの goroutine から、書き込みは複数の goroutine から呼んでよい
+type Conn struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	maxSize int64
+
+	writeMu   sync.Mutex
+	closeSent bool
+
+	pongHandler func(data []byte)
+}
+
+// SetReadDeadline は次の読み込みの期限を設定する
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline は次の書き込みの期限を設定する
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetPongHandler は pong を受け取ったときに呼ぶ関数を設定する（ReadMessage の中から呼ばれる）
+func (c *Conn) SetPongHandler(h func(data []byte)) {
+	c.pongHandler = h
+}
+
+// RemoteAddr は接続元のアドレスを返す
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage は次のテキスト/バイナリメッセージを返す。
+// ping には自動で pong を返し、分割されたフレームは1つのメッセージに組み立てる。
+// クローズフレームを受け取ると応答のクローズを返して *CloseError を返す
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var (
+		opcode  int
+		message []byte
+	)
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case OpPing:
+			if err := c.WriteControl(OpPong, payload, time.Now().Add(5*time.Second)); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			if c.pongHandler != nil {
+				c.pongHandler(payload)
+			}
+			continue
+		case OpClose:
+			closeErr := parseClosePayload(payload)
+			code := closeErr.Code
+			if code == CloseNoStatusReceived {
+				code = CloseNormalClosure
+			}
+			c.WriteClose(code, "")
+			return 0, nil, closeErr
+		case OpText, OpBinary:
+			if message != nil {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected data frame during fragmented message")
+			}
+			opcode = op
+			message = []byte{}
+		case OpContinuation:
+			if message == nil {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(message)+len(payload)) > c.maxSize {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		message = append(message, payload...)
+
+		if fin {
+			if opcode == OpText && !utf8.Valid(message) {
+				return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8")
+			}
+			return opcode, message, nil
+		}
+	}
+}
+
+// readFrame は1フレームを読み、マスクを外したペイロードを返す
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		// 拡張を合意していないので RSV ビットは常に0
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	opcode = int(header[0] & 0x0F)
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n := binary.BigEndian.Uint64(ext[:])
+		if n>>63 != 0 {
+			return false, 0, nil, c.fail(CloseProtocolError, "invalid payload length")
+		}
+		length = int64(n)
+	}
+
+	if opcode >= OpClose {
+		if !fin || length > maxControlPayload {
+			return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+		}
+	}
+	if !masked {
+		return false, 0, nil, c.fail(CloseProtocolError, "client frames must be masked")
+	}
+	if length > c.maxSize {
+		return false, 0, nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// parseClosePayload はクローズフレームのステータスコードと理由を取り出す
+func parseClosePayload(payload []byte) *CloseError {
+	switch {
+	case len(payload) == 0:
+		return &CloseError{Code: CloseNoStatusReceived}
+	case len(payload) == 1:
+		return &CloseError{Code: CloseProtocolError, Text: "invalid close payload"}
+	}
+
+	code := int(binary.BigEndian.Uint16(payload[:2]))
+	reason := payload[2:]
+	if !validCloseCode(code) {
+		return &CloseError{Code: CloseProtocolError, Text: "invalid close code"}
+	}
+	if !utf8.Valid(reason) {
+		return &CloseError{Code: CloseInvalidPayload, Text: "invalid close reason"}
+	}
+	return &CloseError{Code: code, Text: string(reason)}
+}
+
+// validCloseCode はフレームで送ってよいクローズコードか調べる（1005 などは送信禁止）
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// fail はプロトコル違反を相手に伝えて接続を閉じる
+func (c *Conn) fail(code int, reason string) error {
+	c.WriteClose(code, reason)
+	c.conn.Close()
+	return &CloseError{Code: code, Text: reason}
+}
+
+// WriteMessage はテキスト/バイナリメッセージを1フレームで送る。
+// 期限は事前に SetWriteDeadline で設定しておく
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	if opcode != OpText && opcode != OpBinary {
+		return fmt.Errorf("websocket: データフレームではないオペコード %d", opcode)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	return c.writeFrameLocked(opcode, data)
+}
+
+// WriteControl は ping/pong/close を deadline までに送る
+func (c *Conn) WriteControl(opcode int, data []byte, deadline time.Time) error {
+	if opcode < OpClose || len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: 不正な制御フレーム")
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	c.conn.SetWriteDeadline(deadline)
+	if opcode == OpClose {
+		c.closeSent = true
+	}
+	return c.writeFrameLocked(opcode, data)
+}
+
+// WriteClose はクローズフレームを送る（2回目以降は何もしない）
+func (c *Conn) WriteClose(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+
+	err := c.WriteControl(OpClose, payload, time.Now().Add(5*time.Second))
+	if errors.Is(err, ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close はクローズフレームを送ってから TCP 接続を閉じる
+func (c *Conn) Close(code int, reason string) error {
+	c.WriteClose(code, reason)
+	return c.conn.Close()
+}
+
+// writeFrameLocked はヘッダーとペイロードを1回の Write で送る（writeMu を取得しておくこと）
+func (c *Conn) writeFrameLocked(opcode int, data []byte) error {
+	frame := make([]byte, 0, 10+len(data))
+	frame = append(frame, 0x80|byte(opcode)) // FIN=1（サーバーからは分割しない）
+
+	switch n := len(data); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, data...)
+
+	_, err := c.conn.Write(frame)
+	return err
+}
@@ -0,0 +1,248 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// frame はクライアントが送るフレームを組み立てる（masked=false はプロトコル違反の確認用）
+func frame(fin bool, opcode int, payload []byte, masked bool) []byte {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	out := []byte{b0}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		out = append(out, maskBit|byte(n))
+	case n <= 0xFFFF:
+		out = append(out, maskBit|126)
+		out = binary.BigEndian.AppendUint16(out, uint16(n))
+	default:
+		out = append(out, maskBit|127)
+		out = binary.BigEndian.AppendUint64(out, uint64(n))
+	}
+	if !masked {
+		return append(out, payload...)
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	out = append(out, mask[:]...)
+	for i, c := range payload {
+		out = append(out, c^mask[i%4])
+	}
+	return out
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// serverFrame はサーバーが送ったフレームを1つ読む（サーバーはマスクせず、分割もしない）
+func serverFrame(r *bufio.Reader) (opcode int, payload []byte, err error) {
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		n = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload = make([]byte, n)
+	_, err = io.ReadFull(r, payload)
+	return int(h[0] & 0x0F), payload, err
+}
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      [][]byte
+		wantOp     int
+		wantData   string
+		wantClose  int // 0 なら ReadMessage は成功する
+		wantReply  []int
+		replyClose int // 返したクローズフレームのコード
+	}{
+		{"text", [][]byte{frame(true, OpText, []byte("こんにちは"), true)}, OpText, "こんにちは", 0, nil, 0},
+		{"binary 16-bit length", [][]byte{frame(true, OpBinary, bytes.Repeat([]byte{7}, 200), true)}, OpBinary, string(bytes.Repeat([]byte{7}, 200)), 0, nil, 0},
+		{"fragmented", [][]byte{frame(false, OpText, []byte("ab"), true), frame(true, OpContinuation, []byte("cd"), true)}, OpText, "abcd", 0, nil, 0},
+		{"ping in the middle", [][]byte{frame(false, OpText, []byte("ab"), true), frame(true, OpPing, []byte("p"), true), frame(true, OpContinuation, []byte("cd"), true)}, OpText, "abcd", 0, []int{OpPong}, 0},
+		{"close from peer", [][]byte{frame(true, OpClose, closePayload(1001, "bye"), true)}, 0, "", CloseGoingAway, []int{OpClose}, CloseGoingAway},
+		{"close without code", [][]byte{frame(true, OpClose, nil, true)}, 0, "", CloseNoStatusReceived, []int{OpClose}, CloseNormalClosure},
+		{"invalid close code", [][]byte{frame(true, OpClose, closePayload(1005, ""), true)}, 0, "", CloseProtocolError, []int{OpClose}, CloseProtocolError},
+		{"unmasked", [][]byte{frame(true, OpText, []byte("x"), false)}, 0, "", CloseProtocolError, []int{OpClose}, CloseProtocolError},
+		{"reserved bits", [][]byte{append([]byte{0xC1}, frame(true, OpText, []byte("x"), true)[1:]...)}, 0, "", CloseProtocolError, []int{OpClose}, CloseProtocolError},
+		{"unexpected continuation", [][]byte{frame(true, OpContinuation, []byte("x"), true)}, 0, "", CloseProtocolError, []int{OpClose}, CloseProtocolError},
+		{"fragmented control", [][]byte{frame(false, OpPing, []byte("x"), true)}, 0, "", CloseProtocolError, []int{OpClose}, CloseProtocolError},
+		{"too big", [][]byte{frame(true, OpText, bytes.Repeat([]byte("a"), 300), true)}, 0, "", CloseMessageTooBig, []int{OpClose}, CloseMessageTooBig},
+		{"too big after fragments", [][]byte{frame(false, OpText, bytes.Repeat([]byte("a"), 200), true), frame(true, OpContinuation, bytes.Repeat([]byte("a"), 100), true)}, 0, "", CloseMessageTooBig, []int{OpClose}, CloseMessageTooBig},
+		{"invalid utf-8", [][]byte{frame(true, OpText, []byte{0xff, 0xfe}, true)}, 0, "", CloseInvalidPayload, []int{OpClose}, CloseInvalidPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			c := &Conn{conn: server, br: bufio.NewReader(server), maxSize: 256}
+
+			go func() {
+				for _, f := range tt.input {
+					if _, err := client.Write(f); err != nil {
+						return
+					}
+				}
+			}()
+			replies := make(chan []int, 1)
+			go func() {
+				var ops []int
+				r := bufio.NewReader(client)
+				for {
+					op, payload, err := serverFrame(r)
+					if err != nil {
+						replies <- ops
+						return
+					}
+					ops = append(ops, op)
+					if op == OpClose && tt.replyClose != 0 {
+						if code := int(binary.BigEndian.Uint16(payload)); code != tt.replyClose {
+							t.Errorf("close reply code = %d, want %d", code, tt.replyClose)
+						}
+					}
+				}
+			}()
+
+			op, data, err := c.ReadMessage()
+			server.Close()
+			gotReplies := <-replies
+			client.Close()
+
+			if tt.wantClose == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if op != tt.wantOp || string(data) != tt.wantData {
+					t.Errorf("got (%d, %q), want (%d, %q)", op, data, tt.wantOp, tt.wantData)
+				}
+			} else {
+				var closeErr *CloseError
+				if !errors.As(err, &closeErr) || closeErr.Code != tt.wantClose {
+					t.Fatalf("err = %v, want close %d", err, tt.wantClose)
+				}
+			}
+			if len(gotReplies) != len(tt.wantReply) {
+				t.Fatalf("replies = %v, want %v", gotReplies, tt.wantReply)
+			}
+			for i := range gotReplies {
+				if gotReplies[i] != tt.wantReply[i] {
+					t.Errorf("replies = %v, want %v", gotReplies, tt.wantReply)
+				}
+			}
+		})
+	}
+}
+
+func TestUpgradeRejects(t *testing.T) {
+	valid := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		r.Header.Set("Connection", "keep-alive, Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		r.Header.Set("Origin", "https://evil.example")
+		return r
+	}
+	noEvil := &Upgrader{CheckOrigin: func(r *http.Request) bool { return r.Header.Get("Origin") != "https://evil.example" }}
+
+	tests := []struct {
+		name     string
+		upgrader *Upgrader
+		modify   func(r *http.Request)
+		want     int
+	}{
+		{"post", &Upgrader{}, func(r *http.Request) { r.Method = http.MethodPost }, http.StatusMethodNotAllowed},
+		{"no upgrade header", &Upgrader{}, func(r *http.Request) { r.Header.Del("Upgrade") }, http.StatusBadRequest},
+		{"no connection upgrade", &Upgrader{}, func(r *http.Request) { r.Header.Set("Connection", "keep-alive") }, http.StatusBadRequest},
+		{"old version", &Upgrader{}, func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") }, http.StatusUpgradeRequired},
+		{"short key", &Upgrader{}, func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "c2hvcnQ=") }, http.StatusBadRequest},
+		{"origin", noEvil, func(r *http.Request) {}, http.StatusForbidden},
+		// ここまでを通ると Hijack する。httptest.ResponseRecorder は Hijack できないので 500
+		{"cannot hijack", &Upgrader{}, func(r *http.Request) {}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid()
+			tt.modify(r)
+			rec := httptest.NewRecorder()
+			if _, err := tt.upgrader.Upgrade(rec, r); err == nil {
+				t.Fatal("Upgrade should fail")
+			}
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+// 実際の HTTP サーバーでハンドシェイクし、受け取ったメッセージをそのまま返す
+func TestUpgradeEcho(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&Upgrader{}).Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close(CloseNormalClosure, "")
+		op, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(op, append([]byte("echo: "), data...))
+	}))
+	defer srv.Close()
+
+	nc, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	handshake := "GET / HTTP/1.1\r\nHost: example\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+	if _, err := nc.Write([]byte(handshake)); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 6455 の例と同じキーなので、応答も RFC と同じ値になる
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake response: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	nc.Write(frame(true, OpText, []byte("hi"), true))
+	op, payload, err := serverFrame(br)
+	if err != nil || op != OpText || string(payload) != "echo: hi" {
+		t.Fatalf("echo = (%d, %q, %v)", op, payload, err)
+	}
+	op, payload, err = serverFrame(br)
+	if err != nil || op != OpClose || binary.BigEndian.Uint16(payload) != CloseNormalClosure {
+		t.Errorf("close = (%d, %v, %v)", op, payload, err)
+	}
+}
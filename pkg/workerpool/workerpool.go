@@ -0,0 +1,116 @@
+// Package workerpool は固定数のワーカーでタスクを処理する（03_concurrency/05_patterns.go と同じ形）
+//
+// 【学習ポイント】
+// 1. バッファ付きチャネルをキューにし、決まった数のゴルーチンが取り出して実行する（同時実行数の上限になる）
+// 2. 閉じたチャネルへの送信は panic するので、停止後の追加は stopped フラグで断る
+// 3. リクエストの処理中から呼ぶときは TryAdd を使う。select の default でキューが一杯なら待たずに諦め、呼び出し側を止めない
+// 4. Add が待っている間は読み込みロックを持ったままになる。Stop は先に done を閉じて待ちを解いてから書き込みロックを取る
+package workerpool
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrStopped は停止後に追加しようとした
+	ErrStopped = errors.New("workerpool: stopped")
+	// ErrFull はキューが一杯で追加できなかった（TryAdd のみ）
+	ErrFull = errors.New("workerpool: queue is full")
+)
+
+// Pool は固定数のワーカーでタスクを処理する
+type Pool struct {
+	workers int
+	tasks   chan func()
+	wg      sync.WaitGroup
+
+	mu       sync.RWMutex
+	stopped  bool
+	done     chan struct{} // Stop の最初に閉じる（Add の待ちを解く）
+	stopOnce sync.Once
+}
+
+// New は workers 個のワーカーと、queueSize 個まで溜められるキューを持つ Pool を作る
+func New(workers, queueSize int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &Pool{
+		workers: workers,
+		tasks:   make(chan func(), queueSize),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start はワーカーを起動する
+func (p *Pool) Start() {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for task := range p.tasks {
+				task()
+			}
+		}()
+	}
+}
+
+// Add はタスクを追加する。キューが一杯なら空くか停止するまで待つ。
+// 起動時の登録など、待ってもよい場面で使う
+func (p *Pool) Add(task func()) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.stopped {
+		return ErrStopped
+	}
+	select {
+	case p.tasks <- task:
+		return nil
+	case <-p.done:
+		return ErrStopped
+	}
+}
+
+// TryAdd はタスクを追加する。キューが一杯なら待たずに ErrFull を返す
+func (p *Pool) TryAdd(task func()) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.stopped {
+		return ErrStopped
+	}
+	select {
+	case p.tasks <- task:
+		return nil
+	default:
+		return ErrFull
+	}
+}
+
+// Pending はキューに溜まっているタスク数
+func (p *Pool) Pending() int {
+	return len(p.tasks)
+}
+
+// Capacity はキューに溜められるタスク数
+func (p *Pool) Capacity() int {
+	return cap(p.tasks)
+}
+
+// Stop は新しいタスクを断り、キューに残ったタスクが終わるまで待つ
+func (p *Pool) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.done) // 待っている Add を抜けさせ、読み込みロックを返してもらう
+
+		p.mu.Lock()
+		p.stopped = true
+		close(p.tasks)
+		p.mu.Unlock()
+	})
+	p.wg.Wait()
+}
@@ -0,0 +1,71 @@
+package workerpool
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolRunsTasks(t *testing.T) {
+	p := New(3, 10)
+	p.Start()
+
+	var n int32
+	for i := 0; i < 10; i++ {
+		if err := p.Add(func() { atomic.AddInt32(&n, 1) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Stop()
+
+	if n != 10 {
+		t.Errorf("ran %d tasks, want 10", n)
+	}
+	if err := p.TryAdd(func() {}); err != ErrStopped {
+		t.Errorf("TryAdd after Stop = %v, want ErrStopped", err)
+	}
+}
+
+func TestTryAddDoesNotBlock(t *testing.T) {
+	p := New(1, 1)
+	release := make(chan struct{})
+	p.Start()
+	defer p.Stop()
+
+	started := make(chan struct{})
+	p.Add(func() { close(started); <-release })
+	<-started // ワーカーが止まっている
+
+	tests := []struct {
+		name string
+		want error
+	}{
+		{"fills the queue", nil},
+		{"queue is full", ErrFull},
+	}
+	for _, tt := range tests {
+		if err := p.TryAdd(func() {}); err != tt.want {
+			t.Errorf("%s: TryAdd = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	close(release)
+}
+
+// キューが一杯で待っている Add は、Stop で ErrStopped を返して抜ける
+func TestStopReleasesBlockedAdd(t *testing.T) {
+	p := New(1, 0)
+	// ワーカーを起動しないので、Add はずっと待つ
+	result := make(chan error, 1)
+	go func() { result <- p.Add(func() {}) }()
+	time.Sleep(10 * time.Millisecond)
+
+	p.Stop()
+	select {
+	case err := <-result:
+		if err != ErrStopped {
+			t.Errorf("Add = %v, want ErrStopped", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not release the blocked Add")
+	}
+}
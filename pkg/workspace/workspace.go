@@ -0,0 +1,307 @@
+// Package workspace はワークスペース（チーム単位のテナント）と、そのメンバー・招待を管理する
+//
+// 【学習ポイント】
+//  1. ロールはワークスペースごとに持つ（同じユーザーがあるワークスペースでは admin、別のところでは author）
+//  2. 上限（Quota）の確認と追加を同じロックの中で行い、同時に招待を承諾しても上限を超えないようにする
+//  3. Directory は自分では排他しない。投稿数の上限の確認など、呼び出し側のデータと1回のロックでまとめるため
+//  4. 招待の承諾は「承諾済み・有効期限・メールアドレス・参加済み・上限」をまとめて確認してから記録する
+//  5. 上限超過は *QuotaError で返し、呼び出し側は errors.As で取り出してどの上限かを伝える
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// エラーの文言はそのままクライアントへ返すことがある
+var (
+	ErrNotFound           = errors.New("ワークスペースが見つかりません")
+	ErrSlugTaken          = errors.New("このスラッグは使われています")
+	ErrNotMember          = errors.New("このワークスペースのメンバーではありません")
+	ErrAlreadyMember      = errors.New("すでにメンバーです")
+	ErrInvitationNotFound = errors.New("招待が見つかりません")
+	ErrInvitationUsed     = errors.New("この招待はすでに使われています")
+	ErrInvitationExpired  = errors.New("招待の有効期限が切れています")
+	ErrInvitationEmail    = errors.New("招待されたメールアドレスと一致しません")
+)
+
+// Workspace はチーム単位のテナント
+type Workspace struct {
+	ID        int       `json:"id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Public    bool      `json:"public"` // true ならメンバー以外も閲覧できる
+	Quota     Quota     `json:"quota"`
+	CreatedBy int       `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Quota はワークスペースごとの上限（0 は無制限）
+type Quota struct {
+	MaxPosts   int `json:"max_posts"`
+	MaxMembers int `json:"max_members"`
+}
+
+// Usage は上限と比較する現在の使用量
+type Usage struct {
+	Posts   int `json:"posts"`
+	Members int `json:"members"`
+}
+
+// Membership はユーザーの所属と、そのワークスペース内でのロール
+type Membership struct {
+	WorkspaceID int       `json:"workspace_id"`
+	UserID      int       `json:"user_id"`
+	Role        string    `json:"role"`
+	JoinedAt    time.Time `json:"joined_at"`
+}
+
+// Invitation はワークスペースへの招待。
+// 招待トークンは署名付きトークンとしてメールで送り、ここには保存しない
+type Invitation struct {
+	ID          int        `json:"id"`
+	WorkspaceID int        `json:"workspace_id"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"`
+	InvitedBy   int        `json:"invited_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	AcceptedBy  int        `json:"accepted_by,omitempty"`
+	AcceptedAt  *time.Time `json:"accepted_at,omitempty"`
+}
+
+// QuotaError はワークスペースの上限を超える操作のエラー
+type QuotaError struct {
+	Field string // max_posts / max_members
+	Limit int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("ワークスペースの上限に達しました（%s: %d）", e.Field, e.Limit)
+}
+
+// Details はエラーレスポンスの details に入れる内容
+func (e *QuotaError) Details() map[string]string {
+	return map[string]string{e.Field: fmt.Sprintf("Limit is %d", e.Limit)}
+}
+
+// ========== Directory ==========
+
+// State はファイルに保存する形式（呼び出し側のスナップショットに埋め込む）
+type State struct {
+	Workspaces   []Workspace  `json:"workspaces"`
+	Memberships  []Membership `json:"memberships"`
+	Invitations  []Invitation `json:"invitations"`
+	NextID       int          `json:"next_workspace_id"`
+	NextInviteID int          `json:"next_invitation_id"`
+}
+
+// Directory はワークスペース・メンバー・招待を保持する。
+// 自分では排他しないので、呼び出し側の Mutex の中で使う
+type Directory struct {
+	workspaces   []Workspace
+	memberships  []Membership
+	invitations  []Invitation
+	nextID       int
+	nextInviteID int
+}
+
+// Restore は保存した状態（または初期データ）から Directory を作る
+func Restore(st State) *Directory {
+	d := &Directory{
+		workspaces:   st.Workspaces,
+		memberships:  st.Memberships,
+		invitations:  st.Invitations,
+		nextID:       st.NextID,
+		nextInviteID: st.NextInviteID,
+	}
+	// 次の ID が保存されていなければ、1 か既存の最大値の次にする
+	d.nextID = max(d.nextID, 1)
+	d.nextInviteID = max(d.nextInviteID, 1)
+	for _, ws := range d.workspaces {
+		if ws.ID >= d.nextID {
+			d.nextID = ws.ID + 1
+		}
+	}
+	for _, inv := range d.invitations {
+		if inv.ID >= d.nextInviteID {
+			d.nextInviteID = inv.ID + 1
+		}
+	}
+	return d
+}
+
+// State は保存用に現在の状態を返す
+func (d *Directory) State() State {
+	return State{
+		Workspaces:   d.workspaces,
+		Memberships:  d.memberships,
+		Invitations:  d.invitations,
+		NextID:       d.nextID,
+		NextInviteID: d.nextInviteID,
+	}
+}
+
+func (d *Directory) Get(id int) (Workspace, bool) {
+	for _, ws := range d.workspaces {
+		if ws.ID == id {
+			return ws, true
+		}
+	}
+	return Workspace{}, false
+}
+
+func (d *Directory) FindBySlug(slug string) (Workspace, bool) {
+	for _, ws := range d.workspaces {
+		if ws.Slug == slug {
+			return ws, true
+		}
+	}
+	return Workspace{}, false
+}
+
+// Role はワークスペース内でのロールを返す（メンバーでなければ false）
+func (d *Directory) Role(workspaceID, userID int) (string, bool) {
+	for _, m := range d.memberships {
+		if m.WorkspaceID == workspaceID && m.UserID == userID {
+			return m.Role, true
+		}
+	}
+	return "", false
+}
+
+// MembershipsOf はユーザーの所属を返す
+func (d *Directory) MembershipsOf(userID int) []Membership {
+	list := []Membership{}
+	for _, m := range d.memberships {
+		if m.UserID == userID {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+func (d *Directory) Members(workspaceID int) []Membership {
+	members := []Membership{}
+	for _, m := range d.memberships {
+		if m.WorkspaceID == workspaceID {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+func (d *Directory) MemberCount(workspaceID int) int {
+	n := 0
+	for _, m := range d.memberships {
+		if m.WorkspaceID == workspaceID {
+			n++
+		}
+	}
+	return n
+}
+
+// Create はワークスペースを作成し、作成者を ownerRole で参加させる
+func (d *Directory) Create(ws Workspace, ownerRole string) (Workspace, error) {
+	if _, ok := d.FindBySlug(ws.Slug); ok {
+		return Workspace{}, ErrSlugTaken
+	}
+
+	ws.ID = d.nextID
+	d.nextID++
+	ws.CreatedAt = time.Now()
+	d.workspaces = append(d.workspaces, ws)
+	d.memberships = append(d.memberships, Membership{WorkspaceID: ws.ID, UserID: ws.CreatedBy, Role: ownerRole, JoinedAt: ws.CreatedAt})
+	return ws, nil
+}
+
+// Join はメンバーを直接追加する（既定のワークスペースへの自動参加など。上限は確認しない）
+func (d *Directory) Join(m Membership) {
+	d.memberships = append(d.memberships, m)
+}
+
+// UpdateQuota は上限を変更し、変更前と変更後を返す
+func (d *Directory) UpdateQuota(id int, quota Quota) (Workspace, Workspace, bool) {
+	for i := range d.workspaces {
+		if d.workspaces[i].ID == id {
+			before := d.workspaces[i]
+			d.workspaces[i].Quota = quota
+			return before, d.workspaces[i], true
+		}
+	}
+	return Workspace{}, Workspace{}, false
+}
+
+// checkMembers はメンバーを1人増やせるかを確認する
+func (d *Directory) checkMembers(ws Workspace) error {
+	if ws.Quota.MaxMembers > 0 && d.MemberCount(ws.ID) >= ws.Quota.MaxMembers {
+		return &QuotaError{Field: "max_members", Limit: ws.Quota.MaxMembers}
+	}
+	return nil
+}
+
+// Invite は招待を記録する。メンバー数がすでに上限なら *QuotaError を返す
+func (d *Directory) Invite(inv Invitation) (Invitation, error) {
+	ws, ok := d.Get(inv.WorkspaceID)
+	if !ok {
+		return Invitation{}, ErrNotFound
+	}
+	if err := d.checkMembers(ws); err != nil {
+		return Invitation{}, err
+	}
+
+	inv.ID = d.nextInviteID
+	d.nextInviteID++
+	d.invitations = append(d.invitations, inv)
+	return inv, nil
+}
+
+// PendingInvitations は未承諾で有効期限内の招待を返す
+func (d *Directory) PendingInvitations(workspaceID int, now time.Time) []Invitation {
+	pending := []Invitation{}
+	for _, inv := range d.invitations {
+		if inv.WorkspaceID == workspaceID && inv.AcceptedAt == nil && now.Before(inv.ExpiresAt) {
+			pending = append(pending, inv)
+		}
+	}
+	return pending
+}
+
+// Accept は招待を承諾してメンバーに追加する。
+// 承諾済みの確認・有効期限・メールアドレスの一致・上限の確認をすべて通ったときだけ記録する
+func (d *Directory) Accept(id, userID int, email string, now time.Time) (Invitation, Membership, error) {
+	for i := range d.invitations {
+		inv := &d.invitations[i]
+		if inv.ID != id {
+			continue
+		}
+		if inv.AcceptedAt != nil {
+			return Invitation{}, Membership{}, ErrInvitationUsed
+		}
+		if now.After(inv.ExpiresAt) {
+			return Invitation{}, Membership{}, ErrInvitationExpired
+		}
+		if !strings.EqualFold(inv.Email, email) {
+			return Invitation{}, Membership{}, ErrInvitationEmail
+		}
+		if _, ok := d.Role(inv.WorkspaceID, userID); ok {
+			return Invitation{}, Membership{}, ErrAlreadyMember
+		}
+		ws, ok := d.Get(inv.WorkspaceID)
+		if !ok {
+			return Invitation{}, Membership{}, ErrNotFound
+		}
+		if err := d.checkMembers(ws); err != nil {
+			return Invitation{}, Membership{}, err
+		}
+
+		inv.AcceptedAt = &now
+		inv.AcceptedBy = userID
+		m := Membership{WorkspaceID: inv.WorkspaceID, UserID: userID, Role: inv.Role, JoinedAt: now}
+		d.memberships = append(d.memberships, m)
+		return *inv, m, nil
+	}
+	return Invitation{}, Membership{}, ErrInvitationNotFound
+}
@@ -0,0 +1,150 @@
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newDirectory() *Directory {
+	return Restore(State{
+		Workspaces: []Workspace{
+			{ID: 1, Slug: "open", Name: "上限なし"},
+			{ID: 2, Slug: "small", Name: "2人まで", Quota: Quota{MaxMembers: 2}},
+		},
+		Memberships: []Membership{
+			{WorkspaceID: 1, UserID: 1, Role: "admin"},
+			{WorkspaceID: 2, UserID: 1, Role: "admin"},
+		},
+	})
+}
+
+func TestRestoreAssignsNextIDs(t *testing.T) {
+	d := newDirectory()
+	ws, err := d.Create(Workspace{Slug: "new", CreatedBy: 5}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.ID != 3 {
+		t.Errorf("ID = %d, want 3", ws.ID)
+	}
+	if role, ok := d.Role(ws.ID, 5); !ok || role != "admin" {
+		t.Errorf("creator role = %q, %v", role, ok)
+	}
+	if _, err := d.Create(Workspace{Slug: "new"}, "admin"); !errors.Is(err, ErrSlugTaken) {
+		t.Errorf("duplicate slug: err = %v", err)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	accepted := now.Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		inv     Invitation
+		userID  int
+		email   string
+		wantErr error
+	}{
+		{"accepted", Invitation{WorkspaceID: 1, Email: "a@example.com", Role: "author"}, 2, "A@Example.com", nil},
+		{"already used", Invitation{WorkspaceID: 1, Email: "a@example.com", AcceptedAt: &accepted}, 2, "a@example.com", ErrInvitationUsed},
+		{"expired", Invitation{WorkspaceID: 1, Email: "a@example.com", ExpiresAt: now.Add(-time.Second)}, 2, "a@example.com", ErrInvitationExpired},
+		{"other email", Invitation{WorkspaceID: 1, Email: "a@example.com"}, 2, "b@example.com", ErrInvitationEmail},
+		{"already member", Invitation{WorkspaceID: 1, Email: "a@example.com"}, 1, "a@example.com", ErrAlreadyMember},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDirectory()
+			if tt.inv.ExpiresAt.IsZero() {
+				tt.inv.ExpiresAt = now.Add(time.Hour)
+			}
+			inv, err := d.Invite(tt.inv)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, m, err := d.Accept(inv.ID, tt.userID, tt.email, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			wantMembers := 1
+			if err == nil {
+				wantMembers = 2
+				if m.Role != tt.inv.Role || m.UserID != tt.userID {
+					t.Errorf("membership = %+v", m)
+				}
+			}
+			// 失敗したときはメンバーが増えない
+			if n := d.MemberCount(1); n != wantMembers {
+				t.Errorf("members = %d, want %d", n, wantMembers)
+			}
+		})
+	}
+
+	if _, _, err := newDirectory().Accept(99, 2, "a@example.com", now); !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("unknown invitation: err = %v", err)
+	}
+}
+
+// 招待したあとに上限に達したら、承諾の時点で *QuotaError になる
+func TestMemberQuota(t *testing.T) {
+	now := time.Now()
+	d := newDirectory()
+
+	var invs []Invitation
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		inv, err := d.Invite(Invitation{WorkspaceID: 2, Email: email, Role: "author", ExpiresAt: now.Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		invs = append(invs, inv)
+	}
+	if _, _, err := d.Accept(invs[0].ID, 2, "a@example.com", now); err != nil {
+		t.Fatal(err)
+	}
+
+	var qerr *QuotaError
+	if _, _, err := d.Accept(invs[1].ID, 3, "b@example.com", now); !errors.As(err, &qerr) || qerr.Field != "max_members" || qerr.Limit != 2 {
+		t.Errorf("accept over quota: err = %v", err)
+	}
+	if _, err := d.Invite(Invitation{WorkspaceID: 2, Email: "c@example.com"}); !errors.As(err, &qerr) {
+		t.Errorf("invite over quota: err = %v", err)
+	}
+	if _, err := d.Invite(Invitation{WorkspaceID: 9}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown workspace: err = %v", err)
+	}
+	if pending := d.PendingInvitations(2, now); len(pending) != 1 || pending[0].ID != invs[1].ID {
+		t.Errorf("pending = %+v", pending)
+	}
+}
+
+// State は JSON にしても元に戻せる（Store のスナップショットに埋め込んで保存する）
+func TestStateRoundTrip(t *testing.T) {
+	d := newDirectory()
+	if _, err := d.Invite(Invitation{WorkspaceID: 1, Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(d.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatal(err)
+	}
+	restored := Restore(st)
+
+	inv, err := restored.Invite(Invitation{WorkspaceID: 1, Email: "b@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.ID != 2 {
+		t.Errorf("next invitation ID = %d, want 2", inv.ID)
+	}
+	if n := restored.MemberCount(2); n != 1 {
+		t.Errorf("members = %d, want 1", n)
+	}
+}